@@ -0,0 +1,124 @@
+package audio
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// AGCOptions configures AGC.
+type AGCOptions struct {
+	// Target is the RMS level in dBFS speech is brought to; it defaults to
+	// -20.
+	Target float64
+	// MaxGain bounds the amplification in dB, so a quiet room is not pumped
+	// up to speech level; it defaults to 30.
+	MaxGain float64
+	// Attack is how fast the gain falls when the audio gets louder, such as
+	// a speaker leaning in; it defaults to 10ms.
+	Attack time.Duration
+	// Release is how fast the gain rises again when the audio gets quieter;
+	// it defaults to 500ms. A slow release keeps the pauses between words
+	// from being amplified.
+	Release time.Duration
+}
+
+const (
+	// agcWindow is the time constant of the level the gain is derived from.
+	agcWindow = 50 * time.Millisecond
+	// Below agcGate dBFS audio is taken for background, and the gain holds
+	// instead of rising towards MaxGain.
+	agcGate = -55.0
+)
+
+// AGC returns a live 16-bit PCM WAV stream of the 16-bit PCM WAV stream read
+// from r with automatic gain control applied as it is read, so speech from a
+// speaker moving towards or away from a microphone arrives at a steady
+// level.
+func AGC(r io.Reader, opts AGCOptions) (io.Reader, error) {
+	if opts.Target == 0 {
+		opts.Target = -20
+	}
+	if opts.MaxGain == 0 {
+		opts.MaxGain = 30
+	}
+	if opts.Attack == 0 {
+		opts.Attack = 10 * time.Millisecond
+	}
+	if opts.Release == 0 {
+		opts.Release = 500 * time.Millisecond
+	}
+	if opts.Target >= 0 {
+		return nil, fmt.Errorf("AGC target %g dBFS is not below full scale", opts.Target)
+	}
+	br := bufio.NewReader(r)
+	rate, channels, err := readStreamHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	g := newAGC(rate, opts)
+	sr := &sampleReader{r: br, channels: channels, process: func(samples []float32, final bool) []float32 {
+		g.process(samples, channels)
+		return samples
+	}}
+	return io.MultiReader(bytes.NewReader(StreamHeader(rate, channels)), sr), nil
+}
+
+// agc tracks the level of the audio and the gain bringing it to the target.
+type agc struct {
+	target, maxGain float64
+	// The coefficients of one-pole smoothing per frame of the level and of
+	// the gain falling and rising.
+	window, attack, release float64
+	gate                    float64
+
+	level float64
+	gain  float64
+}
+
+func newAGC(rate int, opts AGCOptions) *agc {
+	coef := func(d time.Duration) float64 {
+		return 1 - math.Exp(-1/(d.Seconds()*float64(rate)))
+	}
+	return &agc{
+		target:  math.Pow(10, opts.Target/20),
+		maxGain: math.Pow(10, opts.MaxGain/20),
+		window:  coef(agcWindow),
+		attack:  coef(opts.Attack),
+		release: coef(opts.Release),
+		gate:    math.Pow(10, agcGate/10),
+		gain:    1,
+	}
+}
+
+// process applies the gain to interleaved samples in place.
+func (g *agc) process(samples []float32, channels int) {
+	for i := 0; i+channels <= len(samples); i += channels {
+		var power float64
+		for _, s := range samples[i : i+channels] {
+			power += float64(s) * float64(s)
+		}
+		g.level += g.window * (power/float64(channels) - g.level)
+
+		want := g.gain
+		if g.level > g.gate {
+			want = min(g.maxGain, g.target/math.Sqrt(g.level))
+		}
+		// Peaks the level has not caught up with yet are not amplified past
+		// full scale
+		if peak := math.Sqrt(power) * want; peak > 1 {
+			want /= peak
+		}
+		if want < g.gain {
+			g.gain += g.attack * (want - g.gain)
+		} else {
+			g.gain += g.release * (want - g.gain)
+		}
+		for c := range channels {
+			samples[i+c] *= float32(g.gain)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// Package audio decodes WAV input into samples and runs it through a
+// configurable chain of preprocessing stages before recognition.
+package audio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Audio is decoded PCM audio with samples normalized to [-1, 1] and
+// interleaved by channel.
+type Audio struct {
+	SampleRate int
+	Channels   int
+	Samples    []float32
+}
+
+// Frames returns the number of samples per channel.
+func (a *Audio) Frames() int {
+	if a.Channels == 0 {
+		return 0
+	}
+	return len(a.Samples) / a.Channels
+}
+
+// Duration returns the playback duration of the audio.
+func (a *Audio) Duration() time.Duration {
+	if a.SampleRate == 0 {
+		return 0
+	}
+	return time.Duration(a.Frames()) * time.Second / time.Duration(a.SampleRate)
+}
+
+// DecodeWAV decodes 8, 16, 24 or 32-bit integer PCM or 32-bit float WAV data.
+func DecodeWAV(data []byte) (*Audio, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("not a WAV file")
+	}
+	var (
+		format, channels, bits int
+		rate                   int
+		pcm                    []byte
+	)
+	for pos := 12; pos+8 <= len(data); {
+		id := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		body := data[pos+8 : min(pos+8+size, len(data))]
+		switch id {
+		case "fmt ":
+			if len(body) < 16 {
+				return nil, fmt.Errorf("invalid WAV fmt chunk")
+			}
+			format = int(binary.LittleEndian.Uint16(body[0:2]))
+			channels = int(binary.LittleEndian.Uint16(body[2:4]))
+			rate = int(binary.LittleEndian.Uint32(body[4:8]))
+			bits = int(binary.LittleEndian.Uint16(body[14:16]))
+			// WAVE_FORMAT_EXTENSIBLE carries the real format in its sub-format
+			if format == 0xFFFE && len(body) >= 26 {
+				format = int(binary.LittleEndian.Uint16(body[24:26]))
+			}
+		case "data":
+			// Streaming writers leave the size unset; the data runs to the end
+			if size == 0 || size == math.MaxUint32 {
+				body = data[pos+8:]
+				size = len(body)
+			}
+			pcm = body
+		}
+		pos += 8 + size + size%2
+	}
+	if channels == 0 || rate == 0 {
+		return nil, fmt.Errorf("WAV file has no fmt chunk")
+	}
+
+	a := &Audio{SampleRate: rate, Channels: channels}
+	width := bits / 8
+	if width == 0 {
+		return nil, fmt.Errorf("unsupported WAV sample size %d", bits)
+	}
+	a.Samples = make([]float32, 0, len(pcm)/width)
+	for i := 0; i+width <= len(pcm); i += width {
+		s := pcm[i : i+width]
+		switch {
+		case format == 1 && bits == 8:
+			a.Samples = append(a.Samples, (float32(s[0])-128)/128)
+		case format == 1 && bits == 16:
+			a.Samples = append(a.Samples, float32(int16(binary.LittleEndian.Uint16(s)))/(1<<15))
+		case format == 1 && bits == 24:
+			v := int32(uint32(s[0])<<8|uint32(s[1])<<16|uint32(s[2])<<24) >> 8
+			a.Samples = append(a.Samples, float32(v)/(1<<23))
+		case format == 1 && bits == 32:
+			a.Samples = append(a.Samples, float32(int32(binary.LittleEndian.Uint32(s)))/(1<<31))
+		case format == 3 && bits == 32:
+			a.Samples = append(a.Samples, math.Float32frombits(binary.LittleEndian.Uint32(s)))
+		default:
+			return nil, fmt.Errorf("unsupported WAV encoding: format %d, %d bits", format, bits)
+		}
+	}
+	return a, nil
+}
+
+// WAV encodes the audio as a 16-bit PCM WAV file.
+func (a *Audio) WAV() []byte {
+	dataLen := len(a.Samples) * 2
+	b := append(make([]byte, 0, 44+dataLen), wavHeader(a.SampleRate, a.Channels, uint32(dataLen))...)
+	return appendFloatPCM(b, a.Samples)
+}
+
+// wavHeader returns the 44-byte header of 16-bit PCM WAV audio with dataLen
+// bytes of samples. A dataLen of math.MaxUint32 marks audio of unknown
+// length, such as a live capture.
+func wavHeader(rate, channels int, dataLen uint32) []byte {
+	riffLen := dataLen
+	if dataLen != math.MaxUint32 {
+		riffLen += 36
+	}
+	b := make([]byte, 44)
+	copy(b[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(b[4:8], riffLen)
+	copy(b[8:16], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(b[16:20], 16)
+	binary.LittleEndian.PutUint16(b[20:22], 1)
+	binary.LittleEndian.PutUint16(b[22:24], uint16(channels))
+	binary.LittleEndian.PutUint32(b[24:28], uint32(rate))
+	binary.LittleEndian.PutUint32(b[28:32], uint32(rate*channels*2))
+	binary.LittleEndian.PutUint16(b[32:34], uint16(channels*2))
+	binary.LittleEndian.PutUint16(b[34:36], 16)
+	copy(b[36:40], "data")
+	binary.LittleEndian.PutUint32(b[40:44], dataLen)
+	return b
+}
+
+// StreamHeader returns the header of a 16-bit PCM WAV stream of unknown
+// length, to prefix raw samples arriving live with.
+func StreamHeader(rate, channels int) []byte {
+	return wavHeader(rate, channels, math.MaxUint32)
+}
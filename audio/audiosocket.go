@@ -0,0 +1,139 @@
+package audio
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// AudioSocket message kinds.
+const (
+	audioSocketHangup = 0x00
+	audioSocketUUID   = 0x01
+	audioSocketDTMF   = 0x03
+	audioSocketAudio  = 0x10
+	audioSocketError  = 0xff
+)
+
+// audioSocketRate is the sample rate of AudioSocket audio: 16-bit signed
+// linear mono at 8 kHz.
+const audioSocketRate = 8000
+
+// audioSocketHandshake bounds the wait for the UUID opening a call.
+const audioSocketHandshake = 5 * time.Second
+
+// AudioSocketListener accepts calls from Asterisk's AudioSocket dialplan
+// application.
+type AudioSocketListener struct {
+	ln net.Listener
+}
+
+// ListenAudioSocket listens for AudioSocket connections on the TCP address
+// addr, such as ":9092".
+func ListenAudioSocket(addr string) (*AudioSocketListener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for AudioSocket: %w", err)
+	}
+	return &AudioSocketListener{ln: ln}, nil
+}
+
+// Accept waits for the next call. Connections that do not open with the
+// call's UUID are dropped.
+func (l *AudioSocketListener) Accept() (*AudioSocketCall, error) {
+	for {
+		conn, err := l.ln.Accept()
+		if err != nil {
+			return nil, err
+		}
+		call, err := openAudioSocketCall(conn)
+		if err != nil {
+			conn.Close()
+			continue
+		}
+		return call, nil
+	}
+}
+
+func (l *AudioSocketListener) Addr() net.Addr { return l.ln.Addr() }
+
+func (l *AudioSocketListener) Close() error { return l.ln.Close() }
+
+// AudioSocketCall is the audio of one call, read as a WAV stream until the
+// call hangs up.
+type AudioSocketCall struct {
+	// ID is the call's UUID, as passed to the AudioSocket application.
+	ID string
+
+	conn net.Conn
+	// pending is audio read but not yet returned, starting with the WAV
+	// header.
+	pending []byte
+}
+
+func openAudioSocketCall(conn net.Conn) (*AudioSocketCall, error) {
+	conn.SetReadDeadline(time.Now().Add(audioSocketHandshake))
+	kind, payload, err := readAudioSocketMessage(conn)
+	if err != nil {
+		return nil, err
+	}
+	if kind != audioSocketUUID || len(payload) != 16 {
+		return nil, fmt.Errorf("AudioSocket call did not start with its UUID")
+	}
+	conn.SetReadDeadline(time.Time{})
+	id := hex.EncodeToString(payload)
+	return &AudioSocketCall{
+		ID:      id[:8] + "-" + id[8:12] + "-" + id[12:16] + "-" + id[16:20] + "-" + id[20:],
+		conn:    conn,
+		pending: StreamHeader(audioSocketRate, 1),
+	}, nil
+}
+
+// Read returns the call's audio, or io.EOF once it hangs up.
+func (c *AudioSocketCall) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		kind, payload, err := readAudioSocketMessage(c.conn)
+		if err != nil {
+			return 0, err
+		}
+		switch kind {
+		case audioSocketHangup:
+			return 0, io.EOF
+		case audioSocketError:
+			return 0, fmt.Errorf("AudioSocket error %x", payload)
+		case audioSocketAudio:
+			c.pending = payload
+		case audioSocketDTMF:
+			// Digits are not transcribed
+		}
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// Close hangs up the call.
+func (c *AudioSocketCall) Close() error {
+	c.conn.Write([]byte{audioSocketHangup, 0, 0})
+	return c.conn.Close()
+}
+
+// readAudioSocketMessage reads a message: its kind, a big-endian 16-bit
+// length and the payload. A closed connection is a hangup.
+func readAudioSocketMessage(r io.Reader) (byte, []byte, error) {
+	var header [3]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.EOF {
+			return audioSocketHangup, nil, nil
+		}
+		return 0, nil, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[1:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
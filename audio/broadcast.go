@@ -0,0 +1,416 @@
+package audio
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BroadcastOptions configures OpenBroadcast.
+type BroadcastOptions struct {
+	// Retries is how many times in a row a dropped stream or failed request
+	// is retried before giving up; it defaults to 5.
+	Retries int
+}
+
+// hlsLiveEdge is how many segments from the end of a live playlist playback
+// starts at.
+const hlsLiveEdge = 3
+
+// broadcastClient makes the requests of broadcasts. SHOUTcast servers answer
+// with an "ICY 200 OK" status line, which is read as HTTP/1.0.
+var broadcastClient = &http.Client{Transport: func() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	var d net.Dialer
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &icyConn{Conn: conn}, nil
+	}
+	return t
+}()}
+
+// icyConn rewrites an ICY status line to HTTP/1.0.
+type icyConn struct {
+	net.Conn
+	checked bool
+	pending []byte
+}
+
+func (c *icyConn) Read(p []byte) (int, error) {
+	if !c.checked {
+		c.checked = true
+		head := make([]byte, 4)
+		n, err := io.ReadFull(c.Conn, head)
+		c.pending = head[:n]
+		if string(c.pending) == "ICY " {
+			c.pending = []byte("HTTP/1.0 ")
+		}
+		if err != nil && n == 0 {
+			return 0, err
+		}
+	}
+	if len(c.pending) > 0 {
+		n := copy(p, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+	return c.Conn.Read(p)
+}
+
+// OpenBroadcast returns the media of a live broadcast at an http:// or
+// https:// URL as it is received: an HLS playlist, whose segments are
+// fetched as they are published, or an Icecast or SHOUTcast stream. A
+// dropped stream is reconnected, continuing with the live audio; the media,
+// such as MP3, AAC or MPEG-TS, is decoded with Transcode. The first request
+// is made before OpenBroadcast returns, so an unreachable URL fails early.
+func OpenBroadcast(ctx context.Context, rawURL string, opts BroadcastOptions) (io.ReadCloser, error) {
+	if opts.Retries <= 0 {
+		opts.Retries = 5
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	resp, err := broadcastRequest(ctx, rawURL)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	br := bufio.NewReader(resp.Body)
+	head, _ := br.Peek(7)
+	hls := string(head) == "#EXTM3U" || strings.Contains(resp.Header.Get("Content-Type"), "mpegurl")
+
+	pr, pw := io.Pipe()
+	b := &broadcast{url: rawURL, opts: opts, w: pw}
+	go func() {
+		var err error
+		if hls {
+			err = b.playlist(ctx, br, resp)
+		} else {
+			err = b.stream(ctx, br, resp)
+		}
+		if ctx.Err() != nil {
+			// Stopping a live broadcast ends its audio
+			err = nil
+		}
+		pw.CloseWithError(err)
+	}()
+	return &broadcastReader{PipeReader: pr, cancel: cancel}, nil
+}
+
+// broadcastReader stops the broadcast when closed.
+type broadcastReader struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+}
+
+func (r *broadcastReader) Close() error {
+	r.cancel()
+	return r.PipeReader.Close()
+}
+
+// broadcast copies the media of a broadcast to w.
+type broadcast struct {
+	url  string
+	opts BroadcastOptions
+	w    *io.PipeWriter
+}
+
+func broadcastRequest(ctx context.Context, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid broadcast URL: %w", err)
+	}
+	resp, err := broadcastClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request %s: %w", rawURL, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to request %s: status %s", rawURL, resp.Status)
+	}
+	return resp, nil
+}
+
+// retry waits before retry number failures, or returns err once they are
+// exhausted.
+func (b *broadcast) retry(ctx context.Context, failures int, err error) error {
+	if failures > b.opts.Retries {
+		return err
+	}
+	delay := time.Duration(failures) * time.Second
+	log.Printf("Broadcast %s failed, retrying in %s: %v", b.url, delay, err)
+	select {
+	case <-time.After(delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stream copies an Icecast or SHOUTcast stream, reconnecting when it drops.
+func (b *broadcast) stream(ctx context.Context, body io.Reader, resp *http.Response) error {
+	failures := 0
+	for {
+		n, err := io.Copy(b.w, body)
+		resp.Body.Close()
+		if errors.Is(err, io.ErrClosedPipe) || ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if n > 0 {
+			failures = 0
+		}
+		if err == nil {
+			err = errors.New("stream ended")
+		}
+		for {
+			failures++
+			if err := b.retry(ctx, failures, err); err != nil {
+				return err
+			}
+			if resp, err = broadcastRequest(ctx, b.url); err == nil {
+				break
+			}
+		}
+		body = resp.Body
+	}
+}
+
+// hlsPlaylist is a parsed HLS playlist.
+type hlsPlaylist struct {
+	// variants are the media playlists of a master playlist, and audio
+	// those of its separate audio renditions, default first.
+	variants []hlsVariant
+	audio    []string
+
+	targetDuration time.Duration
+	// sequence is the media sequence number of the first segment.
+	sequence int
+	segments []string
+	// init is the URI of the initialization section of fMP4 segments.
+	init      string
+	encrypted bool
+	ended     bool
+}
+
+type hlsVariant struct {
+	uri       string
+	bandwidth int
+}
+
+// parseHLS parses a master or media playlist, resolving its URIs against
+// base.
+func parseHLS(r io.Reader, base *url.URL) (hlsPlaylist, error) {
+	p := hlsPlaylist{targetDuration: 6 * time.Second}
+	scanner := bufio.NewScanner(r)
+	resolve := func(ref string) string {
+		u, err := base.Parse(ref)
+		if err != nil {
+			return ref
+		}
+		return u.String()
+	}
+	first := true
+	streamInf := -1
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if first {
+			if line != "#EXTM3U" {
+				return p, errors.New("not an HLS playlist")
+			}
+			first = false
+			continue
+		}
+		tag, value, _ := strings.Cut(line, ":")
+		switch {
+		case line == "":
+		case tag == "#EXT-X-STREAM-INF":
+			bandwidth, _ := strconv.Atoi(hlsAttributes(value)["BANDWIDTH"])
+			streamInf = bandwidth
+		case tag == "#EXT-X-MEDIA":
+			attrs := hlsAttributes(value)
+			if attrs["TYPE"] == "AUDIO" && attrs["URI"] != "" {
+				if attrs["DEFAULT"] == "YES" {
+					p.audio = append([]string{resolve(attrs["URI"])}, p.audio...)
+				} else {
+					p.audio = append(p.audio, resolve(attrs["URI"]))
+				}
+			}
+		case tag == "#EXT-X-TARGETDURATION":
+			if s, err := strconv.Atoi(value); err == nil && s > 0 {
+				p.targetDuration = time.Duration(s) * time.Second
+			}
+		case tag == "#EXT-X-MEDIA-SEQUENCE":
+			p.sequence, _ = strconv.Atoi(value)
+		case tag == "#EXT-X-MAP":
+			p.init = resolve(hlsAttributes(value)["URI"])
+		case tag == "#EXT-X-KEY":
+			p.encrypted = hlsAttributes(value)["METHOD"] != "NONE"
+		case tag == "#EXT-X-ENDLIST":
+			p.ended = true
+		case strings.HasPrefix(line, "#"):
+		case streamInf >= 0:
+			p.variants = append(p.variants, hlsVariant{uri: resolve(line), bandwidth: streamInf})
+			streamInf = -1
+		default:
+			p.segments = append(p.segments, resolve(line))
+		}
+	}
+	if first {
+		return p, errors.New("empty HLS playlist")
+	}
+	return p, scanner.Err()
+}
+
+// hlsAttributes parses an attribute list such as
+// BANDWIDTH=64000,CODECS="mp4a.40.2", unquoting values.
+func hlsAttributes(s string) map[string]string {
+	attrs := map[string]string{}
+	for s != "" {
+		key, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			break
+		}
+		var value string
+		if quoted, ok := strings.CutPrefix(rest, `"`); ok {
+			value, s, _ = strings.Cut(quoted, `"`)
+			s = strings.TrimPrefix(s, ",")
+		} else {
+			value, s, _ = strings.Cut(rest, ",")
+		}
+		attrs[key] = value
+	}
+	return attrs
+}
+
+// fetchPlaylist requests and parses the playlist at rawURL.
+func fetchPlaylist(ctx context.Context, rawURL string) (hlsPlaylist, error) {
+	resp, err := broadcastRequest(ctx, rawURL)
+	if err != nil {
+		return hlsPlaylist{}, err
+	}
+	defer resp.Body.Close()
+	return parseHLS(resp.Body, resp.Request.URL)
+}
+
+// playlist follows an HLS playlist, copying each segment once as it is
+// published, until the playlist ends.
+func (b *broadcast) playlist(ctx context.Context, body io.Reader, resp *http.Response) error {
+	p, err := parseHLS(body, resp.Request.URL)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read HLS playlist: %w", err)
+	}
+	mediaURL := b.url
+	switch {
+	case len(p.audio) > 0:
+		// Variants carry no audio of their own when it is a separate rendition
+		mediaURL = p.audio[0]
+	case len(p.variants) > 0:
+		// Audio needs the least bandwidth, often an audio-only variant
+		v := p.variants[0]
+		for _, c := range p.variants[1:] {
+			if c.bandwidth < v.bandwidth {
+				v = c
+			}
+		}
+		mediaURL = v.uri
+	}
+	if mediaURL != b.url {
+		if p, err = fetchPlaylist(ctx, mediaURL); err != nil {
+			return err
+		}
+	}
+
+	var (
+		// next is the media sequence number of the next segment to copy
+		next     = p.sequence + max(len(p.segments)-hlsLiveEdge, 0)
+		init     string
+		failures int
+	)
+	if p.ended {
+		next = p.sequence
+	}
+	for {
+		if p.encrypted {
+			return errors.New("encrypted HLS streams are not supported")
+		}
+		if next < p.sequence {
+			log.Printf("Broadcast %s skipped %d HLS segments that expired before they were fetched", b.url, p.sequence-next)
+			next = p.sequence
+		}
+		copied := false
+		for i := next - p.sequence; i < len(p.segments); i++ {
+			if p.init != "" && p.init != init {
+				if err := b.segment(ctx, p.init); err != nil {
+					return err
+				}
+				init = p.init
+			}
+			if err := b.segment(ctx, p.segments[i]); err != nil {
+				if errors.Is(err, io.ErrClosedPipe) || ctx.Err() != nil {
+					return ctx.Err()
+				}
+				failures++
+				if err := b.retry(ctx, failures, err); err != nil {
+					return err
+				}
+				break
+			}
+			failures = 0
+			next++
+			copied = true
+		}
+		if p.ended && next >= p.sequence+len(p.segments) {
+			return nil
+		}
+
+		// A playlist that has not changed is reloaded sooner
+		wait := p.targetDuration
+		if !copied {
+			wait /= 2
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		for {
+			reloaded, err := fetchPlaylist(ctx, mediaURL)
+			if err == nil {
+				p = reloaded
+				break
+			}
+			failures++
+			if err := b.retry(ctx, failures, err); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// segment copies the segment at rawURL whole, so that a failed request
+// does not leave part of it in the stream.
+func (b *broadcast) segment(ctx context.Context, rawURL string) error {
+	resp, err := broadcastRequest(ctx, rawURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, resp.Body); err != nil {
+		return fmt.Errorf("failed to download HLS segment: %w", err)
+	}
+	_, err = b.w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,108 @@
+package audio
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrCaptureUnsupported is returned by Capture in builds without microphone
+// support, which needs cgo.
+var ErrCaptureUnsupported = errors.New("microphone capture is not supported by this build; rebuild with -tags mic")
+
+// ErrLoopbackUnsupported is returned by CaptureLoopback on systems without
+// loopback capture, which is supported on Windows and Linux.
+var ErrLoopbackUnsupported = errors.New("loopback capture of output audio is supported on Windows and Linux only")
+
+// CaptureDevice is an input device Capture can record from.
+type CaptureDevice struct {
+	// Index is the position of the device in the list of CaptureDevices.
+	Index   int
+	Name    string
+	Default bool
+}
+
+// findCaptureDevice returns the device of devices selected by device: its
+// index, its full name in any case, or else a part of a single device's
+// name.
+func findCaptureDevice(devices []CaptureDevice, device string) (CaptureDevice, error) {
+	if i, err := strconv.Atoi(device); err == nil {
+		if i < 0 || i >= len(devices) {
+			return CaptureDevice{}, fmt.Errorf("no input device %d; there are %d", i, len(devices))
+		}
+		return devices[i], nil
+	}
+	var matches []CaptureDevice
+	for _, d := range devices {
+		if strings.EqualFold(d.Name, device) {
+			return d, nil
+		}
+		if strings.Contains(strings.ToLower(d.Name), strings.ToLower(device)) {
+			matches = append(matches, d)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return CaptureDevice{}, fmt.Errorf("no input device named %q", device)
+	case 1:
+		return matches[0], nil
+	}
+	names := make([]string, len(matches))
+	for i, d := range matches {
+		names[i] = strconv.Quote(d.Name)
+	}
+	return CaptureDevice{}, fmt.Errorf("input device %q is ambiguous: it matches %s", device, strings.Join(names, ", "))
+}
+
+// captureBuffer hands captured audio from the device callback, which must
+// not block, to the reader of a capture.
+type captureBuffer struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	data   []byte
+	closed bool
+}
+
+// newCaptureBuffer returns a buffer starting with the header of a WAV stream
+// of unknown length.
+func newCaptureBuffer(rate, channels int) *captureBuffer {
+	b := &captureBuffer{data: wavHeader(rate, channels, math.MaxUint32)}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+func (b *captureBuffer) write(p []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		b.data = append(b.data, p...)
+		b.cond.Signal()
+	}
+}
+
+// Read blocks until audio is captured, returning io.EOF once the capture is
+// closed and its audio read.
+func (b *captureBuffer) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for len(b.data) == 0 && !b.closed {
+		b.cond.Wait()
+	}
+	if len(b.data) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, b.data)
+	b.data = b.data[n:]
+	return n, nil
+}
+
+func (b *captureBuffer) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	b.cond.Broadcast()
+}
@@ -0,0 +1,176 @@
+//go:build mic
+
+package audio
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+
+	"github.com/gen2brain/malgo"
+)
+
+// Capture records 16-bit PCM from an input device at the given rate and
+// channel count. device selects the device as for CaptureDevices, by index
+// or name; "" is the default device. The returned reader yields a WAV
+// stream as audio is captured; closing it stops the capture, after which
+// the remaining audio is read before io.EOF.
+func Capture(device string, rate, channels int) (io.ReadCloser, error) {
+	ctx, err := malgo.InitContext(nil, malgo.ContextConfig{}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize audio context: %w", err)
+	}
+	buf := newCaptureBuffer(rate, channels)
+
+	config := malgo.DefaultDeviceConfig(malgo.Capture)
+	config.Capture.Format = malgo.FormatS16
+	config.Capture.Channels = uint32(channels)
+	config.SampleRate = uint32(rate)
+	if device != "" {
+		infos, devices, err := captureDevices(ctx)
+		if err == nil {
+			var d CaptureDevice
+			if d, err = findCaptureDevice(devices, device); err == nil {
+				config.Capture.DeviceID = infos[d.Index].ID.Pointer()
+			}
+		}
+		if err != nil {
+			ctx.Uninit()
+			ctx.Free()
+			return nil, err
+		}
+	}
+	return startCapture(ctx, config, buf)
+}
+
+// CaptureLoopback records 16-bit PCM of what the default output device is
+// playing, as Capture does for an input device: through WASAPI loopback on
+// Windows, and from the output's monitor source with PulseAudio, or
+// PipeWire's PulseAudio server, on Linux. Other systems return
+// ErrLoopbackUnsupported.
+func CaptureLoopback(rate, channels int) (io.ReadCloser, error) {
+	var backends []malgo.Backend
+	switch runtime.GOOS {
+	case "windows":
+		backends = []malgo.Backend{malgo.BackendWasapi}
+	case "linux":
+		backends = []malgo.Backend{malgo.BackendPulseaudio}
+	default:
+		return nil, ErrLoopbackUnsupported
+	}
+	ctx, err := malgo.InitContext(backends, malgo.ContextConfig{}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize audio context: %w", err)
+	}
+	buf := newCaptureBuffer(rate, channels)
+
+	deviceType := malgo.Loopback
+	if runtime.GOOS == "linux" {
+		deviceType = malgo.Capture
+	}
+	config := malgo.DefaultDeviceConfig(deviceType)
+	config.Capture.Format = malgo.FormatS16
+	config.Capture.Channels = uint32(channels)
+	config.SampleRate = uint32(rate)
+	if runtime.GOOS == "linux" {
+		id, err := monitorSource(ctx)
+		if err != nil {
+			ctx.Uninit()
+			ctx.Free()
+			return nil, err
+		}
+		config.Capture.DeviceID = id.Pointer()
+	}
+	return startCapture(ctx, config, buf)
+}
+
+// monitorSource returns the PulseAudio monitor source of the default output
+// device, the input device named "Monitor of" the output.
+func monitorSource(ctx *malgo.AllocatedContext) (malgo.DeviceID, error) {
+	outputs, err := ctx.Devices(malgo.Playback)
+	if err != nil {
+		return malgo.DeviceID{}, fmt.Errorf("failed to list output devices: %w", err)
+	}
+	var output string
+	for i := range outputs {
+		if outputs[i].IsDefault != 0 || output == "" {
+			output = outputs[i].Name()
+		}
+	}
+	if output == "" {
+		return malgo.DeviceID{}, fmt.Errorf("no output device to capture")
+	}
+	infos, err := ctx.Devices(malgo.Capture)
+	if err != nil {
+		return malgo.DeviceID{}, fmt.Errorf("failed to list input devices: %w", err)
+	}
+	for i := range infos {
+		if infos[i].Name() == "Monitor of "+output {
+			return infos[i].ID, nil
+		}
+	}
+	return malgo.DeviceID{}, fmt.Errorf("no monitor source of output device %q; loopback capture needs PulseAudio or PipeWire", output)
+}
+
+// startCapture starts capturing into buf from the device of config, taking
+// ownership of ctx.
+func startCapture(ctx *malgo.AllocatedContext, config malgo.DeviceConfig, buf *captureBuffer) (io.ReadCloser, error) {
+	dev, err := malgo.InitDevice(ctx.Context, config, malgo.DeviceCallbacks{
+		Data: func(_, in []byte, _ uint32) { buf.write(in) },
+	})
+	if err != nil {
+		ctx.Uninit()
+		ctx.Free()
+		return nil, fmt.Errorf("failed to open input device: %w", err)
+	}
+	if err := dev.Start(); err != nil {
+		dev.Uninit()
+		ctx.Uninit()
+		ctx.Free()
+		return nil, fmt.Errorf("failed to start capture: %w", err)
+	}
+	return &capture{captureBuffer: buf, ctx: ctx, device: dev}, nil
+}
+
+// CaptureDevices lists the input devices of the system, such as USB audio
+// interfaces and virtual cables, for selecting one for Capture.
+func CaptureDevices() ([]CaptureDevice, error) {
+	ctx, err := malgo.InitContext(nil, malgo.ContextConfig{}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize audio context: %w", err)
+	}
+	defer func() {
+		ctx.Uninit()
+		ctx.Free()
+	}()
+	_, devices, err := captureDevices(ctx)
+	return devices, err
+}
+
+// captureDevices returns the input devices of ctx, with the malgo device
+// infos they were made from.
+func captureDevices(ctx *malgo.AllocatedContext) ([]malgo.DeviceInfo, []CaptureDevice, error) {
+	infos, err := ctx.Devices(malgo.Capture)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list input devices: %w", err)
+	}
+	devices := make([]CaptureDevice, len(infos))
+	for i := range infos {
+		devices[i] = CaptureDevice{Index: i, Name: infos[i].Name(), Default: infos[i].IsDefault != 0}
+	}
+	return infos, devices, nil
+}
+
+type capture struct {
+	*captureBuffer
+	ctx    *malgo.AllocatedContext
+	device *malgo.Device
+}
+
+func (c *capture) Close() error {
+	c.device.Uninit()
+	c.close()
+	err := c.ctx.Uninit()
+	c.ctx.Free()
+	return err
+}
@@ -0,0 +1,23 @@
+//go:build !mic
+
+package audio
+
+import "io"
+
+// Capture records from an input device in builds with the mic tag; this
+// build returns ErrCaptureUnsupported.
+func Capture(device string, rate, channels int) (io.ReadCloser, error) {
+	return nil, ErrCaptureUnsupported
+}
+
+// CaptureDevices lists the input devices in builds with the mic tag; this
+// build returns ErrCaptureUnsupported.
+func CaptureDevices() ([]CaptureDevice, error) {
+	return nil, ErrCaptureUnsupported
+}
+
+// CaptureLoopback records what the output device plays in builds with the
+// mic tag; this build returns ErrCaptureUnsupported.
+func CaptureLoopback(rate, channels int) (io.ReadCloser, error) {
+	return nil, ErrCaptureUnsupported
+}
@@ -0,0 +1,33 @@
+package audio
+
+import "fmt"
+
+// Downmix returns WAV data with every channel mixed into one, as 16-bit PCM
+// WAV.
+func Downmix(data []byte) ([]byte, error) {
+	a, err := DecodeWAV(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode audio: %w", err)
+	}
+	mono{}.Process(a)
+	return a.WAV(), nil
+}
+
+// SplitChannels returns each channel of WAV data as mono 16-bit PCM WAV, such
+// as the caller and the agent of a stereo call recording.
+func SplitChannels(data []byte) ([][]byte, error) {
+	a, err := DecodeWAV(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode audio: %w", err)
+	}
+	channels := make([][]byte, a.Channels)
+	frames := a.Frames()
+	for c := range a.Channels {
+		ch := &Audio{SampleRate: a.SampleRate, Channels: 1, Samples: make([]float32, frames)}
+		for i := range frames {
+			ch.Samples[i] = a.Samples[i*a.Channels+c]
+		}
+		channels[c] = ch.WAV()
+	}
+	return channels, nil
+}
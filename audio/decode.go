@@ -0,0 +1,200 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/hajimehoshi/go-mp3"
+	"github.com/jfreymuth/oggvorbis"
+	"github.com/mewkiz/flac"
+)
+
+// Input formats told apart by DetectFormat. MP3, Ogg Vorbis and FLAC are
+// decoded natively; other media need ffmpeg.
+const (
+	FormatWAV  = "wav"
+	FormatMP3  = "mp3"
+	FormatOgg  = "ogg"
+	FormatFLAC = "flac"
+)
+
+// DetectFormat returns the format of the media starting with head, which
+// should hold its first 64 bytes, or "" if it is none of the Format values.
+// The magic bytes decide; the extension of name, if any, is only used when
+// they are not recognized. Ogg files are only FormatOgg when they carry
+// Vorbis, since Opus is not decoded natively.
+func DetectFormat(head []byte, name string) string {
+	switch {
+	case len(head) >= 12 && string(head[0:4]) == "RIFF" && string(head[8:12]) == "WAVE":
+		return FormatWAV
+	case bytes.HasPrefix(head, []byte("fLaC")):
+		return FormatFLAC
+	case bytes.HasPrefix(head, []byte("OggS")):
+		if bytes.Contains(head, []byte("\x01vorbis")) {
+			return FormatOgg
+		}
+		return ""
+	// An ID3 tag, or the frame sync of an MPEG-1/2 Layer III frame
+	case bytes.HasPrefix(head, []byte("ID3")), len(head) >= 2 && head[0] == 0xFF && head[1]&0xE6 == 0xE2:
+		return FormatMP3
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".mp3":
+		return FormatMP3
+	case ".ogg", ".oga":
+		return FormatOgg
+	case ".flac":
+		return FormatFLAC
+	}
+	return ""
+}
+
+// NeedsTranscoding reports whether the media starting with head is neither
+// WAV nor decoded natively, and so needs ffmpeg.
+func NeedsTranscoding(head []byte) bool {
+	return len(head) >= 4 && DetectFormat(head, "") == ""
+}
+
+// NewDecoder returns a live 16-bit PCM WAV stream of the MP3, Ogg Vorbis or
+// FLAC audio read from r, decoded as it is read, for streaming compressed
+// audio with Session.RunReader.
+func NewDecoder(r io.Reader, format string) (io.Reader, error) {
+	d, err := newPCMDecoder(r, format)
+	if err != nil {
+		return nil, err
+	}
+	return io.MultiReader(bytes.NewReader(StreamHeader(d.rate, d.channels)), d), nil
+}
+
+// DecodeToWAV decodes MP3, Ogg Vorbis or FLAC data to a 16-bit PCM WAV file.
+func DecodeToWAV(data []byte, format string) ([]byte, error) {
+	d, err := newPCMDecoder(bytes.NewReader(data), format)
+	if err != nil {
+		return nil, err
+	}
+	pcm, err := io.ReadAll(d)
+	if err != nil {
+		return nil, err
+	}
+	return append(wavHeader(d.rate, d.channels, uint32(len(pcm))), pcm...), nil
+}
+
+// pcmDecoder reads decoded audio as 16-bit little-endian PCM.
+type pcmDecoder struct {
+	rate, channels int
+	// next decodes the next block of PCM, returning io.EOF at the end.
+	next    func() ([]byte, error)
+	pending []byte
+	format  string
+}
+
+func newPCMDecoder(r io.Reader, format string) (d *pcmDecoder, err error) {
+	defer recoverDecoder(format, &err)
+	d = &pcmDecoder{format: format}
+	switch format {
+	case FormatMP3:
+		dec, err := mp3.NewDecoder(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode MP3: %w", err)
+		}
+		// go-mp3 always decodes to 16-bit stereo
+		d.rate, d.channels = dec.SampleRate(), 2
+		buf := make([]byte, 8192)
+		d.next = func() ([]byte, error) {
+			n, err := dec.Read(buf)
+			if n > 0 {
+				return buf[:n], nil
+			}
+			return nil, err
+		}
+	case FormatOgg:
+		dec, err := oggvorbis.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode Ogg Vorbis: %w", err)
+		}
+		d.rate, d.channels = dec.SampleRate(), dec.Channels()
+		samples := make([]float32, 4096*d.channels)
+		d.next = func() ([]byte, error) {
+			n, err := dec.Read(samples)
+			if n > 0 {
+				return appendFloatPCM(nil, samples[:n]), nil
+			}
+			return nil, err
+		}
+	case FormatFLAC:
+		stream, err := flac.New(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode FLAC: %w", err)
+		}
+		d.rate, d.channels = int(stream.Info.SampleRate), int(stream.Info.NChannels)
+		shift := int(stream.Info.BitsPerSample) - 16
+		d.next = func() ([]byte, error) {
+			frame, err := stream.ParseNext()
+			if err != nil {
+				return nil, err
+			}
+			if len(frame.Subframes) != d.channels {
+				return nil, fmt.Errorf("frame has %d channels, stream %d", len(frame.Subframes), d.channels)
+			}
+			var b []byte
+			for i := range frame.Subframes[0].NSamples {
+				for _, sub := range frame.Subframes {
+					v := sub.Samples[i]
+					if shift > 0 {
+						v >>= shift
+					} else {
+						v <<= -shift
+					}
+					b = binary.LittleEndian.AppendUint16(b, uint16(int16(v)))
+				}
+			}
+			return b, nil
+		}
+	default:
+		return nil, fmt.Errorf("unsupported audio format %q", format)
+	}
+	if d.rate <= 0 || d.channels <= 0 {
+		return nil, fmt.Errorf("failed to decode %s: invalid format", strings.ToUpper(format))
+	}
+	return d, nil
+}
+
+func (d *pcmDecoder) Read(p []byte) (n int, err error) {
+	defer recoverDecoder(d.format, &err)
+	for len(d.pending) == 0 {
+		b, err := d.next()
+		if err == io.EOF {
+			return 0, io.EOF
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to decode %s: %w", strings.ToUpper(d.format), err)
+		}
+		d.pending = b
+	}
+	n = copy(p, d.pending)
+	d.pending = d.pending[n:]
+	return n, nil
+}
+
+// recoverDecoder turns a panic of a third-party decoder on malformed input
+// into an error in *err, so a corrupt upload fails its request instead of
+// crashing the process. It must be deferred.
+func recoverDecoder(format string, err *error) {
+	if p := recover(); p != nil {
+		*err = fmt.Errorf("failed to decode %s: malformed audio: %v", strings.ToUpper(format), p)
+	}
+}
+
+// appendFloatPCM appends samples in [-1, 1] to b as 16-bit PCM.
+func appendFloatPCM(b []byte, samples []float32) []byte {
+	for _, s := range samples {
+		v := int16(math.Round(float64(max(-1, min(1, s))) * math.MaxInt16))
+		b = binary.LittleEndian.AppendUint16(b, uint16(v))
+	}
+	return b
+}
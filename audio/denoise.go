@@ -0,0 +1,216 @@
+package audio
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/bits"
+	"math/cmplx"
+	"slices"
+	"time"
+)
+
+// DenoiseOptions configures Denoise.
+type DenoiseOptions struct {
+	// Reduction is how far noise is attenuated in dB; it defaults to 12.
+	// Stronger reduction removes more noise but leaves more "musical noise"
+	// artifacts on speech.
+	Reduction float64 `json:"reduction"`
+	// Threshold is how far above the noise floor in dB a frequency must
+	// rise to be let through, fully from 3 dB above that; it defaults to 6.
+	Threshold float64 `json:"threshold"`
+}
+
+const (
+	// denoiseFrame is the shortest frame the spectrum is analysed in,
+	// rounded up to a power of two samples; frames overlap by half.
+	denoiseFrame = 32 * time.Millisecond
+	// The noise floor is the mean spectrum of the denoiseQuietest share of
+	// frames with the least energy.
+	denoiseQuietest = 0.1
+	// denoiseRelease is the share of its previous value the gain of a
+	// frequency keeps per frame as it falls, so speech tails fade out
+	// rather than flutter.
+	denoiseRelease = 0.6
+)
+
+// Denoise suppresses steady background noise, such as wind, traffic or
+// hum in field recordings, with a spectral gate: the noise floor of every
+// frequency is estimated from the quietest frames, and frequencies not
+// rising above it are attenuated frame by frame. Noise that changes faster
+// than speech, such as chatter, is not removed.
+func Denoise(a *Audio, opts DenoiseOptions) error {
+	if opts.Reduction == 0 {
+		opts.Reduction = 12
+	}
+	if opts.Threshold == 0 {
+		opts.Threshold = 6
+	}
+	if opts.Reduction < 0 || opts.Threshold < 0 {
+		return fmt.Errorf("denoise reduction %g and threshold %g must be positive", opts.Reduction, opts.Threshold)
+	}
+	n := 1 << bits.Len(uint(time.Duration(a.SampleRate)*denoiseFrame/time.Second-1))
+	if a.Frames() < 2*n {
+		return nil
+	}
+	// Square roots of a periodic Hann window, applied before and after,
+	// add up to one at half overlap
+	window := make([]float64, n)
+	for i := range window {
+		window[i] = math.Sin(math.Pi * float64(i) / float64(n))
+	}
+	floor := math.Pow(10, -opts.Reduction/20)
+	threshold := math.Pow(10, opts.Threshold/10)
+	samples := make([]float64, a.Frames())
+	for c := range a.Channels {
+		for i := range samples {
+			samples[i] = float64(a.Samples[i*a.Channels+c])
+		}
+		out := spectralGate(samples, window, floor, threshold)
+		for i, s := range out {
+			a.Samples[i*a.Channels+c] = float32(s)
+		}
+	}
+	return nil
+}
+
+// spectralGate returns samples with the frequencies of every frame that do
+// not rise threshold, a power ratio, above the noise floor scaled by floor,
+// an amplitude ratio.
+func spectralGate(samples, window []float64, floor, threshold float64) []float64 {
+	n := len(window)
+	hop := n / 2
+	// Padding by a hop on either side gives every sample two frames
+	padded := make([]float64, hop+len(samples)+n)
+	copy(padded[hop:], samples)
+	frames := (len(padded) - n) / hop
+
+	// spectrum returns the half spectrum of frame f in buf
+	spectrum := func(f int, buf []complex128) []complex128 {
+		for i := range buf {
+			buf[i] = complex(padded[f*hop+i]*window[i], 0)
+		}
+		fft(buf, false)
+		return buf[:n/2+1]
+	}
+
+	// The noise floor of every bin, from the quietest frames
+	energy := make([]float64, frames)
+	for f := range energy {
+		for i, w := range window {
+			s := padded[f*hop+i] * w
+			energy[f] += s * s
+		}
+	}
+	// Frames reaching into the padding are not taken for quiet
+	var order []int
+	for f := 1; f*hop+n <= hop+len(samples); f++ {
+		order = append(order, f)
+	}
+	slices.SortFunc(order, func(x, y int) int { return cmp.Compare(energy[x], energy[y]) })
+	quiet := order[:max(1, int(float64(len(order))*denoiseQuietest))]
+	noise := make([]float64, n/2+1)
+	buf := make([]complex128, n)
+	for _, f := range quiet {
+		for k, v := range spectrum(f, buf) {
+			noise[k] += (real(v)*real(v) + imag(v)*imag(v)) / float64(len(quiet))
+		}
+	}
+
+	out := make([]float64, len(padded))
+	gains := make([]float64, n/2+1)
+	smoothed := make([]float64, n/2+1)
+	for f := range frames {
+		s := spectrum(f, buf)
+		// The gain rises from floor to one as the bin rises from the
+		// threshold above the noise floor to twice that
+		for k, v := range s {
+			snr := (real(v)*real(v) + imag(v)*imag(v)) / (noise[k] + 1e-20)
+			g := floor + (1-floor)*min(1, max(0, snr/threshold-1))
+			if f > 0 && g < gains[k] {
+				g = max(g, gains[k]*denoiseRelease)
+			}
+			gains[k] = g
+		}
+		// Averaged with neighbouring bins, isolated noise peaks are not let
+		// through as tones
+		for k := range smoothed {
+			lo, hi := max(0, k-1), min(len(gains)-1, k+1)
+			var sum float64
+			for _, g := range gains[lo : hi+1] {
+				sum += g
+			}
+			smoothed[k] = sum / float64(hi-lo+1)
+		}
+		for k, v := range s {
+			buf[k] = v * complex(smoothed[k], 0)
+		}
+		// The spectrum of a real signal is conjugate symmetric
+		for k := n/2 + 1; k < n; k++ {
+			buf[k] = cmplx.Conj(buf[n-k])
+		}
+		fft(buf, true)
+		for i, v := range buf {
+			out[f*hop+i] += real(v) * window[i]
+		}
+	}
+	return out[hop : hop+len(samples)]
+}
+
+// fft transforms x in place, whose length is a power of two, with the
+// iterative radix-2 Cooley-Tukey algorithm. The inverse transform is scaled
+// by 1/len(x).
+func fft(x []complex128, inverse bool) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	sign := -1.0
+	if inverse {
+		sign = 1
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Rect(1, sign*2*math.Pi/float64(size))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := range size / 2 {
+				u, v := x[start+k], x[start+k+size/2]*w
+				x[start+k], x[start+k+size/2] = u+v, u-v
+				w *= step
+			}
+		}
+	}
+	if inverse {
+		for i := range x {
+			x[i] /= complex(float64(n), 0)
+		}
+	}
+}
+
+// denoise is the pipeline stage of Denoise.
+type denoise struct {
+	opts DenoiseOptions
+}
+
+func newDenoise(opts json.RawMessage) (Stage, error) {
+	var s denoise
+	if err := json.Unmarshal(opts, &s.opts); err != nil {
+		return nil, err
+	}
+	if s.opts.Reduction < 0 || s.opts.Threshold < 0 {
+		return nil, fmt.Errorf("reduction and threshold must be positive")
+	}
+	return s, nil
+}
+
+func (s denoise) Process(a *Audio) error {
+	return Denoise(a, s.opts)
+}
@@ -0,0 +1,183 @@
+package audio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DownloadOptions configures Download.
+type DownloadOptions struct {
+	// Timeout bounds the whole download; 0 means no limit. A download read
+	// as the audio is streamed takes as long as the audio plays.
+	Timeout time.Duration
+	// Retries is how many times in a row a failed request is retried. A
+	// download that breaks off is resumed where it stopped, as servers may
+	// drop a connection read slowly.
+	Retries int
+}
+
+// download streams a remote object, such as an HTTP(S) response body,
+// reopening it with a Range request when the connection fails.
+type download struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	url    string
+	opts   DownloadOptions
+	// request opens the object from offset on.
+	request func(ctx context.Context, offset int64) (io.ReadCloser, error)
+
+	body     io.ReadCloser
+	read     int64
+	failures int
+}
+
+// Download returns a reader of the audio at an http:// or https:// URL that
+// yields it as it downloads, without buffering the whole file. The first
+// request is made before Download returns, so an unreachable URL fails
+// early.
+func Download(ctx context.Context, url string, opts DownloadOptions) (io.ReadCloser, error) {
+	return startDownload(ctx, url, opts, func(ctx context.Context, offset int64) (io.ReadCloser, error) {
+		return httpRequest(ctx, url, offset)
+	})
+}
+
+// startDownload opens the download of url with request.
+func startDownload(ctx context.Context, url string, opts DownloadOptions, request func(context.Context, int64) (io.ReadCloser, error)) (*download, error) {
+	d := &download{url: url, opts: opts, request: request}
+	if opts.Timeout > 0 {
+		d.ctx, d.cancel = context.WithTimeout(ctx, opts.Timeout)
+	} else {
+		d.ctx, d.cancel = context.WithCancel(ctx)
+	}
+	if err := d.open(); err != nil {
+		d.cancel()
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *download) Read(p []byte) (int, error) {
+	for {
+		if d.body == nil {
+			if err := d.open(); err != nil {
+				return 0, err
+			}
+		}
+		n, err := d.body.Read(p)
+		d.read += int64(n)
+		if n > 0 {
+			d.failures = 0
+		}
+		if err == nil || err == io.EOF {
+			return n, err
+		}
+		d.body.Close()
+		d.body = nil
+		if err := d.retry(err); err != nil {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+func (d *download) Close() error {
+	d.cancel()
+	if d.body != nil {
+		return d.body.Close()
+	}
+	return nil
+}
+
+// open requests the audio from the current position, retrying failed
+// requests.
+func (d *download) open() error {
+	for {
+		body, err := d.request(d.ctx, d.read)
+		if err == nil {
+			d.body = body
+			return nil
+		}
+		// Client errors will not go away by retrying
+		var status interface{ permanent() bool }
+		if errors.As(err, &status) && status.permanent() {
+			return fmt.Errorf("failed to download %s: %w", d.url, err)
+		}
+		if err := d.retry(err); err != nil {
+			return err
+		}
+	}
+}
+
+// retry reports err once the retries are used up, and otherwise waits
+// before the next attempt.
+func (d *download) retry(err error) error {
+	if ctxErr := d.ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("failed to download %s: %w", d.url, ctxErr)
+	}
+	if d.failures >= d.opts.Retries {
+		return fmt.Errorf("failed to download %s: %w", d.url, err)
+	}
+	d.failures++
+	log.Printf("Download of %s failed at byte %d, retrying (%d/%d): %v", d.url, d.read, d.failures, d.opts.Retries, err)
+	select {
+	case <-d.ctx.Done():
+		return fmt.Errorf("failed to download %s: %w", d.url, d.ctx.Err())
+	case <-time.After(time.Duration(d.failures) * time.Second):
+		return nil
+	}
+}
+
+// httpRequest opens the response body of url from offset on.
+func httpRequest(ctx context.Context, url string, offset int64) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		return resp.Body, nil
+	case resp.StatusCode == http.StatusOK:
+		// A server ignoring the Range header sends everything again
+		if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		return resp.Body, nil
+	default:
+		resp.Body.Close()
+		return nil, statusError{code: resp.StatusCode, status: resp.Status}
+	}
+}
+
+// statusError is an unexpected HTTP response status.
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e statusError) Error() string { return "unexpected status " + e.status }
+
+func (e statusError) permanent() bool {
+	return permanentStatus(e.code)
+}
+
+// permanentStatus reports whether an HTTP status code is a client error
+// that will not go away by retrying.
+func permanentStatus(code int) bool {
+	return code >= 400 && code < 500 && code != http.StatusRequestTimeout && code != http.StatusTooManyRequests
+}
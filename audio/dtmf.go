@@ -0,0 +1,134 @@
+package audio
+
+import (
+	"math"
+	"time"
+)
+
+// Tone is a DTMF digit detected in the audio.
+type Tone struct {
+	Digit rune
+	Start time.Duration
+	End   time.Duration
+}
+
+var (
+	dtmfRows = []float64{697, 770, 852, 941}
+	dtmfCols = []float64{1209, 1336, 1477, 1633}
+	dtmfKeys = [4][4]rune{
+		{'1', '2', '3', 'A'},
+		{'4', '5', '6', 'B'},
+		{'7', '8', '9', 'C'},
+		{'*', '0', '#', 'D'},
+	}
+)
+
+const (
+	// dtmfBlock is the analysis window; dtmfMinBlocks consecutive windows
+	// make a tone, matching the 40ms minimum of ITU-T Q.24.
+	dtmfBlock     = 20 * time.Millisecond
+	dtmfMinBlocks = 2
+	// dtmfMinLevel is the minimum block energy, about -40 dBFS.
+	dtmfMinLevel = 1e-4
+)
+
+// DetectDTMF returns the DTMF digits in the audio, which recognizers drop, in
+// order of occurrence.
+func DetectDTMF(a *Audio) []Tone {
+	n := int(time.Duration(a.SampleRate) * dtmfBlock / time.Second)
+	if n == 0 {
+		return nil
+	}
+	samples := channelMix(a)
+
+	var (
+		tones   []Tone
+		current rune
+		start   int
+		blocks  int
+	)
+	end := func(block int) {
+		if current != 0 && blocks >= dtmfMinBlocks {
+			tones = append(tones, Tone{
+				Digit: current,
+				Start: time.Duration(start) * dtmfBlock,
+				End:   time.Duration(block) * dtmfBlock,
+			})
+		}
+		current, blocks = 0, 0
+	}
+	for b := 0; (b+1)*n <= len(samples); b++ {
+		digit := dtmfDigit(samples[b*n:(b+1)*n], a.SampleRate)
+		if digit != current {
+			end(b)
+			current, start = digit, b
+		}
+		if digit != 0 {
+			blocks++
+		}
+	}
+	end(len(samples) / n)
+	return tones
+}
+
+// dtmfDigit returns the digit the block carries, or 0 if it is not a clean
+// dual tone.
+func dtmfDigit(block []float32, rate int) rune {
+	var energy float64
+	for _, s := range block {
+		energy += float64(s) * float64(s)
+	}
+	energy /= float64(len(block))
+	if energy < dtmfMinLevel {
+		return 0
+	}
+
+	row, rowPower, rowNext := strongest(block, rate, dtmfRows)
+	col, colPower, colNext := strongest(block, rate, dtmfCols)
+	// Both tones must carry most of the energy, each clearly above the
+	// other candidates of its group
+	if (rowPower+colPower)/2 < 0.6*energy || rowNext > rowPower/4 || colNext > colPower/4 {
+		return 0
+	}
+	return dtmfKeys[row][col]
+}
+
+// strongest returns the index and power of the strongest of freqs in block
+// and the power of the runner-up.
+func strongest(block []float32, rate int, freqs []float64) (int, float64, float64) {
+	best, bestPower, next := 0, 0.0, 0.0
+	for i, f := range freqs {
+		p := goertzel(block, rate, f)
+		switch {
+		case p > bestPower:
+			best, bestPower, next = i, p, bestPower
+		case p > next:
+			next = p
+		}
+	}
+	return best, bestPower, next
+}
+
+// goertzel returns the power of freq in block, scaled so a sine of amplitude
+// A yields about A².
+func goertzel(block []float32, rate int, freq float64) float64 {
+	coeff := 2 * math.Cos(2*math.Pi*freq/float64(rate))
+	var s1, s2 float64
+	for _, x := range block {
+		s := float64(x) + coeff*s1 - s2
+		s2, s1 = s1, s
+	}
+	power := s1*s1 + s2*s2 - coeff*s1*s2
+	n := float64(len(block))
+	return power * 4 / (n * n)
+}
+
+// channelMix returns the audio downmixed to one channel.
+func channelMix(a *Audio) []float32 {
+	if a.Channels <= 1 {
+		return a.Samples
+	}
+	m := &Audio{SampleRate: a.SampleRate, Channels: a.Channels, Samples: a.Samples}
+	mono{}.Process(m)
+	return m.Samples
+}
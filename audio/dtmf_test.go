@@ -0,0 +1,121 @@
+package audio
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// segment is a stretch of test audio: the sum of sines at freqs, each at
+// amplitude amp, or silence without freqs.
+type segment struct {
+	freqs []float64
+	amp   float64
+	dur   time.Duration
+}
+
+// pause is a segment of silence.
+func pause(d time.Duration) segment {
+	return segment{dur: d}
+}
+
+// synth renders segments one after another as mono audio at rate.
+func synth(rate int, segments ...segment) *Audio {
+	a := &Audio{SampleRate: rate, Channels: 1}
+	for _, s := range segments {
+		n := int(time.Duration(rate) * s.dur / time.Second)
+		for i := range n {
+			var v float64
+			for _, f := range s.freqs {
+				v += s.amp * math.Sin(2*math.Pi*f*float64(i)/float64(rate))
+			}
+			a.Samples = append(a.Samples, float32(v))
+		}
+	}
+	return a
+}
+
+// key is a segment of the dual tone of a DTMF key, at -12 dBFS per tone.
+func key(digit rune, d time.Duration) segment {
+	for r, row := range dtmfKeys {
+		for c, k := range row {
+			if k == digit {
+				return segment{freqs: []float64{dtmfRows[r], dtmfCols[c]}, amp: 0.25, dur: d}
+			}
+		}
+	}
+	panic("not a DTMF key: " + string(digit))
+}
+
+func TestDetectDTMF(t *testing.T) {
+	ms := time.Millisecond
+	cases := []struct {
+		name  string
+		audio *Audio
+		want  []Tone
+	}{
+		{
+			name:  "one digit",
+			audio: synth(8000, pause(100*ms), key('5', 100*ms), pause(100*ms)),
+			want:  []Tone{{Digit: '5', Start: 100 * ms, End: 200 * ms}},
+		},
+		{
+			name: "every key",
+			audio: synth(16000,
+				key('1', 60*ms), pause(40*ms), key('2', 60*ms), pause(40*ms), key('3', 60*ms), pause(40*ms), key('A', 60*ms), pause(40*ms),
+				key('*', 60*ms), pause(40*ms), key('0', 60*ms), pause(40*ms), key('#', 60*ms), pause(40*ms), key('D', 60*ms)),
+			want: []Tone{
+				{Digit: '1', Start: 0, End: 60 * ms},
+				{Digit: '2', Start: 100 * ms, End: 160 * ms},
+				{Digit: '3', Start: 200 * ms, End: 260 * ms},
+				{Digit: 'A', Start: 300 * ms, End: 360 * ms},
+				{Digit: '*', Start: 400 * ms, End: 460 * ms},
+				{Digit: '0', Start: 500 * ms, End: 560 * ms},
+				{Digit: '#', Start: 600 * ms, End: 660 * ms},
+				{Digit: 'D', Start: 700 * ms, End: 760 * ms},
+			},
+		},
+		{
+			name:  "repeated digit",
+			audio: synth(8000, key('7', 60*ms), pause(40*ms), key('7', 60*ms)),
+			want: []Tone{
+				{Digit: '7', Start: 0, End: 60 * ms},
+				{Digit: '7', Start: 100 * ms, End: 160 * ms},
+			},
+		},
+		{
+			name:  "digit running to the end",
+			audio: synth(8000, pause(40*ms), key('9', 80*ms)),
+			want:  []Tone{{Digit: '9', Start: 40 * ms, End: 120 * ms}},
+		},
+		{
+			name:  "shorter than 40ms",
+			audio: synth(8000, pause(40*ms), key('5', 20*ms), pause(40*ms)),
+		},
+		{
+			name:  "too quiet",
+			audio: synth(8000, segment{freqs: []float64{770, 1336}, amp: 0.005, dur: 100 * ms}),
+		},
+		{
+			name:  "single tone",
+			audio: synth(8000, segment{freqs: []float64{770}, amp: 0.25, dur: 100 * ms}),
+		},
+		{
+			name:  "row tone with a tone off the keypad",
+			audio: synth(8000, segment{freqs: []float64{697, 1000}, amp: 0.25, dur: 100 * ms}),
+		},
+		{
+			name:  "silence",
+			audio: synth(8000, pause(time.Second)),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if diff := cmp.Diff(c.want, DetectDTMF(c.audio)); diff != "" {
+				t.Errorf("tones mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package audio
+
+import (
+	"math"
+	"time"
+)
+
+// Classifier labels non-speech audio events such as music or applause.
+type Classifier interface {
+	Classify(a *Audio) []Span
+}
+
+// Event labels produced by HeuristicClassifier.
+const (
+	EventSilence  = "silence"
+	EventMusic    = "music"
+	EventApplause = "applause"
+	EventLaughter = "laughter"
+)
+
+const (
+	// silenceLevel is the mean window energy below which audio is silent,
+	// about -50 dBFS.
+	silenceLevel = 1e-5
+	silenceMin   = 2 * time.Second
+	applauseMin  = time.Second
+	laughterMin  = time.Second
+)
+
+// eventBands are the centre frequencies used to estimate spectral flatness.
+var eventBands = []float64{150, 250, 400, 600, 900, 1300, 1800, 2500, 3200, 3800}
+
+// HeuristicClassifier labels silence, music, applause and laughter from
+// frame energy, zero crossings and spectral flatness. It needs no model and
+// is meant for coarse timeline labels, not precise detection.
+type HeuristicClassifier struct{}
+
+func (HeuristicClassifier) Classify(a *Audio) []Span {
+	n := int(time.Duration(a.SampleRate) * holdFrame / time.Second)
+	if n == 0 {
+		return nil
+	}
+	samples := channelMix(a)
+	frames := len(samples) / n
+	energy := make([]float64, frames)
+	zcr := make([]float64, frames)
+	flatness := make([]float64, frames)
+	for f := range frames {
+		block := samples[f*n : (f+1)*n]
+		var crossings int
+		for i, s := range block {
+			energy[f] += float64(s) * float64(s)
+			if i > 0 && (s >= 0) != (block[i-1] >= 0) {
+				crossings++
+			}
+		}
+		energy[f] /= float64(n)
+		zcr[f] = float64(crossings) / float64(n)
+		if energy[f] >= holdMinLevel {
+			flatness[f] = spectralFlatness(block, a.SampleRate)
+		}
+	}
+
+	music := musicSpans(energy, make([]bool, frames))
+	var spans []Span
+	per := int(musicWindow / holdFrame)
+	for w := 0; (w+1)*per <= frames; w++ {
+		start := time.Duration(w) * musicWindow
+		if inSpans(music, start) {
+			continue
+		}
+		label := classifyWindow(energy[w*per:(w+1)*per], zcr[w*per:(w+1)*per], flatness[w*per:(w+1)*per], a.SampleRate)
+		if label == "" {
+			continue
+		}
+		s := Span{Kind: label, Start: start, End: start + musicWindow}
+		if n := len(spans); n > 0 && spans[n-1].Kind == label && spans[n-1].End == s.Start {
+			spans[n-1].End = s.End
+			continue
+		}
+		spans = append(spans, s)
+	}
+
+	var long []Span
+	for _, s := range spans {
+		if s.End-s.Start >= minEventDuration(s.Kind) {
+			long = append(long, s)
+		}
+	}
+	for i := range music {
+		music[i].Kind = EventMusic
+	}
+	return MergeSpans(music, long)
+}
+
+// classifyWindow labels one window of frame features, or returns "" for
+// speech and anything else unrecognized.
+func classifyWindow(energy, zcr, flatness []float64, rate int) string {
+	var mean, meanZCR, meanFlat float64
+	active := 0
+	for i, e := range energy {
+		mean += e
+		if e >= holdMinLevel {
+			active++
+			meanZCR += zcr[i]
+			meanFlat += flatness[i]
+		}
+	}
+	mean /= float64(len(energy))
+	if mean < silenceLevel {
+		return EventSilence
+	}
+	if active == 0 {
+		return ""
+	}
+	meanZCR /= float64(active)
+	meanFlat /= float64(active)
+	// Zero crossing rates are compared as if sampled at 16kHz
+	meanZCR *= float64(rate) / 16000
+
+	// Applause is dense broadband noise: sustained, flat and noisy
+	if active >= len(energy)*9/10 && meanFlat > 0.5 && meanZCR > 0.25 {
+		return EventApplause
+	}
+	// Laughter is a train of breathy bursts, faster and noisier than
+	// syllables
+	if meanFlat > 0.35 && meanZCR > 0.15 {
+		if peaks := energyPeaks(energy, mean); peaks >= 4 && peaks <= 9 {
+			return EventLaughter
+		}
+	}
+	return ""
+}
+
+// energyPeaks counts the bursts in a window: runs of frames above 1.5x the
+// mean energy.
+func energyPeaks(energy []float64, mean float64) int {
+	peaks := 0
+	above := false
+	for _, e := range energy {
+		if e > 1.5*mean {
+			if !above {
+				peaks++
+			}
+			above = true
+		} else if e < mean {
+			above = false
+		}
+	}
+	return peaks
+}
+
+// spectralFlatness is the ratio of the geometric to the arithmetic mean of
+// band powers: near 1 for noise, near 0 for tones and voiced speech.
+func spectralFlatness(block []float32, rate int) float64 {
+	var logSum, sum float64
+	bands := 0
+	for _, f := range eventBands {
+		if f >= float64(rate)/2 {
+			break
+		}
+		p := goertzel(block, rate, f) + 1e-12
+		logSum += math.Log(p)
+		sum += p
+		bands++
+	}
+	if bands == 0 {
+		return 0
+	}
+	return math.Exp(logSum/float64(bands)) / (sum / float64(bands))
+}
+
+func minEventDuration(kind string) time.Duration {
+	switch kind {
+	case EventSilence:
+		return silenceMin
+	case EventApplause:
+		return applauseMin
+	default:
+		return laughterMin
+	}
+}
+
+func inSpans(spans []Span, d time.Duration) bool {
+	for _, s := range spans {
+		if d >= s.Start && d < s.End {
+			return true
+		}
+	}
+	return false
+}
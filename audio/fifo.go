@@ -0,0 +1,160 @@
+package audio
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// IsFIFO reports whether path is a named pipe.
+func IsFIFO(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode()&os.ModeNamedPipe != 0
+}
+
+// OpenFIFO returns the WAV audio written to the named pipe at path as one
+// live stream. Unlike a file, the pipe's writer closing it does not end the
+// stream: the pipe is reopened for the next writer, whose own WAV header is
+// skipped, so producers can come and go. The stream ends once nothing has
+// been written for idle, if positive, or once it is closed.
+func OpenFIFO(path string, idle time.Duration) (io.ReadCloser, error) {
+	if !IsFIFO(path) {
+		return nil, fmt.Errorf("%s is not a named pipe", path)
+	}
+	pr, pw := io.Pipe()
+	f := &fifo{path: path, idle: idle, pr: pr, pw: pw, last: time.Now()}
+	go f.run()
+	if idle > 0 {
+		go f.watch()
+	}
+	return f, nil
+}
+
+// fifo copies the output of a named pipe's successive writers to pw.
+type fifo struct {
+	path string
+	idle time.Duration
+	pr   *io.PipeReader
+	pw   *io.PipeWriter
+
+	mu sync.Mutex
+	// file is the pipe opened for the current writer, if any.
+	file *os.File
+	// last is when audio last arrived.
+	last time.Time
+	done bool
+}
+
+func (f *fifo) run() {
+	buf := make([]byte, 32<<10)
+	for writers := 0; ; writers++ {
+		// Blocks until a writer opens the pipe
+		file, err := os.Open(f.path)
+		f.mu.Lock()
+		if f.done {
+			f.mu.Unlock()
+			if file != nil {
+				file.Close()
+			}
+			f.pw.Close()
+			return
+		}
+		if err != nil {
+			f.mu.Unlock()
+			f.pw.CloseWithError(fmt.Errorf("failed to open %s: %w", f.path, err))
+			return
+		}
+		f.file = file
+		f.mu.Unlock()
+
+		r := bufio.NewReader(file)
+		if writers > 0 {
+			// The stream already has its header
+			if head, _ := r.Peek(12); len(head) == 12 && string(head[0:4]) == "RIFF" && string(head[8:12]) == "WAVE" {
+				if _, _, err := readStreamHeader(r); err != nil {
+					file.Close()
+					f.pw.CloseWithError(fmt.Errorf("failed to read %s: %w", f.path, err))
+					return
+				}
+			}
+		}
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				f.mu.Lock()
+				f.last = time.Now()
+				f.mu.Unlock()
+				if _, err := f.pw.Write(buf[:n]); err != nil {
+					file.Close()
+					return
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+		file.Close()
+		f.mu.Lock()
+		f.file = nil
+		done := f.done
+		f.mu.Unlock()
+		if done {
+			f.pw.Close()
+			return
+		}
+	}
+}
+
+// watch ends the stream once no audio has arrived for idle.
+func (f *fifo) watch() {
+	ticker := time.NewTicker(min(f.idle/4, time.Second))
+	defer ticker.Stop()
+	for range ticker.C {
+		f.mu.Lock()
+		idle := time.Since(f.last) >= f.idle
+		done := f.done
+		f.mu.Unlock()
+		if done {
+			return
+		}
+		if idle {
+			f.stop()
+			return
+		}
+	}
+}
+
+// stop ends the stream after the audio already read, unblocking run whether
+// it is reading or waiting for a writer.
+func (f *fifo) stop() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.done {
+		return
+	}
+	f.done = true
+	if f.file != nil {
+		f.file.Close()
+		return
+	}
+	f.pw.Close()
+	// Opening the pipe for writing releases run waiting for a writer
+	if w, err := os.OpenFile(f.path, os.O_WRONLY|syscall.O_NONBLOCK, 0); err == nil {
+		w.Close()
+	}
+}
+
+func (f *fifo) Read(p []byte) (int, error) {
+	return f.pr.Read(p)
+}
+
+// Close ends the stream; the audio already read from the pipe can still be
+// read before io.EOF.
+func (f *fifo) Close() error {
+	f.stop()
+	return nil
+}
@@ -0,0 +1,114 @@
+package audio
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"testing"
+)
+
+// The fuzz tests cover the parsers of untrusted audio, such as uploads to
+// the server. go test runs them on their seeds; go test -fuzz FuzzDecodeWAV
+// explores further, adding failing inputs to testdata/fuzz.
+
+// tone returns frames of a 440 Hz tone at rate with channels.
+func tone(rate, channels, frames int) *Audio {
+	a := &Audio{SampleRate: rate, Channels: channels, Samples: make([]float32, frames*channels)}
+	for i := range a.Samples {
+		a.Samples[i] = float32(0.5 * math.Sin(2*math.Pi*440*float64(i/channels)/float64(rate)))
+	}
+	return a
+}
+
+// FuzzDecodeWAV decodes WAV data and checks that the audio survives a round
+// trip through 16-bit PCM.
+func FuzzDecodeWAV(f *testing.F) {
+	f.Add(tone(16000, 1, 160).WAV())
+	f.Add(tone(8000, 2, 80).WAV())
+	f.Add(tone(48000, 1, 0).WAV())
+	f.Add(StreamHeader(16000, 1))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		a, err := DecodeWAV(data)
+		if err != nil {
+			return
+		}
+		if a.Channels <= 0 || a.SampleRate <= 0 {
+			t.Fatalf("decoded %d channels at %d Hz", a.Channels, a.SampleRate)
+		}
+		b, err := DecodeWAV(a.WAV())
+		if err != nil {
+			t.Fatalf("failed to decode re-encoded audio: %v", err)
+		}
+		if b.Channels != a.Channels || b.SampleRate != a.SampleRate || len(b.Samples) != len(a.Samples) {
+			t.Fatalf("re-encoded audio has %d samples of %d channels at %d Hz, not %d of %d at %d Hz",
+				len(b.Samples), b.Channels, b.SampleRate, len(a.Samples), a.Channels, a.SampleRate)
+		}
+	})
+}
+
+// FuzzStreamHeader reads data as a live WAV stream, as Resample does.
+func FuzzStreamHeader(f *testing.F) {
+	f.Add(append(StreamHeader(8000, 2), tone(8000, 2, 80).WAV()[44:]...))
+	f.Add(tone(44100, 1, 441).WAV())
+	f.Add(StreamHeader(16000, 1))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r, err := Resample(bytes.NewReader(data), ResampleOptions{Rate: 16000, Mono: true})
+		if err != nil {
+			return
+		}
+		out, err := io.ReadAll(r)
+		if err != nil {
+			return
+		}
+		if len(out) < 44 {
+			t.Fatalf("resampled stream of %d bytes has no header", len(out))
+		}
+	})
+}
+
+// FuzzDecodeCompressed decodes data in the format its magic bytes announce,
+// as uploads of MP3, Ogg Vorbis or FLAC are.
+func FuzzDecodeCompressed(f *testing.F) {
+	f.Add([]byte("fLaC\x00\x00\x00\x22\x10\x00\x10\x00"))
+	f.Add([]byte("ID3\x04\x00\x00\x00\x00\x00\x00"))
+	f.Add([]byte{0xFF, 0xFB, 0x90, 0x64, 0x00, 0x00, 0x00, 0x00})
+	f.Add([]byte("OggS\x00\x02\x00\x00\x00\x00\x00\x00\x00\x00\x01vorbis\x00\x00\x00\x00\x01"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		format := DetectFormat(data[:min(len(data), 64)], "")
+		if format == "" || format == FormatWAV {
+			return
+		}
+		wav, err := DecodeToWAV(data, format)
+		if err != nil {
+			return
+		}
+		if _, err := DecodeWAV(wav); err != nil {
+			t.Fatalf("decoded %s is not valid WAV: %v", format, err)
+		}
+	})
+}
+
+// FuzzOpus decodes data as a sequence of Opus packets, each prefixed with
+// its length byte, as a WebRTC client streaming to the server sends them.
+func FuzzOpus(f *testing.F) {
+	f.Add([]byte{3, 0xF8, 0xFF, 0xFE})
+	f.Add([]byte{3, 0x08, 0x0B, 0xE4, 2, 0x48, 0x00})
+	f.Add([]byte{0})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		d, err := NewOpusDecoder(OpusRate, 2, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for len(data) > 0 {
+			n := min(int(data[0]), len(data)-1)
+			pcm, err := d.Decode(data[1 : 1+n])
+			data = data[1+n:]
+			if err != nil {
+				continue
+			}
+			if len(pcm)%(2*d.Channels()) != 0 {
+				t.Fatalf("decoded %d bytes, not whole frames", len(pcm))
+			}
+		}
+	})
+}
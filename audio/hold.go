@@ -0,0 +1,213 @@
+package audio
+
+import (
+	"math"
+	"time"
+)
+
+// Span is a stretch of non-speech audio, such as ringback or hold music.
+type Span struct {
+	Kind  string
+	Start time.Duration
+	End   time.Duration
+}
+
+const (
+	holdFrame = 20 * time.Millisecond
+	// Ringback bursts separated by up to ringbackGap belong to one ringing
+	// cadence; bursts must last ringbackMinTone.
+	ringbackMinTone = 700 * time.Millisecond
+	ringbackGap     = 4500 * time.Millisecond
+	// Music is detected in musicWindow windows over musicMin at least.
+	musicWindow = time.Second
+	musicMin    = 5 * time.Second
+	// holdMinLevel is the minimum frame energy, about -45 dBFS.
+	holdMinLevel = 3e-5
+)
+
+// ringbackTones are the dual tones of the North American (440+480 Hz) and
+// European/UK (425, 400+450 Hz) ringback signals.
+var ringbackTones = [][]float64{{440, 480}, {400, 450}, {425}}
+
+// DetectHold returns the spans of ringback tones and hold music in the audio,
+// in order.
+func DetectHold(a *Audio) []Span {
+	n := int(time.Duration(a.SampleRate) * holdFrame / time.Second)
+	if n == 0 {
+		return nil
+	}
+	samples := channelMix(a)
+	frames := len(samples) / n
+	energy := make([]float64, frames)
+	ringing := make([]bool, frames)
+	for f := range frames {
+		block := samples[f*n : (f+1)*n]
+		for _, s := range block {
+			energy[f] += float64(s) * float64(s)
+		}
+		energy[f] /= float64(n)
+		ringing[f] = energy[f] >= holdMinLevel && isRingback(block, a.SampleRate, energy[f])
+	}
+
+	ringback := ringbackSpans(ringing)
+	music := musicSpans(energy, ringing)
+	return MergeSpans(ringback, music)
+}
+
+func isRingback(block []float32, rate int, energy float64) bool {
+	for _, tones := range ringbackTones {
+		var power float64
+		for _, f := range tones {
+			power += goertzel(block, rate, f)
+		}
+		if power/2 >= 0.7*energy {
+			return true
+		}
+	}
+	return false
+}
+
+// ringbackSpans groups ringing frames into bursts and bursts into cadences.
+func ringbackSpans(ringing []bool) []Span {
+	var spans []Span
+	minFrames := int(ringbackMinTone / holdFrame)
+	gapFrames := int(ringbackGap / holdFrame)
+	for f := 0; f < len(ringing); {
+		if !ringing[f] {
+			f++
+			continue
+		}
+		start := f
+		for f < len(ringing) && ringing[f] {
+			f++
+		}
+		if f-start < minFrames {
+			continue
+		}
+		s := Span{Kind: "ringback", Start: time.Duration(start) * holdFrame, End: time.Duration(f) * holdFrame}
+		if n := len(spans); n > 0 && s.Start-spans[n-1].End <= time.Duration(gapFrames)*holdFrame {
+			spans[n-1].End = s.End
+			continue
+		}
+		spans = append(spans, s)
+	}
+	return spans
+}
+
+// musicSpans finds windows of continuous, evenly sustained energy: speech
+// pauses between syllables and phrases, music does not.
+func musicSpans(energy []float64, ringing []bool) []Span {
+	per := int(musicWindow / holdFrame)
+	var spans []Span
+	for w := 0; (w+1)*per <= len(energy); w++ {
+		frames := energy[w*per : (w+1)*per]
+		active, rings := 0, 0
+		var mean float64
+		for i, e := range frames {
+			if e >= holdMinLevel {
+				active++
+			}
+			if ringing[w*per+i] {
+				rings++
+			}
+			mean += e
+		}
+		mean /= float64(per)
+		var variance float64
+		for _, e := range frames {
+			variance += (e - mean) * (e - mean)
+		}
+		cv := math.Sqrt(variance/float64(per)) / mean
+		if active < per*95/100 || cv > 0.6 || rings > per/2 {
+			continue
+		}
+		s := Span{Kind: "hold_music", Start: time.Duration(w) * musicWindow, End: time.Duration(w+1) * musicWindow}
+		if n := len(spans); n > 0 && spans[n-1].End == s.Start {
+			spans[n-1].End = s.End
+			continue
+		}
+		spans = append(spans, s)
+	}
+	var long []Span
+	for _, s := range spans {
+		if s.End-s.Start >= musicMin {
+			long = append(long, s)
+		}
+	}
+	return long
+}
+
+// MergeSpans interleaves two ordered span lists, trimming overlaps in favour of
+// the earlier span.
+func MergeSpans(a, b []Span) []Span {
+	var out []Span
+	for len(a) > 0 || len(b) > 0 {
+		var s Span
+		if len(b) == 0 || (len(a) > 0 && a[0].Start <= b[0].Start) {
+			s, a = a[0], a[1:]
+		} else {
+			s, b = b[0], b[1:]
+		}
+		if n := len(out); n > 0 && s.Start < out[n-1].End {
+			s.Start = out[n-1].End
+		}
+		if s.End > s.Start {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// Timeline maps offsets in audio with spans cut out back to the original.
+// A nil Timeline maps every offset to itself.
+type Timeline struct {
+	cuts []cut
+}
+
+// cut is a removed span: its position in the cut audio and its length.
+type cut struct {
+	at, length time.Duration
+}
+
+// Original returns the offset in the original audio of d in the cut audio.
+func (t *Timeline) Original(d time.Duration) time.Duration {
+	if t == nil {
+		return d
+	}
+	orig := d
+	for _, c := range t.cuts {
+		if d < c.at {
+			break
+		}
+		orig += c.length
+	}
+	return orig
+}
+
+// Cut removes the spans from the audio and returns the timeline mapping the
+// remaining audio back to the original.
+func Cut(a *Audio, spans []Span) *Timeline {
+	t := &Timeline{}
+	frameAt := func(d time.Duration) int {
+		return min(int(d*time.Duration(a.SampleRate)/time.Second), a.Frames())
+	}
+	var (
+		out     []float32
+		next    int
+		removed time.Duration
+	)
+	for _, s := range spans {
+		start, end := max(frameAt(s.Start), next), frameAt(s.End)
+		if end <= start {
+			continue
+		}
+		out = append(out, a.Samples[next*a.Channels:start*a.Channels]...)
+		next = end
+		length := time.Duration(end-start) * time.Second / time.Duration(a.SampleRate)
+		t.cuts = append(t.cuts, cut{at: s.Start - removed, length: length})
+		removed += length
+	}
+	out = append(out, a.Samples[next*a.Channels:]...)
+	a.Samples = out
+	return t
+}
@@ -0,0 +1,139 @@
+package audio
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// music is a segment of a sustained C major chord, as of hold music.
+func music(d time.Duration) segment {
+	return segment{freqs: []float64{261.63, 329.63, 392}, amp: 0.1, dur: d}
+}
+
+// ringing is a segment of a ringback tone of the given frequencies.
+func ringing(d time.Duration, freqs ...float64) segment {
+	return segment{freqs: freqs, amp: 0.1, dur: d}
+}
+
+func TestDetectHold(t *testing.T) {
+	s := time.Second
+	ms := time.Millisecond
+	cases := []struct {
+		name  string
+		audio *Audio
+		want  []Span
+	}{
+		{
+			name:  "North American ringback cadence",
+			audio: synth(8000, ringing(2*s, 440, 480), pause(4*s), ringing(2*s, 440, 480), pause(s)),
+			want:  []Span{{Kind: "ringback", Start: 0, End: 8 * s}},
+		},
+		{
+			name:  "tone bursts shorter than 700ms",
+			audio: synth(8000, pause(s), ringing(400*ms, 400, 450), pause(200*ms), ringing(400*ms, 400, 450), pause(2*s)),
+		},
+		{
+			name:  "European ringback",
+			audio: synth(8000, pause(s), ringing(s, 425), pause(4*s), ringing(s, 425)),
+			want:  []Span{{Kind: "ringback", Start: s, End: 7 * s}},
+		},
+		{
+			name:  "ringback bursts too far apart",
+			audio: synth(8000, ringing(s, 425), pause(5*s), ringing(s, 425)),
+			want: []Span{
+				{Kind: "ringback", Start: 0, End: s},
+				{Kind: "ringback", Start: 6 * s, End: 7 * s},
+			},
+		},
+		{
+			name:  "hold music",
+			audio: synth(16000, pause(s), music(6*s), pause(s)),
+			want:  []Span{{Kind: "hold_music", Start: s, End: 7 * s}},
+		},
+		{
+			name:  "music shorter than 5s",
+			audio: synth(16000, music(4*s), pause(s)),
+		},
+		{
+			name: "speech-like bursts",
+			audio: synth(16000, func() []segment {
+				var syllables []segment
+				for range 20 {
+					syllables = append(syllables, music(200*ms), pause(100*ms))
+				}
+				return syllables
+			}()...),
+		},
+		{
+			name:  "ringback then hold music",
+			audio: synth(8000, ringing(2*s, 440, 480), music(6*s)),
+			want: []Span{
+				{Kind: "ringback", Start: 0, End: 2 * s},
+				{Kind: "hold_music", Start: 2 * s, End: 8 * s},
+			},
+		},
+		{
+			name:  "silence",
+			audio: synth(8000, pause(10*s)),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if diff := cmp.Diff(c.want, DetectHold(c.audio)); diff != "" {
+				t.Errorf("spans mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMergeSpans(t *testing.T) {
+	s := time.Second
+	a := []Span{{Kind: "ringback", Start: 0, End: 3 * s}, {Kind: "ringback", Start: 10 * s, End: 12 * s}}
+	b := []Span{{Kind: "hold_music", Start: 2 * s, End: 8 * s}, {Kind: "hold_music", Start: 10 * s, End: 11 * s}}
+	want := []Span{
+		{Kind: "ringback", Start: 0, End: 3 * s},
+		{Kind: "hold_music", Start: 3 * s, End: 8 * s},
+		{Kind: "ringback", Start: 10 * s, End: 12 * s},
+	}
+	if diff := cmp.Diff(want, MergeSpans(a, b)); diff != "" {
+		t.Errorf("spans mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// TestCut cuts spans out of audio whose samples count its frames, and maps
+// offsets in the remaining audio back.
+func TestCut(t *testing.T) {
+	s := time.Second
+	a := &Audio{SampleRate: 10, Channels: 2}
+	for i := range 100 {
+		a.Samples = append(a.Samples, float32(i), float32(i))
+	}
+	timeline := Cut(a, []Span{{Start: 2 * s, End: 3 * s}, {Start: 5 * s, End: 7 * s}, {Start: 9500 * time.Millisecond, End: 20 * s}})
+
+	var want []float32
+	for _, r := range [][2]int{{0, 20}, {30, 50}, {70, 95}} {
+		for i := r[0]; i < r[1]; i++ {
+			want = append(want, float32(i), float32(i))
+		}
+	}
+	if diff := cmp.Diff(want, a.Samples); diff != "" {
+		t.Errorf("samples mismatch (-want +got):\n%s", diff)
+	}
+	for d, orig := range map[time.Duration]time.Duration{
+		0:                       0,
+		1900 * time.Millisecond: 1900 * time.Millisecond,
+		2 * s:                   3 * s,
+		3500 * time.Millisecond: 4500 * time.Millisecond,
+		4 * s:                   7 * s,
+		6 * s:                   9 * s,
+	} {
+		if got := timeline.Original(d); got != orig {
+			t.Errorf("Original(%s) = %s, want %s", d, got, orig)
+		}
+	}
+	if got := (*Timeline)(nil).Original(s); got != s {
+		t.Errorf("nil Original(1s) = %s, want 1s", got)
+	}
+}
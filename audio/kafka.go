@@ -0,0 +1,253 @@
+package audio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// kafkaBacklog is how many frames of a session are buffered for it to read.
+// A session further behind, or not accepted in time, is ended so that it does
+// not hold up the sessions of other keys; its later frames start a new one.
+const kafkaBacklog = 256
+
+// KafkaOptions configures a KafkaConsumer.
+type KafkaOptions struct {
+	// GroupID is the consumer group, which shares the topic's partitions
+	// between consumers and tracks their offsets; it defaults to "stt".
+	GroupID string
+	// Rate and Channels describe the 16-bit PCM frames; they default to
+	// 16000 and 1.
+	Rate     int
+	Channels int
+	// Idle ends a session after this long without frames; it defaults to
+	// 30s.
+	Idle time.Duration
+}
+
+// KafkaConsumer consumes audio frames from a Kafka topic, keyed by session
+// ID, and hands out each session's frames as a stream. A message with an
+// empty value ends its session.
+type KafkaConsumer struct {
+	reader *kafka.Reader
+	opts   KafkaOptions
+
+	accepted chan *KafkaStream
+	cancel   context.CancelFunc
+	// done is closed once consuming has stopped, with err set.
+	done chan struct{}
+	err  error
+}
+
+// ConsumeKafka starts consuming the topic of a kafka://broker[,broker...]/topic
+// URI. Offsets are committed as frames are handed to their sessions.
+func ConsumeKafka(uri string, opts KafkaOptions) (*KafkaConsumer, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid kafka source: %w", err)
+	}
+	topic := strings.TrimPrefix(u.Path, "/")
+	if u.Scheme != "kafka" || u.Host == "" || topic == "" {
+		return nil, fmt.Errorf("invalid kafka source %q: expected kafka://broker/topic", uri)
+	}
+	if opts.GroupID == "" {
+		opts.GroupID = "stt"
+	}
+	if opts.Rate <= 0 {
+		opts.Rate = 16000
+	}
+	if opts.Channels <= 0 {
+		opts.Channels = 1
+	}
+	if opts.Idle <= 0 {
+		opts.Idle = 30 * time.Second
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &KafkaConsumer{
+		reader: kafka.NewReader(kafka.ReaderConfig{
+			Brokers: strings.Split(u.Host, ","),
+			Topic:   topic,
+			GroupID: opts.GroupID,
+			// Committing every frame would add a round trip per frame
+			CommitInterval: time.Second,
+		}),
+		opts:     opts,
+		accepted: make(chan *KafkaStream),
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	go c.consume(ctx)
+	return c, nil
+}
+
+// consume routes messages to their sessions until ctx is done or the
+// reader fails, then ends every session.
+func (c *KafkaConsumer) consume(ctx context.Context) {
+	type fetched struct {
+		msg kafka.Message
+		err error
+	}
+	messages := make(chan fetched)
+	go func() {
+		for {
+			msg, err := c.reader.ReadMessage(ctx)
+			select {
+			case messages <- fetched{msg, err}:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	streams := map[string]*KafkaStream{}
+	end := func(id string) {
+		close(streams[id].frames)
+		delete(streams, id)
+	}
+	ticker := time.NewTicker(min(c.opts.Idle/4, time.Second))
+	defer ticker.Stop()
+	defer func() {
+		for id := range streams {
+			end(id)
+		}
+		close(c.done)
+	}()
+	// unaccepted are the sessions started but not yet handed out by Accept,
+	// oldest first; their frames are buffered meanwhile
+	var unaccepted []*KafkaStream
+	for {
+		var (
+			accept chan<- *KafkaStream
+			next   *KafkaStream
+		)
+		if len(unaccepted) > 0 {
+			accept, next = c.accepted, unaccepted[0]
+		}
+		select {
+		case <-ctx.Done():
+			c.err = ctx.Err()
+			return
+		case accept <- next:
+			unaccepted = unaccepted[1:]
+		case <-ticker.C:
+			for id, s := range streams {
+				if time.Since(s.last) >= c.opts.Idle {
+					end(id)
+				}
+			}
+		case f := <-messages:
+			if f.err != nil {
+				c.err = f.err
+				if !errors.Is(f.err, context.Canceled) {
+					c.err = fmt.Errorf("failed to read from kafka: %w", f.err)
+				}
+				return
+			}
+			id := string(f.msg.Key)
+			s := streams[id]
+			if s != nil && s.abandoned() {
+				end(id)
+				s = nil
+			}
+			if len(f.msg.Value) == 0 {
+				if s != nil {
+					end(id)
+				}
+				continue
+			}
+			if s == nil {
+				s = &KafkaStream{
+					ID:      id,
+					frames:  make(chan []byte, kafkaBacklog),
+					closed:  make(chan struct{}),
+					pending: StreamHeader(c.opts.Rate, c.opts.Channels),
+				}
+				unaccepted = append(unaccepted, s)
+				streams[id] = s
+			}
+			s.last = time.Now()
+			// A session that is not keeping up must not hold up the others
+			select {
+			case s.frames <- f.msg.Value:
+			default:
+				log.Printf("Kafka session %s fell %d frames behind; ending it", id, cap(s.frames))
+				end(id)
+			}
+		}
+	}
+}
+
+// Accept waits for the next session, started by the first frame of a key
+// without a session in progress.
+func (c *KafkaConsumer) Accept() (*KafkaStream, error) {
+	select {
+	case s := <-c.accepted:
+		return s, nil
+	case <-c.done:
+		return nil, c.err
+	}
+}
+
+// Close stops consuming and ends the sessions in progress, whose frames
+// already consumed are still read.
+func (c *KafkaConsumer) Close() error {
+	c.cancel()
+	<-c.done
+	return c.reader.Close()
+}
+
+// KafkaStream is the audio of one session key, read as a WAV stream until
+// its session ends.
+type KafkaStream struct {
+	// ID is the message key of the session's frames.
+	ID string
+
+	frames chan []byte
+	closed chan struct{}
+	once   sync.Once
+	// last is when the latest frame arrived, owned by the consumer.
+	last time.Time
+	// pending is audio read but not yet returned, starting with the WAV
+	// header.
+	pending []byte
+}
+
+// Read returns the session's audio, or io.EOF once it ends.
+func (s *KafkaStream) Read(p []byte) (int, error) {
+	for len(s.pending) == 0 {
+		frame, ok := <-s.frames
+		if !ok {
+			return 0, io.EOF
+		}
+		s.pending = frame
+	}
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+// Close abandons the session; its further frames start a new one.
+func (s *KafkaStream) Close() error {
+	s.once.Do(func() { close(s.closed) })
+	return nil
+}
+
+func (s *KafkaStream) abandoned() bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,304 @@
+package audio
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+// NormalizeOptions configures Normalize.
+type NormalizeOptions struct {
+	// Target is the level to normalize to: the integrated loudness in LUFS
+	// per ITU-R BS.1770, or with RMS the RMS level in dBFS. It defaults to
+	// -23, the EBU R128 broadcast level.
+	Target float64 `json:"target"`
+	// RMS measures the level as the RMS of all samples instead of gated,
+	// K-weighted loudness, which pauses and rumble do not drag down.
+	RMS bool `json:"rms"`
+	// TruePeak is the ceiling in dBTP that a limiter keeps peaks between
+	// samples under after the gain, so amplified audio does not clip. It
+	// defaults to -1 and must be negative.
+	TruePeak float64 `json:"true_peak"`
+	// NoLimiter leaves peaks to clip instead.
+	NoLimiter bool `json:"no_limiter"`
+}
+
+const (
+	// loudnessBlock is the gating block of BS.1770, overlapping by 75%.
+	loudnessBlock = 400 * time.Millisecond
+	// Blocks quieter than loudnessAbsGate LUFS, or loudnessRelGate LU below
+	// the loudness of the louder blocks, are left out of the measurement.
+	loudnessAbsGate = -70.0
+	loudnessRelGate = -10.0
+	// truePeakOversampling is the oversampling BS.1770 measures true peaks
+	// with.
+	truePeakOversampling = 4
+	// The limiter looks limiterLookahead ahead to lower the gain before a
+	// peak and recovers over limiterRelease.
+	limiterLookahead = 5 * time.Millisecond
+	limiterRelease   = 50 * time.Millisecond
+)
+
+// Normalize amplifies or attenuates the audio to opts.Target and limits its
+// true peaks, to help recognition of very quiet recordings. It returns the
+// gain applied in dB; audio too quiet to measure is left unchanged.
+func Normalize(a *Audio, opts NormalizeOptions) (float64, error) {
+	if opts.Target == 0 {
+		opts.Target = -23
+	}
+	if opts.TruePeak == 0 {
+		opts.TruePeak = -1
+	}
+	if opts.Target >= 0 || opts.TruePeak > 0 {
+		return 0, fmt.Errorf("normalization target %g and true peak %g are not below full scale", opts.Target, opts.TruePeak)
+	}
+	level := Loudness(a)
+	if opts.RMS {
+		level = rmsLevel(a.Samples)
+	}
+	if math.IsInf(level, -1) {
+		return 0, nil
+	}
+	gain := opts.Target - level
+	f := float32(math.Pow(10, gain/20))
+	for i := range a.Samples {
+		a.Samples[i] *= f
+	}
+	if !opts.NoLimiter {
+		limitPeaks(a, math.Pow(10, opts.TruePeak/20))
+	}
+	return gain, nil
+}
+
+// Loudness returns the integrated loudness of the audio in LUFS, measured
+// per ITU-R BS.1770 with every channel weighted equally, or -Inf for audio
+// too short or quiet to measure.
+func Loudness(a *Audio) float64 {
+	block := int(time.Duration(a.SampleRate) * loudnessBlock / time.Second)
+	frames := a.Frames()
+	if block == 0 || frames < block {
+		return math.Inf(-1)
+	}
+	weighted := kWeight(a)
+	// The mean square of every 100ms step, summed over channels, from which
+	// the overlapping blocks are added up
+	step := block / 4
+	steps := make([]float64, frames/step)
+	for s := range steps {
+		for _, x := range weighted[s*step*a.Channels : (s+1)*step*a.Channels] {
+			steps[s] += float64(x) * float64(x)
+		}
+		steps[s] /= float64(step)
+	}
+	var blocks []float64
+	for b := 0; b+4 <= len(steps); b++ {
+		z := (steps[b] + steps[b+1] + steps[b+2] + steps[b+3]) / 4
+		if blockLoudness(z) > loudnessAbsGate {
+			blocks = append(blocks, z)
+		}
+	}
+	gated := func(threshold float64) (float64, bool) {
+		var sum float64
+		n := 0
+		for _, z := range blocks {
+			if blockLoudness(z) > threshold {
+				sum += z
+				n++
+			}
+		}
+		return sum / float64(n), n > 0
+	}
+	mean, ok := gated(loudnessAbsGate)
+	if !ok {
+		return math.Inf(-1)
+	}
+	mean, _ = gated(blockLoudness(mean) + loudnessRelGate)
+	return blockLoudness(mean)
+}
+
+func blockLoudness(z float64) float64 {
+	return -0.691 + 10*math.Log10(z)
+}
+
+// kWeight returns the samples through the K-weighting filter of BS.1770, a
+// high shelf modelling the head followed by a high-pass, designed for the
+// audio's sample rate.
+func kWeight(a *Audio) []float32 {
+	rate := float64(a.SampleRate)
+	// Shelf
+	k := math.Tan(math.Pi * 1681.974450955533 / rate)
+	q := 0.7071752369554196
+	vh := math.Pow(10, 3.999843853973347/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+	shelf := biquad{
+		b0: (vh + vb*k/q + k*k) / a0, b1: 2 * (k*k - vh) / a0, b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0, a2: (1 - k/q + k*k) / a0,
+	}
+	// High-pass
+	k = math.Tan(math.Pi * 38.13547087602444 / rate)
+	q = 0.5003270373238773
+	a0 = 1 + k/q + k*k
+	highPass := biquad{b0: 1, b1: -2, b2: 1, a1: 2 * (k*k - 1) / a0, a2: (1 - k/q + k*k) / a0}
+
+	out := make([]float32, len(a.Samples))
+	for c := range a.Channels {
+		s1, s2 := shelf, highPass
+		for i := c; i < len(a.Samples); i += a.Channels {
+			out[i] = float32(s2.filter(s1.filter(float64(a.Samples[i]))))
+		}
+	}
+	return out
+}
+
+// biquad is a second-order IIR filter in direct form I.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+func (f *biquad) filter(x float64) float64 {
+	y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+	f.x2, f.x1 = f.x1, x
+	f.y2, f.y1 = f.y1, y
+	return y
+}
+
+// rmsLevel returns the RMS level of samples in dBFS.
+func rmsLevel(samples []float32) float64 {
+	if len(samples) == 0 {
+		return math.Inf(-1)
+	}
+	var sum float64
+	for _, s := range samples {
+		sum += float64(s) * float64(s)
+	}
+	if sum == 0 {
+		return math.Inf(-1)
+	}
+	return 10 * math.Log10(sum/float64(len(samples)))
+}
+
+// TruePeak returns the highest peak of the audio in dBTP, estimated between
+// samples by 4x oversampling as BS.1770 does.
+func TruePeak(a *Audio) float64 {
+	var peak float64
+	for _, p := range truePeaks(a) {
+		peak = max(peak, p)
+	}
+	return 20 * math.Log10(peak)
+}
+
+// truePeaks returns, for every frame, the highest absolute value of any
+// channel from it to the next frame, interpolated at the positions of 4x
+// oversampling.
+func truePeaks(a *Audio) []float64 {
+	frames := a.Frames()
+	peaks := make([]float64, frames)
+	// taps[p] interpolates at p+1 quarters past a frame from the frames
+	// around it
+	const half = 8
+	interp := &resampler{half: half, cutoff: 0.95}
+	var taps [truePeakOversampling - 1][2 * half]float64
+	for p := range taps {
+		frac := float64(p+1) / truePeakOversampling
+		for k := range taps[p] {
+			taps[p][k] = interp.kernel(frac - float64(k-half+1))
+		}
+	}
+	at := func(f, c int) float64 {
+		if f < 0 || f >= frames {
+			return 0
+		}
+		return float64(a.Samples[f*a.Channels+c])
+	}
+	for f := range frames {
+		for c := range a.Channels {
+			peak := math.Abs(at(f, c))
+			for p := range taps {
+				var sum float64
+				for k, t := range taps[p] {
+					sum += at(f+k-half+1, c) * t
+				}
+				peak = max(peak, math.Abs(sum))
+			}
+			peaks[f] = max(peaks[f], peak)
+		}
+	}
+	return peaks
+}
+
+// limitPeaks lowers the gain around true peaks above ceiling, a linear
+// amplitude. The gain a peak needs is reached limiterLookahead before it
+// and recovers over limiterRelease after it, so the limiting is smooth.
+func limitPeaks(a *Audio, ceiling float64) {
+	peaks := truePeaks(a)
+	needed := make([]float64, len(peaks))
+	over := false
+	for i, p := range peaks {
+		needed[i] = 1
+		if p > ceiling {
+			needed[i], over = ceiling/p, true
+		}
+	}
+	if !over {
+		return
+	}
+	look := max(1, int(time.Duration(a.SampleRate)*limiterLookahead/time.Second))
+	// The lowest gain needed within the lookahead, averaged over it, is at
+	// most the gain every peak needs when it arrives
+	lowest := make([]float64, len(needed))
+	// window holds the frames ahead whose needed gain is lower than that of
+	// every later one, so its front is the lowest
+	var window []int
+	for i := len(needed) - 1; i >= 0; i-- {
+		for len(window) > 0 && needed[window[len(window)-1]] >= needed[i] {
+			window = window[:len(window)-1]
+		}
+		window = append(window, i)
+		if window[0] >= i+look {
+			window = window[1:]
+		}
+		lowest[i] = needed[window[0]]
+	}
+	release := math.Exp(-1 / (limiterRelease.Seconds() * float64(a.SampleRate)))
+	var sum float64
+	gain := 1.0
+	for i := range lowest {
+		sum += lowest[i]
+		if i >= look {
+			sum -= lowest[i-look]
+		}
+		smooth := sum / float64(min(i+1, look))
+		if smooth < gain {
+			gain = smooth
+		} else {
+			gain = smooth + (gain-smooth)*release
+		}
+		for c := range a.Channels {
+			a.Samples[i*a.Channels+c] *= float32(gain)
+		}
+	}
+}
+
+// normalize is the pipeline stage of Normalize.
+type normalize struct {
+	opts NormalizeOptions
+}
+
+func newNormalize(opts json.RawMessage) (Stage, error) {
+	var s normalize
+	if err := json.Unmarshal(opts, &s.opts); err != nil {
+		return nil, err
+	}
+	if s.opts.Target > 0 || s.opts.TruePeak > 0 {
+		return nil, fmt.Errorf("target and true_peak must be below 0")
+	}
+	return s, nil
+}
+
+func (s normalize) Process(a *Audio) error {
+	_, err := Normalize(a, s.opts)
+	return err
+}
@@ -0,0 +1,83 @@
+package audio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/pion/opus"
+)
+
+// OpusRate is the RTP clock rate of Opus, and the rate RTP Opus audio is
+// decoded at.
+const OpusRate = 48000
+
+// DefaultOpusFrame is the frame duration WebRTC and most VoIP endpoints
+// send Opus in.
+const DefaultOpusFrame = 20 * time.Millisecond
+
+// opusMaxFrame is the longest audio an Opus packet can carry.
+const opusMaxFrame = 120 * time.Millisecond
+
+// OpusDecoder decodes a sequence of Opus packets, as sent by browsers over
+// WebRTC and by VoIP endpoints, to 16-bit little-endian PCM. Packets must be
+// decoded in order, since each continues from the decoder state left by the
+// previous one.
+type OpusDecoder struct {
+	dec      opus.Decoder
+	rate     int
+	channels int
+	frame    time.Duration
+	samples  []int16
+}
+
+// NewOpusDecoder returns a decoder producing audio at rate (8, 12, 16, 24
+// or 48 kHz) with 1 or 2 channels, whatever the packets were encoded at.
+// frame is the duration of the packets, 2.5ms to 120ms, which is the
+// silence put in place of a lost one; 0 means DefaultOpusFrame.
+func NewOpusDecoder(rate, channels int, frame time.Duration) (*OpusDecoder, error) {
+	if frame == 0 {
+		frame = DefaultOpusFrame
+	}
+	switch frame {
+	case 2500 * time.Microsecond, 5 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond,
+		40 * time.Millisecond, 60 * time.Millisecond, 80 * time.Millisecond, 100 * time.Millisecond, opusMaxFrame:
+	default:
+		return nil, fmt.Errorf("invalid Opus frame duration %s", frame)
+	}
+	dec, err := opus.NewDecoderWithOutput(rate, channels)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Opus output format %d Hz with %d channels: %w", rate, channels, err)
+	}
+	return &OpusDecoder{
+		dec:      dec,
+		rate:     rate,
+		channels: channels,
+		frame:    frame,
+		samples:  make([]int16, int(opusMaxFrame.Seconds()*float64(rate))*channels),
+	}, nil
+}
+
+// Rate returns the sample rate of the decoded audio.
+func (d *OpusDecoder) Rate() int { return d.rate }
+
+// Channels returns the channel count of the decoded audio.
+func (d *OpusDecoder) Channels() int { return d.channels }
+
+// Decode decodes an Opus packet. An empty packet stands for a lost one and
+// decodes to a frame of silence.
+func (d *OpusDecoder) Decode(packet []byte) (pcm []byte, err error) {
+	defer recoverDecoder("Opus", &err)
+	if len(packet) == 0 {
+		return make([]byte, int(d.frame.Seconds()*float64(d.rate))*d.channels*2), nil
+	}
+	n, err := d.dec.DecodeToInt16(packet, d.samples)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode Opus packet: %w", err)
+	}
+	pcm = make([]byte, 0, n*d.channels*2)
+	for _, s := range d.samples[:n*d.channels] {
+		pcm = binary.LittleEndian.AppendUint16(pcm, uint16(s))
+	}
+	return pcm, nil
+}
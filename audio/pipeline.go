@@ -0,0 +1,153 @@
+package audio
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Stage is one step of a preprocessing pipeline, transforming audio in place.
+type Stage interface {
+	Process(a *Audio) error
+}
+
+// StageFactory creates a stage from its options in the config file.
+type StageFactory func(opts json.RawMessage) (Stage, error)
+
+var stages = map[string]StageFactory{}
+
+// Register makes a stage available to pipeline configs under name.
+func Register(name string, f StageFactory) {
+	stages[name] = f
+}
+
+// Config is the audio pipeline config file: named profiles of stages.
+type Config struct {
+	Profiles map[string]Profile `json:"profiles"`
+}
+
+// Profile declares a pipeline. The input is decoded before the stages run
+// and encoded as 16-bit PCM WAV after them; chunking then splits the result
+// for streaming.
+type Profile struct {
+	Stages []StageConfig `json:"stages"`
+	Chunk  ChunkConfig   `json:"chunk"`
+}
+
+// StageConfig names a stage and carries its options, for example
+// {"stage": "resample", "rate": 16000}. Disabled stages are skipped.
+type StageConfig struct {
+	Stage    string
+	Disabled bool
+	Options  json.RawMessage
+}
+
+func (c *StageConfig) UnmarshalJSON(b []byte) error {
+	var head struct {
+		Stage    string `json:"stage"`
+		Disabled bool   `json:"disabled"`
+	}
+	if err := json.Unmarshal(b, &head); err != nil {
+		return err
+	}
+	c.Stage, c.Disabled, c.Options = head.Stage, head.Disabled, slices.Clone(b)
+	return nil
+}
+
+// ChunkConfig sets how processed audio is split into stream requests.
+type ChunkConfig struct {
+	Bytes    int      `json:"bytes"`
+	Interval Duration `json:"interval"`
+}
+
+// Duration is a time.Duration written as a string such as "100ms" in
+// config files.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// LoadConfig reads a pipeline config file.
+func LoadConfig(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audio config: %w", err)
+	}
+	var c Config
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse audio config %s: %w", path, err)
+	}
+	return &c, nil
+}
+
+// Pipeline builds the pipeline of the named profile.
+func (c *Config) Pipeline(profile string) (*Pipeline, error) {
+	p, ok := c.Profiles[profile]
+	if !ok {
+		return nil, fmt.Errorf("unknown audio profile %q", profile)
+	}
+	return p.Pipeline()
+}
+
+// Pipeline is a built chain of stages.
+type Pipeline struct {
+	Stages []Stage
+	Chunk  ChunkConfig
+}
+
+// Pipeline builds the profile's enabled stages.
+func (p Profile) Pipeline() (*Pipeline, error) {
+	pl := &Pipeline{Chunk: p.Chunk}
+	for _, sc := range p.Stages {
+		if sc.Disabled {
+			continue
+		}
+		f, ok := stages[sc.Stage]
+		if !ok {
+			return nil, fmt.Errorf("unknown audio stage %q (available: %s)", sc.Stage, stageNames())
+		}
+		s, err := f(sc.Options)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s stage: %w", sc.Stage, err)
+		}
+		pl.Stages = append(pl.Stages, s)
+	}
+	return pl, nil
+}
+
+// Process decodes WAV data, runs every stage and returns the result as WAV.
+func (p *Pipeline) Process(wav []byte) ([]byte, error) {
+	a, err := DecodeWAV(wav)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode audio: %w", err)
+	}
+	for _, s := range p.Stages {
+		if err := s.Process(a); err != nil {
+			return nil, err
+		}
+	}
+	return a.WAV(), nil
+}
+
+func stageNames() string {
+	var names []string
+	for name := range stages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,250 @@
+package audio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Resampling qualities. Linear interpolation is cheapest but aliases when
+// downsampling; the windowed-sinc qualities low-pass filter the audio first,
+// high with a sharper filter at several times the cost of medium.
+const (
+	ResampleLinear = "linear"
+	ResampleMedium = "medium"
+	ResampleHigh   = "high"
+)
+
+// ResampleOptions configures Resample and ResampleWAV.
+type ResampleOptions struct {
+	// Rate is the sample rate to downsample to. Audio at or below it keeps
+	// its rate.
+	Rate int
+	// Quality is ResampleLinear, ResampleMedium or ResampleHigh; empty
+	// selects ResampleMedium.
+	Quality string
+	// Mono downmixes every channel into one.
+	Mono bool
+}
+
+func (o ResampleOptions) validate() error {
+	if o.Rate < 1000 {
+		return fmt.Errorf("resample rate %d is too low", o.Rate)
+	}
+	switch o.Quality {
+	case "", ResampleLinear, ResampleMedium, ResampleHigh:
+		return nil
+	}
+	return fmt.Errorf("unknown resample quality %q: expected %s, %s or %s", o.Quality, ResampleLinear, ResampleMedium, ResampleHigh)
+}
+
+// ResampleWAV downsamples WAV data to opts.Rate, returning it as 16-bit PCM
+// WAV.
+func ResampleWAV(data []byte, opts ResampleOptions) ([]byte, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	a, err := DecodeWAV(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode audio: %w", err)
+	}
+	if opts.Mono {
+		mono{}.Process(a)
+	}
+	if a.SampleRate > opts.Rate {
+		if err := (resample{Rate: opts.Rate, Quality: opts.Quality}).Process(a); err != nil {
+			return nil, err
+		}
+	}
+	return a.WAV(), nil
+}
+
+// Resample returns a live 16-bit PCM WAV stream of the 16-bit PCM WAV stream
+// read from r, downsampled to opts.Rate as it is read, for cutting the
+// bandwidth and cost of 44.1 or 48 kHz audio before streaming it.
+func Resample(r io.Reader, opts ResampleOptions) (io.Reader, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(r)
+	rate, channels, err := readStreamHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	outChannels := channels
+	if opts.Mono {
+		outChannels = 1
+	}
+	outRate := min(rate, opts.Rate)
+	var rs *resampler
+	if rate > opts.Rate {
+		rs = newResampler(rate, opts.Rate, outChannels, opts.Quality)
+	}
+	sr := &sampleReader{r: br, channels: channels, process: func(samples []float32, final bool) []float32 {
+		if opts.Mono && channels > 1 {
+			a := &Audio{Channels: channels, Samples: samples}
+			mono{}.Process(a)
+			samples = a.Samples
+		}
+		if rs != nil {
+			samples = rs.process(samples, final)
+		}
+		return samples
+	}}
+	return io.MultiReader(bytes.NewReader(StreamHeader(outRate, outChannels)), sr), nil
+}
+
+// sampleReader converts the 16-bit PCM read from r to samples, runs them
+// through process and returns the result as 16-bit PCM.
+type sampleReader struct {
+	r        io.Reader
+	channels int
+	// process transforms interleaved samples as they are read; final is set
+	// with the last of them.
+	process func(samples []float32, final bool) []float32
+
+	buf []byte
+	// partial holds the bytes of a sample frame not yet read in full.
+	partial []byte
+	pending []byte
+	eof     bool
+}
+
+func (s *sampleReader) Read(p []byte) (int, error) {
+	for len(s.pending) == 0 {
+		if s.eof {
+			return 0, io.EOF
+		}
+		if s.buf == nil {
+			s.buf = make([]byte, 16<<10)
+		}
+		n, err := s.r.Read(s.buf)
+		data := append(s.partial, s.buf[:n]...)
+		frame := 2 * s.channels
+		whole := len(data) / frame * frame
+		s.partial = append([]byte(nil), data[whole:]...)
+		samples := make([]float32, whole/2)
+		for i := range samples {
+			samples[i] = float32(int16(binary.LittleEndian.Uint16(data[2*i:]))) / (1 << 15)
+		}
+		if err == io.EOF {
+			s.eof = true
+		} else if err != nil {
+			return 0, err
+		}
+		s.pending = appendFloatPCM(s.pending[:0], s.process(samples, s.eof))
+	}
+	n := copy(p, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+// resampler converts the sample rate of interleaved audio delivered in
+// pieces, keeping the input a filter still needs between them.
+type resampler struct {
+	channels int
+	// from and to are the input and output rates.
+	from, to int
+	// half is the filter's reach in input frames on either side of an
+	// output frame, and cutoff its cutoff relative to the input Nyquist
+	// frequency; linear interpolation has no cutoff.
+	half   int
+	cutoff float64
+
+	// hist holds the input from frame base on, and pos is the input
+	// position of output frame next.
+	hist []float32
+	base int
+	next int
+	pos  float64
+}
+
+func newResampler(from, to, channels int, quality string) *resampler {
+	r := &resampler{channels: channels, from: from, to: to, half: 1}
+	var crossings int
+	switch quality {
+	case ResampleLinear:
+		return r
+	case ResampleHigh:
+		crossings, r.cutoff = 32, 0.95
+	default:
+		crossings, r.cutoff = 8, 0.9
+	}
+	// Filtering below the output's Nyquist frequency keeps what is above
+	// it from aliasing into the speech band
+	r.cutoff *= min(1, float64(to)/float64(from))
+	r.half = int(math.Ceil(float64(crossings) / r.cutoff))
+	return r
+}
+
+// process returns the output frames the input so far allows, all of them if
+// final.
+func (r *resampler) process(in []float32, final bool) []float32 {
+	r.hist = append(r.hist, in...)
+	frames := r.base + len(r.hist)/r.channels
+	var out []float32
+	for {
+		// Past the end of the input only the final call pads with silence
+		if !final && int(r.pos)+r.half >= frames || final && r.next*r.from >= frames*r.to {
+			break
+		}
+		out = r.frame(out, frames)
+		// Positions are worked out from the frame rather than by adding up
+		// steps, whose rounding errors would add up to extra frames
+		r.next++
+		r.pos = float64(r.next*r.from) / float64(r.to)
+	}
+	// Drop the input no later output frame reaches
+	if drop := int(r.pos) - r.half - r.base; drop > 0 {
+		drop = min(drop, len(r.hist)/r.channels)
+		r.hist = append(r.hist[:0], r.hist[drop*r.channels:]...)
+		r.base += drop
+	}
+	return out
+}
+
+// frame appends the output frame at r.pos of the input of the given length.
+func (r *resampler) frame(out []float32, frames int) []float32 {
+	at := func(j, c int) float32 {
+		if j < r.base || j >= frames {
+			return 0
+		}
+		return r.hist[(j-r.base)*r.channels+c]
+	}
+	j := int(r.pos)
+	if r.cutoff == 0 {
+		frac := float32(r.pos - float64(j))
+		next := min(j+1, frames-1)
+		for c := range r.channels {
+			x0, x1 := at(j, c), at(next, c)
+			out = append(out, x0+(x1-x0)*frac)
+		}
+		return out
+	}
+	for c := range r.channels {
+		var sum float64
+		for k := j - r.half + 1; k <= j+r.half; k++ {
+			sum += float64(at(k, c)) * r.kernel(r.pos-float64(k))
+		}
+		out = append(out, float32(sum))
+	}
+	return out
+}
+
+// kernel is the Blackman-windowed sinc low-pass filter at distance d input
+// frames.
+func (r *resampler) kernel(d float64) float64 {
+	if math.Abs(d) >= float64(r.half) {
+		return 0
+	}
+	x := r.cutoff * d
+	sinc := 1.0
+	if x != 0 {
+		sinc = math.Sin(math.Pi*x) / (math.Pi * x)
+	}
+	w := 0.42 + 0.5*math.Cos(math.Pi*d/float64(r.half)) + 0.08*math.Cos(2*math.Pi*d/float64(r.half))
+	return r.cutoff * sinc * w
+}
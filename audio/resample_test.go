@@ -0,0 +1,123 @@
+package audio
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// level returns the amplitude of freq in the middle of the audio, away from
+// the edges a filter pads with silence.
+func level(a *Audio, freq float64) float64 {
+	samples := channelMix(a)
+	return math.Sqrt(goertzel(samples[len(samples)/4:len(samples)*3/4], a.SampleRate, freq))
+}
+
+func TestResampleWAV(t *testing.T) {
+	qualities := []string{ResampleLinear, ResampleMedium, ResampleHigh}
+	cases := []struct {
+		name     string
+		rate     int
+		channels int
+		// freq is a tone at amplitude 0.5 in the input, and alias where it
+		// lands at 16 kHz; an alias of 0 means the tone is kept.
+		freq, alias float64
+		// maxAlias is the highest amplitude of the alias per filtering
+		// quality; linear interpolation lets it through.
+		maxAlias map[string]float64
+	}{
+		{name: "48 kHz speech band", rate: 48000, channels: 1, freq: 1000},
+		{name: "44.1 kHz speech band", rate: 44100, channels: 2, freq: 3000},
+		{name: "8 kHz kept", rate: 8000, channels: 1, freq: 1000},
+		{
+			name: "48 kHz above 8 kHz", rate: 48000, channels: 1, freq: 12000, alias: 4000,
+			maxAlias: map[string]float64{ResampleMedium: 0.001, ResampleHigh: 0.0001},
+		},
+		{
+			name: "44.1 kHz above 8 kHz", rate: 44100, channels: 1, freq: 10000, alias: 6000,
+			maxAlias: map[string]float64{ResampleMedium: 0.001, ResampleHigh: 0.0001},
+		},
+	}
+	for _, c := range cases {
+		for _, quality := range qualities {
+			t.Run(c.name+"/"+quality, func(t *testing.T) {
+				in := synth(c.rate, segment{freqs: []float64{c.freq}, amp: 0.5, dur: time.Second})
+				if c.channels == 2 {
+					stereo := &Audio{SampleRate: in.SampleRate, Channels: 2}
+					for _, s := range in.Samples {
+						stereo.Samples = append(stereo.Samples, s, s)
+					}
+					in = stereo
+				}
+				wav, err := ResampleWAV(in.WAV(), ResampleOptions{Rate: 16000, Quality: quality, Mono: true})
+				if err != nil {
+					t.Fatal(err)
+				}
+				out, err := DecodeWAV(wav)
+				if err != nil {
+					t.Fatal(err)
+				}
+				rate := min(c.rate, 16000)
+				if out.SampleRate != rate || out.Channels != 1 || out.Frames() != rate {
+					t.Fatalf("got %d frames of %d channels at %d Hz, want %d of 1 at %d Hz", out.Frames(), out.Channels, out.SampleRate, rate, rate)
+				}
+				if c.alias == 0 {
+					if got := level(out, c.freq); math.Abs(got-0.5) > 0.02 {
+						t.Errorf("%g Hz tone has amplitude %.3f, want 0.5", c.freq, got)
+					}
+					return
+				}
+				got := level(out, c.alias)
+				if quality == ResampleLinear {
+					if got < 0.1 {
+						t.Errorf("%g Hz tone aliases to %g Hz with amplitude %.4f, want it let through", c.freq, c.alias, got)
+					}
+					return
+				}
+				if got > c.maxAlias[quality] {
+					t.Errorf("%g Hz tone aliases to %g Hz with amplitude %.4f, want at most %g", c.freq, c.alias, got, c.maxAlias[quality])
+				}
+			})
+		}
+	}
+}
+
+// TestResampleStream checks that resampling a stream read a byte at a time
+// yields the same audio as resampling the whole file.
+func TestResampleStream(t *testing.T) {
+	for _, quality := range []string{ResampleLinear, ResampleMedium, ResampleHigh} {
+		t.Run(quality, func(t *testing.T) {
+			in := tone(44100, 2, 4410).WAV()
+			opts := ResampleOptions{Rate: 16000, Quality: quality, Mono: true}
+			whole, err := ResampleWAV(in, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, err := Resample(iotest.OneByteReader(bytes.NewReader(in)), opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			streamed, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(streamed[:44], StreamHeader(16000, 1)) {
+				t.Errorf("stream header % x, want % x", streamed[:44], StreamHeader(16000, 1))
+			}
+			if !bytes.Equal(streamed[44:], whole[44:]) {
+				t.Errorf("streamed %d bytes of audio differ from the %d resampled whole", len(streamed)-44, len(whole)-44)
+			}
+		})
+	}
+}
+
+func TestResampleOptions(t *testing.T) {
+	for _, opts := range []ResampleOptions{{Rate: 500}, {Rate: 16000, Quality: "best"}} {
+		if _, err := ResampleWAV(tone(48000, 1, 480).WAV(), opts); err == nil {
+			t.Errorf("%+v was accepted", opts)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Static RTP payload types of the audio ListenRTP decodes (RFC 3551).
+const (
+	payloadPCMU      = 0
+	payloadPCMA      = 8
+	payloadL16Stereo = 10
+	payloadL16Mono   = 11
+)
+
+// RTPOptions configures ListenRTP.
+type RTPOptions struct {
+	// L16PayloadType is a dynamic payload type (96-127) carrying L16 audio
+	// at Rate and Channels, as negotiated in SDP; 0 means none. PCMU, PCMA
+	// and the static L16 payload types are always decoded.
+	L16PayloadType int
+	Rate           int
+	Channels       int
+
+	// OpusPayloadType is a dynamic payload type carrying Opus, as sent by
+	// WebRTC endpoints; 0 means none. Opus is decoded to 48 kHz mono.
+	OpusPayloadType int
+
+	// JitterDelay is how long a packet is held for earlier ones arriving out
+	// of order before they are given up as lost; it defaults to 60ms.
+	JitterDelay time.Duration
+	// IdleTimeout ends the audio once no packet has arrived for this long
+	// after the first; 0 means it only ends when closed.
+	IdleTimeout time.Duration
+}
+
+// rtpPacket is a received RTP packet with its payload decoded to 16-bit
+// little-endian PCM. Packets of payload types that are not audio, such as
+// DTMF events, have no samples but still fill their place in the sequence.
+// Opus payloads are only decoded once the packet is due, since each
+// continues from the previous one.
+type rtpPacket struct {
+	seq      uint16
+	ts       uint32
+	ssrc     uint32
+	pcm      []byte
+	opus     []byte
+	rate     int
+	channels int
+	arrived  time.Time
+}
+
+// rtpStream reorders RTP packets and writes their audio to a WAV stream.
+type rtpStream struct {
+	opts RTPOptions
+	buf  *captureBuffer
+
+	// pending are the packets waiting for earlier ones, by sequence number.
+	pending map[uint16]rtpPacket
+	// next is the sequence number of the packet due next and nextTS the
+	// timestamp its audio should start at, once started is set.
+	started bool
+	ssrc    uint32
+	next    uint16
+	nextTS  uint32
+	// rate and channels are the format of the WAV stream, taken from the
+	// first audio packet.
+	rate, channels int
+	skipped        bool
+	// opus decodes the Opus packets of the current sequence.
+	opus *OpusDecoder
+}
+
+// ListenRTP receives RTP packets carrying PCMU, PCMA, L16 or Opus audio on a
+// UDP address such as ":5004". The returned reader yields the audio as a
+// 16-bit PCM WAV stream of unknown length, in the format of the first audio
+// packet, once packets are reordered by sequence number; lost packets and
+// gaps such as DTMF events are filled with silence. Closing it stops
+// listening, after which the remaining audio is read before io.EOF.
+func ListenRTP(addr string, opts RTPOptions) (io.ReadCloser, error) {
+	if opts.JitterDelay <= 0 {
+		opts.JitterDelay = 60 * time.Millisecond
+	}
+	if opts.L16PayloadType != 0 && (opts.Rate <= 0 || opts.Channels <= 0) {
+		return nil, fmt.Errorf("L16 payload type %d needs a sample rate and channel count", opts.L16PayloadType)
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RTP address %q: %w", addr, err)
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for RTP: %w", err)
+	}
+	l := &rtpListener{conn: conn, rtpStream: newRTPStream(opts)}
+	go l.run()
+	return l, nil
+}
+
+// rtpListener receives RTP packets on a UDP socket.
+type rtpListener struct {
+	conn *net.UDPConn
+	*rtpStream
+}
+
+func (l *rtpListener) Close() error {
+	return l.conn.Close()
+}
+
+// run reads packets until the socket is closed or goes idle, then ends the
+// stream.
+func (l *rtpListener) run() {
+	defer l.end()
+	packet := make([]byte, 65536)
+	var last time.Time
+	for {
+		// Held packets are released while waiting for the next one
+		l.conn.SetReadDeadline(time.Now().Add(l.opts.JitterDelay / 3))
+		n, err := l.conn.Read(packet)
+		now := time.Now()
+		switch {
+		case errors.Is(err, os.ErrDeadlineExceeded):
+			if l.opts.IdleTimeout > 0 && !last.IsZero() && now.Sub(last) > l.opts.IdleTimeout {
+				log.Printf("No RTP packets for %s, ending the stream", l.opts.IdleTimeout)
+				return
+			}
+		case err != nil:
+			return
+		default:
+			last = now
+			l.receive(packet[:n], now)
+		}
+		l.flush(now.Add(-l.opts.JitterDelay))
+	}
+}
+
+// newRTPStream returns a stream writing no WAV header until the first audio
+// packet gives the format.
+func newRTPStream(opts RTPOptions) *rtpStream {
+	buf := &captureBuffer{}
+	buf.cond = sync.NewCond(&buf.mu)
+	return &rtpStream{opts: opts, buf: buf, pending: map[uint16]rtpPacket{}}
+}
+
+func (r *rtpStream) Read(p []byte) (int, error) {
+	return r.buf.Read(p)
+}
+
+// receive holds the packet b until it is due.
+func (r *rtpStream) receive(b []byte, now time.Time) {
+	p, err := r.decode(b, now)
+	if err != nil {
+		log.Printf("Dropping RTP packet: %v", err)
+		return
+	}
+	r.add(p)
+}
+
+// restart writes the packets still held and starts a new sequence with the
+// next packet.
+func (r *rtpStream) restart() {
+	r.flush(time.Time{})
+	r.started = false
+	r.opus = nil
+}
+
+// end writes the packets still held and ends the WAV stream.
+func (r *rtpStream) end() {
+	r.flush(time.Time{})
+	r.buf.close()
+}
+
+// decode parses an RTP packet and decodes its payload.
+func (r *rtpStream) decode(b []byte, now time.Time) (rtpPacket, error) {
+	if len(b) < 12 || b[0]>>6 != 2 {
+		return rtpPacket{}, fmt.Errorf("not an RTP version 2 packet")
+	}
+	p := rtpPacket{
+		seq:     binary.BigEndian.Uint16(b[2:4]),
+		ts:      binary.BigEndian.Uint32(b[4:8]),
+		ssrc:    binary.BigEndian.Uint32(b[8:12]),
+		arrived: now,
+	}
+	header := 12 + 4*int(b[0]&0x0f)
+	if b[0]&0x10 != 0 && header+4 <= len(b) {
+		header += 4 + 4*int(binary.BigEndian.Uint16(b[header+2:header+4]))
+	}
+	if header > len(b) {
+		return rtpPacket{}, fmt.Errorf("truncated RTP header")
+	}
+	payload := b[header:]
+	if b[0]&0x20 != 0 && len(payload) > 0 {
+		payload = payload[:max(len(payload)-int(payload[len(payload)-1]), 0)]
+	}
+
+	switch pt := int(b[1] & 0x7f); {
+	case pt == payloadPCMU:
+		p.pcm, p.rate, p.channels = decodeG711(payload, ulawSample), 8000, 1
+	case pt == payloadPCMA:
+		p.pcm, p.rate, p.channels = decodeG711(payload, alawSample), 8000, 1
+	case pt == payloadL16Stereo:
+		p.pcm, p.rate, p.channels = decodeL16(payload), 44100, 2
+	case pt == payloadL16Mono:
+		p.pcm, p.rate, p.channels = decodeL16(payload), 44100, 1
+	case pt == r.opts.L16PayloadType:
+		p.pcm, p.rate, p.channels = decodeL16(payload), r.opts.Rate, r.opts.Channels
+	case pt == r.opts.OpusPayloadType && len(payload) > 0:
+		p.opus, p.rate, p.channels = bytes.Clone(payload), OpusRate, 1
+	}
+	return p, nil
+}
+
+// add holds a packet until it is due. A new SSRC, such as after a call
+// transfer, restarts the sequence.
+func (r *rtpStream) add(p rtpPacket) {
+	if r.started && p.ssrc != r.ssrc {
+		r.restart()
+	}
+	if !r.started {
+		r.started, r.ssrc, r.next = true, p.ssrc, p.seq
+	}
+	// Packets arriving after their place was given up are too late
+	if int16(p.seq-r.next) < 0 {
+		return
+	}
+	r.pending[p.seq] = p
+}
+
+// flush writes the packets that are due in sequence order. Once the oldest
+// held packet arrived before deadline, the packets missing ahead of it are
+// given up as lost; a zero deadline gives up all of them.
+func (r *rtpStream) flush(deadline time.Time) {
+	for len(r.pending) > 0 {
+		if p, ok := r.pending[r.next]; ok {
+			delete(r.pending, r.next)
+			r.write(p)
+			r.next++
+			continue
+		}
+		var oldest *rtpPacket
+		for _, p := range r.pending {
+			if oldest == nil || p.arrived.Before(oldest.arrived) {
+				oldest = &p
+			}
+		}
+		if !deadline.IsZero() && oldest.arrived.After(deadline) {
+			return
+		}
+		// Skip to the earliest packet held
+		first := oldest.seq
+		for seq := range r.pending {
+			if int16(seq-first) < 0 {
+				first = seq
+			}
+		}
+		r.next = first
+	}
+}
+
+// write appends the audio of p, preceded by silence for any audio missing
+// since the previous packet.
+func (r *rtpStream) write(p rtpPacket) {
+	if p.opus != nil {
+		if r.opus == nil {
+			r.opus, _ = NewOpusDecoder(OpusRate, 1, 0)
+		}
+		pcm, err := r.opus.Decode(p.opus)
+		if err != nil {
+			log.Printf("Dropping RTP packet: %v", err)
+			return
+		}
+		p.pcm = pcm
+	}
+	if len(p.pcm) == 0 {
+		return
+	}
+	if r.rate == 0 {
+		r.rate, r.channels = p.rate, p.channels
+		r.buf.write(wavHeader(r.rate, r.channels, math.MaxUint32))
+		r.nextTS = p.ts
+	}
+	if p.rate != r.rate || p.channels != r.channels {
+		if !r.skipped {
+			log.Printf("Dropping RTP audio at %d Hz with %d channels, the stream is %d Hz with %d channels", p.rate, p.channels, r.rate, r.channels)
+			r.skipped = true
+		}
+		return
+	}
+	// Gaps longer than a second are a timestamp jump rather than lost audio
+	if gap := int32(p.ts - r.nextTS); gap > 0 && int(gap) <= r.rate {
+		r.buf.write(make([]byte, int(gap)*r.channels*2))
+	}
+	r.buf.write(p.pcm)
+	r.nextTS = p.ts + uint32(len(p.pcm)/(2*r.channels))
+}
+
+// decodeL16 converts big-endian 16-bit samples to little-endian.
+func decodeL16(payload []byte) []byte {
+	pcm := make([]byte, len(payload)&^1)
+	for i := 0; i+1 < len(payload); i += 2 {
+		pcm[i], pcm[i+1] = payload[i+1], payload[i]
+	}
+	return pcm
+}
+
+// decodeG711 expands 8-bit G.711 samples to 16-bit little-endian PCM.
+func decodeG711(payload []byte, sample func(byte) int16) []byte {
+	pcm := make([]byte, 0, 2*len(payload))
+	for _, b := range payload {
+		pcm = binary.LittleEndian.AppendUint16(pcm, uint16(sample(b)))
+	}
+	return pcm
+}
+
+// DecodeMULaw expands G.711 μ-law audio, as carried by telephony streams, to
+// 16-bit little-endian PCM.
+func DecodeMULaw(payload []byte) []byte {
+	return decodeG711(payload, ulawSample)
+}
+
+// ulawSample decodes a G.711 μ-law sample.
+func ulawSample(b byte) int16 {
+	b = ^b
+	v := (int16(b&0x0f)<<3 + 0x84) << (b & 0x70 >> 4)
+	if b&0x80 != 0 {
+		return 0x84 - v
+	}
+	return v - 0x84
+}
+
+// alawSample decodes a G.711 A-law sample.
+func alawSample(b byte) int16 {
+	b ^= 0x55
+	v := int16(b&0x0f) << 4
+	switch exp := b & 0x70 >> 4; exp {
+	case 0:
+		v += 8
+	case 1:
+		v += 0x108
+	default:
+		v = (v + 0x108) << (exp - 1)
+	}
+	if b&0x80 != 0 {
+		return v
+	}
+	return -v
+}
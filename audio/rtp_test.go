@@ -0,0 +1,179 @@
+package audio
+
+import (
+	"encoding/binary"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// testPacket is an RTP packet of 8 kHz mono audio arriving at a time, in
+// milliseconds from the start of the stream.
+type testPacket struct {
+	seq  uint16
+	ts   uint32
+	ssrc uint32
+	at   int
+	// value fills the packet's samples, so the output shows which packets
+	// were written where; 0 makes a packet without audio, such as a DTMF
+	// event.
+	value int16
+}
+
+// packetSamples is the number of samples of every test packet.
+const packetSamples = 4
+
+// rtpTime is when the test streams start.
+var rtpTime = time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+
+func (p testPacket) packet() rtpPacket {
+	pkt := rtpPacket{seq: p.seq, ts: p.ts, ssrc: p.ssrc, rate: 8000, channels: 1,
+		arrived: rtpTime.Add(time.Duration(p.at) * time.Millisecond)}
+	if p.value != 0 {
+		for range packetSamples {
+			pkt.pcm = binary.LittleEndian.AppendUint16(pkt.pcm, uint16(p.value))
+		}
+	}
+	return pkt
+}
+
+// audioOf is the samples of a packet holding value.
+func audioOf(value int16) []int16 {
+	return slices.Repeat([]int16{value}, packetSamples)
+}
+
+// silence is n samples of silence.
+func silence(n int) []int16 {
+	return make([]int16, n)
+}
+
+// TestRTPStream feeds packets straight into an rtpStream, releasing the
+// packets due after each as ListenRTP does with a 60ms jitter delay, and
+// checks the audio written.
+func TestRTPStream(t *testing.T) {
+	cases := []struct {
+		name    string
+		packets []testPacket
+		want    []int16
+	}{
+		{
+			name: "in order",
+			packets: []testPacket{
+				{seq: 1, ts: 0, at: 0, value: 1},
+				{seq: 2, ts: 4, at: 20, value: 2},
+				{seq: 3, ts: 8, at: 40, value: 3},
+			},
+			want: slices.Concat(audioOf(1), audioOf(2), audioOf(3)),
+		},
+		{
+			name: "reordered within the jitter delay",
+			packets: []testPacket{
+				{seq: 1, ts: 0, at: 0, value: 1},
+				{seq: 3, ts: 8, at: 20, value: 3},
+				{seq: 2, ts: 4, at: 50, value: 2},
+				{seq: 4, ts: 12, at: 60, value: 4},
+			},
+			want: slices.Concat(audioOf(1), audioOf(2), audioOf(3), audioOf(4)),
+		},
+		{
+			name: "lost packet filled with silence",
+			packets: []testPacket{
+				{seq: 1, ts: 0, at: 0, value: 1},
+				{seq: 3, ts: 8, at: 20, value: 3},
+				{seq: 4, ts: 12, at: 100, value: 4},
+			},
+			want: slices.Concat(audioOf(1), silence(4), audioOf(3), audioOf(4)),
+		},
+		{
+			name: "packet arriving after it was given up",
+			packets: []testPacket{
+				{seq: 1, ts: 0, at: 0, value: 1},
+				{seq: 3, ts: 8, at: 20, value: 3},
+				{seq: 4, ts: 12, at: 100, value: 4},
+				{seq: 2, ts: 4, at: 110, value: 2},
+			},
+			want: slices.Concat(audioOf(1), silence(4), audioOf(3), audioOf(4)),
+		},
+		{
+			name: "lost packets at the end given up",
+			packets: []testPacket{
+				{seq: 1, ts: 0, at: 0, value: 1},
+				{seq: 4, ts: 12, at: 20, value: 4},
+			},
+			want: slices.Concat(audioOf(1), silence(8), audioOf(4)),
+		},
+		{
+			name: "packet without audio",
+			packets: []testPacket{
+				{seq: 1, ts: 0, at: 0, value: 1},
+				{seq: 2, ts: 4, at: 20},
+				{seq: 3, ts: 8, at: 40, value: 3},
+			},
+			want: slices.Concat(audioOf(1), silence(4), audioOf(3)),
+		},
+		{
+			name: "sequence wrapping around",
+			packets: []testPacket{
+				{seq: 65534, ts: 0, at: 0, value: 1},
+				{seq: 0, ts: 8, at: 20, value: 3},
+				{seq: 65535, ts: 4, at: 30, value: 2},
+			},
+			want: slices.Concat(audioOf(1), audioOf(2), audioOf(3)),
+		},
+		{
+			name: "timestamp jump",
+			packets: []testPacket{
+				{seq: 1, ts: 0, at: 0, value: 1},
+				{seq: 2, ts: 4 + 8001, at: 20, value: 2},
+			},
+			want: slices.Concat(audioOf(1), audioOf(2)),
+		},
+		{
+			name: "new SSRC",
+			packets: []testPacket{
+				{seq: 1, ts: 0, ssrc: 1, at: 0, value: 1},
+				{seq: 3, ts: 8, ssrc: 1, at: 20, value: 3},
+				{seq: 900, ts: 12, ssrc: 2, at: 30, value: 5},
+				{seq: 901, ts: 16, ssrc: 2, at: 40, value: 6},
+			},
+			want: slices.Concat(audioOf(1), silence(4), audioOf(3), audioOf(5), audioOf(6)),
+		},
+		{
+			name: "new SSRC with its own timestamps",
+			packets: []testPacket{
+				{seq: 1, ts: 0, ssrc: 1, at: 0, value: 1},
+				{seq: 7, ts: 90000, ssrc: 2, at: 20, value: 2},
+			},
+			want: slices.Concat(audioOf(1), audioOf(2)),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := newRTPStream(RTPOptions{})
+			for _, p := range c.packets {
+				pkt := p.packet()
+				r.add(pkt)
+				r.flush(pkt.arrived.Add(-60 * time.Millisecond))
+			}
+			r.end()
+
+			wav, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(wav) < 44 {
+				t.Fatalf("got %d bytes, not a WAV stream", len(wav))
+			}
+			got := make([]int16, (len(wav)-44)/2)
+			for i := range got {
+				got[i] = int16(binary.LittleEndian.Uint16(wav[44+2*i:]))
+			}
+			if diff := cmp.Diff(c.want, got); diff != "" {
+				t.Errorf("audio mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
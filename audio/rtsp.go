@@ -0,0 +1,440 @@
+package audio
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RTSPOptions configures DialRTSP.
+type RTSPOptions struct {
+	// Timeout bounds connecting, each request and the wait for the next
+	// packet; it defaults to 10s.
+	Timeout time.Duration
+	// Retries is how many times in a row reconnecting is attempted after the
+	// session drops, waiting a second longer each time.
+	Retries int
+}
+
+// rtspSource plays the audio track of an RTSP stream, reconnecting when the
+// session drops.
+type rtspSource struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	url    *url.URL
+	opts   RTSPOptions
+	*rtpStream
+
+	mu   sync.Mutex
+	conn *rtspConn
+}
+
+// DialRTSP plays the first PCMU, PCMA, L16 or Opus audio track of an
+// rtsp:// URL, such as an IP camera or media server, with RTP interleaved
+// over the RTSP connection. Credentials in the URL are sent with basic or
+// digest authentication. The returned reader yields the audio as a 16-bit
+// PCM WAV stream of unknown length, as for ListenRTP. A dropped session is
+// reconnected, with the gap left out of the audio. The session is set up
+// before DialRTSP returns, so an unreachable stream fails early; closing the
+// reader stops playing.
+func DialRTSP(ctx context.Context, rawURL string, opts RTSPOptions) (io.ReadCloser, error) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "rtsp" {
+		return nil, fmt.Errorf("invalid RTSP URL %q", rawURL)
+	}
+	s := &rtspSource{url: u, opts: opts, rtpStream: newRTPStream(RTPOptions{})}
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	if err := s.connect(); err != nil {
+		s.cancel()
+		return nil, err
+	}
+	go s.run()
+	return s, nil
+}
+
+func (s *rtspSource) Close() error {
+	s.cancel()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		return s.conn.Close()
+	}
+	return nil
+}
+
+// connect sets up and plays a new session.
+func (s *rtspSource) connect() error {
+	d := net.Dialer{Timeout: s.opts.Timeout}
+	host := s.url.Host
+	if s.url.Port() == "" {
+		host = net.JoinHostPort(s.url.Hostname(), "554")
+	}
+	nc, err := d.DialContext(s.ctx, "tcp", host)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", s.url.Redacted(), err)
+	}
+	c := newRTSPConn(nc, s.url, s.opts.Timeout)
+	s.mu.Lock()
+	if s.ctx.Err() != nil {
+		s.mu.Unlock()
+		nc.Close()
+		return s.ctx.Err()
+	}
+	s.conn = c
+	s.mu.Unlock()
+
+	track, err := c.setup()
+	if err != nil {
+		c.Close()
+		return fmt.Errorf("failed to play %s: %w", s.url.Redacted(), err)
+	}
+	s.play(track)
+	log.Printf("Playing %s audio at %d Hz from %s", track.encoding, track.rate, s.url.Redacted())
+	return nil
+}
+
+// play restarts the sequence of the stream for a new session of track,
+// decoding a dynamic L16 or Opus payload type as negotiated.
+func (s *rtspSource) play(t rtspTrack) {
+	s.restart()
+	s.rtpStream.opts.L16PayloadType, s.rtpStream.opts.OpusPayloadType = 0, 0
+	switch {
+	case t.encoding == "L16" && t.payloadType >= 96:
+		s.rtpStream.opts.L16PayloadType, s.rtpStream.opts.Rate, s.rtpStream.opts.Channels = t.payloadType, t.rate, t.channels
+	case t.encoding == "OPUS":
+		s.rtpStream.opts.OpusPayloadType = t.payloadType
+	}
+}
+
+// run reads packets, reconnecting until the retries are used up or the
+// source is closed, then ends the stream.
+func (s *rtspSource) run() {
+	defer s.end()
+	failures := 0
+	for {
+		received, err := s.read(s.conn)
+		s.conn.Close()
+		if s.ctx.Err() != nil {
+			return
+		}
+		// Only consecutive failures count against the retries
+		if received {
+			failures = 0
+		}
+		for {
+			if failures >= s.opts.Retries {
+				log.Printf("RTSP session of %s ended: %v", s.url.Redacted(), err)
+				return
+			}
+			failures++
+			log.Printf("RTSP session of %s dropped, reconnecting (%d/%d): %v", s.url.Redacted(), failures, s.opts.Retries, err)
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-time.After(time.Duration(failures) * time.Second):
+			}
+			if err = s.connect(); err == nil {
+				break
+			}
+			if s.ctx.Err() != nil {
+				return
+			}
+		}
+	}
+}
+
+// read passes the RTP packets of the session to the stream until the
+// connection fails, keeping the session alive. It reports whether any
+// packet was received.
+func (s *rtspSource) read(c *rtspConn) (bool, error) {
+	received := false
+	keepalive := time.Now()
+	for {
+		if time.Since(keepalive) > c.keepalive {
+			if err := c.send("GET_PARAMETER", c.base, nil); err != nil {
+				return received, err
+			}
+			keepalive = time.Now()
+		}
+		channel, packet, err := c.readFrame()
+		if err != nil {
+			return received, err
+		}
+		switch channel {
+		case -1:
+			// Responses to keepalives
+			if _, err := c.readResponse(); err != nil {
+				return received, err
+			}
+		case 0:
+			// TCP delivers packets in order, so none are waited for
+			received = true
+			now := time.Now()
+			s.receive(packet, now)
+			s.flush(now)
+		}
+	}
+}
+
+// rtspTrack is the audio track a session plays.
+type rtspTrack struct {
+	control     string
+	payloadType int
+	encoding    string
+	rate        int
+	channels    int
+}
+
+// rtspConn is an RTSP connection with RTP interleaved on channel 0.
+type rtspConn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+
+	// base is the URL requests are made for; the credentials are taken out
+	// of it.
+	base string
+	user *url.Userinfo
+	cseq int
+	// auth is the Authorization header once the server has challenged.
+	auth      func(method, uri string) string
+	session   string
+	keepalive time.Duration
+}
+
+func newRTSPConn(nc net.Conn, u *url.URL, timeout time.Duration) *rtspConn {
+	base := *u
+	base.User = nil
+	return &rtspConn{
+		conn:      nc,
+		r:         bufio.NewReader(nc),
+		timeout:   timeout,
+		base:      base.String(),
+		user:      u.User,
+		keepalive: 30 * time.Second,
+	}
+}
+
+func (c *rtspConn) Close() error {
+	return c.conn.Close()
+}
+
+// setup describes the stream, sets up its first supported audio track and
+// starts playing it.
+func (c *rtspConn) setup() (rtspTrack, error) {
+	resp, err := c.do("DESCRIBE", c.base, map[string]string{"Accept": "application/sdp"})
+	if err != nil {
+		return rtspTrack{}, err
+	}
+	base := c.base
+	if cb := resp.header.Get("Content-Base"); cb != "" {
+		base = cb
+	}
+	track, err := audioTrack(string(resp.body))
+	if err != nil {
+		return rtspTrack{}, err
+	}
+	uri := base
+	switch {
+	case strings.HasPrefix(track.control, "rtsp://"):
+		uri = track.control
+	case track.control != "" && track.control != "*":
+		uri = strings.TrimSuffix(base, "/") + "/" + track.control
+	}
+
+	resp, err = c.do("SETUP", uri, map[string]string{"Transport": "RTP/AVP/TCP;unicast;interleaved=0-1"})
+	if err != nil {
+		return rtspTrack{}, err
+	}
+	session, params, _ := strings.Cut(resp.header.Get("Session"), ";")
+	c.session = strings.TrimSpace(session)
+	if _, timeout, ok := strings.Cut(params, "timeout="); ok {
+		if n, err := strconv.Atoi(strings.TrimSpace(timeout)); err == nil && n > 0 {
+			c.keepalive = time.Duration(n) * time.Second / 2
+		}
+	}
+	if _, err := c.do("PLAY", base, map[string]string{"Range": "npt=0.000-"}); err != nil {
+		return rtspTrack{}, err
+	}
+	c.base = base
+	return track, nil
+}
+
+// rtspResponse is a response to an RTSP request.
+type rtspResponse struct {
+	code   int
+	status string
+	header textproto.MIMEHeader
+	body   []byte
+}
+
+// do sends a request and waits for its response, answering an
+// authentication challenge once.
+func (c *rtspConn) do(method, uri string, header map[string]string) (*rtspResponse, error) {
+	for attempt := 0; ; attempt++ {
+		if err := c.send(method, uri, header); err != nil {
+			return nil, err
+		}
+		var resp *rtspResponse
+		for resp == nil {
+			// Packets may arrive before the response to PLAY
+			channel, _, err := c.readFrame()
+			if err != nil {
+				return nil, err
+			}
+			if channel < 0 {
+				if resp, err = c.readResponse(); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if resp.code == 401 && attempt == 0 && c.user != nil {
+			var err error
+			if c.auth, err = authenticator(c.user, resp.header.Get("WWW-Authenticate")); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if resp.code != 200 {
+			return nil, fmt.Errorf("%s: unexpected status %d %s", method, resp.code, resp.status)
+		}
+		return resp, nil
+	}
+}
+
+// send writes a request without waiting for its response.
+func (c *rtspConn) send(method, uri string, header map[string]string) error {
+	c.cseq++
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s RTSP/1.0\r\nCSeq: %d\r\nUser-Agent: stt-receivetranscription-mve\r\n", method, uri, c.cseq)
+	for k, v := range header {
+		fmt.Fprintf(&b, "%s: %s\r\n", k, v)
+	}
+	if c.session != "" {
+		fmt.Fprintf(&b, "Session: %s\r\n", c.session)
+	}
+	if c.auth != nil {
+		fmt.Fprintf(&b, "Authorization: %s\r\n", c.auth(method, uri))
+	}
+	b.WriteString("\r\n")
+	c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	_, err := io.WriteString(c.conn, b.String())
+	return err
+}
+
+// readFrame reads the next interleaved frame, returning its channel and
+// payload, or a channel of -1 if an RTSP message comes next.
+func (c *rtspConn) readFrame() (int, []byte, error) {
+	c.conn.SetReadDeadline(time.Now().Add(c.timeout))
+	b, err := c.r.Peek(1)
+	if err != nil {
+		return 0, nil, err
+	}
+	if b[0] != '$' {
+		return -1, nil, nil
+	}
+	var header [4]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[2:4]))
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return 0, nil, err
+	}
+	return int(header[1]), payload, nil
+}
+
+// readResponse reads an RTSP response, or skips a request from the server.
+func (c *rtspConn) readResponse() (*rtspResponse, error) {
+	tp := textproto.NewReader(c.r)
+	line, err := tp.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	var body []byte
+	if n, _ := strconv.Atoi(header.Get("Content-Length")); n > 0 {
+		body = make([]byte, n)
+		if _, err := io.ReadFull(c.r, body); err != nil {
+			return nil, err
+		}
+	}
+	proto, status, _ := strings.Cut(line, " ")
+	if !strings.HasPrefix(proto, "RTSP/") {
+		return nil, nil
+	}
+	codeText, reason, _ := strings.Cut(status, " ")
+	code, err := strconv.Atoi(codeText)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RTSP status line %q", line)
+	}
+	return &rtspResponse{code: code, status: reason, header: header, body: body}, nil
+}
+
+// authenticator answers a basic or digest challenge with the credentials.
+func authenticator(user *url.Userinfo, challenge string) (func(method, uri string) string, error) {
+	password, _ := user.Password()
+	scheme, params, _ := strings.Cut(challenge, " ")
+	switch strings.ToLower(scheme) {
+	case "basic":
+		auth := "Basic " + base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+password))
+		return func(string, string) string { return auth }, nil
+	case "digest":
+		p := map[string]string{}
+		for _, kv := range strings.Split(params, ",") {
+			k, v, _ := strings.Cut(strings.TrimSpace(kv), "=")
+			p[strings.ToLower(k)] = strings.Trim(v, `"`)
+		}
+		ha1 := md5Hex(user.Username() + ":" + p["realm"] + ":" + password)
+		return func(method, uri string) string {
+			response := md5Hex(ha1 + ":" + p["nonce"] + ":" + md5Hex(method+":"+uri))
+			return fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", response="%s"`,
+				user.Username(), p["realm"], p["nonce"], uri, response)
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported RTSP authentication %q", scheme)
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// audioTrack returns the first audio track of an SDP description with a
+// PCMU, PCMA, L16 or Opus payload.
+func audioTrack(sdp string) (rtspTrack, error) {
+	var found []string
+	for _, m := range parseSDP(sdp) {
+		if m.kind != "audio" {
+			continue
+		}
+		t, encodings := m.track()
+		if t.encoding != "" {
+			return t, nil
+		}
+		found = append(found, encodings...)
+	}
+	if len(found) == 0 {
+		return rtspTrack{}, fmt.Errorf("stream has no audio track")
+	}
+	return rtspTrack{}, fmt.Errorf("stream has no PCMU, PCMA, L16 or Opus audio track (found %s)", strings.Join(found, ", "))
+}
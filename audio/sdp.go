@@ -0,0 +1,94 @@
+package audio
+
+import (
+	"strconv"
+	"strings"
+)
+
+// sdpMedia is a media description (m= section) of an SDP session
+// description.
+type sdpMedia struct {
+	kind    string
+	port    int
+	formats []int
+	// rtpmap maps payload types to their encoding, such as "L16/16000/1".
+	rtpmap  map[int]string
+	control string
+	// label identifies the media to other descriptions, such as SIPREC
+	// recording metadata.
+	label string
+}
+
+// parseSDP returns the media descriptions of an SDP session description, in
+// order.
+func parseSDP(sdp string) []*sdpMedia {
+	var media []*sdpMedia
+	var m *sdpMedia
+	for _, line := range strings.Split(sdp, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "m="):
+			fields := strings.Fields(line[2:])
+			m = &sdpMedia{rtpmap: map[int]string{}}
+			media = append(media, m)
+			if len(fields) < 3 {
+				continue
+			}
+			m.kind = fields[0]
+			m.port, _ = strconv.Atoi(fields[1])
+			for _, f := range fields[3:] {
+				if pt, err := strconv.Atoi(f); err == nil {
+					m.formats = append(m.formats, pt)
+				}
+			}
+		case m == nil:
+		case strings.HasPrefix(line, "a=rtpmap:"):
+			pt, encoding, _ := strings.Cut(line[len("a=rtpmap:"):], " ")
+			if n, err := strconv.Atoi(pt); err == nil {
+				m.rtpmap[n] = encoding
+			}
+		case strings.HasPrefix(line, "a=control:"):
+			m.control = line[len("a=control:"):]
+		case strings.HasPrefix(line, "a=label:"):
+			m.label = line[len("a=label:"):]
+		}
+	}
+	return media
+}
+
+// track returns the first PCMU, PCMA, L16 or Opus format of the media. If it has
+// none, the track's encoding is empty and the encodings it offers instead
+// are returned.
+func (m *sdpMedia) track() (rtspTrack, []string) {
+	var found []string
+	for _, pt := range m.formats {
+		t := rtspTrack{control: m.control, payloadType: pt, channels: 1}
+		switch encoding := m.rtpmap[pt]; {
+		case encoding != "":
+			parts := strings.Split(encoding, "/")
+			t.encoding = strings.ToUpper(parts[0])
+			if len(parts) > 1 {
+				t.rate, _ = strconv.Atoi(parts[1])
+			}
+			if len(parts) > 2 {
+				t.channels, _ = strconv.Atoi(parts[2])
+			}
+		case pt == payloadPCMU:
+			t.encoding, t.rate = "PCMU", 8000
+		case pt == payloadPCMA:
+			t.encoding, t.rate = "PCMA", 8000
+		case pt == payloadL16Stereo:
+			t.encoding, t.rate, t.channels = "L16", 44100, 2
+		case pt == payloadL16Mono:
+			t.encoding, t.rate = "L16", 44100
+		}
+		switch {
+		case t.encoding == "PCMU" && pt == payloadPCMU, t.encoding == "PCMA" && pt == payloadPCMA,
+			t.encoding == "L16" && t.rate > 0 && t.channels > 0, t.encoding == "OPUS" && pt >= 96:
+			return t, nil
+		case t.encoding != "":
+			found = append(found, t.encoding)
+		}
+	}
+	return rtspTrack{}, found
+}
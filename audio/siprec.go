@@ -0,0 +1,413 @@
+package audio
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SIPRECOptions configures ListenSIPREC.
+type SIPRECOptions struct {
+	// MediaIP is the address offered for the recorded RTP streams; it
+	// defaults to the local address facing each recording client.
+	MediaIP string
+	// RTP configures the reception of each stream; its L16 and Opus payload
+	// types, and the L16 format, are taken from the offer.
+	RTP RTPOptions
+}
+
+// SIPRECListener is a SIPREC recording server (RFC 7866) receiving SIP over
+// UDP. Every audio stream forked to it by a recording client is accepted as
+// a separate SIPRECStream.
+type SIPRECListener struct {
+	conn    *net.UDPConn
+	opts    SIPRECOptions
+	streams chan *SIPRECStream
+	done    chan struct{}
+
+	mu sync.Mutex
+	// calls are the recording sessions in progress by Call-ID.
+	calls map[string]*siprecCall
+}
+
+// siprecCall is a recording session: the answer given to its INVITE and the
+// streams it set up.
+type siprecCall struct {
+	answer  []byte
+	toTag   string
+	streams []*SIPRECStream
+}
+
+// SIPRECStream is the audio of one forked RTP stream of a recorded call, read
+// as a WAV stream until the recording session ends.
+type SIPRECStream struct {
+	// CallID is the SIP Call-ID of the recording session.
+	CallID string
+	// Label is the stream's SDP label, unique within the session.
+	Label string
+	// Participant names who the stream carries, from the recording
+	// metadata, if known.
+	Participant string
+
+	io.ReadCloser
+}
+
+// ListenSIPREC receives SIPREC recording sessions on a UDP address such as
+// ":5060".
+func ListenSIPREC(addr string, opts SIPRECOptions) (*SIPRECListener, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SIP address %q: %w", addr, err)
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for SIP: %w", err)
+	}
+	l := &SIPRECListener{
+		conn:    conn,
+		opts:    opts,
+		streams: make(chan *SIPRECStream),
+		done:    make(chan struct{}),
+		calls:   map[string]*siprecCall{},
+	}
+	go l.run()
+	return l, nil
+}
+
+// Accept waits for the next stream of a recording session.
+func (l *SIPRECListener) Accept() (*SIPRECStream, error) {
+	select {
+	case s := <-l.streams:
+		return s, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *SIPRECListener) Addr() net.Addr { return l.conn.LocalAddr() }
+
+// Close stops receiving SIP and ends the streams of every session.
+func (l *SIPRECListener) Close() error {
+	err := l.conn.Close()
+	<-l.done
+	return err
+}
+
+func (l *SIPRECListener) run() {
+	defer func() {
+		l.mu.Lock()
+		for id, call := range l.calls {
+			call.close()
+			delete(l.calls, id)
+		}
+		l.mu.Unlock()
+		close(l.done)
+	}()
+	packet := make([]byte, 65536)
+	for {
+		n, from, err := l.conn.ReadFromUDP(packet)
+		if err != nil {
+			return
+		}
+		req, err := readSIPRequest(packet[:n])
+		if err != nil {
+			// Responses and keepalives are ignored
+			continue
+		}
+		if resp := l.handle(req, from); resp != nil {
+			l.conn.WriteToUDP(resp, from)
+		}
+	}
+}
+
+// sipRequest is a received SIP request.
+type sipRequest struct {
+	method string
+	header textproto.MIMEHeader
+	// via are the Via headers in order, which textproto would merge.
+	via  []string
+	body []byte
+}
+
+func readSIPRequest(b []byte) (*sipRequest, error) {
+	r := textproto.NewReader(bufio.NewReader(bytes.NewReader(b)))
+	line, err := r.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	method, rest, _ := strings.Cut(line, " ")
+	if !strings.HasSuffix(rest, "SIP/2.0") {
+		return nil, fmt.Errorf("not a SIP request: %q", line)
+	}
+	header, err := r.ReadMIMEHeader()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	// Compact header forms
+	for short, long := range map[string]string{"V": "Via", "I": "Call-Id", "F": "From", "T": "To", "C": "Content-Type", "L": "Content-Length"} {
+		if v, ok := header[short]; ok {
+			header[long] = append(header[long], v...)
+		}
+	}
+	req := &sipRequest{method: method, header: header}
+	for _, v := range header["Via"] {
+		for _, via := range strings.Split(v, ",") {
+			req.via = append(req.via, strings.TrimSpace(via))
+		}
+	}
+	body, _ := io.ReadAll(r.R)
+	if n, err := strconv.Atoi(header.Get("Content-Length")); err == nil && n < len(body) {
+		body = body[:n]
+	}
+	req.body = body
+	return req, nil
+}
+
+// handle answers a request, returning the response to send.
+func (l *SIPRECListener) handle(req *sipRequest, from *net.UDPAddr) []byte {
+	callID := req.header.Get("Call-Id")
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	call := l.calls[callID]
+	switch req.method {
+	case "INVITE":
+		if call != nil {
+			// A retransmission, or a re-INVITE keeping the streams
+			return l.response(req, 200, "OK", call.toTag, call.answer)
+		}
+		call, err := l.open(req, from)
+		if err != nil {
+			log.Printf("Rejecting SIPREC session %s: %v", callID, err)
+			return l.response(req, 488, "Not Acceptable Here", newSIPTag(), nil)
+		}
+		l.calls[callID] = call
+		for _, s := range call.streams {
+			// Streams are accepted in the background so SIP keeps flowing
+			go func() {
+				select {
+				case l.streams <- s:
+				case <-l.done:
+					s.Close()
+				}
+			}()
+		}
+		return l.response(req, 200, "OK", call.toTag, call.answer)
+	case "BYE", "CANCEL":
+		if call != nil {
+			call.close()
+			delete(l.calls, callID)
+		}
+		return l.response(req, 200, "OK", "", nil)
+	case "ACK":
+		return nil
+	case "OPTIONS":
+		return l.response(req, 200, "OK", "", nil)
+	default:
+		return l.response(req, 501, "Not Implemented", "", nil)
+	}
+}
+
+// open sets up the streams of a recording session offered in an INVITE and
+// returns the session with its SDP answer.
+func (l *SIPRECListener) open(req *sipRequest, from *net.UDPAddr) (*siprecCall, error) {
+	sdp, metadata, err := siprecBody(req)
+	if err != nil {
+		return nil, err
+	}
+	participants := parseRecordingMetadata(metadata)
+
+	ip := l.opts.MediaIP
+	if ip == "" {
+		ip = localIP(from)
+	}
+	call := &siprecCall{toTag: newSIPTag()}
+	answer := fmt.Sprintf("v=0\r\no=- 0 0 IN IP4 %s\r\ns=SIPREC\r\nc=IN IP4 %s\r\nt=0 0\r\n", ip, ip)
+	for _, m := range parseSDP(sdp) {
+		t, _ := m.track()
+		if m.kind != "audio" || m.port == 0 || t.encoding == "" {
+			// Media that is not recorded is declined
+			answer += fmt.Sprintf("m=%s 0 RTP/AVP %d\r\n", m.kind, append(m.formats, 0)[0])
+			continue
+		}
+		opts := l.opts.RTP
+		if opts.JitterDelay <= 0 {
+			opts.JitterDelay = 60 * time.Millisecond
+		}
+		switch t.encoding {
+		case "L16":
+			opts.L16PayloadType, opts.Rate, opts.Channels = t.payloadType, t.rate, t.channels
+		case "OPUS":
+			opts.OpusPayloadType = t.payloadType
+		}
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{})
+		if err != nil {
+			call.close()
+			return nil, fmt.Errorf("failed to listen for RTP: %w", err)
+		}
+		rtp := &rtpListener{conn: conn, rtpStream: newRTPStream(opts)}
+		go rtp.run()
+		port := conn.LocalAddr().(*net.UDPAddr).Port
+		call.streams = append(call.streams, &SIPRECStream{
+			CallID:      req.header.Get("Call-Id"),
+			Label:       m.label,
+			Participant: participants[m.label],
+			ReadCloser:  rtp,
+		})
+		answer += fmt.Sprintf("m=audio %d RTP/AVP %d\r\n", port, t.payloadType)
+		if rtpmap := m.rtpmap[t.payloadType]; rtpmap != "" {
+			answer += fmt.Sprintf("a=rtpmap:%d %s\r\n", t.payloadType, rtpmap)
+		}
+		if m.label != "" {
+			answer += "a=label:" + m.label + "\r\n"
+		}
+		answer += "a=recvonly\r\n"
+	}
+	if len(call.streams) == 0 {
+		return nil, fmt.Errorf("no PCMU, PCMA, L16 or Opus audio stream offered")
+	}
+	call.answer = []byte(answer)
+	return call, nil
+}
+
+// close ends the call's streams; the audio received is read before io.EOF.
+func (c *siprecCall) close() {
+	for _, s := range c.streams {
+		s.Close()
+	}
+}
+
+// response formats a response to req, with an SDP body if body is set.
+func (l *SIPRECListener) response(req *sipRequest, code int, reason, toTag string, body []byte) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "SIP/2.0 %d %s\r\n", code, reason)
+	for _, via := range req.via {
+		fmt.Fprintf(&b, "Via: %s\r\n", via)
+	}
+	to := req.header.Get("To")
+	if toTag != "" && !strings.Contains(to, ";tag=") {
+		to += ";tag=" + toTag
+	}
+	fmt.Fprintf(&b, "From: %s\r\nTo: %s\r\nCall-ID: %s\r\nCSeq: %s\r\n", req.header.Get("From"), to, req.header.Get("Call-Id"), req.header.Get("Cseq"))
+	if body != nil {
+		fmt.Fprintf(&b, "Contact: <sip:%s>\r\nContent-Type: application/sdp\r\n", l.conn.LocalAddr())
+	}
+	fmt.Fprintf(&b, "Content-Length: %d\r\n\r\n", len(body))
+	b.Write(body)
+	return b.Bytes()
+}
+
+// siprecBody returns the SDP offer and recording metadata of an INVITE's
+// multipart body.
+func siprecBody(req *sipRequest) (sdp, metadata string, err error) {
+	mediaType, params, err := mime.ParseMediaType(req.header.Get("Content-Type"))
+	if err != nil {
+		return "", "", fmt.Errorf("invalid content type: %w", err)
+	}
+	if mediaType == "application/sdp" {
+		return string(req.body), "", nil
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return "", "", fmt.Errorf("unexpected content type %s", mediaType)
+	}
+	mr := multipart.NewReader(bytes.NewReader(req.body), params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", "", fmt.Errorf("invalid multipart body: %w", err)
+		}
+		data, _ := io.ReadAll(part)
+		switch t, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type")); t {
+		case "application/sdp":
+			sdp = string(data)
+		case "application/rs-metadata+xml":
+			metadata = string(data)
+		}
+	}
+	if sdp == "" {
+		return "", "", fmt.Errorf("no SDP offer")
+	}
+	return sdp, metadata, nil
+}
+
+// recordingMetadata is the part of SIPREC recording metadata (RFC 7865)
+// relating streams to participants.
+type recordingMetadata struct {
+	Participants []struct {
+		ID     string `xml:"participant_id,attr"`
+		NameID []struct {
+			AOR  string `xml:"aor,attr"`
+			Name string `xml:"name"`
+		} `xml:"nameID"`
+	} `xml:"participant"`
+	Streams []struct {
+		ID    string `xml:"stream_id,attr"`
+		Label string `xml:"label"`
+	} `xml:"stream"`
+	Associations []struct {
+		Participant string   `xml:"participant_id,attr"`
+		Send        []string `xml:"send"`
+	} `xml:"participantstreamassoc"`
+}
+
+// parseRecordingMetadata returns the name, or else the address, of the
+// participant sending each stream by stream label.
+func parseRecordingMetadata(metadata string) map[string]string {
+	labels := map[string]string{}
+	var m recordingMetadata
+	if metadata == "" || xml.Unmarshal([]byte(metadata), &m) != nil {
+		return labels
+	}
+	streams := map[string]string{}
+	for _, s := range m.Streams {
+		streams[s.ID] = s.Label
+	}
+	names := map[string]string{}
+	for _, p := range m.Participants {
+		for _, n := range p.NameID {
+			if names[p.ID] = n.Name; n.Name == "" {
+				names[p.ID] = n.AOR
+			}
+		}
+	}
+	for _, a := range m.Associations {
+		for _, stream := range a.Send {
+			if label, ok := streams[stream]; ok && names[a.Participant] != "" {
+				labels[label] = names[a.Participant]
+			}
+		}
+	}
+	return labels
+}
+
+// localIP returns the local address packets to peer are sent from.
+func localIP(peer *net.UDPAddr) string {
+	conn, err := net.DialUDP("udp", nil, peer)
+	if err != nil {
+		return "127.0.0.1"
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String()
+}
+
+func newSIPTag() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,311 @@
+package audio
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Spool queues live audio on disk as consecutive WAV segment files, so it
+// can be transcribed later, in capture order, for example once a recognizer
+// that was unreachable is back. Segments survive restarts: opening a spool
+// queues the segments left in its directory before any new ones, including
+// the one being recorded when the process stopped.
+type Spool struct {
+	dir     string
+	segment time.Duration
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// queue holds the complete segments not yet returned by Next, oldest
+	// first.
+	queue   []*SpoolSegment
+	nextSeq int
+	done    bool
+}
+
+// SpoolSegment is a queued segment of 16-bit PCM WAV audio.
+type SpoolSegment struct {
+	Path string
+	// Start is when the segment's first sample was captured.
+	Start time.Time
+}
+
+// Remove deletes the segment once it has been transcribed.
+func (s *SpoolSegment) Remove() error {
+	return os.Remove(s.Path)
+}
+
+// spoolPart marks a segment still being recorded.
+const spoolPart = ".part"
+
+// OpenSpool opens the spool in dir, creating it if needed, that cuts audio
+// into segments of the given duration.
+func OpenSpool(dir string, segment time.Duration) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool directory: %w", err)
+	}
+	s := &Spool{dir: dir, segment: segment}
+	s.cond = sync.NewCond(&s.mu)
+	// Names sort in capture order
+	for _, e := range entries {
+		name := e.Name()
+		path := filepath.Join(dir, name)
+		if strings.HasSuffix(name, spoolPart) {
+			// Cut short by a crash: complete its header and queue it
+			if path, err = finishSegment(path); err != nil {
+				return nil, err
+			}
+			if path == "" {
+				continue
+			}
+			name = filepath.Base(path)
+		}
+		seq, start, ok := parseSegmentName(name)
+		if !ok {
+			continue
+		}
+		s.queue = append(s.queue, &SpoolSegment{Path: path, Start: start})
+		s.nextSeq = max(s.nextSeq, seq+1)
+	}
+	slices.SortFunc(s.queue, func(a, b *SpoolSegment) int { return strings.Compare(a.Path, b.Path) })
+	return s, nil
+}
+
+// segmentName names a segment by sequence number and capture start, so that
+// names sort in capture order.
+func segmentName(seq int, start time.Time) string {
+	return fmt.Sprintf("%010d-%d.wav", seq, start.UnixNano())
+}
+
+func parseSegmentName(name string) (int, time.Time, bool) {
+	base, ok := strings.CutSuffix(name, ".wav")
+	if !ok {
+		return 0, time.Time{}, false
+	}
+	seqStr, startStr, ok := strings.Cut(base, "-")
+	seq, err1 := strconv.Atoi(seqStr)
+	nanos, err2 := strconv.ParseInt(startStr, 10, 64)
+	if !ok || err1 != nil || err2 != nil {
+		return 0, time.Time{}, false
+	}
+	return seq, time.Unix(0, nanos), true
+}
+
+// Len returns the number of complete segments queued and not yet returned
+// by Next.
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.queue)
+}
+
+// Record writes 16-bit PCM WAV audio read from r, such as a live capture,
+// into segments until r ends, completing the last one. It returns nil when r
+// returns io.EOF.
+func (s *Spool) Record(r io.Reader) error {
+	defer func() {
+		s.mu.Lock()
+		s.done = true
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	}()
+	rate, channels, err := readStreamHeader(r)
+	if err != nil {
+		return err
+	}
+	frame := channels * 2
+	segmentBytes := max(int(s.segment.Seconds()*float64(rate))*frame, frame)
+	captured := time.Now()
+
+	buf := make([]byte, 32*1024)
+	for offset := 0; ; {
+		start := captured.Add(time.Duration(offset/frame) * time.Second / time.Duration(rate))
+		n, err := s.recordSegment(r, buf, start, rate, channels, segmentBytes)
+		offset += n
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// recordSegment records up to size bytes of audio from r into a new
+// segment and queues it. It returns the bytes recorded and io.EOF once r
+// has ended.
+func (s *Spool) recordSegment(r io.Reader, buf []byte, start time.Time, rate, channels, size int) (int, error) {
+	s.mu.Lock()
+	seq := s.nextSeq
+	s.nextSeq++
+	s.mu.Unlock()
+
+	path := filepath.Join(s.dir, segmentName(seq, start))
+	f, err := os.Create(path + spoolPart)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create spool segment: %w", err)
+	}
+	// The data size is filled in when the segment is complete
+	if _, err := f.Write(wavHeader(rate, channels, 0)); err != nil {
+		f.Close()
+		return 0, fmt.Errorf("failed to write spool segment: %w", err)
+	}
+	n := 0
+	var readErr error
+	for n < size && readErr == nil {
+		var m int
+		m, readErr = r.Read(buf[:min(len(buf), size-n)])
+		if _, err := f.Write(buf[:m]); err != nil {
+			f.Close()
+			return n, fmt.Errorf("failed to write spool segment: %w", err)
+		}
+		n += m
+	}
+	if err := f.Close(); err != nil {
+		return n, fmt.Errorf("failed to write spool segment: %w", err)
+	}
+	if _, err := finishSegment(path + spoolPart); err != nil {
+		return n, err
+	}
+	if n > 0 {
+		s.mu.Lock()
+		s.queue = append(s.queue, &SpoolSegment{Path: path, Start: start})
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	}
+	return n, readErr
+}
+
+// finishSegment sets the sizes in the header of the segment recorded at
+// path and gives it its final name, which it returns. An empty segment is
+// removed instead, returning "".
+func finishSegment(path string) (string, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return "", fmt.Errorf("failed to complete spool segment: %w", err)
+	}
+	defer f.Close()
+	n, err := FinishWAV(f)
+	if err != nil {
+		return "", fmt.Errorf("failed to complete spool segment: %w", err)
+	}
+	// A header cut short is not a segment
+	if n <= 0 {
+		f.Close()
+		return "", os.Remove(path)
+	}
+	final := strings.TrimSuffix(path, spoolPart)
+	if err := os.Rename(path, final); err != nil {
+		return "", fmt.Errorf("failed to complete spool segment: %w", err)
+	}
+	return final, nil
+}
+
+// FinishWAV sets the sizes in the header of f, a 16-bit PCM WAV file written
+// as a stream starting with StreamHeader, once all of its audio is written,
+// so it can be read as a file. It returns the bytes of audio, which are 0 or
+// less for a file cut short in its header, whose sizes are left alone.
+func FinishWAV(f *os.File) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	n := info.Size() - 44
+	if n <= 0 {
+		return n, nil
+	}
+	var sizes [4]byte
+	binary.LittleEndian.PutUint32(sizes[:], uint32(n+36))
+	if _, err := f.WriteAt(sizes[:], 4); err != nil {
+		return 0, err
+	}
+	binary.LittleEndian.PutUint32(sizes[:], uint32(n))
+	if _, err := f.WriteAt(sizes[:], 40); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Next returns the oldest queued segment, waiting for one to be recorded.
+// It returns io.EOF once Record has finished and every segment has been
+// returned. The segment stays on disk until it is removed.
+func (s *Spool) Next(ctx context.Context) (*SpoolSegment, error) {
+	stop := context.AfterFunc(ctx, func() {
+		s.mu.Lock()
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	})
+	defer stop()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.queue) == 0 {
+		switch {
+		case ctx.Err() != nil:
+			return nil, ctx.Err()
+		case s.done:
+			return nil, io.EOF
+		}
+		s.cond.Wait()
+	}
+	seg := s.queue[0]
+	s.queue = s.queue[1:]
+	return seg, nil
+}
+
+// maxHeaderChunk bounds the chunks before the audio data of a WAV stream,
+// so a corrupt or hostile size cannot make readStreamHeader allocate
+// gigabytes.
+const maxHeaderChunk = 1 << 20
+
+// readStreamHeader reads the header of a 16-bit PCM WAV stream up to its
+// audio data, returning the sample rate and channel count.
+func readStreamHeader(r io.Reader) (rate, channels int, err error) {
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return 0, 0, fmt.Errorf("failed to read WAV header: %w", err)
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return 0, 0, fmt.Errorf("not a WAV stream")
+	}
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return 0, 0, fmt.Errorf("failed to read WAV header: %w", err)
+		}
+		if string(chunk[0:4]) == "data" {
+			break
+		}
+		size := int(binary.LittleEndian.Uint32(chunk[4:8]))
+		if size > maxHeaderChunk {
+			return 0, 0, fmt.Errorf("WAV header chunk %q of %d bytes is too large", chunk[0:4], size)
+		}
+		body := make([]byte, size+size%2)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return 0, 0, fmt.Errorf("failed to read WAV header: %w", err)
+		}
+		if string(chunk[0:4]) == "fmt " && size >= 16 {
+			channels = int(binary.LittleEndian.Uint16(body[2:4]))
+			rate = int(binary.LittleEndian.Uint32(body[4:8]))
+			if bits := binary.LittleEndian.Uint16(body[14:16]); bits != 16 {
+				return 0, 0, fmt.Errorf("unsupported WAV sample size %d, expected 16-bit PCM", bits)
+			}
+		}
+	}
+	if rate == 0 || channels == 0 {
+		return 0, 0, fmt.Errorf("WAV stream has no fmt chunk")
+	}
+	return rate, channels, nil
+}
@@ -0,0 +1,90 @@
+package audio
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+func init() {
+	Register("mono", newMono)
+	Register("resample", newResample)
+	Register("gain", newGain)
+	Register("normalize", newNormalize)
+	Register("denoise", newDenoise)
+}
+
+// mono downmixes every channel into one.
+type mono struct{}
+
+func newMono(json.RawMessage) (Stage, error) { return mono{}, nil }
+
+func (mono) Process(a *Audio) error {
+	if a.Channels <= 1 {
+		return nil
+	}
+	out := make([]float32, a.Frames())
+	for i := range out {
+		var sum float32
+		for c := range a.Channels {
+			sum += a.Samples[i*a.Channels+c]
+		}
+		out[i] = sum / float32(a.Channels)
+	}
+	a.Samples, a.Channels = out, 1
+	return nil
+}
+
+// resample converts the sample rate, by linear interpolation unless a
+// higher quality is given.
+type resample struct {
+	Rate    int    `json:"rate"`
+	Quality string `json:"quality"`
+}
+
+func newResample(opts json.RawMessage) (Stage, error) {
+	var s resample
+	if err := json.Unmarshal(opts, &s); err != nil {
+		return nil, err
+	}
+	if s.Rate <= 0 {
+		return nil, fmt.Errorf("rate must be positive")
+	}
+	if s.Quality == "" {
+		s.Quality = ResampleLinear
+	}
+	if err := (ResampleOptions{Rate: s.Rate, Quality: s.Quality}).validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s resample) Process(a *Audio) error {
+	if a.SampleRate == s.Rate || a.Frames() == 0 {
+		return nil
+	}
+	r := newResampler(a.SampleRate, s.Rate, a.Channels, s.Quality)
+	a.Samples, a.SampleRate = r.process(a.Samples, true), s.Rate
+	return nil
+}
+
+// gain amplifies the audio by a fixed number of decibels.
+type gain struct {
+	DB float64 `json:"db"`
+}
+
+func newGain(opts json.RawMessage) (Stage, error) {
+	var s gain
+	if err := json.Unmarshal(opts, &s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s gain) Process(a *Audio) error {
+	f := float32(math.Pow(10, s.DB/20))
+	for i := range a.Samples {
+		a.Samples[i] *= f
+	}
+	return nil
+}
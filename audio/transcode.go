@@ -0,0 +1,137 @@
+package audio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TranscodeOptions configures Transcode and TranscodeFile.
+type TranscodeOptions struct {
+	// FFmpeg is the ffmpeg executable; it defaults to "ffmpeg" on the PATH.
+	FFmpeg string
+	// SampleRate and Channels are the format of the transcoded audio; they
+	// default to 16 kHz mono.
+	SampleRate int
+	Channels   int
+}
+
+func (o TranscodeOptions) withDefaults() TranscodeOptions {
+	if o.FFmpeg == "" {
+		o.FFmpeg = "ffmpeg"
+	}
+	if o.SampleRate == 0 {
+		o.SampleRate = 16000
+	}
+	if o.Channels == 0 {
+		o.Channels = 1
+	}
+	return o
+}
+
+// args returns the ffmpeg arguments decoding the audio of input to raw
+// 16-bit little-endian PCM on stdout.
+func (o TranscodeOptions) args(input string) []string {
+	return []string{"-hide_banner", "-loglevel", "error", "-i", input, "-vn",
+		"-ac", strconv.Itoa(o.Channels), "-ar", strconv.Itoa(o.SampleRate), "-f", "s16le", "pipe:1"}
+}
+
+// TranscodeFile decodes the audio track of the media file at path, such as
+// an MP4, MKV, M4A or WebM file, to 16-bit PCM WAV with ffmpeg.
+func TranscodeFile(ctx context.Context, path string, opts TranscodeOptions) ([]byte, error) {
+	opts = opts.withDefaults()
+	var stdout, stderr bytes.Buffer
+	// -nostdin keeps ffmpeg from consuming the caller's input
+	cmd := exec.CommandContext(ctx, opts.FFmpeg, append([]string{"-nostdin"}, opts.args(path)...)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, ffmpegError(path, err, &stderr)
+	}
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("failed to transcode %s: no audio track", path)
+	}
+	return append(wavHeader(opts.SampleRate, opts.Channels, uint32(stdout.Len())), stdout.Bytes()...), nil
+}
+
+// Transcode returns a live 16-bit PCM WAV stream of the audio of media read
+// from r, decoded by ffmpeg as it arrives. Containers that keep their index
+// at the end, such as most MP4 files, cannot be decoded from a stream; use
+// TranscodeFile for them. Closing the stream stops ffmpeg.
+func Transcode(ctx context.Context, r io.Reader, opts TranscodeOptions) (io.ReadCloser, error) {
+	opts = opts.withDefaults()
+	cmd := exec.CommandContext(ctx, opts.FFmpeg, opts.args("pipe:0")...)
+	cmd.Stdin = r
+	// Stop waiting for the copy of r once ffmpeg has exited
+	cmd.WaitDelay = time.Second
+	t := &transcoder{cmd: cmd, pending: StreamHeader(opts.SampleRate, opts.Channels)}
+	cmd.Stderr = &t.stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to transcode stream: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, ffmpegError("stream", err, &t.stderr)
+	}
+	t.stdout = stdout
+	return t, nil
+}
+
+// transcoder is the output of a running ffmpeg, starting with the WAV
+// header.
+type transcoder struct {
+	cmd     *exec.Cmd
+	stdout  io.Reader
+	stderr  bytes.Buffer
+	pending []byte
+	err     error
+}
+
+func (t *transcoder) Read(p []byte) (int, error) {
+	if len(t.pending) > 0 {
+		n := copy(p, t.pending)
+		t.pending = t.pending[n:]
+		return n, nil
+	}
+	if t.err != nil {
+		return 0, t.err
+	}
+	n, err := t.stdout.Read(p)
+	if err == io.EOF {
+		t.err = io.EOF
+		if werr := t.cmd.Wait(); werr != nil {
+			t.err = ffmpegError("stream", werr, &t.stderr)
+		}
+		if n > 0 {
+			return n, nil
+		}
+		return 0, t.err
+	}
+	return n, err
+}
+
+func (t *transcoder) Close() error {
+	if t.err == nil {
+		t.err = io.ErrClosedPipe
+		t.cmd.Process.Kill()
+		t.cmd.Wait()
+	}
+	return nil
+}
+
+// ffmpegError describes a failed ffmpeg run, with the errors it printed.
+func ffmpegError(input string, err error, stderr *bytes.Buffer) error {
+	if errors.Is(err, exec.ErrNotFound) {
+		return fmt.Errorf("failed to transcode %s: ffmpeg is not installed: %w", input, err)
+	}
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return fmt.Errorf("failed to transcode %s: %w: %s", input, err, msg)
+	}
+	return fmt.Errorf("failed to transcode %s: %w", input, err)
+}
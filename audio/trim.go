@@ -0,0 +1,69 @@
+package audio
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// TrimOptions configures TrimSilence.
+type TrimOptions struct {
+	// Threshold is the level in dBFS, measured over 20ms frames, below which
+	// audio is silent; it defaults to -50.
+	Threshold float64
+	// MinDuration is the shortest leading or trailing silence worth
+	// trimming; it defaults to 500ms.
+	MinDuration time.Duration
+}
+
+// trimPad is the silence kept next to the first and last sound, so their
+// onset and tail are not clipped.
+const trimPad = 100 * time.Millisecond
+
+// TrimSilence returns the spans of silence at the start and end of the
+// audio, for cutting out before recognition so dead air is not paid for.
+// Audio that is silent throughout is left to the recognizer.
+func TrimSilence(a *Audio, opts TrimOptions) ([]Span, error) {
+	if opts.Threshold > 0 {
+		return nil, fmt.Errorf("trim threshold %g dBFS is above full scale", opts.Threshold)
+	}
+	if opts.Threshold == 0 {
+		opts.Threshold = -50
+	}
+	if opts.MinDuration == 0 {
+		opts.MinDuration = 500 * time.Millisecond
+	}
+	n := int(time.Duration(a.SampleRate) * vadFrame / time.Second)
+	if n == 0 {
+		return nil, nil
+	}
+	samples := channelMix(a)
+	frames := len(samples) / n
+	loud := func(f int) bool {
+		var energy float64
+		for _, s := range samples[f*n : (f+1)*n] {
+			energy += float64(s) * float64(s)
+		}
+		return 10*math.Log10(energy/float64(n)+1e-10) >= opts.Threshold
+	}
+	first := 0
+	for first < frames && !loud(first) {
+		first++
+	}
+	if first == frames {
+		return nil, nil
+	}
+	last := frames - 1
+	for !loud(last) {
+		last--
+	}
+
+	var spans []Span
+	if lead := time.Duration(first)*vadFrame - trimPad; lead >= opts.MinDuration {
+		spans = append(spans, Span{Kind: SpanSilence, End: lead})
+	}
+	if start := time.Duration(last+1)*vadFrame + trimPad; a.Duration()-start >= opts.MinDuration {
+		spans = append(spans, Span{Kind: SpanSilence, Start: start, End: a.Duration()})
+	}
+	return spans, nil
+}
@@ -0,0 +1,136 @@
+package audio
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"time"
+)
+
+// SpanSilence is the kind of the spans DetectSilence returns.
+const SpanSilence = "silence"
+
+// VADOptions configures DetectSilence.
+type VADOptions struct {
+	// Aggressiveness, from 0 to 3 as in WebRTC's VAD, is how readily audio
+	// is judged silent: 0 keeps anything louder than the background, 3
+	// needs speech well above it and cuts more noise along with silence.
+	Aggressiveness int
+	// Hangover is the audio kept on either side of speech so the onsets and
+	// tails of words are not clipped; it defaults to 300ms.
+	Hangover time.Duration
+}
+
+const (
+	vadFrame = 20 * time.Millisecond
+	// Silences shorter than vadMinSilence, after the hangover, are kept.
+	vadMinSilence = 500 * time.Millisecond
+	// The background level starts at the vadFloorPercentile quietest frame
+	// and then follows the quiet frames, drifting up through speech by at
+	// most vadFloorDrift per frame.
+	vadFloorPercentile = 0.1
+	vadFloorDrift      = 0.005
+)
+
+// vadModes are, per aggressiveness, how far above the background level in
+// dB a frame must be to count as speech, the absolute level in dBFS it must
+// reach and the consecutive frames it takes.
+var vadModes = []struct {
+	aboveFloor, minLevel float64
+	minFrames            int
+}{
+	{4, -60, 1},
+	{6, -55, 2},
+	{9, -50, 3},
+	{12, -45, 5},
+}
+
+// DetectSilence returns the spans of the audio without speech, in order, for
+// cutting out before recognition. It is an energy detector that adapts to
+// the background level, so steady noise counts as silence.
+func DetectSilence(a *Audio, opts VADOptions) ([]Span, error) {
+	if opts.Aggressiveness < 0 || opts.Aggressiveness >= len(vadModes) {
+		return nil, fmt.Errorf("VAD aggressiveness %d is not between 0 and %d", opts.Aggressiveness, len(vadModes)-1)
+	}
+	if opts.Hangover == 0 {
+		opts.Hangover = 300 * time.Millisecond
+	}
+	mode := vadModes[opts.Aggressiveness]
+	n := int(time.Duration(a.SampleRate) * vadFrame / time.Second)
+	if n == 0 {
+		return nil, nil
+	}
+	samples := channelMix(a)
+	frames := len(samples) / n
+	if frames == 0 {
+		return nil, nil
+	}
+	level := make([]float64, frames)
+	for f := range frames {
+		var energy float64
+		for _, s := range samples[f*n : (f+1)*n] {
+			energy += float64(s) * float64(s)
+		}
+		level[f] = 10 * math.Log10(energy/float64(n)+1e-10)
+	}
+
+	sorted := slices.Clone(level)
+	slices.Sort(sorted)
+	floor := sorted[int(float64(frames-1)*vadFloorPercentile)]
+	speech := make([]bool, frames)
+	for f, l := range level {
+		loud := l >= floor+mode.aboveFloor && l >= mode.minLevel
+		speech[f] = loud
+		switch {
+		case l < floor:
+			floor = l
+		case !loud:
+			floor += 0.1 * (l - floor)
+		default:
+			floor += vadFloorDrift
+		}
+	}
+
+	// Runs of loud frames too short to be speech are clicks
+	for f := 0; f < frames; {
+		if !speech[f] {
+			f++
+			continue
+		}
+		end := f
+		for end < frames && speech[end] {
+			end++
+		}
+		if end-f < mode.minFrames {
+			clear(speech[f:end])
+		}
+		f = end
+	}
+
+	frameTime := func(f int) time.Duration { return time.Duration(f) * vadFrame }
+	var spans []Span
+	for f := 0; f < frames; {
+		if speech[f] {
+			f++
+			continue
+		}
+		end := f
+		for end < frames && !speech[end] {
+			end++
+		}
+		start, stop := frameTime(f), frameTime(end)
+		if f > 0 {
+			start += opts.Hangover
+		}
+		if end < frames {
+			stop -= opts.Hangover
+		} else {
+			stop = a.Duration()
+		}
+		if stop-start >= vadMinSilence {
+			spans = append(spans, Span{Kind: SpanSilence, Start: start, End: stop})
+		}
+		f = end
+	}
+	return spans, nil
+}
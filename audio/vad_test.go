@@ -0,0 +1,111 @@
+package audio
+
+import (
+	"math/rand/v2"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// voice is a segment loud enough for speech, at about -20 dBFS.
+func voice(d time.Duration) segment {
+	return segment{freqs: []float64{220, 660}, amp: 0.1, dur: d}
+}
+
+// withNoise adds steady white noise of amplitude amp to the audio.
+func withNoise(a *Audio, amp float64) *Audio {
+	rng := rand.New(rand.NewPCG(1, 2))
+	for i := range a.Samples {
+		a.Samples[i] += float32(amp * (2*rng.Float64() - 1))
+	}
+	return a
+}
+
+func TestDetectSilence(t *testing.T) {
+	s := time.Second
+	ms := time.Millisecond
+	silence := func(start, end time.Duration) Span {
+		return Span{Kind: SpanSilence, Start: start, End: end}
+	}
+	cases := []struct {
+		name  string
+		audio *Audio
+		opts  VADOptions
+		want  []Span
+	}{
+		{
+			name:  "speech between silences",
+			audio: synth(16000, pause(2*s), voice(s), pause(2*s)),
+			opts:  VADOptions{Aggressiveness: 1},
+			want:  []Span{silence(0, 1700*ms), silence(3300*ms, 5*s)},
+		},
+		{
+			name:  "pause shorter than the hangovers and 500ms",
+			audio: synth(16000, voice(s), pause(800*ms), voice(s)),
+			opts:  VADOptions{Aggressiveness: 1},
+		},
+		{
+			name:  "pause between speech",
+			audio: synth(16000, voice(s), pause(2*s), voice(s)),
+			opts:  VADOptions{Aggressiveness: 1},
+			want:  []Span{silence(1300*ms, 2700*ms)},
+		},
+		{
+			name:  "shorter hangover",
+			audio: synth(16000, voice(s), pause(2*s), voice(s)),
+			opts:  VADOptions{Aggressiveness: 1, Hangover: 100 * ms},
+			want:  []Span{silence(1100*ms, 2900*ms)},
+		},
+		{
+			name:  "steady noise counts as silence",
+			audio: withNoise(synth(16000, pause(2*s), voice(s), pause(2*s)), 0.01),
+			opts:  VADOptions{Aggressiveness: 1},
+			want:  []Span{silence(0, 1700*ms), silence(3300*ms, 5*s)},
+		},
+		{
+			name:  "click ignored",
+			audio: synth(16000, pause(s), voice(20*ms), pause(s)),
+			opts:  VADOptions{Aggressiveness: 1},
+			want:  []Span{silence(0, 2020*ms)},
+		},
+		{
+			name:  "click kept by aggressiveness 0",
+			audio: synth(16000, pause(s), voice(20*ms), pause(s)),
+			opts:  VADOptions{Aggressiveness: 0},
+			want:  []Span{silence(0, 700*ms), silence(1320*ms, 2020*ms)},
+		},
+		{
+			name:  "quiet sound kept by aggressiveness 0",
+			audio: synth(16000, pause(s), segment{freqs: []float64{440}, amp: 0.0018, dur: s}, pause(s)),
+			opts:  VADOptions{Aggressiveness: 0},
+			want:  []Span{silence(0, 700*ms), silence(2300*ms, 3*s)},
+		},
+		{
+			name:  "quiet sound cut by aggressiveness 1",
+			audio: synth(16000, pause(s), segment{freqs: []float64{440}, amp: 0.0018, dur: s}, pause(s)),
+			opts:  VADOptions{Aggressiveness: 1},
+			want:  []Span{silence(0, 3*s)},
+		},
+		{
+			name:  "no audio",
+			audio: synth(16000),
+			opts:  VADOptions{Aggressiveness: 3},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			spans, err := DetectSilence(c.audio, c.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(c.want, spans); diff != "" {
+				t.Errorf("spans mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	if _, err := DetectSilence(synth(16000, pause(s)), VADOptions{Aggressiveness: 4}); err == nil {
+		t.Error("aggressiveness 4 was accepted")
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"stt-receivetranscription-mve/output"
+	"stt-receivetranscription-mve/stt"
+)
+
+// formatExtensions are the file extensions of transcripts written by batch.
+var formatExtensions = map[string]string{
+	"text": ".txt",
+	"json": ".json",
+	"srt":  ".srt",
+	"vtt":  ".vtt",
+	"html": ".html",
+}
+
+// runBatch transcribes every audio file matching the glob patterns given as
+// arguments, and every file listed by -manifest, writing one transcript file
+// per input.
+func runBatch(args []string) error {
+	fs := flag.NewFlagSet("batch", flag.ExitOnError)
+	primaryLang := fs.String("primary", "en-US", "Primary language code")
+	model := fs.String("model", "", "Recognition model (default "+stt.DefaultModel+")")
+	fallback := fs.String("fallback-model", "", "Model to retry with if -model is not available for the region or language")
+	format := fs.String("format", "text", "Transcript format as format[,key=value...], as for -output")
+	outDir := fs.String("out-dir", "", "Directory to write transcripts to (default next to each input)")
+	workers := fs.Int("workers", 4, "Number of files transcribed concurrently (1 transcribes them in order)")
+	manifest := fs.String("manifest", "", "File listing audio paths to transcribe, one per line, or as a CSV file with a header row and path, output, language, model and recognizer columns overriding the flags per file")
+	report := fs.String("report", "", "File to write a CSV report of every file's outcome, audio length and transcription time to")
+	fs.Parse(args)
+
+	config := &Config{PrimaryLang: *primaryLang, Model: *model, Fallback: *fallback}
+	if err := config.loadEnv(); err != nil {
+		return err
+	}
+	name, _, _ := strings.Cut(*format, ",")
+	ext, ok := formatExtensions[name]
+	if !ok {
+		return fmt.Errorf("unknown output format %q", name)
+	}
+
+	var items []batchItem
+	for _, pattern := range fs.Args() {
+		// A directory stands for every file in it
+		if info, err := os.Stat(pattern); err == nil && info.IsDir() {
+			pattern = filepath.Join(pattern, "*")
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		for _, m := range matches {
+			if info, err := os.Stat(m); err == nil && !info.IsDir() {
+				items = append(items, batchItem{path: m})
+			}
+		}
+	}
+	if *manifest != "" {
+		listed, err := readManifest(*manifest)
+		if err != nil {
+			return err
+		}
+		items = append(items, listed...)
+	}
+	if len(items) == 0 {
+		return fmt.Errorf("no audio files match %v", fs.Args())
+	}
+	if *outDir != "" {
+		if err := os.MkdirAll(*outDir, 0o755); err != nil {
+			return fmt.Errorf("failed to create output directory: %w", err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("Transcribing %d files with %d workers", len(items), *workers)
+	var failed atomic.Int32
+	results := make([]*batchResult, len(items))
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(max(*workers, 1))
+	for i, item := range items {
+		out := item.output
+		if out == "" {
+			dir := filepath.Dir(item.path)
+			if *outDir != "" {
+				dir = *outDir
+			}
+			out = filepath.Join(dir, strings.TrimSuffix(filepath.Base(item.path), filepath.Ext(item.path))+ext)
+		}
+		recognition := config.Recognition()
+		if item.language != "" {
+			recognition.LanguageCodes = []string{item.language}
+		}
+		if item.model != "" {
+			recognition.Model = item.model
+		}
+		if item.recognizer != "" {
+			recognition.RecognizerID = item.recognizer
+		}
+		g.Go(func() error {
+			start := time.Now()
+			transcript, err := transcribeWith(ctx, recognition, item.path, *format+":"+out)
+			if err != nil && ctx.Err() != nil {
+				return ctx.Err()
+			}
+			results[i] = &batchResult{item: item, output: out, err: err, duration: transcript.Duration, segments: len(transcript.Segments), elapsed: time.Since(start)}
+			// One failed file does not stop the others
+			if err != nil {
+				failed.Add(1)
+				log.Printf("Failed to transcribe %s: %v", item.path, err)
+				if hint := remediation(err); hint != "" {
+					log.Printf("%s", hint)
+				}
+				return nil
+			}
+			log.Printf("Transcribed %s to %s", item.path, out)
+			return nil
+		})
+	}
+	err := g.Wait()
+	if *report != "" {
+		// An interrupted batch reports the files it finished
+		if err := writeBatchReport(*report, results); err != nil {
+			return err
+		}
+		log.Printf("Wrote report to %s", *report)
+	}
+	if err != nil {
+		return err
+	}
+	if n := failed.Load(); n > 0 {
+		return fmt.Errorf("%d of %d files failed", n, len(items))
+	}
+	return nil
+}
+
+// transcribeToFile transcribes the audio at path and writes the transcript
+// to the output spec.
+func transcribeToFile(ctx context.Context, config *Config, path, spec string) error {
+	_, err := transcribeWith(ctx, config.Recognition(), path, spec)
+	return err
+}
+
+// transcribeWith is transcribeToFile with the given recognition settings,
+// also returning the transcript.
+func transcribeWith(ctx context.Context, config stt.Config, path, spec string) (stt.Transcript, error) {
+	transcript, err := stt.TranscribeFile(ctx, path, stt.TranscribeOptions{Config: config})
+	if err != nil {
+		return transcript, err
+	}
+	sink, err := output.Open(spec)
+	if err != nil {
+		return transcript, err
+	}
+	session := output.Session{ID: stt.NewSessionID(), Audio: path}
+	if len(config.LanguageCodes) > 0 {
+		session.Language = config.LanguageCodes[0]
+	}
+	sinks := output.WithSession(sink, session)
+	for _, record := range output.FromTranscript(transcript) {
+		if err := sinks.Write(ctx, record); err != nil {
+			sink.Close()
+			return transcript, fmt.Errorf("failed to write transcript: %w", err)
+		}
+	}
+	return transcript, sink.Close()
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"stt-receivetranscription-mve/stt"
+)
+
+// runBench runs the benchmark named by the first argument.
+func runBench(args []string) error {
+	if len(args) == 0 || args[0] != "providers" {
+		return fmt.Errorf("usage: bench providers [flags] corpus...")
+	}
+	return runBenchProviders(args[1:])
+}
+
+// benchItem is one labeled recording of a corpus.
+type benchItem struct {
+	audio     string
+	reference string
+	// duration is the length of WAV audio, or 0 if unknown.
+	duration time.Duration
+}
+
+// benchStats accumulates a provider's results over the corpus.
+type benchStats struct {
+	provider string
+	files    int
+	failed   int
+	// errs and words are the word errors and reference words of the files
+	// transcribed, and audio and elapsed their length and transcription
+	// time.
+	errs, words int
+	audio       time.Duration
+	elapsed     time.Duration
+	// price is per minute of audio, or negative if unknown.
+	price float64
+}
+
+// runBenchProviders transcribes a labeled corpus with each provider and
+// reports their word error rates, latencies and costs.
+func runBenchProviders(args []string) error {
+	fs := flag.NewFlagSet("bench providers", flag.ExitOnError)
+	providers := fs.String("providers", stt.ProviderGoogle, "Comma-separated providers to compare")
+	primaryLang := fs.String("primary", "en-US", "Language code of the corpus")
+	format := fs.String("format", "markdown", "Report format: markdown or csv")
+	out := fs.String("out", "", "File to write the report to (default stdout)")
+	var prices stringList
+	fs.Var(&prices, "price", "Price of one minute of audio for a provider as provider=price, repeatable (google defaults to 0.016; self-hosted providers cost nothing)")
+	fs.Parse(args)
+
+	if *format != "markdown" && *format != "csv" {
+		return fmt.Errorf("unknown report format %q", *format)
+	}
+	perMinute := map[string]float64{stt.ProviderGoogle: 0.016, stt.ProviderRiva: 0, stt.ProviderVosk: 0}
+	for _, p := range prices {
+		name, v, ok := strings.Cut(p, "=")
+		price, err := strconv.ParseFloat(v, 64)
+		if !ok || err != nil {
+			return fmt.Errorf("invalid price %q, want provider=price", p)
+		}
+		perMinute[name] = price
+	}
+	corpus, err := loadCorpus(fs.Args())
+	if err != nil {
+		return err
+	}
+
+	var configs []*Config
+	for _, name := range strings.Split(*providers, ",") {
+		name = strings.TrimSpace(name)
+		if _, err := stt.LookupProvider(name); err != nil {
+			return err
+		}
+		config := &Config{Provider: name, PrimaryLang: *primaryLang}
+		if err := config.loadEnv(); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		configs = append(configs, config)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("Benchmarking %d providers on %d recordings", len(configs), len(corpus))
+	var stats []*benchStats
+	for _, config := range configs {
+		s := &benchStats{provider: config.Provider, price: -1}
+		if price, ok := perMinute[config.Provider]; ok {
+			s.price = price
+		}
+		for _, item := range corpus {
+			s.files++
+			// Every provider streams, so files are streamed for all of them
+			start := time.Now()
+			t, err := stt.TranscribeFile(ctx, item.audio, stt.TranscribeOptions{Config: config.Recognition(), Mode: stt.ModeStreaming})
+			elapsed := time.Since(start)
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				s.failed++
+				log.Printf("%s failed on %s: %v", config.Provider, item.audio, err)
+				continue
+			}
+			errs, words := stt.WordErrors(item.reference, t.Text)
+			s.errs += errs
+			s.words += words
+			s.elapsed += elapsed
+			if item.duration > 0 {
+				s.audio += item.duration
+			} else {
+				s.audio += t.Duration
+			}
+			log.Printf("%s: %s WER %.1f%% in %s", config.Provider, filepath.Base(item.audio), rate(errs, words)*100, elapsed.Round(time.Millisecond))
+		}
+		stats = append(stats, s)
+	}
+
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return fmt.Errorf("failed to create report: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if *format == "csv" {
+		return writeBenchCSV(w, stats)
+	}
+	return writeBenchMarkdown(w, stats)
+}
+
+// loadCorpus finds the audio files matching the patterns, with their
+// reference transcripts: a .txt file next to each, or the LibriSpeech
+// *.trans.txt file of its directory, whose lines are "<id> <text>" for
+// <id>.flac.
+func loadCorpus(patterns []string) ([]benchItem, error) {
+	var items []benchItem
+	transcripts := map[string]map[string]string{}
+	for _, pattern := range patterns {
+		if info, err := os.Stat(pattern); err == nil && info.IsDir() {
+			pattern = filepath.Join(pattern, "*")
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		for _, m := range matches {
+			if info, err := os.Stat(m); err != nil || info.IsDir() || filepath.Ext(m) == ".txt" {
+				continue
+			}
+			item := benchItem{audio: m}
+			if data, err := os.ReadFile(m); err == nil {
+				item.duration, _ = stt.WAVDuration(data)
+			}
+			base := strings.TrimSuffix(m, filepath.Ext(m))
+			if ref, err := os.ReadFile(base + ".txt"); err == nil {
+				item.reference = string(ref)
+				items = append(items, item)
+				continue
+			}
+			dir := filepath.Dir(m)
+			if _, ok := transcripts[dir]; !ok {
+				if transcripts[dir], err = readLibriSpeech(dir); err != nil {
+					return nil, err
+				}
+			}
+			ref, ok := transcripts[dir][filepath.Base(base)]
+			if !ok {
+				log.Printf("Skipping %s without a reference transcript", m)
+				continue
+			}
+			item.reference = ref
+			items = append(items, item)
+		}
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("no labeled audio files match %v", patterns)
+	}
+	return items, nil
+}
+
+// readLibriSpeech reads the utterances of the *.trans.txt files in dir by
+// ID.
+func readLibriSpeech(dir string) (map[string]string, error) {
+	refs := map[string]string{}
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.trans.txt"))
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			if id, text, ok := strings.Cut(sc.Text(), " "); ok {
+				refs[id] = text
+			}
+		}
+		f.Close()
+		if err := sc.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+	}
+	return refs, nil
+}
+
+func rate(n, of int) float64 {
+	if of == 0 {
+		return 0
+	}
+	return float64(n) / float64(of)
+}
+
+// columns returns the report row of s.
+func (s *benchStats) columns() []string {
+	done := s.files - s.failed
+	cols := []string{
+		s.provider,
+		strconv.Itoa(s.files),
+		strconv.Itoa(s.failed),
+		"", "", "", "",
+	}
+	if done > 0 {
+		cols[3] = fmt.Sprintf("%.2f", rate(s.errs, s.words)*100)
+		cols[4] = (s.elapsed / time.Duration(done)).Round(time.Millisecond).String()
+		if s.audio > 0 {
+			cols[5] = fmt.Sprintf("%.2f", s.elapsed.Seconds()/s.audio.Seconds())
+		}
+	}
+	if s.price >= 0 {
+		cols[6] = fmt.Sprintf("%.4f", s.audio.Minutes()*s.price)
+	}
+	return cols
+}
+
+var benchHeader = []string{"provider", "files", "failed", "wer_percent", "mean_latency", "real_time_factor", "cost"}
+
+func writeBenchCSV(w io.Writer, stats []*benchStats) error {
+	cw := csv.NewWriter(w)
+	cw.Write(benchHeader)
+	for _, s := range stats {
+		cw.Write(s.columns())
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeBenchMarkdown(w io.Writer, stats []*benchStats) error {
+	fmt.Fprintln(w, "| Provider | Files | Failed | WER | Mean latency | Real-time factor | Cost |")
+	fmt.Fprintln(w, "|---|---:|---:|---:|---:|---:|---:|")
+	for _, s := range stats {
+		cols := s.columns()
+		if cols[3] != "" {
+			cols[3] += "%"
+		}
+		for i, c := range cols {
+			if c == "" {
+				cols[i] = "-"
+			}
+		}
+		if _, err := fmt.Fprintf(w, "| %s |\n", strings.Join(cols, " | ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/api/googleapi"
+
+	"stt-receivetranscription-mve/stt"
+)
+
+// claimRetention is how long a completed claim is kept, so that duplicate
+// deliveries of a job are skipped rather than transcribed again.
+const claimRetention = 24 * time.Hour
+
+// errClaimLost reports that a claim expired or was taken over by another
+// instance while its work was in progress.
+var errClaimLost = errors.New("claim lost to another instance")
+
+// claimState is the outcome of taking a claim.
+type claimState int
+
+const (
+	claimAcquired claimState = iota
+	// claimHeld means another instance holds the claim.
+	claimHeld
+	// claimDone means another instance completed the claimed work.
+	claimDone
+)
+
+// leaseStore keeps expiring leases on keys, shared by the instances that
+// coordinate through it.
+type leaseStore interface {
+	// acquire takes the lease on key for token until ttl passes, unless
+	// another token holds it or it is completed.
+	acquire(ctx context.Context, key, token string, ttl time.Duration) (claimState, error)
+	// renew extends token's lease on key by ttl, failing with errClaimLost
+	// once it no longer holds it.
+	renew(ctx context.Context, key, token string, ttl time.Duration) error
+	// release gives up token's lease on key.
+	release(ctx context.Context, key, token string) error
+	// complete replaces token's lease on key with a completion mark kept for
+	// retention.
+	complete(ctx context.Context, key, token string, retention time.Duration) error
+	Close() error
+}
+
+// claimer claims work items in a lease store, so that instances sharing a
+// watch folder or job queue process each item once. A nil claimer claims
+// every item without coordinating.
+type claimer struct {
+	store     leaseStore
+	ttl       time.Duration
+	namespace string
+	// owner identifies this instance in the leases it holds.
+	owner string
+}
+
+// openClaimer opens the lease store of a redis:// or rediss:// URI, or of a
+// gs://bucket[/prefix] URI whose objects are the leases, prefixing every key
+// with namespace. Leases last ttl unless renewed, bounding how long the item
+// of a crashed instance stays claimed.
+func openClaimer(uri string, ttl time.Duration, namespace string) (*claimer, error) {
+	if ttl < 3*time.Second {
+		return nil, fmt.Errorf("-claim-ttl must be at least 3s")
+	}
+	var store leaseStore
+	switch {
+	case strings.HasPrefix(uri, "redis://"), strings.HasPrefix(uri, "rediss://"):
+		opts, err := redis.ParseURL(uri)
+		if err != nil {
+			return nil, fmt.Errorf("invalid claim store: %w", err)
+		}
+		store = redisLeases{redis.NewClient(opts)}
+	case strings.HasPrefix(uri, "gs://"):
+		bucket, prefix, _ := strings.Cut(strings.TrimPrefix(uri, "gs://"), "/")
+		if bucket == "" {
+			return nil, fmt.Errorf("invalid claim store %q: expected gs://bucket[/prefix]", uri)
+		}
+		client, err := storage.NewClient(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create storage client: %w", err)
+		}
+		if prefix != "" {
+			prefix = strings.TrimSuffix(prefix, "/") + "/"
+		}
+		store = &gcsLeases{client: client, bucket: client.Bucket(bucket), prefix: prefix, generations: map[string]int64{}}
+	default:
+		return nil, fmt.Errorf("unsupported claim store %q: expected redis://, rediss:// or gs://", uri)
+	}
+	host, _ := os.Hostname()
+	return &claimer{store: store, ttl: ttl, namespace: namespace, owner: host + "/" + stt.NewSessionID()}, nil
+}
+
+// Close closes the lease store.
+func (c *claimer) Close() error {
+	if c == nil {
+		return nil
+	}
+	return c.store.Close()
+}
+
+// claim takes key for this instance. A claim it acquires is renewed until
+// released or completed, and its context, derived from ctx, is cancelled
+// with errClaimLost if the claim is lost meanwhile.
+func (c *claimer) claim(ctx context.Context, key string) (*claim, claimState, error) {
+	if c == nil {
+		return &claim{ctx: ctx}, claimAcquired, nil
+	}
+	cl := &claim{c: c, key: c.namespace + key, token: c.owner + "/" + stt.NewSessionID()}
+	state, err := c.store.acquire(ctx, cl.key, cl.token, c.ttl)
+	if err != nil || state != claimAcquired {
+		return nil, state, err
+	}
+	var cancel context.CancelCauseFunc
+	cl.ctx, cancel = context.WithCancelCause(ctx)
+	cl.stop = make(chan struct{})
+	cl.stopped = make(chan struct{})
+	go cl.renew(cancel)
+	return cl, claimAcquired, nil
+}
+
+// claim is a claim held by this instance.
+type claim struct {
+	c     *claimer
+	key   string
+	token string
+
+	ctx     context.Context
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// Context is cancelled with errClaimLost if the claim is lost.
+func (cl *claim) Context() context.Context {
+	return cl.ctx
+}
+
+// renew extends the lease every third of its TTL until stopped, cancelling
+// the claim's context once it is lost, or once it could not be renewed for
+// a whole TTL.
+func (cl *claim) renew(cancel context.CancelCauseFunc) {
+	defer close(cl.stopped)
+	defer cancel(nil)
+	ticker := time.NewTicker(cl.c.ttl / 3)
+	defer ticker.Stop()
+	renewed := time.Now()
+	for {
+		select {
+		case <-cl.stop:
+			return
+		case <-cl.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		ctx, done := context.WithTimeout(cl.ctx, cl.c.ttl/3)
+		err := cl.c.store.renew(ctx, cl.key, cl.token, cl.c.ttl)
+		done()
+		switch {
+		case err == nil:
+			renewed = time.Now()
+		case errors.Is(err, errClaimLost) || time.Since(renewed) >= cl.c.ttl:
+			log.Printf("Lost claim on %s: %v", cl.key, err)
+			cancel(errClaimLost)
+			return
+		default:
+			log.Printf("Failed to renew claim on %s, retrying: %v", cl.key, err)
+		}
+	}
+}
+
+// Release gives up the claim, so another instance can take the item.
+func (cl *claim) Release() {
+	cl.end(func(ctx context.Context) error {
+		return cl.c.store.release(ctx, cl.key, cl.token)
+	})
+}
+
+// Complete marks the claimed item done for claimRetention, so other
+// instances skip it.
+func (cl *claim) Complete() {
+	cl.end(func(ctx context.Context) error {
+		return cl.c.store.complete(ctx, cl.key, cl.token, claimRetention)
+	})
+}
+
+// end stops renewing the claim and then updates its lease; a failure only
+// leaves the lease to expire.
+func (cl *claim) end(update func(context.Context) error) {
+	if cl.c == nil {
+		return
+	}
+	close(cl.stop)
+	<-cl.stopped
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := update(ctx); err != nil {
+		log.Printf("Failed to update claim on %s: %v", cl.key, err)
+	}
+}
+
+// redisLeases keeps leases as Redis keys holding their token, which expire
+// with the lease.
+type redisLeases struct {
+	client *redis.Client
+}
+
+// redisDone is the value of a completed key.
+const redisDone = "done"
+
+var (
+	redisRenew    = redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pexpire", KEYS[1], ARGV[2]) end return 0`)
+	redisComplete = redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then redis.call("set", KEYS[1], ARGV[2], "px", ARGV[3]) return 1 end return 0`)
+	redisRelease  = redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) end return 0`)
+)
+
+func (l redisLeases) acquire(ctx context.Context, key, token string, ttl time.Duration) (claimState, error) {
+	ok, err := l.client.SetNX(ctx, key, token, ttl).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to claim %s: %w", key, err)
+	}
+	if ok {
+		return claimAcquired, nil
+	}
+	value, err := l.client.Get(ctx, key).Result()
+	switch {
+	case err == redis.Nil:
+		// Expired since; taken on a later attempt
+		return claimHeld, nil
+	case err != nil:
+		return 0, fmt.Errorf("failed to claim %s: %w", key, err)
+	case value == redisDone:
+		return claimDone, nil
+	}
+	return claimHeld, nil
+}
+
+func (l redisLeases) renew(ctx context.Context, key, token string, ttl time.Duration) error {
+	n, err := redisRenew.Run(ctx, l.client, []string{key}, token, ttl.Milliseconds()).Int()
+	if err != nil {
+		return fmt.Errorf("failed to renew claim: %w", err)
+	}
+	if n == 0 {
+		return errClaimLost
+	}
+	return nil
+}
+
+func (l redisLeases) release(ctx context.Context, key, token string) error {
+	if err := redisRelease.Run(ctx, l.client, []string{key}, token).Err(); err != nil {
+		return fmt.Errorf("failed to release claim: %w", err)
+	}
+	return nil
+}
+
+func (l redisLeases) complete(ctx context.Context, key, token string, retention time.Duration) error {
+	n, err := redisComplete.Run(ctx, l.client, []string{key}, token, redisDone, retention.Milliseconds()).Int()
+	if err != nil {
+		return fmt.Errorf("failed to complete claim: %w", err)
+	}
+	if n == 0 {
+		return errClaimLost
+	}
+	return nil
+}
+
+func (l redisLeases) Close() error {
+	return l.client.Close()
+}
+
+// gcsLeases keeps leases as objects whose metadata holds their token and
+// expiry, changed only through generation preconditions so that one
+// instance wins each race. Expiry is judged by the local clock, so the
+// instances' clocks must agree to well within the TTL.
+type gcsLeases struct {
+	client *storage.Client
+	bucket *storage.BucketHandle
+	prefix string
+
+	mu sync.Mutex
+	// generations holds the object generation of each lease held, by token.
+	generations map[string]int64
+}
+
+// write replaces the lease object of key if cond holds, returning its new
+// generation.
+func (l *gcsLeases) write(ctx context.Context, key, token, state string, ttl time.Duration, cond storage.Conditions) (int64, error) {
+	w := l.bucket.Object(l.prefix + key).If(cond).NewWriter(ctx)
+	w.ContentType = "text/plain"
+	w.Metadata = map[string]string{
+		"token":   token,
+		"state":   state,
+		"expires": time.Now().Add(ttl).UTC().Format(time.RFC3339Nano),
+	}
+	if _, err := w.Write([]byte(token + "\n")); err != nil {
+		w.Close()
+		return 0, err
+	}
+	if err := w.Close(); err != nil {
+		return 0, err
+	}
+	return w.Attrs().Generation, nil
+}
+
+func preconditionFailed(err error) bool {
+	var gerr *googleapi.Error
+	return errors.As(err, &gerr) && gerr.Code == http.StatusPreconditionFailed
+}
+
+func (l *gcsLeases) acquire(ctx context.Context, key, token string, ttl time.Duration) (claimState, error) {
+	gen, err := l.write(ctx, key, token, "held", ttl, storage.Conditions{DoesNotExist: true})
+	if preconditionFailed(err) {
+		attrs, aerr := l.bucket.Object(l.prefix + key).Attrs(ctx)
+		if errors.Is(aerr, storage.ErrObjectNotExist) {
+			return claimHeld, nil
+		}
+		if aerr != nil {
+			return 0, fmt.Errorf("failed to claim %s: %w", key, aerr)
+		}
+		expires, perr := time.Parse(time.RFC3339Nano, attrs.Metadata["expires"])
+		if perr == nil && time.Now().Before(expires) {
+			if attrs.Metadata["state"] == "done" {
+				return claimDone, nil
+			}
+			return claimHeld, nil
+		}
+		// Expired: take it over, unless another instance does first
+		gen, err = l.write(ctx, key, token, "held", ttl, storage.Conditions{GenerationMatch: attrs.Generation})
+		if preconditionFailed(err) {
+			return claimHeld, nil
+		}
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to claim %s: %w", key, err)
+	}
+	l.mu.Lock()
+	l.generations[token] = gen
+	l.mu.Unlock()
+	return claimAcquired, nil
+}
+
+// update rewrites token's lease object of key if it still holds it.
+func (l *gcsLeases) update(ctx context.Context, key, token, state string, ttl time.Duration) error {
+	l.mu.Lock()
+	gen := l.generations[token]
+	l.mu.Unlock()
+	gen, err := l.write(ctx, key, token, state, ttl, storage.Conditions{GenerationMatch: gen})
+	if preconditionFailed(err) {
+		return errClaimLost
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update claim: %w", err)
+	}
+	l.mu.Lock()
+	l.generations[token] = gen
+	l.mu.Unlock()
+	return nil
+}
+
+func (l *gcsLeases) renew(ctx context.Context, key, token string, ttl time.Duration) error {
+	return l.update(ctx, key, token, "held", ttl)
+}
+
+func (l *gcsLeases) release(ctx context.Context, key, token string) error {
+	l.mu.Lock()
+	gen := l.generations[token]
+	delete(l.generations, token)
+	l.mu.Unlock()
+	err := l.bucket.Object(l.prefix + key).If(storage.Conditions{GenerationMatch: gen}).Delete(ctx)
+	if err != nil && !preconditionFailed(err) && !errors.Is(err, storage.ErrObjectNotExist) {
+		return fmt.Errorf("failed to release claim: %w", err)
+	}
+	return nil
+}
+
+func (l *gcsLeases) complete(ctx context.Context, key, token string, retention time.Duration) error {
+	defer func() {
+		l.mu.Lock()
+		delete(l.generations, token)
+		l.mu.Unlock()
+	}()
+	return l.update(ctx, key, token, "done", retention)
+}
+
+func (l *gcsLeases) Close() error {
+	return l.client.Close()
+}
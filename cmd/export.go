@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"stt-receivetranscription-mve/output"
+	"stt-receivetranscription-mve/store"
+)
+
+// runExport packages selected stored sessions into a portable archive.
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	storePath := fs.String("store", "", "SQLite database to export from")
+	outPath := fs.String("out", "", "Path of the zip archive to write")
+	includeAudio := fs.Bool("audio", false, "Include referenced audio files")
+	since := fs.String("since", "", "Only sessions created at or after this RFC 3339 time")
+	until := fs.String("until", "", "Only sessions created before this RFC 3339 time")
+	var ids, tags stringList
+	fs.Var(&ids, "session", "Session ID to export (repeatable; default all matching sessions)")
+	fs.Var(&tags, "tag", "Only sessions with this key=value tag (repeatable)")
+	fs.Parse(args)
+
+	if *storePath == "" || *outPath == "" {
+		return fmt.Errorf("both -store and -out must be set")
+	}
+
+	st, err := store.Open(*storePath)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	ctx := context.Background()
+	if len(ids) == 0 {
+		q := store.Query{Tags: map[string]string{}, Limit: 500}
+		for _, tag := range tags {
+			k, v, err := output.ParseTag(tag)
+			if err != nil {
+				return err
+			}
+			q.Tags[k] = v
+		}
+		for name, dst := range map[string]*time.Time{*since: &q.Since, *until: &q.Until} {
+			if name == "" {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, name)
+			if err != nil {
+				return fmt.Errorf("invalid time %q: %w", name, err)
+			}
+			*dst = t
+		}
+		for {
+			sessions, next, err := st.ListSessions(ctx, q)
+			if err != nil {
+				return err
+			}
+			for _, sess := range sessions {
+				ids = append(ids, sess.ID)
+			}
+			if next == "" {
+				break
+			}
+			q.Cursor = next
+		}
+	}
+
+	f, err := os.Create(*outPath)
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %w", err)
+	}
+	defer f.Close()
+
+	manifest, err := st.Export(ctx, f, ids, *includeAudio)
+	if err != nil {
+		return err
+	}
+	log.Printf("Exported %d sessions to %s", len(manifest.Sessions), *outPath)
+	return f.Close()
+}
+
+// runImport loads an archive written by export into a store.
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	storePath := fs.String("store", "", "SQLite database to import into")
+	inPath := fs.String("in", "", "Path of the zip archive to read")
+	audioDir := fs.String("audio-dir", "", "Directory to extract archived audio into (skipped if unset)")
+	fs.Parse(args)
+
+	if *storePath == "" || *inPath == "" {
+		return fmt.Errorf("both -store and -in must be set")
+	}
+
+	st, err := store.Open(*storePath)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	n, err := st.Import(context.Background(), *inPath, *audioDir)
+	if err != nil {
+		return err
+	}
+	log.Printf("Imported %d sessions from %s", n, *inPath)
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// flagEnvPrefix prefixes the environment variables that set flags, such as
+// STT_DRAIN_TIMEOUT for -drain-timeout.
+const flagEnvPrefix = "STT_"
+
+// flagEnv returns the environment variable that sets the flag name.
+func flagEnv(name string) string {
+	return flagEnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// configureFlags sets the flags of fs not given on the command line from
+// their environment variables, or else from the config at path: a file of
+// name=value lines, or a directory holding one file per flag named after
+// it, as a Kubernetes ConfigMap is mounted. Flags given on the command line
+// win over the environment, which wins over the config.
+func configureFlags(fs *flag.FlagSet, path string) error {
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	values := map[string]string{}
+	if path != "" {
+		var err error
+		if values, err = readFlagConfig(path); err != nil {
+			return err
+		}
+	}
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || given[f.Name] {
+			return
+		}
+		value, ok := os.LookupEnv(flagEnv(f.Name))
+		source := flagEnv(f.Name)
+		if !ok {
+			if value, ok = values[f.Name]; !ok {
+				return
+			}
+			source = fmt.Sprintf("%s in %s", f.Name, path)
+		}
+		if serr := fs.Set(f.Name, value); serr != nil {
+			err = fmt.Errorf("invalid %s: %w", source, serr)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	for name := range values {
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("unknown flag %q in %s", name, path)
+		}
+	}
+	return nil
+}
+
+// readFlagConfig reads the flag values of the config file or directory at
+// path by flag name.
+func readFlagConfig(path string) (map[string]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	values := map[string]string{}
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config: %w", err)
+		}
+		for _, e := range entries {
+			// ConfigMap volumes keep their data in hidden entries
+			if strings.HasPrefix(e.Name(), ".") {
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(path, e.Name()))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read config: %w", err)
+			}
+			values[e.Name()] = strings.TrimSpace(string(data))
+		}
+		return values, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid config %s: line %d is not name=value", path, n)
+		}
+		values[strings.TrimPrefix(strings.TrimSpace(name), "-")] = strings.TrimSpace(value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	return values, nil
+}
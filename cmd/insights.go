@@ -0,0 +1,67 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+
+	"stt-receivetranscription-mve/insights"
+	"stt-receivetranscription-mve/output"
+	"stt-receivetranscription-mve/stt"
+)
+
+// collector is a sink keeping the final speech segments of a session for
+// post-processing.
+type collector struct {
+	mu       sync.Mutex
+	segments []stt.Segment
+}
+
+func (c *collector) Write(_ context.Context, r output.Record) error {
+	if !r.IsFinal || r.Event != "" {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.segments = append(c.segments, r.Segment)
+	return nil
+}
+
+func (c *collector) Close() error { return nil }
+
+// transcript returns the collected segments in timeline order.
+func (c *collector) transcript() stt.Transcript {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	segments := slices.Clone(c.segments)
+	slices.SortStableFunc(segments, func(a, b stt.Segment) int {
+		return cmp.Compare(a.Start, b.Start)
+	})
+	return stt.TranscriptFromSegments(segments)
+}
+
+// extractInsights writes the questions, action items and decisions of the
+// collected transcript to outputs as event records following it.
+func extractInsights(ctx context.Context, config *Config, extractor insights.Extractor, outputs output.Sink, c *collector) error {
+	items, err := extractor.Extract(ctx, c.transcript())
+	if err != nil {
+		return fmt.Errorf("failed to extract insights: %w", err)
+	}
+	sinks := output.WithSession(outputs, output.Session{
+		ID:    config.SessionID,
+		Tags:  config.Tags,
+		Audio: config.WAVInputPath,
+	})
+	for _, item := range items {
+		record := eventRecord(item.Kind, item.Text, item.Start, item.End)
+		record.Speaker = item.Speaker
+		if err := sinks.Write(ctx, record); err != nil {
+			return fmt.Errorf("failed to write insights to outputs: %w", err)
+		}
+	}
+	log.Printf("Extracted %d questions, action items and decisions", len(items))
+	return nil
+}
@@ -1,287 +1,2027 @@
 package main
 
 import (
+	"bufio"
+	"cmp"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"maps"
+	"net"
 	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	speech "cloud.google.com/go/speech/apiv2"
-	speechpb "cloud.google.com/go/speech/apiv2/speechpb"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/api/impersonate"
 	"google.golang.org/api/option"
+
+	"stt-receivetranscription-mve/audio"
+	"stt-receivetranscription-mve/insights"
+	"stt-receivetranscription-mve/output"
+	"stt-receivetranscription-mve/store"
+	"stt-receivetranscription-mve/stt"
 )
 
+// defaultOutput keeps the original behaviour of logging every result.
+const defaultOutput = "text,partials=true,confidence=true:log"
+
+// stringList is a flag.Value collecting every occurrence of a repeated flag.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ", ") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// parseMetadata parses a -metadata key=value pair; gRPC metadata keys are
+// lowercase.
+func parseMetadata(kv string) (string, string, error) {
+	k, v, ok := strings.Cut(kv, "=")
+	k = strings.ToLower(k)
+	if !ok || k == "" || strings.Trim(k, "abcdefghijklmnopqrstuvwxyz0123456789-_.") != "" {
+		return "", "", fmt.Errorf("invalid metadata %q: expected key=value with a key of letters, digits, -, _ and .", kv)
+	}
+	if strings.HasSuffix(k, "-bin") || strings.HasPrefix(k, "grpc-") {
+		return "", "", fmt.Errorf("invalid metadata %q: binary and grpc- keys are reserved", kv)
+	}
+	return k, v, nil
+}
+
 type Config struct {
+	Provider     string
 	ProjectID    string
 	Region       string
 	RecognizerID string
 	PrimaryLang  string
+	Model        string
+	Fallback     string
 	WAVInputPath string
 	OneShot      bool
+	Outputs      []string
+	SessionID    string
+	Tags         map[string]string
+	StorePath    string
+	Embedder     string
+	Timeout      time.Duration
+
+	// OutputDefaults are the options of outputs that do not set them.
+	OutputDefaults output.Options
+	// Locale renders the times and numbers of the session summary, like
+	// those of text outputs.
+	Locale output.Locale
+
+	// ClientOptions configure the Google speech clients, from
+	// GOOGLE_IMPERSONATE_SERVICE_ACCOUNT and GOOGLE_USER_AGENT or
+	// -user-agent.
+	ClientOptions []option.ClientOption
+	// Metadata is sent with every Google call, from -metadata and
+	// -request-reason.
+	Metadata map[string]string
+
+	// BatchOutput is the gs:// prefix batch recognition of a gs:// input
+	// writes its results under; they are returned inline if unset.
+	BatchOutput string
+
+	DownloadTimeout time.Duration
+	DownloadRetries int
+	// IdleTimeout ends the stream of a named pipe -wav-in after this long
+	// without audio; otherwise it is read until interrupted.
+	IdleTimeout time.Duration
+
+	// Audit records the requests and responses of streaming sessions in this
+	// file.
+	Audit        string
+	AuditOptions output.AuditOptions
+	audit        *output.AuditLog
+
+	// FFmpeg transcodes inputs that are not decoded natively, such as MP4
+	// or WebM, to 16 kHz mono PCM.
+	FFmpeg string
+	// Resample downmixes WAV input to mono and downsamples it to this rate
+	// before sending it, if above; 0 sends it as it is.
+	Resample        int
+	ResampleQuality string
+	// Denoise suppresses steady background noise in WAV input before
+	// sending it, set by DenoiseOptions.
+	Denoise        bool
+	DenoiseOptions audio.DenoiseOptions
+	// Normalize brings WAV input to a loudness before sending it, set by
+	// NormalizeOptions.
+	Normalize        bool
+	NormalizeOptions audio.NormalizeOptions
+
+	StallTimeout   time.Duration
+	RestartOnStall bool
+	DrainTimeout   time.Duration
+	// Keepalive injects silence into live audio after this long without
+	// any, such as between push-to-talk bursts.
+	Keepalive time.Duration
+
+	UtteranceTimeout time.Duration
+
+	CheckRecognizer bool
+
+	MaxBilled      time.Duration
+	MaxCost        float64
+	PricePerMinute float64
+
+	Budget       time.Duration
+	BudgetPeriod string
+
+	PaceBytes      int
+	RealtimeFactor float64
+
+	// Languages, when more than one, are each transcribed in parallel.
+	Languages []string
+	// Tracks are transcribed separately and merged into one transcript.
+	Tracks []stt.Track
+	// Channels is how multi-channel -wav-in files are sent: "mix" downmixes
+	// them to mono, "split" transcribes each channel in its own session
+	// labelled with its ChannelNames entry, and "" sends them as they are.
+	Channels     string
+	ChannelNames []string
+	// Alternatives are recognized alongside PrimaryLang in the same session,
+	// for code-switching speech.
+	Alternatives []string
+
+	// DTMF injects locally detected DTMF digits into the results.
+	DTMF bool
+	// SuppressHold cuts ringback and hold music out of the audio before
+	// recognition, marking their spans in the results.
+	SuppressHold bool
+	holdSpans    []audio.Span
+	timeline     *audio.Timeline
+	// VAD cuts stretches without speech out of the audio before
+	// recognition, detected with VADOptions.
+	VAD        bool
+	VADOptions audio.VADOptions
+	// TrimSilence cuts leading and trailing silence out of the audio before
+	// recognition, detected with TrimOptions.
+	TrimSilence bool
+	TrimOptions audio.TrimOptions
+	// AudioEvents tags music, applause, laughter and silence locally.
+	AudioEvents bool
+
+	// Mic streams an input device, captured at MicRate, instead of a WAV
+	// file: MicDevice, by index or name, or else the default one.
+	Mic       bool
+	MicRate   int
+	MicDevice string
+	// Loopback captures what the default output device plays instead of an
+	// input device, and implies Mic.
+	Loopback bool
+	// ListDevices lists the input devices -mic can capture from and exits.
+	ListDevices bool
+
+	// QueueDir spools live -mic or RTP audio to disk in QueueSegment long
+	// segments and transcribes them in order, keeping them queued while the
+	// recognizer is unreachable.
+	QueueDir     string
+	QueueSegment time.Duration
+
+	// AGC levels live -mic and RTP audio with automatic gain control,
+	// configured by AGCOptions, before it is sent.
+	AGC        bool
+	AGCOptions audio.AGCOptions
+
+	// RTP streams the audio of RTP packets received on this UDP address
+	// instead of a WAV file.
+	RTP        string
+	RTPOptions audio.RTPOptions
+	// RTSPURL plays the audio track of an RTSP stream instead, reconnecting
+	// up to RTSPRetries times in a row when the session drops.
+	RTSPURL     string
+	RTSPRetries int
+
+	// AudioSocket accepts Asterisk AudioSocket calls on this TCP address
+	// instead of a WAV file, transcribing each call as its own session.
+	AudioSocket string
+
+	// SIPREC accepts SIPREC recording sessions on this UDP address instead
+	// of a WAV file, transcribing each forked RTP stream as its own session.
+	SIPREC string
+
+	// Kafka consumes PCM frames keyed by session ID from this
+	// kafka://broker/topic URI instead of a WAV file, transcribing each key
+	// as its own session.
+	Kafka        string
+	KafkaOptions audio.KafkaOptions
+
+	// LiveURL follows an HLS, Icecast or SHOUTcast broadcast instead of a WAV
+	// file, decoding it with ffmpeg.
+	LiveURL string
+
+	// Paragraphs regroups final results into readable paragraphs.
+	Paragraphs       bool
+	ParagraphOptions stt.ParagraphOptions
+
+	// Extract names the extractor of questions, action items and decisions
+	// written after the transcript.
+	Extract string
+
+	// WordConfidence requests per-word confidences, which html outputs
+	// highlight.
+	WordConfidence bool
+	// Boost and RedactPII are mapped onto each provider's options.
+	Boost     []string
+	RedactPII bool
+	// ChunkDuration and ChunkOverlap chunk streamed audio for providers
+	// without native streaming.
+	ChunkDuration time.Duration
+	ChunkOverlap  time.Duration
+	// Compress names the codec streamed audio is compressed with.
+	Compress string
+
+	AudioConfig  string
+	AudioProfile string
+	// ChunkSize and ChunkInterval come from the audio profile.
+	ChunkSize     int
+	ChunkInterval time.Duration
+}
+
+// google reports whether recognition uses the Google provider.
+func (c *Config) google() bool {
+	return c.Provider == "" || c.Provider == stt.ProviderGoogle
+}
+
+// loadEnv fills in the recognizer settings taken from the environment.
+// Other providers read their credentials themselves.
+func (c *Config) loadEnv() error {
+	if !c.google() {
+		return nil
+	}
+	c.ProjectID = os.Getenv("GOOGLE_PROJECT_ID")
+	c.Region = os.Getenv("GOOGLE_REGION")
+	c.RecognizerID = os.Getenv("RECOGNIZER_ID")
+
+	if c.ProjectID == "" {
+		return fmt.Errorf("GOOGLE_PROJECT_ID environment variable is not set")
+	}
+
+	if c.Region == "" {
+		c.Region = "global"
+		log.Printf("Missing GOOGLE_REGION environment variable, using %s", c.Region)
+	}
+
+	if c.RecognizerID == "" {
+		return fmt.Errorf("RECOGNIZER_ID environment variable is not set")
+	}
+
+	if account := os.Getenv("GOOGLE_IMPERSONATE_SERVICE_ACCOUNT"); account != "" {
+		// The token source refreshes tokens for as long as the process runs
+		ts, err := impersonate.CredentialsTokenSource(context.Background(), impersonate.CredentialsConfig{
+			TargetPrincipal: account,
+			Scopes:          []string{"https://www.googleapis.com/auth/cloud-platform"},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to impersonate %s: %w", account, err)
+		}
+		c.ClientOptions = append(c.ClientOptions, option.WithTokenSource(ts))
+	}
+	if ua := os.Getenv("GOOGLE_USER_AGENT"); ua != "" {
+		c.ClientOptions = append(c.ClientOptions, option.WithUserAgent(ua))
+	}
+	return nil
+}
+
+// gcs reports whether the input is a gs:// URI, transcribed with batch
+// recognition.
+func (c *Config) gcs() bool {
+	return strings.HasPrefix(c.WAVInputPath, "gs://")
+}
+
+// streamed reports whether the audio is streamed as it is read, from stdin,
+// an http(s):// URL, an s3:// object or a named pipe.
+func (c *Config) streamed() bool {
+	return c.WAVInputPath == "-" || strings.HasPrefix(c.WAVInputPath, "http://") ||
+		strings.HasPrefix(c.WAVInputPath, "https://") || strings.HasPrefix(c.WAVInputPath, "s3://") || c.fifo()
+}
+
+// fifo reports whether -wav-in is a named pipe, read with tail semantics.
+func (c *Config) fifo() bool {
+	return c.WAVInputPath != "" && audio.IsFIFO(c.WAVInputPath)
+}
+
+// rtp reports whether the audio is received as RTP, on -rtp or from
+// -rtsp-url.
+func (c *Config) rtp() bool {
+	return c.RTP != "" || c.RTSPURL != ""
+}
+
+// paragraphs regroups t into paragraphs if -paragraphs is set.
+func (c *Config) paragraphs(t stt.Transcript) stt.Transcript {
+	if !c.Paragraphs {
+		return t
+	}
+	return t.Paragraphs(c.ParagraphOptions)
+}
+
+// billingCeiling returns the most audio a streaming session may send, the
+// lower of -max-billed and the audio -max-cost pays for, or 0 for no limit.
+func (c *Config) billingCeiling() time.Duration {
+	ceiling := c.MaxBilled
+	if c.MaxCost > 0 && c.PricePerMinute > 0 {
+		d := time.Duration(c.MaxCost / c.PricePerMinute * float64(time.Minute))
+		if ceiling == 0 || d < ceiling {
+			ceiling = d
+		}
+	}
+	return ceiling
+}
+
+// Recognition returns the library configuration for this session.
+func (c *Config) Recognition() stt.Config {
+	return stt.Config{
+		Provider:       c.Provider,
+		ProjectID:      c.ProjectID,
+		Region:         c.Region,
+		RecognizerID:   c.RecognizerID,
+		LanguageCodes:  append([]string{c.PrimaryLang}, c.Alternatives...),
+		Model:          c.Model,
+		FallbackModel:  c.Fallback,
+		WordConfidence: c.WordConfidence,
+		Boost:          c.Boost,
+		RedactPII:      c.RedactPII,
+		ChunkDuration:  c.ChunkDuration,
+		ChunkOverlap:   c.ChunkOverlap,
+		Compression:    c.Compress,
+		ClientOptions:  c.ClientOptions,
+		Metadata:       c.Metadata,
+	}
 }
 
 func loadConfig() (*Config, error) {
 	// Parse command line flags
 	primaryLang := flag.String("primary", "en-US", "Primary language code")
-	wavInPath := flag.String("wav-in", "", "Path to read WAV file from")
+	provider := flag.String("provider", stt.ProviderGoogle, "Recognition provider: "+stt.ProviderGoogle+", "+stt.ProviderAssemblyAI+", "+stt.ProviderWhisper+", "+stt.ProviderRiva+" or "+stt.ProviderVosk)
+	boost := flag.String("boost", "", "Comma-separated words and phrases to bias recognition towards")
+	chunkDuration := flag.Duration("chunk-duration", 10*time.Second, "Length of the overlapping chunks streamed audio is transcribed in by providers without native streaming (whisper); longer is more accurate but slower")
+	chunkOverlap := flag.Duration("chunk-overlap", time.Second, "Overlap of consecutive -chunk-duration chunks, stitched in its middle")
+	compress := flag.String("compress", "", "Compress 16-bit PCM audio before streaming it to save uplink bandwidth: flac (the provider must decode FLAC)")
+	redactPII := flag.Bool("redact-pii", false, "Have the provider redact personal information such as names and phone numbers")
+	model := flag.String("model", "", "Recognition model (default "+stt.DefaultModel+")")
+	fallback := flag.String("fallback-model", "", "Model to retry with if -model is not available for the region or language")
+	wavInPath := flag.String("wav-in", "", "Path to read WAV file from, - to stream it from stdin, a named pipe to stream it from as other processes write it, an http(s):// URL or s3:// object to stream as it downloads, or a gs:// URI for batch recognition")
+	idleTimeout := flag.Duration("idle-timeout", 0, "End a named pipe -wav-in stream once nothing has been written to it for this long (0 reads it until interrupted)")
+	downloadTimeout := flag.Duration("download-timeout", 0, "Give up downloading an http(s):// input after this long (0 for no limit)")
+	resample := flag.Int("resample", 0, "Downmix WAV input to mono and downsample it to this rate, such as 16000, before sending it, to cut bandwidth and cost (0 sends it as it is)")
+	denoise := flag.Bool("denoise", false, "Suppress steady background noise, such as wind, traffic or hum, in file inputs with a spectral gate before sending them, for noisy field recordings")
+	denoiseReduction := flag.Float64("denoise-reduction", 12, "How far -denoise attenuates noise, in dB; more also distorts speech more")
+	denoiseThreshold := flag.Float64("denoise-threshold", 6, "How far above the noise floor, in dB, a frequency must rise for -denoise to let it through")
+	normalize := flag.Bool("normalize", false, "Normalize the loudness of file inputs to -normalize-target before sending them, to help recognition of very quiet recordings")
+	normalizeTarget := flag.Float64("normalize-target", -23, "Loudness -normalize brings audio to, in LUFS (or dBFS with -normalize-rms)")
+	normalizeRMS := flag.Bool("normalize-rms", false, "Measure the level -normalize targets as plain RMS instead of gated, K-weighted loudness (ITU-R BS.1770)")
+	truePeak := flag.Float64("true-peak", -1, "Ceiling in dBTP the limiter of -normalize keeps peaks under")
+	limiter := flag.Bool("limiter", true, "Limit true peaks after -normalize amplifies the audio; false lets them clip")
+	resampleQuality := flag.String("resample-quality", audio.ResampleMedium, "Quality of -resample: "+audio.ResampleLinear+" (cheapest, but aliases), "+audio.ResampleMedium+" or "+audio.ResampleHigh+" (sharpest filter, several times the CPU)")
+	ffmpeg := flag.String("ffmpeg", "ffmpeg", "ffmpeg executable used to transcode -wav-in media that is not WAV, MP3, Ogg Vorbis or FLAC, such as MP4, MKV, M4A or WebM, and -live-url broadcasts")
+	downloadRetries := flag.Int("download-retries", 3, "Retry a failed http(s):// download this many times, resuming where it stopped, or a dropped -live-url broadcast this many times in a row")
+	batchOutput := flag.String("batch-output", "", "gs:// prefix batch recognition writes its results under, needed for long audio (inline results if unset)")
+	mic := flag.Bool("mic", false, "Transcribe the default input device live until interrupted, instead of -wav-in")
+	micRate := flag.Int("mic-rate", 16000, "Sample rate to capture -mic or -loopback audio at")
+	loopback := flag.Bool("loopback", false, "Transcribe whatever the default output device is playing, such as a meeting or video, live until interrupted, instead of -wav-in; captured with WASAPI loopback on Windows or the PulseAudio monitor source on Linux")
+	micDevice := flag.String("device", "", "Input device to capture -mic audio from, by index or name as listed by -list-devices (default the system default)")
+	listDevices := flag.Bool("list-devices", false, "List the input devices -mic can capture from and exit")
+	queueDir := flag.String("queue-dir", "", "Queue live -mic, -rtp or -rtsp-url audio in this directory and transcribe it segment by segment, keeping the backlog on disk while the recognizer is unreachable")
+	agc := flag.Bool("agc", true, "Apply automatic gain control to live -mic, -loopback, -rtp and -rtsp-url audio, so speakers near and far from the microphone arrive at a steady level; disable it for audio already leveled upstream")
+	agcTarget := flag.Float64("agc-target", -20, "RMS level in dBFS -agc brings speech to")
+	agcMaxGain := flag.Float64("agc-max-gain", 30, "Most -agc amplifies quiet audio by, in dB")
+	agcAttack := flag.Duration("agc-attack", 10*time.Millisecond, "How fast -agc lowers the gain when the audio gets louder")
+	agcRelease := flag.Duration("agc-release", 500*time.Millisecond, "How fast -agc raises the gain again when the audio gets quieter")
+	queueSegment := flag.Duration("queue-segment", 15*time.Second, "Length of the -queue-dir segments, and so the delay before audio is transcribed")
+	rtp := flag.String("rtp", "", "Transcribe PCMU, PCMA, L16 or Opus audio received as RTP on this UDP address (such as :5004) until interrupted, instead of -wav-in")
+	rtpPayload := flag.Int("rtp-payload", 0, "Dynamic RTP payload type carrying L16 audio at -rtp-rate and -rtp-channels (0 for none)")
+	rtpRate := flag.Int("rtp-rate", 16000, "Sample rate of L16 audio on -rtp-payload")
+	rtpChannels := flag.Int("rtp-channels", 1, "Channel count of L16 audio on -rtp-payload")
+	rtpOpusPayload := flag.Int("rtp-opus-payload", 0, "Dynamic RTP payload type carrying Opus audio, such as 111 from WebRTC (0 for none)")
+	rtpJitter := flag.Duration("rtp-jitter", 60*time.Millisecond, "How long to wait for RTP packets arriving out of order before treating them as lost")
+	rtspURL := flag.String("rtsp-url", "", "Transcribe the PCMU, PCMA or L16 audio track of an rtsp:// stream, such as an IP camera, until interrupted, instead of -wav-in")
+	rtspRetries := flag.Int("rtsp-retries", 5, "Reconnect a dropped -rtsp-url session this many times in a row before giving up")
+	audioSocket := flag.String("audiosocket", "", "Transcribe calls piped by Asterisk's AudioSocket application to this TCP address (such as :9092), each as a session named by the call's UUID, until interrupted, instead of -wav-in")
+	siprec := flag.String("siprec", "", "Accept SIPREC recording sessions forked by a session recording client to this UDP address (such as :5060), transcribing each RTP stream of a call as its own session, until interrupted, instead of -wav-in")
+	kafka := flag.String("kafka", "", "Consume 16-bit PCM frames keyed by session ID from a kafka://broker[,broker]/topic URI, transcribing each key as its own session, until interrupted, instead of -wav-in")
+	kafkaGroup := flag.String("kafka-group", "stt", "Consumer group of -kafka")
+	kafkaRate := flag.Int("kafka-rate", 16000, "Sample rate of the -kafka frames")
+	kafkaChannels := flag.Int("kafka-channels", 1, "Channel count of the -kafka frames")
+	kafkaIdle := flag.Duration("kafka-idle", 30*time.Second, "End a -kafka session once no frames have arrived for its key for this long")
+	liveURL := flag.String("live-url", "", "Caption the HLS playlist or Icecast or SHOUTcast stream of a live broadcast at this http(s):// URL, decoded with ffmpeg and reconnected when it drops, until interrupted or it ends, instead of -wav-in")
+	rtpIdle := flag.Duration("rtp-idle", 0, "End the -rtp session once no packets have arrived for this long (0 waits until interrupted)")
 	oneShot := flag.Bool("one-shot", false, "Use one-shot recognition instead of streaming")
+	sessionID := flag.String("session-id", "", "Session ID attached to every output record (random if unset)")
+	audit := flag.String("audit", "", "Append an audit log of what streaming sessions send to the provider and receive, as JSON lines, to this file")
+	auditRedact := flag.Bool("audit-redact", false, "Redact digit sequences and email addresses from transcripts in the -audit log")
+	auditText := flag.Bool("audit-text", true, "Record transcripts in the -audit log")
+	auditAudio := flag.Bool("audit-audio", false, "Record the raw audio sent in the -audit log, not just its size and SHA-256 digest")
+	storePath := flag.String("store", "", "SQLite database to persist final results in")
+	embedder := flag.String("embedder", "", "Embedding backend (backend:model) for semantic search of stored results")
+	timeout := flag.Duration("timeout", 0, "Cancel the session after this long (0 for no limit)")
+	stallTimeout := flag.Duration("stall-timeout", 10*time.Second, "Report a stalled stream after this long without responses while sending (0 disables)")
+	restartOnStall := flag.Bool("restart-on-stall", false, "Restart a stalled stream, resuming after the last final result")
+	drainTimeout := flag.Duration("drain-timeout", 30*time.Second, "How long to keep receiving results after the last audio is sent")
+	keepalive := flag.Duration("keepalive", 0, "Inject a chunk of silence into live audio after this long without any, to keep the stream alive (0 disables)")
+	utteranceTimeout := flag.Duration("utterance-timeout", 0, "Flush the best partial as an unstable final if an utterance has no final result after this long (0 disables)")
+	checkRecognizer := flag.Bool("check-recognizer", true, "Warn when the recognizer's stored config conflicts with the requested settings")
+	maxBilled := flag.Duration("max-billed", 0, "Stop streaming after this much billed audio (0 for no limit)")
+	maxCost := flag.Float64("max-cost", 0, "Stop streaming once the session would cost more than this (0 for no limit)")
+	pricePerMinute := flag.Float64("price-per-minute", 0.016, "Price of one minute of audio, used by -max-cost")
+	budget := flag.Duration("budget", 0, "Refuse to start once this much audio has been billed in the budget period (0 for no budget)")
+	budgetPeriod := flag.String("budget-period", "daily", "Budget period: daily or monthly; shared through -store when set")
+	paceBytes := flag.Int("pace-bytes", 0, "Send audio at this many bytes per second instead of as fast as it plays (0 disables)")
+	realtimeFactor := flag.Float64("realtime-factor", 1, "Send audio this many times faster than it plays, such as 2 for double speed or 0.5 for half")
+	channels := flag.String("channels", "", "How to send a multi-channel -wav-in file: mix to downmix it to mono, or split to transcribe each channel in its own parallel session, such as the caller and agent of a call (default as it is)")
+	channelNames := flag.String("channel-names", "", "Comma-separated speaker labels of the -channels split channels in order, such as caller,agent (default ch1, ch2, ...)")
+	languages := flag.String("languages", "", "Comma-separated language codes to transcribe the audio with in parallel (overrides -primary)")
+	alternatives := flag.String("alternative-languages", "", "Comma-separated language codes recognized alongside -primary in the same session, for code-switching speech")
+	audioConfig := flag.String("audio-config", "", "Audio preprocessing config file (JSON) declaring pipeline profiles")
+	audioProfile := flag.String("audio-profile", "default", "Profile of -audio-config to preprocess the audio with")
+	dtmf := flag.Bool("dtmf", false, "Detect DTMF digits locally and add them to the results as dtmf events")
+	vad := flag.Bool("vad", false, "Detect speech locally and skip recognition of the silence between it, to cut the cost of recordings with long silences")
+	vadAggressiveness := flag.Int("vad-aggressiveness", 1, "How readily -vad judges audio silent, from 0 (only clear silence) to 3 (also noise and quiet speech)")
+	vadHangover := flag.Duration("vad-hangover", 300*time.Millisecond, "Audio -vad keeps on either side of speech so word onsets and tails are not clipped")
+	trimSilence := flag.Bool("trim-silence", false, "Cut leading and trailing silence out of file inputs before recognition, to avoid paying for dead air")
+	trimThreshold := flag.Float64("trim-threshold", -50, "Level in dBFS below which -trim-silence judges audio silent")
+	trimMin := flag.Duration("trim-min", 500*time.Millisecond, "Shortest leading or trailing silence -trim-silence cuts")
+	suppressHold := flag.Bool("suppress-hold", false, "Skip recognition of ringback and hold music, marking their spans in the results")
+	audioEvents := flag.Bool("audio-events", false, "Tag music, applause, laughter and silence locally and add them to the results as events")
+	wordConfidence := flag.Bool("word-confidence", false, "Request per-word confidences, so html outputs highlight individual low-confidence words")
+	paragraphs := flag.Bool("paragraphs", false, "Regroup final results into paragraphs by pauses, speaker changes and sentence boundaries")
+	paragraphPause := flag.Duration("paragraph-pause", 2*time.Second, "Start a new paragraph after a pause this long (with -paragraphs)")
+	paragraphSentences := flag.Int("paragraph-sentences", 5, "End a paragraph at the next sentence boundary after this many sentences (with -paragraphs)")
+	paragraphMax := flag.Duration("paragraph-max", time.Minute, "End a paragraph at the next sentence boundary once it lasts this long (with -paragraphs)")
+	extract := flag.String("extract", "", "Extract questions, action items and decisions after the transcript: rules, or an LLM as openai:model or ollama:model")
+	removeFillers := flag.Bool("remove-fillers", false, "Drop filler words such as \"um\" from rendered outputs without a fillers option; JSON outputs keep them")
+	locale := flag.String("locale", output.DefaultLocale, "Locale for dates, times and numbers in text outputs without a locale option")
+	captionPreset := flag.String("caption-preset", "", "Captioning conventions for srt and vtt outputs without a preset option, splitting results into cues by line length and reading speed: a language such as ja or fr-CA, or auto for each result's language (default one cue per result)")
+	var outputs, tags, tracks, fillers, grpcMetadata stringList
+	userAgent := flag.String("user-agent", "", "User agent sent with Google API calls, overriding GOOGLE_USER_AGENT")
+	requestReason := flag.String("request-reason", "", "Justification sent with Google API calls as x-goog-request-reason, recorded in Data Access audit logs")
+	flag.Var(&grpcMetadata, "metadata", "gRPC metadata sent with every Google API call as key=value, such as labels attributing usage (repeatable)")
+	flag.Var(&fillers, "fillers", "Filler words removed by -remove-fillers for a language, as lang=word,word (repeatable, replaces the built-in list)")
+	flag.Var(&tracks, "track", "Multitrack input as speaker=path, one per participant (repeatable, replaces -wav-in)")
+	flag.Var(&outputs, "output", "Output as format[,key=value...]:destination (repeatable)")
+	flag.Var(&tags, "tag", "Session metadata as key=value (repeatable)")
 	flag.Parse()
+	output.DefaultLocale = *locale
+	summaryLocale, err := output.LookupLocale(*locale)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -locale: %w", err)
+	}
+	if *captionPreset != "" && *captionPreset != "auto" {
+		if _, err := output.LookupCaptionPreset(*captionPreset); err != nil {
+			return nil, fmt.Errorf("invalid -caption-preset: %w", err)
+		}
+	}
+	output.DefaultCaptionPreset = *captionPreset
+	for _, f := range fillers {
+		lang, words, ok := strings.Cut(f, "=")
+		if !ok || lang == "" {
+			return nil, fmt.Errorf("invalid fillers %q: expected lang=word,word", f)
+		}
+		output.Fillers[lang] = strings.Split(words, ",")
+	}
 
 	config := &Config{
-		ProjectID:    os.Getenv("GOOGLE_PROJECT_ID"),
-		Region:       os.Getenv("GOOGLE_REGION"),
-		RecognizerID: os.Getenv("RECOGNIZER_ID"),
+		Provider:     *provider,
 		PrimaryLang:  *primaryLang,
+		Model:        *model,
+		Fallback:     *fallback,
 		WAVInputPath: *wavInPath,
 		OneShot:      *oneShot,
+		Outputs:      outputs,
+		SessionID:    *sessionID,
+		Tags:         map[string]string{},
+		StorePath:    *storePath,
+		Embedder:     *embedder,
+		Timeout:      *timeout,
+
+		OutputDefaults: output.Options{"fillers": strconv.FormatBool(!*removeFillers)},
+		Locale:         summaryLocale,
+
+		BatchOutput: *batchOutput,
+
+		DownloadTimeout: *downloadTimeout,
+		IdleTimeout:     *idleTimeout,
+		DownloadRetries: *downloadRetries,
+
+		Audit: *audit,
+		AuditOptions: output.AuditOptions{
+			Redact:   *auditRedact,
+			OmitText: !*auditText,
+			Audio:    *auditAudio,
+		},
+
+		FFmpeg:          *ffmpeg,
+		Resample:        *resample,
+		ResampleQuality: *resampleQuality,
+
+		Denoise: *denoise,
+		DenoiseOptions: audio.DenoiseOptions{
+			Reduction: *denoiseReduction,
+			Threshold: *denoiseThreshold,
+		},
+
+		Normalize: *normalize,
+		NormalizeOptions: audio.NormalizeOptions{
+			Target:    *normalizeTarget,
+			RMS:       *normalizeRMS,
+			TruePeak:  *truePeak,
+			NoLimiter: !*limiter,
+		},
+
+		StallTimeout:   *stallTimeout,
+		RestartOnStall: *restartOnStall,
+		DrainTimeout:   *drainTimeout,
+		Keepalive:      *keepalive,
+
+		UtteranceTimeout: *utteranceTimeout,
+
+		CheckRecognizer: *checkRecognizer,
+
+		MaxBilled:      *maxBilled,
+		MaxCost:        *maxCost,
+		PricePerMinute: *pricePerMinute,
+
+		Budget:       *budget,
+		BudgetPeriod: *budgetPeriod,
+
+		PaceBytes:      *paceBytes,
+		RealtimeFactor: *realtimeFactor,
+
+		DTMF:         *dtmf,
+		SuppressHold: *suppressHold,
+		VAD:          *vad,
+		VADOptions:   audio.VADOptions{Aggressiveness: *vadAggressiveness, Hangover: *vadHangover},
+		TrimSilence:  *trimSilence,
+		TrimOptions:  audio.TrimOptions{Threshold: *trimThreshold, MinDuration: *trimMin},
+		AudioEvents:  *audioEvents,
+
+		Mic:         *mic,
+		MicRate:     *micRate,
+		MicDevice:   *micDevice,
+		Loopback:    *loopback,
+		ListDevices: *listDevices,
+
+		QueueDir:     *queueDir,
+		QueueSegment: *queueSegment,
+
+		AGC: *agc,
+		AGCOptions: audio.AGCOptions{
+			Target:  *agcTarget,
+			MaxGain: *agcMaxGain,
+			Attack:  *agcAttack,
+			Release: *agcRelease,
+		},
+
+		RTP: *rtp,
+		RTPOptions: audio.RTPOptions{
+			L16PayloadType:  *rtpPayload,
+			Rate:            *rtpRate,
+			Channels:        *rtpChannels,
+			OpusPayloadType: *rtpOpusPayload,
+			JitterDelay:     *rtpJitter,
+			IdleTimeout:     *rtpIdle,
+		},
+		RTSPURL:     *rtspURL,
+		RTSPRetries: *rtspRetries,
+
+		AudioSocket: *audioSocket,
+		SIPREC:      *siprec,
+
+		Kafka: *kafka,
+		KafkaOptions: audio.KafkaOptions{
+			GroupID:  *kafkaGroup,
+			Rate:     *kafkaRate,
+			Channels: *kafkaChannels,
+			Idle:     *kafkaIdle,
+		},
+
+		LiveURL: *liveURL,
+
+		Paragraphs: *paragraphs,
+		ParagraphOptions: stt.ParagraphOptions{
+			Pause:        *paragraphPause,
+			MaxSentences: *paragraphSentences,
+			MaxDuration:  *paragraphMax,
+		},
+
+		Extract: *extract,
+
+		WordConfidence: *wordConfidence,
+		RedactPII:      *redactPII,
+		ChunkDuration:  *chunkDuration,
+		ChunkOverlap:   *chunkOverlap,
+		Compress:       *compress,
+
+		AudioConfig:  *audioConfig,
+		AudioProfile: *audioProfile,
 	}
 
-	if config.ProjectID == "" {
-		return nil, fmt.Errorf("GOOGLE_PROJECT_ID environment variable is not set")
+	for _, tag := range tags {
+		k, v, err := output.ParseTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		config.Tags[k] = v
 	}
 
-	if config.Region == "" {
-		config.Region = "global"
-		log.Printf("Missing GOOGLE_REGION environment variable, using %s", config.Region)
+	// Listing devices needs no recognizer
+	if config.ListDevices {
+		return config, nil
+	}
+	if err := config.loadEnv(); err != nil {
+		return nil, err
+	}
+	if *userAgent != "" {
+		config.ClientOptions = append(config.ClientOptions, option.WithUserAgent(*userAgent))
+	}
+	for _, kv := range grpcMetadata {
+		k, v, err := parseMetadata(kv)
+		if err != nil {
+			return nil, err
+		}
+		if config.Metadata == nil {
+			config.Metadata = map[string]string{}
+		}
+		config.Metadata[k] = v
+	}
+	if *requestReason != "" {
+		if config.Metadata == nil {
+			config.Metadata = map[string]string{}
+		}
+		config.Metadata["x-goog-request-reason"] = *requestReason
 	}
 
-	if config.RecognizerID == "" {
-		return nil, fmt.Errorf("RECOGNIZER_ID environment variable is not set")
+	for _, track := range tracks {
+		speaker, path, ok := strings.Cut(track, "=")
+		if !ok || speaker == "" || path == "" {
+			return nil, fmt.Errorf("invalid track %q: expected speaker=path", track)
+		}
+		config.Tracks = append(config.Tracks, stt.Track{Speaker: speaker, Path: path})
 	}
 
-	if config.WAVInputPath == "" {
+	if config.WAVInputPath == "" && len(config.Tracks) == 0 && !config.Mic && !config.rtp() && config.AudioSocket == "" && config.SIPREC == "" && config.Kafka == "" && config.LiveURL == "" {
 		return nil, fmt.Errorf("WAV input path is not set")
 	}
+	if config.Loopback {
+		if config.MicDevice != "" {
+			return nil, fmt.Errorf("-device cannot be combined with -loopback")
+		}
+		config.Mic = true
+	}
+	if config.IdleTimeout != 0 && !config.fifo() {
+		return nil, fmt.Errorf("-idle-timeout needs a named pipe -wav-in")
+	}
+	if config.MicDevice != "" && !config.Mic {
+		return nil, fmt.Errorf("-device needs -mic")
+	}
+	if config.VAD && (config.WAVInputPath == "" || config.streamed() || config.gcs()) {
+		return nil, fmt.Errorf("-vad needs a -wav-in file")
+	}
+	if config.VAD && (*vadAggressiveness < 0 || *vadAggressiveness > 3) {
+		return nil, fmt.Errorf("-vad-aggressiveness %d is not between 0 and 3", *vadAggressiveness)
+	}
+	if config.TrimSilence && (config.WAVInputPath == "" || config.streamed() || config.gcs()) {
+		return nil, fmt.Errorf("-trim-silence needs a -wav-in file")
+	}
+	if config.TrimSilence && *trimThreshold >= 0 {
+		return nil, fmt.Errorf("-trim-threshold %g is not below 0 dBFS", *trimThreshold)
+	}
+	config.Channels = *channels
+	if *channelNames != "" {
+		config.ChannelNames = strings.Split(*channelNames, ",")
+	}
+	switch {
+	case config.Channels != "" && config.Channels != "mix" && config.Channels != "split":
+		return nil, fmt.Errorf("unsupported -channels %q: expected mix or split", config.Channels)
+	case config.Channels != "" && (config.WAVInputPath == "" || config.streamed() || config.gcs()):
+		return nil, fmt.Errorf("-channels needs a -wav-in file")
+	case config.Channels == "split" && *languages != "":
+		return nil, fmt.Errorf("-channels split cannot be combined with -languages")
+	case len(config.ChannelNames) > 0 && config.Channels != "split":
+		return nil, fmt.Errorf("-channel-names needs -channels split")
+	}
+	if config.Resample != 0 && config.Resample < 8000 {
+		return nil, fmt.Errorf("-resample %d is below the 8000 Hz recognizers accept", config.Resample)
+	}
+	switch config.ResampleQuality {
+	case audio.ResampleLinear, audio.ResampleMedium, audio.ResampleHigh:
+	default:
+		return nil, fmt.Errorf("unsupported -resample-quality %q: expected %s, %s or %s",
+			config.ResampleQuality, audio.ResampleLinear, audio.ResampleMedium, audio.ResampleHigh)
+	}
+	if config.AGC && (*agcTarget >= 0 || *agcMaxGain < 0 || *agcAttack <= 0 || *agcRelease <= 0) {
+		return nil, fmt.Errorf("-agc-target must be below 0 dBFS, -agc-max-gain at least 0 and -agc-attack and -agc-release positive")
+	}
+	if config.Denoise && (config.WAVInputPath == "" || config.streamed() || config.gcs()) {
+		return nil, fmt.Errorf("-denoise needs a -wav-in file")
+	}
+	if config.Denoise && (*denoiseReduction <= 0 || *denoiseThreshold <= 0) {
+		return nil, fmt.Errorf("-denoise-reduction and -denoise-threshold must be positive")
+	}
+	if config.Normalize && (config.WAVInputPath == "" || config.streamed() || config.gcs()) {
+		return nil, fmt.Errorf("-normalize needs a -wav-in file")
+	}
+	if config.Normalize && (*normalizeTarget >= 0 || *truePeak >= 0) {
+		return nil, fmt.Errorf("-normalize-target %g and -true-peak %g must be below 0", *normalizeTarget, *truePeak)
+	}
+	if config.Compress != "" && config.Compress != stt.CompressionFLAC {
+		return nil, fmt.Errorf("unsupported -compress %q: expected flac", config.Compress)
+	}
+	if config.QueueDir != "" && !config.Mic && !config.rtp() {
+		return nil, fmt.Errorf("-queue-dir needs -mic, -rtp or -rtsp-url")
+	}
+	if config.QueueDir != "" && config.QueueSegment <= 0 {
+		return nil, fmt.Errorf("-queue-segment must be positive")
+	}
+	if config.RealtimeFactor <= 0 {
+		return nil, fmt.Errorf("-realtime-factor %g must be positive", config.RealtimeFactor)
+	}
+	// Everything else needs the whole recording up front
+	if config.Mic && (config.WAVInputPath != "" || len(config.Tracks) > 0 || config.OneShot ||
+		*languages != "" || config.AudioConfig != "" || config.DTMF || config.SuppressHold || config.AudioEvents) {
+		return nil, fmt.Errorf("-mic cannot be combined with -wav-in, -track, -one-shot, -languages, -audio-config, -dtmf, -suppress-hold or -audio-events")
+	}
+	if config.rtp() && (config.RTP != "" && config.RTSPURL != "" || config.Mic || config.WAVInputPath != "" || len(config.Tracks) > 0 || config.OneShot ||
+		*languages != "" || config.AudioConfig != "" || config.DTMF || config.SuppressHold || config.AudioEvents) {
+		return nil, fmt.Errorf("-rtp and -rtsp-url cannot be combined with each other, -mic, -wav-in, -track, -one-shot, -languages, -audio-config, -dtmf, -suppress-hold or -audio-events")
+	}
+	if config.AudioSocket != "" && (config.rtp() || config.Mic || config.WAVInputPath != "" || len(config.Tracks) > 0 || config.OneShot ||
+		*languages != "" || config.AudioConfig != "" || config.DTMF || config.SuppressHold || config.AudioEvents) {
+		return nil, fmt.Errorf("-audiosocket cannot be combined with -rtp, -rtsp-url, -mic, -wav-in, -track, -one-shot, -languages, -audio-config, -dtmf, -suppress-hold or -audio-events")
+	}
+	if config.SIPREC != "" && (config.AudioSocket != "" || config.rtp() || config.Mic || config.WAVInputPath != "" || len(config.Tracks) > 0 || config.OneShot ||
+		*languages != "" || config.AudioConfig != "" || config.DTMF || config.SuppressHold || config.AudioEvents) {
+		return nil, fmt.Errorf("-siprec cannot be combined with -audiosocket, -rtp, -rtsp-url, -mic, -wav-in, -track, -one-shot, -languages, -audio-config, -dtmf, -suppress-hold or -audio-events")
+	}
+	if config.Kafka != "" && (config.SIPREC != "" || config.AudioSocket != "" || config.rtp() || config.Mic || config.WAVInputPath != "" || len(config.Tracks) > 0 || config.OneShot ||
+		*languages != "" || config.AudioConfig != "" || config.DTMF || config.SuppressHold || config.AudioEvents) {
+		return nil, fmt.Errorf("-kafka cannot be combined with -siprec, -audiosocket, -rtp, -rtsp-url, -mic, -wav-in, -track, -one-shot, -languages, -audio-config, -dtmf, -suppress-hold or -audio-events")
+	}
+	if config.LiveURL != "" && (config.Kafka != "" || config.SIPREC != "" || config.AudioSocket != "" || config.rtp() || config.Mic || config.WAVInputPath != "" || len(config.Tracks) > 0 || config.OneShot ||
+		*languages != "" || config.AudioConfig != "" || config.DTMF || config.SuppressHold || config.AudioEvents) {
+		return nil, fmt.Errorf("-live-url cannot be combined with -kafka, -siprec, -audiosocket, -rtp, -rtsp-url, -mic, -wav-in, -track, -one-shot, -languages, -audio-config, -dtmf, -suppress-hold or -audio-events")
+	}
+	if config.LiveURL != "" && !strings.HasPrefix(config.LiveURL, "http://") && !strings.HasPrefix(config.LiveURL, "https://") {
+		return nil, fmt.Errorf("-live-url must be an http:// or https:// URL")
+	}
+	if config.gcs() && (len(config.Tracks) > 0 || config.OneShot ||
+		*languages != "" || config.AudioConfig != "" || config.DTMF || config.SuppressHold || config.AudioEvents) {
+		return nil, fmt.Errorf("a gs:// input cannot be combined with -track, -one-shot, -languages, -audio-config, -dtmf, -suppress-hold or -audio-events")
+	}
+	if config.BatchOutput != "" && (!config.gcs() || !strings.HasPrefix(config.BatchOutput, "gs://")) {
+		return nil, fmt.Errorf("-batch-output must be a gs:// URI and needs a gs:// input")
+	}
+	if config.streamed() && (len(config.Tracks) > 0 || config.OneShot ||
+		*languages != "" || config.AudioConfig != "" || config.DTMF || config.SuppressHold || config.AudioEvents) {
+		return nil, fmt.Errorf("stdin, http(s):// and s3:// inputs cannot be combined with -track, -one-shot, -languages, -audio-config, -dtmf, -suppress-hold or -audio-events")
+	}
+
+	if *boost != "" {
+		config.Boost = strings.Split(*boost, ",")
+	}
+
+	if *alternatives != "" {
+		config.Alternatives = strings.Split(*alternatives, ",")
+	}
+
+	if *languages != "" {
+		config.Languages = strings.Split(*languages, ",")
+		config.PrimaryLang = config.Languages[0]
+	}
+	output.CaptionLanguage = config.PrimaryLang
+
+	if len(config.Outputs) == 0 {
+		config.Outputs = []string{defaultOutput}
+	}
+
+	if config.SessionID == "" {
+		config.SessionID = stt.NewSessionID()
+	}
 
 	return config, nil
 }
 
-type StreamingClient struct {
-	client *speech.Client
-	stream speechpb.Speech_StreamingRecognizeClient
+// checkCapabilities reports the requested features the provider does not
+// support, before any audio is sent.
+func checkCapabilities(config *Config, audioData []byte) error {
+	p, err := stt.LookupProvider(config.Provider)
+	if err != nil {
+		return err
+	}
+	req := stt.Request{OneShot: config.OneShot, Batch: config.gcs()}
+	switch {
+	case config.Mic, config.rtp(), config.AudioSocket != "", config.SIPREC != "", config.Kafka != "", config.LiveURL != "", config.streamed():
+		req.Streaming = true
+	case !config.OneShot && !config.gcs() && len(config.Tracks) == 0:
+		req.Streaming = true
+		req.Duration, _ = stt.WAVDuration(audioData)
+	}
+	return stt.Check(p, config.Recognition(), req)
 }
 
-func NewStreamingClient(ctx context.Context, config *Config) (*StreamingClient, error) {
-	// Create client with explicit regional endpoint
-	client, err := speech.NewClient(ctx,
-		option.WithEndpoint(fmt.Sprintf("%s-speech.googleapis.com:443", config.Region)))
+// preprocess runs audioData through the configured audio profile, taking
+// the profile's chunking for streaming.
+func preprocess(config *Config, audioData []byte) ([]byte, error) {
+	ac, err := audio.LoadConfig(config.AudioConfig)
+	if err != nil {
+		return nil, err
+	}
+	pipeline, err := ac.Pipeline(config.AudioProfile)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create speech client: %w", err)
+		return nil, err
 	}
+	config.ChunkSize = pipeline.Chunk.Bytes
+	config.ChunkInterval = time.Duration(pipeline.Chunk.Interval)
+	log.Printf("Preprocessing audio with profile %s (%d stages)", config.AudioProfile, len(pipeline.Stages))
+	return pipeline.Process(audioData)
+}
 
-	stream, err := client.StreamingRecognize(ctx)
+// denoised returns audioData with its background noise suppressed by
+// -denoise.
+func denoised(config *Config, audioData []byte) ([]byte, error) {
+	a, err := audio.DecodeWAV(audioData)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create streaming client: %w", err)
+		return nil, fmt.Errorf("failed to decode audio: %w", err)
+	}
+	if err := audio.Denoise(a, config.DenoiseOptions); err != nil {
+		return nil, err
 	}
+	return a.WAV(), nil
+}
 
-	// Send the initial configuration
-	configReq := &speechpb.StreamingRecognizeRequest{
-		StreamingRequest: &speechpb.StreamingRecognizeRequest_StreamingConfig{
-			StreamingConfig: &speechpb.StreamingRecognitionConfig{
-				Config: &speechpb.RecognitionConfig{
-					DecodingConfig: &speechpb.RecognitionConfig_AutoDecodingConfig{
-						AutoDecodingConfig: &speechpb.AutoDetectDecodingConfig{},
-					},
-					LanguageCodes: []string{config.PrimaryLang},
-					Model:         "latest_long",
-				},
-			},
-		},
-		Recognizer: fmt.Sprintf("projects/%s/locations/%s/recognizers/%s",
-			config.ProjectID, config.Region, config.RecognizerID),
+// normalized returns audioData with its loudness normalized by -normalize.
+func normalized(config *Config, audioData []byte) ([]byte, error) {
+	a, err := audio.DecodeWAV(audioData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode audio: %w", err)
+	}
+	gain, err := audio.Normalize(a, config.NormalizeOptions)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Normalized loudness by %+.1f dB", gain)
+	return a.WAV(), nil
+}
+
+// resampleOptions returns the -resample settings.
+func (c *Config) resampleOptions() audio.ResampleOptions {
+	// Split channels are resampled before they are split
+	return audio.ResampleOptions{Rate: c.Resample, Quality: c.ResampleQuality, Mono: c.Channels != "split"}
+}
+
+// resampled returns the WAV stream r downsampled by -resample, or r itself
+// if it is not set.
+func resampled(config *Config, r io.Reader) (io.Reader, error) {
+	if config.Resample == 0 {
+		return r, nil
+	}
+	return audio.Resample(r, config.resampleOptions())
+}
+
+// leveled returns the live WAV stream r with -agc applied, or r itself if it
+// is off.
+func leveled(config *Config, r io.Reader) (io.Reader, error) {
+	if !config.AGC {
+		return r, nil
+	}
+	return audio.AGC(r, config.AGCOptions)
+}
+
+// warnDrift logs every conflict between the recognizer's stored config and
+// the requested settings.
+func warnDrift(ctx context.Context, client *speech.Client, config stt.Config) {
+	drift, err := stt.CheckRecognizer(ctx, client, config)
+	if err != nil {
+		log.Printf("Warning: could not check recognizer config: %v", err)
+		return
+	}
+	for _, d := range drift {
+		log.Printf("Warning: recognizer %s config differs from the request, %s", config.RecognizerID, d)
+	}
+}
+
+// sessionSinks wraps outputs with the session's metadata and partial
+// flushing.
+func sessionSinks(config *Config, outputs output.Sink) output.Sink {
+	sinks := output.WithSession(outputs, output.Session{
+		ID:       config.SessionID,
+		Tags:     config.Tags,
+		Audio:    config.WAVInputPath,
+		Language: config.PrimaryLang,
+	})
+	if config.UtteranceTimeout > 0 {
+		sinks = output.WithPartialFlush(sinks, config.UtteranceTimeout)
+	}
+	return sinks
+}
+
+// transcribe runs one session over audioData, writing its results to outputs.
+func transcribe(ctx context.Context, config *Config, budget *stt.Budget, outputs output.Sink, audioData []byte) error {
+	sinks := sessionSinks(config, outputs)
+	var events *output.EventSink
+	if config.DTMF || config.SuppressHold || config.AudioEvents {
+		var records []output.Record
+		if config.DTMF {
+			tones, err := dtmfEvents(audioData, config.timeline)
+			if err != nil {
+				return err
+			}
+			records = tones
+		}
+		if config.AudioEvents {
+			tags, err := audioEvents(audioData, config.timeline)
+			if err != nil {
+				return err
+			}
+			records = append(records, tags...)
+		}
+		for _, s := range config.holdSpans {
+			records = append(records, eventRecord(s.Kind, "", s.Start, s.End))
+		}
+		slices.SortStableFunc(records, func(a, b output.Record) int {
+			return cmp.Compare(a.Start, b.Start)
+		})
+		events = output.WithEvents(sinks, records)
+		sinks = events
+	}
+	// Results of audio with spans cut out are mapped back onto the original
+	// timeline before events are merged in
+	if config.timeline != nil {
+		sinks = output.WithOffsets(sinks, config.timeline.Original)
 	}
 
-	if err := stream.Send(configReq); err != nil {
-		return nil, fmt.Errorf("failed to send config: %w", err)
+	var err error
+	if config.OneShot {
+		err = handleOneShotTranscription(ctx, config, budget, sinks, audioData)
+	} else {
+		err = handleStreamingTranscription(ctx, config, budget, sinks, audioData)
+	}
+	if err != nil || events == nil {
+		return err
+	}
+	return events.Flush(ctx)
+}
+
+// dtmfEvents detects the DTMF digits in audioData as event records, with
+// offsets mapped back to the original audio.
+func dtmfEvents(audioData []byte, timeline *audio.Timeline) ([]output.Record, error) {
+	a, err := audio.DecodeWAV(audioData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode audio for DTMF detection: %w", err)
 	}
+	var events []output.Record
+	for _, tone := range audio.DetectDTMF(a) {
+		events = append(events, eventRecord("dtmf", string(tone.Digit),
+			timeline.Original(tone.Start), timeline.Original(tone.End)))
+	}
+	log.Printf("Detected %d DTMF digits", len(events))
+	return events, nil
+}
 
-	return &StreamingClient{
-		client: client,
-		stream: stream,
-	}, nil
+// audioEvents labels music, applause, laughter and silence in audioData as
+// event records, with offsets mapped back to the original audio.
+func audioEvents(audioData []byte, timeline *audio.Timeline) ([]output.Record, error) {
+	a, err := audio.DecodeWAV(audioData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode audio for event tagging: %w", err)
+	}
+	var events []output.Record
+	for _, s := range (audio.HeuristicClassifier{}).Classify(a) {
+		events = append(events, eventRecord(s.Kind, "", timeline.Original(s.Start), timeline.Original(s.End)))
+	}
+	log.Printf("Tagged %d audio events", len(events))
+	return events, nil
 }
 
-func (c *StreamingClient) SendAudio(ctx context.Context, audio []byte) error {
-	req := &speechpb.StreamingRecognizeRequest{
-		StreamingRequest: &speechpb.StreamingRecognizeRequest_Audio{
-			Audio: audio,
+// eventRecord returns a final record marking a non-speech event.
+func eventRecord(event, text string, start, end time.Duration) output.Record {
+	return output.Record{
+		Result: stt.Result{
+			Segment: stt.Segment{Text: text, Start: start, End: end, Confidence: 1, Event: event},
+			IsFinal: true,
 		},
+		Time: time.Now(),
 	}
-	log.Printf("Sending audio chunk: %d bytes", len(audio))
-	return c.stream.Send(req)
 }
 
-func (c *StreamingClient) ReceiveTranscription(ctx context.Context) (*speechpb.StreamingRecognitionResult, error) {
-	log.Printf("Waiting for transcription response...")
-	resp, err := c.stream.Recv()
+// cutNonSpeech cuts ringback and hold music, with -suppress-hold, silence,
+// with -vad, and leading and trailing silence, with -trim-silence, out of
+// audioData so they are not recognized, remembering the hold spans and how
+// to map offsets back.
+func cutNonSpeech(config *Config, audioData []byte) ([]byte, error) {
+	a, err := audio.DecodeWAV(audioData)
 	if err != nil {
-		if err == io.EOF {
-			log.Printf("Stream ended with EOF")
-			return nil, io.EOF
+		return nil, fmt.Errorf("failed to decode audio for hold and silence detection: %w", err)
+	}
+	length := func(spans []audio.Span) time.Duration {
+		var d time.Duration
+		for _, s := range spans {
+			d += s.End - s.Start
 		}
-		return nil, fmt.Errorf("failed to receive response: %w", err)
+		return d
 	}
+	var spans []audio.Span
+	if config.SuppressHold {
+		config.holdSpans = audio.DetectHold(a)
+		log.Printf("Suppressing %d ringback and hold music spans (%s of audio)", len(config.holdSpans), length(config.holdSpans))
+		spans = config.holdSpans
+	}
+	if config.VAD {
+		silence, err := audio.DetectSilence(a, config.VADOptions)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Skipping %d silent spans (%s of %s of audio)", len(silence), length(silence), a.Duration().Round(time.Second))
+		spans = audio.MergeSpans(spans, silence)
+	}
+	if config.TrimSilence {
+		edges, err := audio.TrimSilence(a, config.TrimOptions)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Trimming %s of leading and trailing silence", length(edges))
+		spans = audio.MergeSpans(spans, edges)
+	}
+	config.timeline = audio.Cut(a, spans)
+	return a.WAV(), nil
+}
 
-	log.Printf("Received STT response: %+v", resp)
-
-	if len(resp.Results) == 0 {
-		log.Printf("No results in response")
-		return nil, nil
+// transcribeTracks transcribes one track per participant and writes the
+// merged, speaker-labelled transcript as one session.
+func transcribeTracks(ctx context.Context, config *Config, budget *stt.Budget, outputs output.Sink) error {
+	opts := stt.TranscribeOptions{
+		Config: config.Recognition(),
+		Session: stt.SessionOptions{
+			StallTimeout:   config.StallTimeout,
+			RestartOnStall: config.RestartOnStall,
+			DrainTimeout:   config.DrainTimeout,
+			MaxBilled:      config.billingCeiling(),
+			Budget:         budget,
+			ByteRate:       config.PaceBytes,
+			RealtimeFactor: config.RealtimeFactor,
+		},
+	}
+	if config.OneShot {
+		opts.Mode = stt.ModeOneShot
+	}
+	transcript, err := stt.TranscribeTracks(ctx, config.Tracks, opts)
+	if err != nil {
+		return err
 	}
 
-	return resp.Results[0], nil
+	var paths []string
+	for _, track := range config.Tracks {
+		paths = append(paths, track.Path)
+	}
+	sinks := output.WithSession(outputs, output.Session{
+		ID:       config.SessionID,
+		Tags:     config.Tags,
+		Audio:    strings.Join(paths, ","),
+		Language: config.PrimaryLang,
+	})
+	for _, record := range output.FromTranscript(config.paragraphs(transcript)) {
+		if err := sinks.Write(ctx, record); err != nil {
+			return fmt.Errorf("failed to write transcription to outputs: %w", err)
+		}
+	}
+	log.Printf("Merged %d tracks into %d segments", len(config.Tracks), len(transcript.Segments))
+	logSummary(transcript.Analytics(), config.Locale)
+	return nil
 }
 
-func (c *StreamingClient) Close() error {
-	if err := c.stream.CloseSend(); err != nil {
-		return fmt.Errorf("failed to close stream: %w", err)
+// transcribeLanguages runs one session per language concurrently. Each is
+// written as its own session, suffixed and tagged with its language.
+func transcribeLanguages(ctx context.Context, config *Config, budget *stt.Budget, outputs output.Sink, audioData []byte) error {
+	g, ctx := errgroup.WithContext(ctx)
+	for _, lang := range config.Languages {
+		lc := *config
+		lc.PrimaryLang = lang
+		lc.SessionID = config.SessionID + "-" + lang
+		lc.Tags = maps.Clone(config.Tags)
+		lc.Tags["language"] = lang
+		g.Go(func() error {
+			if err := transcribe(ctx, &lc, budget, outputs, audioData); err != nil {
+				return fmt.Errorf("%s: %w", lang, err)
+			}
+			return nil
+		})
 	}
-	return c.client.Close()
+	return g.Wait()
 }
 
-func handleStreamingTranscription(ctx context.Context, config *Config, audioData []byte) error {
-	// Streaming recognition
-	client, err := NewStreamingClient(ctx, config)
+// transcribeChannels runs one session per channel of audioData
+// concurrently. Each is written as its own session, suffixed and tagged with
+// its channel's label, which also labels its records' speaker.
+func transcribeChannels(ctx context.Context, config *Config, budget *stt.Budget, outputs output.Sink, audioData []byte) error {
+	channels, err := audio.SplitChannels(audioData)
 	if err != nil {
-		return fmt.Errorf("failed to create streaming client: %w", err)
+		return err
 	}
-	defer client.Close()
-	// Create error channel for goroutine error handling
-	errChan := make(chan error, 2)
-
-	// Send audio chunks in goroutine
-	go func() {
-		const chunkSize = 8192
-		for i := 0; i < len(audioData); i += chunkSize {
-			end := min(i+chunkSize, len(audioData))
-			chunk := audioData[i:end]
-			if err := client.SendAudio(ctx, chunk); err != nil {
-				errChan <- fmt.Errorf("failed to send audio chunk: %w", err)
-				return
+	if len(channels) == 1 {
+		log.Printf("Warning: the audio has a single channel, transcribing it as it is")
+		return transcribe(ctx, config, budget, outputs, audioData)
+	}
+	g, ctx := errgroup.WithContext(ctx)
+	for i, channel := range channels {
+		label := fmt.Sprintf("ch%d", i+1)
+		if i < len(config.ChannelNames) && config.ChannelNames[i] != "" {
+			label = config.ChannelNames[i]
+		}
+		cc := *config
+		cc.SessionID = config.SessionID + "-" + label
+		cc.Tags = maps.Clone(config.Tags)
+		cc.Tags["channel"] = label
+		g.Go(func() error {
+			if err := transcribe(ctx, &cc, budget, output.WithSpeaker(outputs, label), channel); err != nil {
+				return fmt.Errorf("channel %s: %w", label, err)
 			}
-			time.Sleep(200 * time.Millisecond)
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+// listDevices prints the input devices -device selects from.
+func listDevices() error {
+	devices, err := audio.CaptureDevices()
+	if err != nil {
+		return err
+	}
+	if len(devices) == 0 {
+		fmt.Println("No input devices found")
+		return nil
+	}
+	for _, d := range devices {
+		mark := ""
+		if d.Default {
+			mark = " (default)"
 		}
+		fmt.Printf("%d: %s%s\n", d.Index, d.Name, mark)
+	}
+	return nil
+}
+
+// transcribeMic streams the -device input device, or the default one, or
+// with -loopback what the default output device plays, until ctx ends, then
+// drains the results of the last audio captured.
+func transcribeMic(ctx context.Context, config *Config, budget *stt.Budget, outputs output.Sink) error {
+	capture := func() (io.ReadCloser, error) { return audio.Capture(config.MicDevice, config.MicRate, 1) }
+	device := "the default input device"
+	switch {
+	case config.Loopback:
+		capture = func() (io.ReadCloser, error) { return audio.CaptureLoopback(config.MicRate, 1) }
+		device = "the default output device"
+	case config.MicDevice != "":
+		device = fmt.Sprintf("input device %q", config.MicDevice)
+	}
+	mic, err := capture()
+	if err != nil {
+		return err
+	}
+	// Closing the device, which may only happen once, ends the capture so
+	// the audio captured so far drains
+	stop := context.AfterFunc(ctx, func() { mic.Close() })
+	src, err := leveled(config, mic)
+	if err != nil {
+		if stop() {
+			mic.Close()
+		}
+		return err
+	}
+	if config.QueueDir != "" {
+		log.Printf("Capturing from %s at %d Hz into %s; interrupt to stop", device, config.MicRate, config.QueueDir)
+		return transcribeQueued(ctx, config, budget, outputs, src)
+	}
+	// 100ms chunks keep latency low
+	if config.ChunkSize == 0 {
+		config.ChunkSize = config.MicRate * 2 / 10
+	}
+	log.Printf("Capturing from %s at %d Hz; interrupt to stop", device, config.MicRate)
 
-		errChan <- client.stream.CloseSend()
-	}()
+	// The session outlives ctx so results of the trailing audio arrive
+	ctx = context.WithoutCancel(ctx)
+	session := newStreamingSession(ctx, config, budget, sessionSinks(config, outputs))
+	return session.RunLive(ctx, src)
+}
 
-	// Receive transcriptions in goroutine
-	go func() {
-		for {
-			result, err := client.ReceiveTranscription(ctx)
-			if err != nil {
-				if err == io.EOF {
-					close(errChan)
-					return
+// transcribeRTP streams the audio of RTP packets received on -rtp or played
+// from -rtsp-url until ctx ends or the packets stop, then drains the results
+// of the last audio.
+func transcribeRTP(ctx context.Context, config *Config, budget *stt.Budget, outputs output.Sink) error {
+	var (
+		rtp io.ReadCloser
+		err error
+	)
+	if config.RTSPURL != "" {
+		rtp, err = audio.DialRTSP(ctx, config.RTSPURL, audio.RTSPOptions{Retries: config.RTSPRetries})
+	} else {
+		log.Printf("Receiving RTP audio on %s; interrupt to stop", config.RTP)
+		rtp, err = audio.ListenRTP(config.RTP, config.RTPOptions)
+	}
+	if err != nil {
+		return err
+	}
+	defer rtp.Close()
+	context.AfterFunc(ctx, func() { rtp.Close() })
+	src, err := resampled(config, rtp)
+	if err != nil {
+		return err
+	}
+	if src, err = leveled(config, src); err != nil {
+		return err
+	}
+	if config.QueueDir != "" {
+		return transcribeQueued(ctx, config, budget, outputs, src)
+	}
+	// Small chunks keep latency low at telephony sample rates
+	if config.ChunkSize == 0 {
+		config.ChunkSize = 3200
+	}
+
+	// The session outlives ctx so results of the trailing audio arrive
+	ctx = context.WithoutCancel(ctx)
+	session := newStreamingSession(ctx, config, budget, sessionSinks(config, outputs))
+	return session.RunLive(ctx, src)
+}
+
+// transcribeQueued records live audio from src into the -queue-dir spool
+// and transcribes its segments in capture order as they complete, after any
+// left from an earlier run. While the recognizer is unreachable the oldest
+// segment is retried with backoff and the rest stay queued on disk. Result
+// offsets run from the start of the first segment transcribed, and each
+// record's time is when its audio was captured.
+func transcribeQueued(ctx context.Context, config *Config, budget *stt.Budget, outputs output.Sink, src io.Reader) error {
+	spool, err := audio.OpenSpool(config.QueueDir, config.QueueSegment)
+	if err != nil {
+		return err
+	}
+	if n := spool.Len(); n > 0 {
+		log.Printf("Transcribing %d segments left queued in %s first", n, config.QueueDir)
+	}
+	recorded := make(chan error, 1)
+	go func() { recorded <- spool.Record(src) }()
+
+	cc := *config
+	// A backlog is sent several times faster than real time to catch up
+	if cc.ChunkSize == 0 {
+		cc.ChunkSize = 32768
+	}
+	sinks := sessionSinks(config, outputs)
+	var epoch time.Time
+	for {
+		// Recording stops with ctx, and the segments it completed are
+		// still transcribed
+		seg, err := spool.Next(context.WithoutCancel(ctx))
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if epoch.IsZero() {
+			epoch = seg.Start
+		}
+		if err := forwardSegment(ctx, &cc, budget, sinks, seg, epoch); err != nil {
+			if ctx.Err() != nil && unreachable(err) {
+				<-recorded
+				log.Printf("Stopped while the recognizer is unreachable; %d segments stay queued in %s for the next run", spool.Len()+1, config.QueueDir)
+				return nil
+			}
+			return err
+		}
+	}
+	return <-recorded
+}
+
+// forwardSegment transcribes a queued segment, retrying with backoff while
+// the recognizer is unreachable until ctx ends, then removes it. Its results
+// are held until it has been transcribed, so a retried segment's results are
+// not written twice.
+func forwardSegment(ctx context.Context, config *Config, budget *stt.Budget, sinks output.Sink, seg *audio.SpoolSegment, epoch time.Time) error {
+	data, err := os.ReadFile(seg.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read queued audio: %w", err)
+	}
+	offset := seg.Start.Sub(epoch)
+	sessionCtx := context.WithoutCancel(ctx)
+	for backoff := time.Second; ; backoff = min(2*backoff, time.Minute) {
+		held := &heldSink{}
+		session := newStreamingSession(sessionCtx, config, budget,
+			output.WithOffsets(held, func(d time.Duration) time.Duration { return d + offset }))
+		err := session.Run(sessionCtx, data)
+		if err == nil {
+			for _, r := range held.records {
+				r.Time = epoch.Add(r.End)
+				if err := sinks.Write(sessionCtx, r); err != nil {
+					log.Printf("Failed to write result: %v", err)
 				}
-				errChan <- fmt.Errorf("failed to receive transcription: %w", err)
-				close(errChan)
-				return
 			}
-			if result == nil {
-				log.Printf("Received nil result")
-				continue
+			return seg.Remove()
+		}
+		if !unreachable(err) {
+			return err
+		}
+		log.Printf("Recognizer unreachable, retrying the audio queued at %s in %s: %v", seg.Start.Format(time.TimeOnly), backoff, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// heldSink keeps the records written to it.
+type heldSink struct {
+	records []output.Record
+}
+
+func (s *heldSink) Write(_ context.Context, r output.Record) error {
+	s.records = append(s.records, r)
+	return nil
+}
+
+func (s *heldSink) Close() error { return nil }
+
+// unreachable reports whether err means the recognizer could not be reached
+// or accepts no more audio for now, so queued audio is retried later.
+func unreachable(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, stt.ErrUnreachable) || errors.Is(err, stt.ErrQuota) || errors.As(err, &netErr)
+}
+
+// transcribeAudioSocket accepts AudioSocket calls until ctx ends,
+// transcribing each in its own session with the call's UUID as session ID,
+// then waits for the calls in progress to hang up.
+func transcribeAudioSocket(ctx context.Context, config *Config, budget *stt.Budget, outputs output.Sink) error {
+	ln, err := audio.ListenAudioSocket(config.AudioSocket)
+	if err != nil {
+		return err
+	}
+	context.AfterFunc(ctx, func() { ln.Close() })
+	log.Printf("Accepting AudioSocket calls on %s; interrupt to stop", ln.Addr())
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		call, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
 			}
-			if len(result.Alternatives) == 0 {
-				log.Printf("Received empty alternatives")
-				continue
+			return fmt.Errorf("failed to accept AudioSocket call: %w", err)
+		}
+		cc := *config
+		cc.SessionID = call.ID
+		// 100ms chunks at AudioSocket's 8 kHz keep latency low
+		if cc.ChunkSize == 0 {
+			cc.ChunkSize = 1600
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer call.Close()
+			log.Printf("Transcribing AudioSocket call %s", call.ID)
+			// Calls in progress are transcribed until they hang up
+			callCtx := context.WithoutCancel(ctx)
+			session := newStreamingSession(callCtx, &cc, budget, sessionSinks(&cc, outputs))
+			if err := session.RunLive(callCtx, call); err != nil {
+				log.Printf("Failed to transcribe AudioSocket call %s: %v", call.ID, err)
+				return
 			}
-			alt := result.Alternatives[0]
-			log.Printf("Transcription: %q (confidence: %.2f, final: %v)",
-				alt.Transcript, alt.Confidence, result.IsFinal)
+			log.Printf("AudioSocket call %s ended", call.ID)
+		}()
+	}
+}
+
+// transcribeSIPREC accepts SIPREC recording sessions until ctx ends,
+// transcribing each RTP stream of a call in its own session. Stopping ends
+// the calls in progress, whose received audio is still transcribed.
+// Sessions are named by the call's Call-ID and the stream's label, and
+// tagged with the participant it records.
+func transcribeSIPREC(ctx context.Context, config *Config, budget *stt.Budget, outputs output.Sink) error {
+	ln, err := audio.ListenSIPREC(config.SIPREC, audio.SIPRECOptions{RTP: config.RTPOptions})
+	if err != nil {
+		return err
+	}
+	context.AfterFunc(ctx, func() { ln.Close() })
+	log.Printf("Accepting SIPREC sessions on %s; interrupt to stop", ln.Addr())
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		stream, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to accept SIPREC stream: %w", err)
 		}
-	}()
-	// Wait until errChan is closed to finish.
+		cc := *config
+		cc.SessionID = stream.CallID + "-" + stream.Label
+		cc.Tags = maps.Clone(config.Tags)
+		cc.Tags["call_id"] = stream.CallID
+		cc.Tags["label"] = stream.Label
+		if stream.Participant != "" {
+			cc.Tags["participant"] = stream.Participant
+		}
+		// 100ms chunks at the 8 kHz of telephony codecs keep latency low
+		if cc.ChunkSize == 0 {
+			cc.ChunkSize = 1600
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer stream.Close()
+			log.Printf("Transcribing SIPREC stream %s of call %s", stream.Label, stream.CallID)
+			// The session outlives ctx so results of the trailing audio arrive
+			streamCtx := context.WithoutCancel(ctx)
+			session := newStreamingSession(streamCtx, &cc, budget, sessionSinks(&cc, outputs))
+			if err := session.RunLive(streamCtx, stream); err != nil {
+				log.Printf("Failed to transcribe SIPREC stream %s: %v", cc.SessionID, err)
+				return
+			}
+			log.Printf("SIPREC stream %s ended", cc.SessionID)
+		}()
+	}
+}
+
+// transcribeKafka consumes audio frames from a Kafka topic until ctx ends,
+// transcribing the frames of each key in its own session with the key as
+// session ID. Stopping ends the sessions in progress, whose consumed audio
+// is still transcribed.
+func transcribeKafka(ctx context.Context, config *Config, budget *stt.Budget, outputs output.Sink) error {
+	consumer, err := audio.ConsumeKafka(config.Kafka, config.KafkaOptions)
+	if err != nil {
+		return err
+	}
+	context.AfterFunc(ctx, func() { consumer.Close() })
+	log.Printf("Consuming audio from %s; interrupt to stop", config.Kafka)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
 	for {
-		select {
-		case err := <-errChan:
+		stream, err := consumer.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		cc := *config
+		cc.SessionID = stream.ID
+		// 100ms chunks keep latency low
+		if cc.ChunkSize == 0 {
+			rate, channels := config.KafkaOptions.Rate, config.KafkaOptions.Channels
+			if config.Resample != 0 {
+				rate, channels = min(rate, config.Resample), 1
+			}
+			cc.ChunkSize = rate * channels * 2 / 10
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer stream.Close()
+			log.Printf("Transcribing Kafka session %s", stream.ID)
+			// The session outlives ctx so results of the consumed audio arrive
+			streamCtx := context.WithoutCancel(ctx)
+			r, err := resampled(&cc, stream)
 			if err != nil {
-				log.Fatalf("Error: %v", err)
+				log.Printf("Failed to transcribe Kafka session %s: %v", stream.ID, err)
+				return
 			}
-		default:
-			if errChan == nil {
-				return nil
+			session := newStreamingSession(streamCtx, &cc, budget, sessionSinks(&cc, outputs))
+			if err := session.RunLive(streamCtx, r); err != nil {
+				log.Printf("Failed to transcribe Kafka session %s: %v", stream.ID, err)
+				return
 			}
+			log.Printf("Kafka session %s ended", stream.ID)
+		}()
+	}
+}
+
+// transcribeBatch transcribes a gs:// input with batch recognition, writing
+// the transcript once the operation finishes.
+func transcribeBatch(ctx context.Context, config *Config, budget *stt.Budget, outputs output.Sink) error {
+	transcript, err := stt.TranscribeFile(ctx, config.WAVInputPath, stt.TranscribeOptions{
+		Config:  config.Recognition(),
+		Mode:    stt.ModeBatch,
+		Session: stt.SessionOptions{Budget: budget},
+		Batch:   stt.BatchOptions{OutputURI: config.BatchOutput},
+	})
+	if err != nil {
+		return err
+	}
+
+	sinks := sessionSinks(config, outputs)
+	for _, record := range output.FromTranscript(config.paragraphs(transcript)) {
+		if err := sinks.Write(ctx, record); err != nil {
+			return fmt.Errorf("failed to write transcription to outputs: %w", err)
 		}
 	}
+	log.Printf("Batch recognition succeeded with %d segments", len(transcript.Segments))
+	logSummary(transcript.Analytics(), config.Locale)
+	return nil
 }
 
-func handleOneShotTranscription(ctx context.Context, config *Config, audioData []byte) error {
+// transcribeStream streams WAV audio piped to stdin or downloaded from a URL
+// or S3 as it arrives.
+func transcribeStream(ctx context.Context, config *Config, budget *stt.Budget, outputs output.Sink) error {
+	var r io.Reader = os.Stdin
+	if config.WAVInputPath == "-" {
+		log.Printf("Reading WAV audio from stdin")
+	} else {
+		log.Printf("Downloading WAV audio from %s", config.WAVInputPath)
+		open := audio.Download
+		if strings.HasPrefix(config.WAVInputPath, "s3://") {
+			open = audio.DownloadS3
+		}
+		download, err := open(ctx, config.WAVInputPath, audio.DownloadOptions{
+			Timeout: config.DownloadTimeout,
+			Retries: config.DownloadRetries,
+		})
+		if err != nil {
+			return err
+		}
+		defer download.Close()
+		r = download
+	}
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(64)
+	switch format := audio.DetectFormat(head, config.WAVInputPath); format {
+	case audio.FormatWAV:
+		r = br
+	case "":
+		log.Printf("Transcoding the stream to PCM with ffmpeg")
+		transcoded, err := audio.Transcode(ctx, br, audio.TranscodeOptions{FFmpeg: config.FFmpeg})
+		if err != nil {
+			return err
+		}
+		defer transcoded.Close()
+		r = transcoded
+	default:
+		decoded, err := audio.NewDecoder(br, format)
+		if err != nil {
+			return err
+		}
+		r = decoded
+	}
+	r, err := resampled(config, r)
+	if err != nil {
+		return err
+	}
+	session := newStreamingSession(ctx, config, budget, sessionSinks(config, outputs))
+	return session.RunReader(ctx, r)
+}
+
+// transcribeFIFO streams the WAV audio written to the named pipe -wav-in
+// by other processes until ctx ends, or -idle-timeout passes without audio,
+// then drains the results of the last audio.
+func transcribeFIFO(ctx context.Context, config *Config, budget *stt.Budget, outputs output.Sink) error {
+	fifo, err := audio.OpenFIFO(config.WAVInputPath, config.IdleTimeout)
+	if err != nil {
+		return err
+	}
+	defer fifo.Close()
+	context.AfterFunc(ctx, func() { fifo.Close() })
+	log.Printf("Reading WAV audio from named pipe %s; interrupt to stop", config.WAVInputPath)
+	br := bufio.NewReader(fifo)
+	head, _ := br.Peek(64)
+	if len(head) == 0 {
+		return nil
+	}
+	if format := audio.DetectFormat(head, config.WAVInputPath); format != audio.FormatWAV {
+		return fmt.Errorf("named pipe %s must carry a WAV stream", config.WAVInputPath)
+	}
+	r, err := resampled(config, br)
+	if err != nil {
+		return err
+	}
+
+	// The session outlives ctx so results of the trailing audio arrive
+	ctx = context.WithoutCancel(ctx)
+	session := newStreamingSession(ctx, config, budget, sessionSinks(config, outputs))
+	return session.RunLive(ctx, r)
+}
+
+// transcribeBroadcast captions the live broadcast at -live-url until ctx
+// ends or the broadcast does, then drains the results of the last audio.
+func transcribeBroadcast(ctx context.Context, config *Config, budget *stt.Budget, outputs output.Sink) error {
+	log.Printf("Following live broadcast %s; interrupt to stop", config.LiveURL)
+	broadcast, err := audio.OpenBroadcast(ctx, config.LiveURL, audio.BroadcastOptions{Retries: config.DownloadRetries})
+	if err != nil {
+		return err
+	}
+	defer broadcast.Close()
+
+	// The session and ffmpeg outlive ctx so results of the trailing audio
+	// arrive; stopping the broadcast ends its audio
+	ctx = context.WithoutCancel(ctx)
+	transcoded, err := audio.Transcode(ctx, broadcast, audio.TranscodeOptions{FFmpeg: config.FFmpeg})
+	if err != nil {
+		return err
+	}
+	defer transcoded.Close()
+	// HLS segments arrive in bursts, which pacing spreads out
+	session := newStreamingSession(ctx, config, budget, sessionSinks(config, outputs))
+	return session.RunReader(ctx, transcoded)
+}
+
+func handleStreamingTranscription(ctx context.Context, config *Config, budget *stt.Budget, sinks output.Sink, audioData []byte) error {
+	return newStreamingSession(ctx, config, budget, sinks).Run(ctx, audioData)
+}
+
+// newStreamingSession creates a streaming session writing its results to
+// sinks.
+func newStreamingSession(ctx context.Context, config *Config, budget *stt.Budget, sinks output.Sink) *stt.Session {
+	session := stt.NewSession(config.SessionID, stt.SessionOptions{
+		Config:         config.Recognition(),
+		StallTimeout:   config.StallTimeout,
+		RestartOnStall: config.RestartOnStall,
+		DrainTimeout:   config.DrainTimeout,
+		MaxBilled:      config.billingCeiling(),
+		Budget:         budget,
+		ByteRate:       config.PaceBytes,
+		RealtimeFactor: config.RealtimeFactor,
+		ChunkSize:      config.ChunkSize,
+		ChunkInterval:  config.ChunkInterval,
+		Keepalive:      config.Keepalive,
+	})
+	if config.audit != nil {
+		config.audit.Watch(session)
+	}
+	stt.On(session.Events(), func(ctx context.Context, e stt.SessionTruncated) {
+		log.Printf("Warning: session truncated at byte %d after %s of billed audio; the transcript is incomplete", e.Offset, e.Billed)
+	})
+
+	// Outputs subscribe to the session's results; paragraphs are written as
+	// the next one starts, and the last when the session ends
+	if config.Paragraphs {
+		paragraphs := output.WithParagraphs(sinks, config.ParagraphOptions)
+		stt.On(session.Events(), func(ctx context.Context, e stt.SessionEnded) {
+			if err := paragraphs.Flush(context.WithoutCancel(ctx)); err != nil {
+				log.Printf("Failed to write transcription to outputs: %v", err)
+			}
+		})
+		sinks = paragraphs
+	}
+	write := func(result stt.Result) {
+		if err := sinks.Write(ctx, output.FromResult(result)); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to write transcription to outputs: %v", err)
+		}
+	}
+	session.OnPartial(write)
+	session.OnFinal(write)
+	stt.On(session.Events(), func(context.Context, stt.SessionEnded) {
+		logSummary(session.Analytics(), config.Locale)
+	})
+	return session
+}
+
+// logSummary logs the conversation metrics of a session.
+func logSummary(a stt.Analytics, l output.Locale) {
+	log.Printf("Summary: %s of audio, %s of speech, %s%% silence", l.Offset(a.Duration), l.Offset(a.Speech), l.Number(a.SilenceRatio*100, 0))
+	for _, s := range a.Speakers {
+		speaker := s.Speaker
+		if speaker == "" {
+			speaker = "(unlabelled)"
+		}
+		log.Printf("Summary: speaker %s talked %s, %d words at %s wpm, %d interruptions",
+			speaker, l.Offset(s.TalkTime), s.Words, l.Number(s.WordsPerMinute, 0), s.Interruptions)
+	}
+}
+
+func handleOneShotTranscription(ctx context.Context, config *Config, budget *stt.Budget, sinks output.Sink, audioData []byte) error {
+	if budget != nil {
+		if err := budget.Allow(ctx); err != nil {
+			return err
+		}
+	}
+
 	// One-shot recognition
-	client, err := speech.NewClient(ctx,
-		option.WithEndpoint(fmt.Sprintf("%s-speech.googleapis.com:443", config.Region)))
+	client, err := stt.NewClient(ctx, config.Recognition())
 	if err != nil {
-		return fmt.Errorf("failed to create speech client: %w", err)
+		return err
 	}
 	defer client.Close()
 
-	req := &speechpb.RecognizeRequest{
-		Recognizer: fmt.Sprintf("projects/%s/locations/%s/recognizers/%s",
-			config.ProjectID, config.Region, config.RecognizerID),
-		Config: &speechpb.RecognitionConfig{
-			DecodingConfig: &speechpb.RecognitionConfig_AutoDecodingConfig{
-				AutoDecodingConfig: &speechpb.AutoDetectDecodingConfig{},
-			},
-			LanguageCodes: []string{config.PrimaryLang},
-			Model:         "latest_long",
-		},
-		AudioSource: &speechpb.RecognizeRequest_Content{
-			Content: audioData,
-		},
+	transcript, err := stt.Recognize(ctx, client, config.Recognition(), audioData)
+	if err != nil {
+		return err
 	}
 
-	log.Printf("Sending one-shot recognition request...")
-	resp, err := client.Recognize(ctx, req)
-	if err != nil {
-		return fmt.Errorf("failed to recognize audio: %w", err)
+	if budget != nil {
+		d, _ := stt.WAVDuration(audioData)
+		if err := budget.Add(ctx, d); err != nil {
+			log.Printf("Failed to charge recognition to the usage budget: %v", err)
+		}
 	}
 
-	if len(resp.Results) == 0 {
+	if len(transcript.Segments) == 0 {
 		return fmt.Errorf("no results in response")
 	}
 
-	result := resp.Results[0]
-	if len(result.Alternatives) == 0 {
-		return fmt.Errorf("no alternatives in result")
+	for _, record := range output.FromTranscript(config.paragraphs(transcript)) {
+		if err := sinks.Write(ctx, record); err != nil {
+			return fmt.Errorf("failed to write transcription to outputs: %w", err)
+		}
 	}
 
-	alt := result.Alternatives[0]
-	log.Printf("One-shot recognition succeeded: %q (confidence: %.2f)",
-		alt.Transcript, alt.Confidence)
+	log.Printf("One-shot recognition succeeded with %d segments", len(transcript.Segments))
+	logSummary(transcript.Analytics(), config.Locale)
 	return nil
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "serve":
+			if err := runServe(os.Args[2:]); err != nil {
+				log.Fatalf("Server failed: %v", err)
+			}
+			return
+		case "batch":
+			if err := runBatch(os.Args[2:]); err != nil {
+				log.Fatalf("Batch failed: %v", err)
+			}
+			return
+		case "watch":
+			if err := runWatch(os.Args[2:]); err != nil {
+				log.Fatalf("Watch failed: %v", err)
+			}
+			return
+		case "worker":
+			if err := runWorker(os.Args[2:]); err != nil {
+				log.Fatalf("Worker failed: %v", err)
+			}
+			return
+		case "search":
+			if err := runSearch(os.Args[2:]); err != nil {
+				log.Fatalf("Search failed: %v", err)
+			}
+			return
+		case "export":
+			if err := runExport(os.Args[2:]); err != nil {
+				log.Fatalf("Export failed: %v", err)
+			}
+			return
+		case "bench":
+			if err := runBench(os.Args[2:]); err != nil {
+				log.Fatalf("Benchmark failed: %v", err)
+			}
+			return
+		case "import":
+			if err := runImport(os.Args[2:]); err != nil {
+				log.Fatalf("Import failed: %v", err)
+			}
+			return
+		}
+	}
+
 	// Load configuration
 	config, err := loadConfig()
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
+	if config.ListDevices {
+		if err := listDevices(); err != nil {
+			log.Fatalf("Failed to list input devices: %v", err)
+		}
+		return
+	}
+
+	// Only a summary is logged: the configuration holds credentials and
+	// signed URLs, and stdout may carry an output
+	log.Printf("Session %s: %s recognizer %s in %s, language %s, model %s", config.SessionID, config.Provider, config.RecognizerID, config.Region, config.PrimaryLang, cmp.Or(config.Model, "default"))
 
-	fmt.Printf("Configuration: %+v\n", config)
+	// Create context, cancelled on interrupt or when the timeout expires
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+
+	// Read WAV file; tracks and gs:// URIs are read by the library, stdin and
+	// URLs as they arrive
+	var audioData []byte
+	if len(config.Tracks) == 0 && !config.Mic && !config.rtp() && config.AudioSocket == "" && config.SIPREC == "" && config.Kafka == "" && config.LiveURL == "" && !config.streamed() && !config.gcs() {
+		log.Printf("Reading WAV file from %s", config.WAVInputPath)
+		audioData, err = os.ReadFile(config.WAVInputPath)
+		if err != nil {
+			log.Fatalf("failed to read WAV file: %v", err)
+		}
+		switch format := audio.DetectFormat(audioData, config.WAVInputPath); format {
+		case audio.FormatWAV:
+		case "":
+			log.Printf("Transcoding %s to PCM with ffmpeg", config.WAVInputPath)
+			audioData, err = audio.TranscodeFile(ctx, config.WAVInputPath, audio.TranscodeOptions{FFmpeg: config.FFmpeg})
+			if err != nil {
+				log.Fatalf("Failed to transcode audio: %v", err)
+			}
+		default:
+			if audioData, err = audio.DecodeToWAV(audioData, format); err != nil {
+				log.Fatalf("Failed to decode audio: %v", err)
+			}
+		}
+		if config.Channels == "mix" {
+			if audioData, err = audio.Downmix(audioData); err != nil {
+				log.Fatalf("Failed to downmix audio: %v", err)
+			}
+		}
+		if config.Resample != 0 {
+			if audioData, err = audio.ResampleWAV(audioData, config.resampleOptions()); err != nil {
+				log.Fatalf("Failed to resample audio: %v", err)
+			}
+		}
+		if config.Denoise {
+			if audioData, err = denoised(config, audioData); err != nil {
+				log.Fatalf("Failed to denoise audio: %v", err)
+			}
+		}
+		if config.Normalize {
+			if audioData, err = normalized(config, audioData); err != nil {
+				log.Fatalf("Failed to normalize audio: %v", err)
+			}
+		}
+		if config.AudioConfig != "" {
+			if audioData, err = preprocess(config, audioData); err != nil {
+				log.Fatalf("Failed to preprocess audio: %v", err)
+			}
+		}
+		if config.SuppressHold || config.VAD || config.TrimSilence {
+			if audioData, err = cutNonSpeech(config, audioData); err != nil {
+				log.Fatalf("Failed to cut non-speech audio: %v", err)
+			}
+		}
+	}
 
-	// Create context
-	ctx := context.Background()
+	if err := checkCapabilities(config, audioData); err != nil {
+		log.Fatalf("Unsupported request: %v", err)
+	}
 
-	// Read WAV file
-	log.Printf("Reading WAV file from %s", config.WAVInputPath)
-	audioData, err := os.ReadFile(config.WAVInputPath)
+	// Open outputs
+	outputs, err := output.OpenAll(config.Outputs, config.OutputDefaults)
 	if err != nil {
-		log.Fatalf("failed to read WAV file: %w", err)
+		log.Fatalf("Failed to open outputs: %v", err)
+	}
+	defer outputs.Close()
+	if config.Audit != "" {
+		if config.audit, err = output.OpenAuditLog(config.Audit, config.AuditOptions); err != nil {
+			log.Fatalf("Failed to open audit log: %v", err)
+		}
+		defer config.audit.Close()
+	}
+	var usage stt.UsageStore
+	if config.StorePath != "" {
+		st, err := store.Open(config.StorePath)
+		if err != nil {
+			log.Fatalf("Failed to open store: %v", err)
+		}
+		usage = st
+		if config.Embedder != "" {
+			e, err := store.NewEmbedder(config.Embedder)
+			if err != nil {
+				log.Fatalf("Failed to create embedder: %v", err)
+			}
+			st.SetEmbedder(e)
+		}
+		outputs = append(outputs, st)
+	}
+	var (
+		extractor insights.Extractor
+		collected *collector
+	)
+	if config.Extract != "" {
+		if extractor, err = insights.New(config.Extract); err != nil {
+			log.Fatalf("Invalid extractor: %v", err)
+		}
+		collected = &collector{}
+		outputs = append(outputs, collected)
+	}
+	var budget *stt.Budget
+	if config.Budget > 0 {
+		budget, err = stt.NewBudget(config.Budget, stt.BudgetPeriod(config.BudgetPeriod), usage)
+		if err != nil {
+			log.Fatalf("Invalid budget: %v", err)
+		}
+	}
+
+	if config.CheckRecognizer && config.google() {
+		client, err := stt.NewClient(ctx, config.Recognition())
+		if err != nil {
+			log.Fatalf("Failed to create speech client: %v", err)
+		}
+		warnDrift(ctx, client, config.Recognition())
+		client.Close()
 	}
 
 	// Handle WAV input
+	mode := "streaming"
 	if config.OneShot {
-		if err := handleOneShotTranscription(ctx, config, audioData); err != nil {
-			log.Fatalf("Failed to handle one-shot WAV input: %v", err)
+		mode = "one-shot"
+	}
+	switch {
+	case config.Mic:
+		mode = "microphone"
+		if config.Loopback {
+			mode = "loopback"
 		}
-		return
-	} else {
-		if err := handleStreamingTranscription(ctx, config, audioData); err != nil {
-			log.Fatalf("Failed to handle streaming WAV input: %v", err)
+		// The first interrupt only ends the capture; restore the default
+		// handling so a second one exits while results are drained
+		context.AfterFunc(ctx, stop)
+		err = transcribeMic(ctx, config, budget, outputs)
+	case config.rtp():
+		mode = "RTP"
+		context.AfterFunc(ctx, stop)
+		err = transcribeRTP(ctx, config, budget, outputs)
+	case config.AudioSocket != "":
+		mode = "AudioSocket"
+		context.AfterFunc(ctx, stop)
+		err = transcribeAudioSocket(ctx, config, budget, outputs)
+	case config.SIPREC != "":
+		mode = "SIPREC"
+		context.AfterFunc(ctx, stop)
+		err = transcribeSIPREC(ctx, config, budget, outputs)
+	case config.Kafka != "":
+		mode = "Kafka"
+		context.AfterFunc(ctx, stop)
+		err = transcribeKafka(ctx, config, budget, outputs)
+	case config.LiveURL != "":
+		mode = "live broadcast"
+		context.AfterFunc(ctx, stop)
+		err = transcribeBroadcast(ctx, config, budget, outputs)
+	case config.gcs():
+		mode = "batch"
+		err = transcribeBatch(ctx, config, budget, outputs)
+	case config.fifo():
+		mode = "named pipe"
+		context.AfterFunc(ctx, stop)
+		err = transcribeFIFO(ctx, config, budget, outputs)
+	case config.streamed():
+		mode = "streamed"
+		err = transcribeStream(ctx, config, budget, outputs)
+	case len(config.Tracks) > 0:
+		mode = "multitrack"
+		err = transcribeTracks(ctx, config, budget, outputs)
+	case config.Channels == "split":
+		err = transcribeChannels(ctx, config, budget, outputs, audioData)
+	case len(config.Languages) > 1:
+		err = transcribeLanguages(ctx, config, budget, outputs, audioData)
+	default:
+		err = transcribe(ctx, config, budget, outputs, audioData)
+	}
+	if err == nil && extractor != nil {
+		// Microphone, RTP, AudioSocket, SIPREC, Kafka, live broadcast and
+		// named pipe sessions end by interrupting ctx
+		extractCtx := ctx
+		if config.Mic || config.rtp() || config.AudioSocket != "" || config.SIPREC != "" || config.Kafka != "" || config.LiveURL != "" || config.fifo() {
+			extractCtx = context.WithoutCancel(ctx)
+		}
+		err = extractInsights(extractCtx, config, extractor, outputs, collected)
+	}
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		// Outputs are still closed by the deferred calls
+		log.Printf("Session cancelled: %v", err)
+	case err != nil:
+		outputs.Close()
+		if hint := remediation(err); hint != "" {
+			log.Printf("%s", hint)
 		}
+		log.Fatalf("Failed to handle %s input: %v", mode, err)
+	}
+}
+
+// remediation suggests how to fix a classified recognition failure.
+func remediation(err error) string {
+	switch {
+	case errors.Is(err, stt.ErrAuth):
+		return "Check your credentials: run `gcloud auth application-default login` or set GOOGLE_APPLICATION_CREDENTIALS, and make sure the account may use the recognizer in GOOGLE_PROJECT_ID"
+	case errors.Is(err, stt.ErrQuota):
+		return "The project's Speech-to-Text quota is exhausted: retry later or request a quota increase in the Cloud console"
+	case errors.Is(err, stt.ErrAudioFormat):
+		return "The recognizer could not decode the audio: check that the input is a valid WAV file in a supported encoding"
+	case errors.Is(err, stt.ErrBudgetExhausted):
+		return "The usage budget for this period is spent: wait for the next period or raise -budget"
+	case errors.Is(err, stt.ErrModelUnavailable):
+		return "The model is not available for this region or language: pick another with -model or set -fallback-model"
+	case errors.Is(err, stt.ErrStreamLimit):
+		return "The stream exceeded the streaming duration limit: split the audio or use batch recognition for long files"
+	case errors.Is(err, stt.ErrUnreachable):
+		return "The recognizer could not be reached: check the network connection, or pass -queue-dir to queue live audio until it is back"
 	}
+	return ""
 }
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// batchItem is one file of a batch, with the overrides of its manifest row.
+type batchItem struct {
+	path string
+	// output is the transcript path, or "" for the default.
+	output     string
+	language   string
+	model      string
+	recognizer string
+}
+
+// manifestColumns are the columns a CSV manifest may have.
+var manifestColumns = []string{"path", "output", "language", "model", "recognizer"}
+
+// readManifest reads the files listed by the manifest at path: either a CSV
+// file whose header row names its columns, among them path, or a list of
+// paths, one per line, ignoring blank lines and # comments. Relative paths
+// are resolved against the manifest's directory.
+func readManifest(path string) ([]batchItem, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	base := filepath.Dir(path)
+	resolve := func(p string) string {
+		if p == "" || filepath.IsAbs(p) || strings.Contains(p, "://") {
+			return p
+		}
+		return filepath.Join(base, p)
+	}
+
+	var items []batchItem
+	if header := firstLine(data); slices.Contains(splitHeader(header), "path") {
+		r := csv.NewReader(bytes.NewReader(data))
+		r.Comment = '#'
+		r.FieldsPerRecord = -1
+		columns, err := r.Read()
+		if err != nil {
+			return nil, fmt.Errorf("invalid manifest %s: %w", path, err)
+		}
+		index := map[string]int{}
+		for i, c := range columns {
+			c = strings.ToLower(strings.TrimSpace(c))
+			if !slices.Contains(manifestColumns, c) {
+				return nil, fmt.Errorf("invalid manifest %s: unknown column %q, expected %s", path, c, strings.Join(manifestColumns, ", "))
+			}
+			index[c] = i
+		}
+		for {
+			row, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid manifest %s: %w", path, err)
+			}
+			field := func(name string) string {
+				if i, ok := index[name]; ok && i < len(row) {
+					return strings.TrimSpace(row[i])
+				}
+				return ""
+			}
+			if field("path") == "" {
+				line, _ := r.FieldPos(0)
+				return nil, fmt.Errorf("invalid manifest %s: line %d has no path", path, line)
+			}
+			items = append(items, batchItem{
+				path:       resolve(field("path")),
+				output:     resolve(field("output")),
+				language:   field("language"),
+				model:      field("model"),
+				recognizer: field("recognizer"),
+			})
+		}
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			items = append(items, batchItem{path: resolve(line)})
+		}
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("manifest %s lists no files", path)
+	}
+	return items, nil
+}
+
+// firstLine returns the first line of data that is not blank or a comment.
+func firstLine(data []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			return line
+		}
+	}
+	return ""
+}
+
+// splitHeader returns the lowercased column names of a CSV header line.
+func splitHeader(line string) []string {
+	var names []string
+	for _, name := range strings.Split(line, ",") {
+		names = append(names, strings.ToLower(strings.Trim(strings.TrimSpace(name), `"`)))
+	}
+	return names
+}
+
+// batchResult is the outcome of one file of a batch, a row of its report.
+type batchResult struct {
+	item     batchItem
+	output   string
+	err      error
+	duration time.Duration
+	segments int
+	elapsed  time.Duration
+}
+
+var batchReportHeader = []string{"path", "output", "status", "error", "language", "model", "audio_seconds", "segments", "elapsed_seconds"}
+
+// writeBatchReport writes a CSV report of the results, in manifest order,
+// to path.
+func writeBatchReport(path string, results []*batchResult) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create report: %w", err)
+	}
+	cw := csv.NewWriter(f)
+	cw.Write(batchReportHeader)
+	for _, r := range results {
+		if r == nil {
+			// Not started before the batch was interrupted
+			continue
+		}
+		status, msg := "ok", ""
+		if r.err != nil {
+			status, msg = "failed", r.err.Error()
+		}
+		cw.Write([]string{
+			r.item.path,
+			r.output,
+			status,
+			msg,
+			r.item.language,
+			r.item.model,
+			strconv.FormatFloat(r.duration.Seconds(), 'f', 2, 64),
+			strconv.Itoa(r.segments),
+			strconv.FormatFloat(r.elapsed.Seconds(), 'f', 2, 64),
+		})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
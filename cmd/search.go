@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"stt-receivetranscription-mve/store"
+)
+
+// runSearch prints stored segments matching the query given as arguments.
+func runSearch(args []string) error {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	storePath := fs.String("store", "", "SQLite database to search")
+	limit := fs.Int("limit", 20, "Maximum number of matches")
+	embedder := fs.String("embedder", "", "Search semantically with this embedder (backend:model)")
+	fs.Parse(args)
+
+	if *storePath == "" {
+		return fmt.Errorf("store path is not set")
+	}
+	query := strings.Join(fs.Args(), " ")
+	if query == "" {
+		return fmt.Errorf("search query is not set")
+	}
+
+	st, err := store.Open(*storePath)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	ctx := context.Background()
+	var matches []store.Match
+	if *embedder != "" {
+		e, err := store.NewEmbedder(*embedder)
+		if err != nil {
+			return err
+		}
+		// Backfill segments stored without an embedder
+		if _, err := st.EmbedMissing(ctx, e); err != nil {
+			return err
+		}
+		matches, err = st.SemanticSearch(ctx, e, query, *limit)
+		if err != nil {
+			return err
+		}
+	} else {
+		matches, err = st.Search(ctx, query, *limit)
+		if err != nil {
+			return err
+		}
+	}
+	for _, m := range matches {
+		fmt.Printf("%s [%s - %s] %s", m.SessionID, m.Start, m.End, m.Snippet)
+		if m.Audio != "" {
+			fmt.Printf(" (%s)", m.Audio)
+		}
+		fmt.Println()
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"stt-receivetranscription-mve/server"
+	"stt-receivetranscription-mve/store"
+	"stt-receivetranscription-mve/stt"
+)
+
+// runServe runs the HTTP server mode.
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := fs.String("listen", ":8080", "Address to listen on")
+	primaryLang := fs.String("primary", "en-US", "Default primary language code")
+	model := fs.String("model", "", "Default recognition model (default "+stt.DefaultModel+")")
+	fallback := fs.String("fallback-model", "", "Model to retry with if the requested model is not available")
+	storePath := fs.String("store", "", "SQLite database to persist transcriptions in")
+	embedder := fs.String("embedder", "", "Embedding backend (backend:model) enabling semantic search")
+	shareDuplicates := fs.Bool("share-duplicates", false, "Share one upstream recognition between concurrent uploads or streaming sessions of the same audio")
+	budget := fs.Duration("budget", 0, "Refuse transcriptions once this much audio has been billed in the budget period (0 for no budget)")
+	budgetPeriod := fs.String("budget-period", "daily", "Budget period: daily or monthly; shared through -store when set")
+	stagingURI := fs.String("staging-uri", "", "gs:// prefix uploads to /transcribe too long for one-shot recognition are staged under for batch recognition")
+	maxUpload := fs.Int64("max-upload-mb", 0, "Largest upload accepted, in MiB, answered with 413 above it (default 480 for /transcribe, 25 for /v1/audio/transcriptions)")
+	ws := fs.Bool("ws", false, "Accept audio streamed over WebSockets at /v1/stream, sending results back as they arrive")
+	recordURI := fs.String("record-uri", "", "gs:// prefix the audio of streaming sessions opened with transcribe=later is recorded under, to be transcribed by a worker instead of live (needs -record-topic)")
+	recordTopic := fs.String("record-topic", "", "Pub/Sub topic the jobs of sessions recorded under -record-uri are published to, in the worker subcommand's job format")
+	twilio := fs.Bool("twilio", false, "Transcribe calls streamed by Twilio Media Streams to /v1/twilio")
+	ingest := fs.Bool("grpc-ingest", false, "Serve the stt.v1.Ingest gRPC streaming service (proto/ingest.proto) on the listen address")
+	keepalive := fs.Duration("keepalive", 0, "Inject a chunk of silence into streamed audio after this long without any, to keep the upstream stream alive (0 disables)")
+	corsOrigins := fs.String("cors-origins", "", "Comma-separated browser origins allowed to call the server (* for any)")
+	canaryPercent := fs.Float64("canary-percent", 0, "Percentage of sessions sent to the canary recognizer set by -canary-region, -canary-recognizer or -canary-model, compared at /v1/canary")
+	canaryRegion := fs.String("canary-region", "", "Region of the canary recognizer (default the baseline's)")
+	canaryRecognizer := fs.String("canary-recognizer", "", "Recognizer ID of the canary (default the baseline's)")
+	canaryModel := fs.String("canary-model", "", "Model of the canary recognizer (default the baseline's)")
+	minConfidence := fs.Float64("alert-min-confidence", 0, "Alert when the rolling mean confidence of a recognizer's final results drops below this (0 disables)")
+	maxErrorRate := fs.Float64("alert-max-error-rate", 0, "Alert when the share of a recognizer's recent sessions that failed rises above this (0 disables)")
+	qualityWindow := fs.Int("alert-window", 50, "Number of latest sessions per recognizer the rolling quality metrics cover")
+	alertWebhook := fs.String("alert-webhook", "", "URL quality alerts are POSTed to as JSON, such as a Slack incoming webhook (default logging them)")
+	demo := fs.Bool("demo", false, "Serve a live transcription demo page at /, built into the binary, which streams the browser's microphone to /v1/stream (needs -ws)")
+	configPath := fs.String("config", os.Getenv(flagEnv("config")), "File of flag=value lines, or directory of files named after flags such as a mounted ConfigMap, setting flags not given on the command line or as STT_ environment variables")
+	drainTimeout := fs.Duration("drain-timeout", 0, "On SIGTERM or interrupt, refuse new sessions and fail /readyz while letting active ones finish for up to this long before shutting down (0 cancels them at once)")
+	fs.Parse(args)
+	if err := configureFlags(fs, *configPath); err != nil {
+		return err
+	}
+
+	if (*recordURI == "") != (*recordTopic == "") {
+		return fmt.Errorf("-record-uri and -record-topic must be given together")
+	}
+	if *recordURI != "" && !strings.HasPrefix(*recordURI, "gs://") {
+		return fmt.Errorf("-record-uri must be a gs:// URI")
+	}
+	config := &Config{PrimaryLang: *primaryLang, Model: *model, Fallback: *fallback}
+	if err := config.loadEnv(); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// Cancelling requests waits for the drain, if any
+	requestCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+
+	client, err := stt.NewClient(ctx, config.Recognition())
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	warnDrift(ctx, client, config.Recognition())
+
+	// gRPC services are reachable from browsers through gRPC-Web
+	grpcServer := grpc.NewServer()
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+
+	opts := server.Options{GRPC: grpcServer, ShareDuplicates: *shareDuplicates, WebSocket: *ws, StagingURI: *stagingURI, MaxUpload: *maxUpload << 20, Ingest: *ingest, Twilio: *twilio, Keepalive: *keepalive, Demo: *demo}
+	if *storePath != "" {
+		st, err := store.Open(*storePath)
+		if err != nil {
+			return err
+		}
+		defer st.Close()
+		if *embedder != "" {
+			e, err := store.NewEmbedder(*embedder)
+			if err != nil {
+				return err
+			}
+			st.SetEmbedder(e)
+			opts.Embedder = e
+		}
+		opts.Store = st
+	}
+	if *budget > 0 {
+		var usage stt.UsageStore
+		if opts.Store != nil {
+			usage = opts.Store
+		}
+		b, err := stt.NewBudget(*budget, stt.BudgetPeriod(*budgetPeriod), usage)
+		if err != nil {
+			return err
+		}
+		opts.Budget = b
+	}
+	if *recordTopic != "" {
+		client, err := pubsub.NewClient(ctx, config.ProjectID)
+		if err != nil {
+			return fmt.Errorf("failed to create Pub/Sub client: %w", err)
+		}
+		defer client.Close()
+		project, id := pubsubName(*recordTopic, "topics", config.ProjectID)
+		topic := client.TopicInProject(id, project)
+		defer topic.Stop()
+		opts.RecordURI = *recordURI
+		opts.Enqueue = func(ctx context.Context, job server.Job) error {
+			data, err := json.Marshal(job)
+			if err != nil {
+				return err
+			}
+			_, err = topic.Publish(ctx, &pubsub.Message{Data: data}).Get(ctx)
+			return err
+		}
+	}
+	if *corsOrigins != "" {
+		opts.CORSOrigins = strings.Split(*corsOrigins, ",")
+	}
+	if *minConfidence > 0 || *maxErrorRate > 0 || *alertWebhook != "" {
+		quality := stt.QualityOptions{Window: *qualityWindow, MinConfidence: *minConfidence, MaxErrorRate: *maxErrorRate}
+		if *alertWebhook != "" {
+			// Alerts are raised from sessions, which must not wait for the webhook
+			quality.Alert = func(a stt.QualityAlert) { go postAlert(*alertWebhook, a) }
+		}
+		opts.Quality = stt.NewQualityMonitor(quality)
+	}
+	if *canaryPercent > 0 {
+		canary, err := stt.NewCanary(stt.CanaryOptions{
+			Region:       *canaryRegion,
+			RecognizerID: *canaryRecognizer,
+			Model:        *canaryModel,
+			Percent:      *canaryPercent,
+		})
+		if err != nil {
+			return err
+		}
+		opts.Canary = canary
+		// Streaming sessions dial their own region; one-shot recognition
+		// needs a client for the canary's
+		if variant := canary.Variant(config.Recognition()); variant.Region != config.Region {
+			canaryClient, err := stt.NewClient(ctx, variant)
+			if err != nil {
+				return err
+			}
+			defer canaryClient.Close()
+			opts.CanaryClient = canaryClient
+		}
+		log.Printf("Sending %g%% of sessions to the canary recognizer", *canaryPercent)
+	}
+
+	handler := server.New(client, config.Recognition(), opts)
+	srv := &http.Server{
+		Addr:        *listen,
+		Handler:     handler,
+		BaseContext: func(net.Listener) context.Context { return requestCtx },
+	}
+	// Native gRPC clients speak HTTP/2 without TLS
+	srv.Protocols = new(http.Protocols)
+	srv.Protocols.SetHTTP1(true)
+	srv.Protocols.SetUnencryptedHTTP2(true)
+	go func() {
+		<-ctx.Done()
+		if *drainTimeout > 0 {
+			// A second signal exits at once
+			stop()
+			healthServer.Shutdown()
+			idle := handler.Drain()
+			log.Printf("Draining %d active sessions for up to %s; interrupt again to exit", handler.Active(), *drainTimeout)
+			select {
+			case <-idle:
+			case <-time.After(*drainTimeout):
+				log.Printf("Drain timed out with %d sessions still active", handler.Active())
+			}
+		}
+		cancelRequests()
+		log.Printf("Shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("Listening on %s", *listen)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// qualityAlert is the body of a quality alert webhook. Text makes it a
+// valid Slack incoming webhook message.
+type qualityAlert struct {
+	Text string `json:"text"`
+	stt.QualityAlert
+}
+
+// postAlert posts a to the webhook at url, logging it if that fails.
+func postAlert(url string, a stt.QualityAlert) {
+	log.Printf("Warning: %s", a)
+	body, err := json.Marshal(qualityAlert{Text: "Transcription quality: " + a.String(), QualityAlert: a})
+	if err != nil {
+		log.Printf("Failed to encode quality alert: %v", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Failed to send quality alert: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("Failed to send quality alert: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("Failed to send quality alert: webhook returned status %s", resp.Status)
+	}
+}
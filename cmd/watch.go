@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"stt-receivetranscription-mve/stt"
+)
+
+// runWatch transcribes audio files as they land in a directory, moving each
+// into done/ or error/ once processed.
+func runWatch(args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	primaryLang := fs.String("primary", "en-US", "Primary language code")
+	model := fs.String("model", "", "Recognition model (default "+stt.DefaultModel+")")
+	fallback := fs.String("fallback-model", "", "Model to retry with if -model is not available for the region or language")
+	format := fs.String("format", "text", "Transcript format as format[,key=value...], as for -output")
+	outDir := fs.String("out-dir", "", "Directory to write transcripts to (default the done/ directory)")
+	workers := fs.Int("workers", 2, "Number of files transcribed concurrently")
+	settle := fs.Duration("settle", 2*time.Second, "How long a file must go unmodified before it is transcribed")
+	claimURI := fs.String("claim", "", "Share the directory with other instances, claiming each file in this redis:// or gs://bucket/prefix lease store before transcribing it")
+	claimTTL := fs.Duration("claim-ttl", time.Minute, "How long a -claim lease lasts unless renewed, and so how long the file of a crashed instance waits to be retried")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("expected the directory to watch")
+	}
+	dir := fs.Arg(0)
+	config := &Config{PrimaryLang: *primaryLang, Model: *model, Fallback: *fallback}
+	if err := config.loadEnv(); err != nil {
+		return err
+	}
+	name, _, _ := strings.Cut(*format, ",")
+	ext, ok := formatExtensions[name]
+	if !ok {
+		return fmt.Errorf("unknown output format %q", name)
+	}
+	doneDir, errorDir := filepath.Join(dir, "done"), filepath.Join(dir, "error")
+	if *outDir == "" {
+		*outDir = doneDir
+	}
+	for _, d := range []string{doneDir, errorDir, *outDir} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", d, err)
+		}
+	}
+
+	var claims *claimer
+	if *claimURI != "" {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %w", dir, err)
+		}
+		// Instances may mount the directory at different paths
+		if claims, err = openClaimer(*claimURI, *claimTTL, "watch/"+filepath.Base(abs)+"/"); err != nil {
+			return err
+		}
+		defer claims.Close()
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create watcher: %w", err)
+	}
+	defer watcher.Close()
+	if err := watcher.Add(dir); err != nil {
+		return fmt.Errorf("failed to watch %s: %w", dir, err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	w := &folderWatcher{
+		settle: *settle,
+		queue:  make(chan string),
+		timers: map[string]*time.Timer{},
+	}
+	// process transcribes path, reporting whether to try it again later
+	// because another instance holds it
+	process := func(path string) bool {
+		cl, state, err := claims.claim(ctx, filepath.Base(path))
+		if err != nil {
+			log.Printf("Failed to claim %s: %v", path, err)
+			return ctx.Err() == nil
+		}
+		if state != claimAcquired {
+			// Retried in case the holder crashes
+			return state == claimHeld
+		}
+		defer cl.Release()
+		if _, err := os.Stat(path); err != nil {
+			// Processed by another instance meanwhile
+			return false
+		}
+		out := filepath.Join(*outDir, strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))+ext)
+		err = transcribeToFile(cl.Context(), config, path, *format+":"+out)
+		if cl.Context().Err() != nil {
+			// Left in place to be picked up again on the next start, or by
+			// the instance that took the claim
+			if ctx.Err() == nil {
+				log.Printf("Stopped transcribing %s: %v", path, context.Cause(cl.Context()))
+			}
+			return false
+		}
+		if err != nil {
+			log.Printf("Failed to transcribe %s: %v", path, err)
+			if err := moveFailed(path, errorDir, err); err != nil {
+				log.Printf("Failed to move %s to %s: %v", path, errorDir, err)
+			}
+			return false
+		}
+		if err := os.Rename(path, filepath.Join(doneDir, filepath.Base(path))); err != nil {
+			log.Printf("Failed to move %s to %s: %v", path, doneDir, err)
+			return false
+		}
+		log.Printf("Transcribed %s to %s", path, out)
+		return false
+	}
+	var wg sync.WaitGroup
+	for range max(*workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range w.queue {
+				retry := process(path)
+				w.finish(path)
+				if retry {
+					w.retry(ctx, path, *claimTTL)
+				}
+			}
+		}()
+	}
+
+	// Files that landed while not watching are picked up first
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %w", dir, err)
+	}
+	for _, e := range entries {
+		w.schedule(ctx, filepath.Join(dir, e.Name()))
+	}
+
+	log.Printf("Watching %s for audio files", dir)
+	defer func() {
+		w.stop()
+		wg.Wait()
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("Stopped watching %s: %v", dir, ctx.Err())
+			return nil
+		case e, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if e.Has(fsnotify.Create) || e.Has(fsnotify.Write) {
+				w.schedule(ctx, e.Name)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Printf("Watcher error: %v", err)
+		}
+	}
+}
+
+// folderWatcher queues each file once it has gone unmodified for settle, so
+// files still being copied in are not transcribed early.
+type folderWatcher struct {
+	settle time.Duration
+	queue  chan string
+
+	mu      sync.Mutex
+	timers  map[string]*time.Timer
+	stopped bool
+	sending sync.WaitGroup
+}
+
+// schedule queues path after settle, restarting the wait if it is already
+// scheduled. Directories, hidden files and partial downloads are skipped.
+func (w *folderWatcher) schedule(ctx context.Context, path string) {
+	base := filepath.Base(path)
+	if strings.HasPrefix(base, ".") || strings.HasSuffix(base, ".part") || strings.HasSuffix(base, ".tmp") {
+		return
+	}
+	if info, err := os.Stat(path); err != nil || info.IsDir() {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stopped {
+		return
+	}
+	if t, ok := w.timers[path]; ok {
+		// A nil timer marks a file already queued or being transcribed
+		if t != nil {
+			t.Reset(w.settle)
+		}
+		return
+	}
+	w.sending.Add(1)
+	w.timers[path] = time.AfterFunc(w.settle, func() {
+		defer w.sending.Done()
+		w.mu.Lock()
+		w.timers[path] = nil
+		w.mu.Unlock()
+		select {
+		case w.queue <- path:
+		case <-ctx.Done():
+		}
+	})
+}
+
+// finish forgets a processed file, so a new file of the same name is
+// transcribed too.
+func (w *folderWatcher) finish(path string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.timers, path)
+}
+
+// retry schedules path again after delay, unless stopped by then.
+func (w *folderWatcher) retry(ctx context.Context, path string, delay time.Duration) {
+	time.AfterFunc(delay, func() {
+		if ctx.Err() == nil {
+			w.schedule(ctx, path)
+		}
+	})
+}
+
+// stop cancels pending files and closes the queue once queued files are
+// handed to the workers.
+func (w *folderWatcher) stop() {
+	w.mu.Lock()
+	w.stopped = true
+	for _, t := range w.timers {
+		if t != nil && t.Stop() {
+			w.sending.Done()
+		}
+	}
+	w.mu.Unlock()
+	w.sending.Wait()
+	close(w.queue)
+}
+
+// moveFailed moves path into dir with a log of the error next to it.
+func moveFailed(path, dir string, failure error) error {
+	dst := filepath.Join(dir, filepath.Base(path))
+	if err := os.Rename(path, dst); err != nil {
+		return err
+	}
+	msg := fmt.Sprintf("%s: failed to transcribe %s: %v\n", time.Now().Format(time.RFC3339), path, failure)
+	if hint := remediation(failure); hint != "" {
+		msg += hint + "\n"
+	}
+	return os.WriteFile(dst+".error.log", []byte(msg), 0o644)
+}
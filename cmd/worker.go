@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+
+	"stt-receivetranscription-mve/output"
+	"stt-receivetranscription-mve/stt"
+)
+
+// workerJob is a transcription job message.
+type workerJob struct {
+	// ID names the job's session; it defaults to the message ID.
+	ID string `json:"id"`
+	// URI is the gs:// URI of the audio.
+	URI string `json:"uri"`
+	// Output is the gs:// URI the transcript is written to; it defaults to
+	// the job ID under -output-prefix.
+	Output   string            `json:"output"`
+	Format   string            `json:"format"`
+	Language string            `json:"language"`
+	Model    string            `json:"model"`
+	Tags     map[string]string `json:"tags"`
+}
+
+// permanentError is a job failure that retrying cannot fix.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+
+func (e permanentError) Unwrap() error { return e.err }
+
+// Outcomes of a job claimed by another instance under -claim.
+var (
+	errJobClaimed = errors.New("job is being transcribed by another instance")
+	errJobDone    = errors.New("job was already transcribed")
+)
+
+// runWorker transcribes the jobs received on a Pub/Sub subscription,
+// acknowledging each once its transcript is written.
+func runWorker(args []string) error {
+	fs := flag.NewFlagSet("worker", flag.ExitOnError)
+	primaryLang := fs.String("primary", "en-US", "Primary language code of jobs without a language")
+	model := fs.String("model", "", "Recognition model of jobs without a model (default "+stt.DefaultModel+")")
+	fallback := fs.String("fallback-model", "", "Model to retry with if a job's model is not available for the region or language")
+	format := fs.String("format", "json", "Transcript format of jobs without a format, as format[,key=value...] as for -output")
+	outputPrefix := fs.String("output-prefix", "", "gs:// prefix transcripts of jobs without an output URI are written under, named by job ID")
+	deadLetter := fs.String("dead-letter-topic", "", "Topic jobs that fail permanently, such as invalid jobs or unsupported audio, are published to before being acknowledged (default retrying them)")
+	workers := fs.Int("workers", 4, "Number of jobs transcribed concurrently")
+	claimURI := fs.String("claim", "", "Claim each job by ID in this redis:// or gs://bucket/prefix lease store before transcribing it, skipping duplicate deliveries of jobs other instances are transcribing or have transcribed")
+	claimTTL := fs.Duration("claim-ttl", time.Minute, "How long a -claim lease lasts unless renewed, and so how long the job of a crashed instance stays claimed")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return fmt.Errorf("expected the subscription to receive jobs from")
+	}
+	if *outputPrefix != "" && !strings.HasPrefix(*outputPrefix, "gs://") {
+		return fmt.Errorf("-output-prefix must be a gs:// URI")
+	}
+	config := &Config{PrimaryLang: *primaryLang, Model: *model, Fallback: *fallback}
+	if err := config.loadEnv(); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client, err := pubsub.NewClient(ctx, config.ProjectID)
+	if err != nil {
+		return fmt.Errorf("failed to create Pub/Sub client: %w", err)
+	}
+	defer client.Close()
+	project, id := pubsubName(fs.Arg(0), "subscriptions", config.ProjectID)
+	sub := client.SubscriptionInProject(id, project)
+	sub.ReceiveSettings.MaxOutstandingMessages = max(*workers, 1)
+	var dlq *pubsub.Topic
+	if *deadLetter != "" {
+		project, id := pubsubName(*deadLetter, "topics", config.ProjectID)
+		dlq = client.TopicInProject(id, project)
+		defer dlq.Stop()
+	}
+
+	var claims *claimer
+	if *claimURI != "" {
+		if claims, err = openClaimer(*claimURI, *claimTTL, "worker/"+id+"/"); err != nil {
+			return err
+		}
+		defer claims.Close()
+	}
+
+	w := &jobWorker{config: config, format: *format, outputPrefix: *outputPrefix, claims: claims}
+	log.Printf("Receiving transcription jobs from %s; interrupt to stop", sub)
+	err = sub.Receive(ctx, func(ctx context.Context, msg *pubsub.Message) {
+		job, err := w.run(ctx, msg)
+		switch {
+		case err == nil:
+			log.Printf("Transcribed job %s to %s", job.ID, job.Output)
+			msg.Ack()
+		case ctx.Err() != nil:
+			// Redelivered to the next worker
+			msg.Nack()
+		case errors.Is(err, errJobDone):
+			log.Printf("Skipping duplicate delivery of job %s: %v", job.ID, err)
+			msg.Ack()
+		case errors.Is(err, errJobClaimed):
+			// Redelivered until its holder completes it or its claim expires
+			msg.Nack()
+		case errors.As(err, new(permanentError)) && dlq != nil:
+			log.Printf("Dead-lettering job %s: %v", job.ID, err)
+			if err := deadLetterJob(ctx, dlq, msg, err); err != nil {
+				log.Printf("Failed to dead-letter job %s: %v", job.ID, err)
+				msg.Nack()
+				return
+			}
+			msg.Ack()
+		default:
+			log.Printf("Failed to transcribe job %s: %v", job.ID, err)
+			// A dead-letter policy on the subscription bounds the retries
+			msg.Nack()
+		}
+	})
+	if err != nil && ctx.Err() == nil {
+		return fmt.Errorf("failed to receive jobs: %w", err)
+	}
+	log.Printf("Stopped receiving jobs")
+	return nil
+}
+
+// pubsubName splits a projects/<project>/<kind>/<id> resource name into
+// its project and ID; a bare ID is in project.
+func pubsubName(name, kind, project string) (string, string) {
+	parts := strings.Split(name, "/")
+	if len(parts) == 4 && parts[0] == "projects" && parts[2] == kind {
+		return parts[1], parts[3]
+	}
+	return project, name
+}
+
+// jobWorker transcribes jobs with the defaults of the worker's flags.
+type jobWorker struct {
+	config       *Config
+	format       string
+	outputPrefix string
+	claims       *claimer
+}
+
+// run transcribes the job in msg and writes its transcript, returning the
+// job with its defaults filled in.
+func (w *jobWorker) run(ctx context.Context, msg *pubsub.Message) (workerJob, error) {
+	job := workerJob{ID: msg.ID}
+	if err := json.Unmarshal(msg.Data, &job); err != nil {
+		return job, permanentError{fmt.Errorf("invalid job: %w", err)}
+	}
+	if job.ID == "" {
+		job.ID = msg.ID
+	}
+	if !strings.HasPrefix(job.URI, "gs://") {
+		return job, permanentError{fmt.Errorf("invalid job: uri must be a gs:// URI, got %q", job.URI)}
+	}
+	if job.Format == "" {
+		job.Format = w.format
+	}
+	name, _, _ := strings.Cut(job.Format, ",")
+	ext, ok := formatExtensions[name]
+	if !ok {
+		return job, permanentError{fmt.Errorf("invalid job: unknown output format %q", name)}
+	}
+	if job.Output == "" {
+		if w.outputPrefix == "" {
+			return job, permanentError{errors.New("invalid job: no output URI and no -output-prefix")}
+		}
+		job.Output = strings.TrimSuffix(w.outputPrefix, "/") + "/" + job.ID + ext
+	}
+	if !strings.HasPrefix(job.Output, "gs://") {
+		return job, permanentError{fmt.Errorf("invalid job: output must be a gs:// URI, got %q", job.Output)}
+	}
+
+	cl, state, err := w.claims.claim(ctx, job.ID)
+	switch {
+	case err != nil:
+		return job, err
+	case state == claimHeld:
+		return job, errJobClaimed
+	case state == claimDone:
+		return job, errJobDone
+	}
+	if err := w.transcribe(cl.Context(), job); err != nil {
+		cl.Release()
+		return job, err
+	}
+	cl.Complete()
+	return job, nil
+}
+
+// transcribe transcribes job and writes its transcript.
+func (w *jobWorker) transcribe(ctx context.Context, job workerJob) error {
+	config := w.config.Recognition()
+	if job.Language != "" {
+		config.LanguageCodes = []string{job.Language}
+	}
+	if job.Model != "" {
+		config.Model = job.Model
+	}
+	transcript, err := stt.TranscribeFile(ctx, job.URI, stt.TranscribeOptions{Config: config})
+	if err != nil {
+		if errors.Is(err, stt.ErrAudioFormat) || errors.Is(err, stt.ErrModelUnavailable) {
+			err = permanentError{err}
+		}
+		return err
+	}
+
+	sink, err := output.Open(job.Format + ":" + job.Output)
+	if err != nil {
+		return permanentError{err}
+	}
+	session := output.Session{ID: job.ID, Tags: job.Tags, Audio: job.URI}
+	if len(config.LanguageCodes) > 0 {
+		session.Language = config.LanguageCodes[0]
+	}
+	sinks := output.WithSession(sink, session)
+	for _, record := range output.FromTranscript(transcript) {
+		if err := sinks.Write(ctx, record); err != nil {
+			sink.Close()
+			return fmt.Errorf("failed to write transcript: %w", err)
+		}
+	}
+	return sink.Close()
+}
+
+// deadLetterJob publishes the message of a failed job to topic, with the
+// failure in its "error" attribute.
+func deadLetterJob(ctx context.Context, topic *pubsub.Topic, msg *pubsub.Message, jobErr error) error {
+	attrs := maps.Clone(msg.Attributes)
+	if attrs == nil {
+		attrs = map[string]string{}
+	}
+	attrs["error"] = jobErr.Error()
+	attrs["message_id"] = msg.ID
+	_, err := topic.Publish(ctx, &pubsub.Message{Data: msg.Data, Attributes: attrs}).Get(ctx)
+	return err
+}
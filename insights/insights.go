@@ -0,0 +1,48 @@
+// Package insights extracts questions, action items and decisions from
+// transcripts.
+package insights
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"stt-receivetranscription-mve/stt"
+)
+
+// Kinds of extracted items.
+const (
+	KindQuestion   = "question"
+	KindActionItem = "action_item"
+	KindDecision   = "decision"
+)
+
+// Item is a question, action item or decision found in a transcript, with
+// the time span of the words it was found in.
+type Item struct {
+	Kind    string        `json:"kind"`
+	Text    string        `json:"text"`
+	Speaker string        `json:"speaker,omitempty"`
+	Start   time.Duration `json:"start"`
+	End     time.Duration `json:"end"`
+}
+
+// Extractor finds the items of a transcript, in transcript order.
+type Extractor interface {
+	Extract(ctx context.Context, t stt.Transcript) ([]Item, error)
+}
+
+// New creates an extractor from a spec: "rules" for the built-in rules, or
+// backend:model for an LLM, with the backends of store.NewEmbedder ("openai"
+// and "ollama"). LLM extractors fall back to the rules when the model fails.
+func New(spec string) (Extractor, error) {
+	if spec == "rules" {
+		return Rules{}, nil
+	}
+	backend, model, ok := strings.Cut(spec, ":")
+	if !ok || model == "" {
+		return nil, fmt.Errorf("invalid extractor %q: expected rules or backend:model", spec)
+	}
+	return newLLM(backend, model)
+}
@@ -0,0 +1,153 @@
+package insights
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"stt-receivetranscription-mve/stt"
+)
+
+const llmPrompt = `You extract meeting insights from a transcript. Each line is one segment: [index] speaker: text.
+Find the questions asked, the action items (tasks someone commits to or is asked to do) and the decisions made.
+Reply with a JSON object {"items": [{"kind": "question" | "action_item" | "decision", "segment": index, "text": "..."}]}, where text is the item in a few words taken from the segment. Reply {"items": []} if there are none.`
+
+// llm extracts items with a chat model, falling back to the rules.
+type llm struct {
+	client *http.Client
+	url    string
+	token  string
+	model  string
+	ollama bool
+}
+
+func newLLM(backend, model string) (*llm, error) {
+	client := &http.Client{Timeout: 2 * time.Minute}
+	switch backend {
+	case "openai":
+		baseURL := os.Getenv("OPENAI_BASE_URL")
+		if baseURL == "" {
+			baseURL = "https://api.openai.com/v1"
+		}
+		return &llm{client: client, url: strings.TrimSuffix(baseURL, "/") + "/chat/completions", token: os.Getenv("OPENAI_API_KEY"), model: model}, nil
+	case "ollama":
+		host := os.Getenv("OLLAMA_HOST")
+		if host == "" {
+			host = "http://localhost:11434"
+		}
+		return &llm{client: client, url: strings.TrimSuffix(host, "/") + "/api/chat", model: model, ollama: true}, nil
+	default:
+		return nil, fmt.Errorf("unknown extractor backend %q", backend)
+	}
+}
+
+func (l *llm) Extract(ctx context.Context, t stt.Transcript) ([]Item, error) {
+	items, err := l.extract(ctx, t)
+	if err != nil {
+		log.Printf("Warning: LLM extraction failed, using rules instead: %v", err)
+		return Rules{}.Extract(ctx, t)
+	}
+	return items, nil
+}
+
+func (l *llm) extract(ctx context.Context, t stt.Transcript) ([]Item, error) {
+	var lines []string
+	for i, seg := range t.Segments {
+		if seg.Event == "" {
+			lines = append(lines, fmt.Sprintf("[%d] %s: %s", i, cmp.Or(seg.Speaker, "unknown"), seg.Text))
+		}
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+	messages := []map[string]string{
+		{"role": "system", "content": llmPrompt},
+		{"role": "user", "content": strings.Join(lines, "\n")},
+	}
+
+	var content string
+	if l.ollama {
+		var resp struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		}
+		body := map[string]any{"model": l.model, "messages": messages, "format": "json", "stream": false}
+		if err := l.post(ctx, body, &resp); err != nil {
+			return nil, err
+		}
+		content = resp.Message.Content
+	} else {
+		var resp struct {
+			Choices []struct {
+				Message struct {
+					Content string `json:"content"`
+				} `json:"message"`
+			} `json:"choices"`
+		}
+		body := map[string]any{"model": l.model, "messages": messages, "response_format": map[string]string{"type": "json_object"}}
+		if err := l.post(ctx, body, &resp); err != nil {
+			return nil, err
+		}
+		if len(resp.Choices) == 0 {
+			return nil, fmt.Errorf("model returned no choices")
+		}
+		content = resp.Choices[0].Message.Content
+	}
+
+	var reply struct {
+		Items []struct {
+			Kind    string `json:"kind"`
+			Segment int    `json:"segment"`
+			Text    string `json:"text"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal([]byte(content), &reply); err != nil {
+		return nil, fmt.Errorf("failed to decode model reply: %w", err)
+	}
+	var items []Item
+	for _, r := range reply.Items {
+		if r.Segment < 0 || r.Segment >= len(t.Segments) || !slices.Contains([]string{KindQuestion, KindActionItem, KindDecision}, r.Kind) {
+			continue
+		}
+		seg := t.Segments[r.Segment]
+		items = append(items, Item{Kind: r.Kind, Text: r.Text, Speaker: seg.Speaker, Start: seg.Start, End: seg.End})
+	}
+	slices.SortStableFunc(items, func(a, b Item) int { return cmp.Compare(a.Start, b.Start) })
+	return items, nil
+}
+
+func (l *llm) post(ctx context.Context, body, out any) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.url, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if l.token != "" {
+		req.Header.Set("Authorization", "Bearer "+l.token)
+	}
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call model: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("model returned status %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode model response: %w", err)
+	}
+	return nil
+}
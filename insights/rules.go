@@ -0,0 +1,106 @@
+package insights
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"strings"
+
+	"stt-receivetranscription-mve/stt"
+)
+
+// Rules extracts items from English transcripts with keyword rules. It needs
+// punctuated transcripts to find questions reliably.
+type Rules struct{}
+
+var (
+	sentencePattern = regexp.MustCompile(`[^.!?]+[.!?]*`)
+	punctuation     = strings.NewReplacer(",", " ", ".", " ", ";", " ", ":", " ", "!", " ", "?", " ", "\u2019", "'")
+
+	interrogatives = []string{"who", "what", "when", "where", "why", "how", "which",
+		"is", "are", "am", "was", "were", "can", "could", "would", "will", "should",
+		"shall", "do", "does", "did", "have", "has", "may"}
+	decisionPhrases = []string{"we decided", "we've decided", "we have decided", "decided to",
+		"we agreed", "we've agreed", "agreed to", "let's go with", "we'll go with",
+		"we will go with", "we're going with", "we are going with", "the decision is",
+		"final decision"}
+	actionPhrases = []string{"i will", "i'll", "we will", "we'll", "you will", "you'll",
+		"need to", "needs to", "have to", "has to", "let's", "action item", "follow up",
+		"follow-up", "to do", "todo", "make sure", "please", "can you", "could you",
+		"assign", "by tomorrow", "by monday", "by tuesday", "by wednesday", "by thursday",
+		"by friday", "by next week", "by end of", "deadline"}
+)
+
+func (Rules) Extract(_ context.Context, t stt.Transcript) ([]Item, error) {
+	var items []Item
+	for _, seg := range t.Segments {
+		if seg.Event != "" {
+			continue
+		}
+		for _, s := range sentences(seg) {
+			if kind := classify(s.Text); kind != "" {
+				s.Kind = kind
+				items = append(items, s)
+			}
+		}
+	}
+	return items, nil
+}
+
+// classify returns the kind of a sentence, or "" if it is none. Decisions
+// take precedence over action items, and requests such as "can you send it?"
+// are action items rather than questions.
+func classify(sentence string) string {
+	words := strings.Fields(punctuation.Replace(strings.ToLower(sentence)))
+	if len(words) == 0 {
+		return ""
+	}
+	norm := " " + strings.Join(words, " ") + " "
+	switch {
+	case containsPhrase(norm, decisionPhrases):
+		return KindDecision
+	case containsPhrase(norm, actionPhrases):
+		return KindActionItem
+	case strings.HasSuffix(sentence, "?"):
+		return KindQuestion
+	// Unpunctuated transcripts only mark questions by their first word
+	case !strings.ContainsAny(sentence, ".!") && slices.Contains(interrogatives, words[0]):
+		return KindQuestion
+	}
+	return ""
+}
+
+func containsPhrase(norm string, phrases []string) bool {
+	for _, p := range phrases {
+		if strings.Contains(norm, " "+p+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// sentences splits a segment into sentences, timed by their words when the
+// segment's word timings line up with its text.
+func sentences(seg stt.Segment) []Item {
+	var (
+		items []Item
+		word  int
+	)
+	for _, text := range sentencePattern.FindAllString(seg.Text, -1) {
+		text = strings.TrimSpace(text)
+		n := len(strings.Fields(text))
+		if n == 0 {
+			continue
+		}
+		item := Item{Text: text, Speaker: seg.Speaker, Start: seg.Start, End: seg.End}
+		if word+n <= len(seg.Words) {
+			item.Start, item.End = seg.Words[word].Start, seg.Words[word+n-1].End
+			if s := seg.Words[word].Speaker; s != "" {
+				item.Speaker = s
+			}
+		}
+		word += n
+		items = append(items, item)
+	}
+	return items
+}
@@ -0,0 +1,194 @@
+package output
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"stt-receivetranscription-mve/stt"
+)
+
+// AuditOptions configures what an AuditLog records beyond request metadata.
+type AuditOptions struct {
+	// Redact replaces digit sequences, such as phone, card and account
+	// numbers, and email addresses in transcripts with [REDACTED].
+	Redact bool
+	// OmitText leaves transcripts out entirely.
+	OmitText bool
+	// Audio records every chunk of audio sent, base64-encoded. By default
+	// only its size and SHA-256 digest are recorded.
+	Audio bool
+}
+
+// AuditLog records what streaming sessions send to the recognition provider
+// and what it returns, as one JSON object per line: the config each stream
+// was opened with, a digest of every audio chunk sent, every result and how
+// the session ended. It is meant for environments that must be able to show
+// which data left the machine.
+type AuditLog struct {
+	opts AuditOptions
+
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+// OpenAuditLog opens the audit log at path, appending to it if it exists.
+func OpenAuditLog(path string, opts AuditOptions) (*AuditLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &AuditLog{opts: opts, w: f, closer: f}, nil
+}
+
+// NewAuditLog returns an audit log writing to w.
+func NewAuditLog(w io.Writer, opts AuditOptions) *AuditLog {
+	return &AuditLog{opts: opts, w: w}
+}
+
+// auditEntry is a line of the audit log. Fields are set according to Event.
+type auditEntry struct {
+	Time      time.Time `json:"time"`
+	SessionID string    `json:"session_id"`
+	// Event is "stream_opened", "audio_sent", "result", "stream_restarted",
+	// "session_truncated" or "session_ended".
+	Event string `json:"event"`
+
+	Provider       string   `json:"provider,omitempty"`
+	Recognizer     string   `json:"recognizer,omitempty"`
+	Model          string   `json:"model,omitempty"`
+	Languages      []string `json:"languages,omitempty"`
+	Boost          []string `json:"boost,omitempty"`
+	WordTimings    bool     `json:"word_timings,omitempty"`
+	WordConfidence bool     `json:"word_confidence,omitempty"`
+	RedactPII      bool     `json:"redact_pii,omitempty"`
+	MaxSpeakers    int      `json:"max_speakers,omitempty"`
+
+	Offset int    `json:"offset,omitempty"`
+	Bytes  int    `json:"bytes,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	Audio  []byte `json:"audio,omitempty"`
+
+	IsFinal    bool          `json:"is_final,omitempty"`
+	Text       string        `json:"text,omitempty"`
+	Redacted   bool          `json:"redacted,omitempty"`
+	Language   string        `json:"language,omitempty"`
+	Start      time.Duration `json:"start,omitempty"`
+	End        time.Duration `json:"end,omitempty"`
+	Confidence float32       `json:"confidence,omitempty"`
+
+	Billed time.Duration `json:"billed,omitempty"`
+	Error  string        `json:"error,omitempty"`
+}
+
+// Watch records the events of s until it ends. Call it before Run or Start.
+func (a *AuditLog) Watch(s *stt.Session) {
+	s.Events().Subscribe(func(_ context.Context, e stt.Event) {
+		entry := auditEntry{Time: time.Now(), SessionID: s.ID}
+		switch e := e.(type) {
+		case stt.StreamOpened:
+			c := e.Config
+			entry.Event = "stream_opened"
+			entry.Provider = c.Provider
+			if entry.Provider == "" {
+				entry.Provider = stt.ProviderGoogle
+			}
+			if entry.Provider == stt.ProviderGoogle {
+				entry.Recognizer = c.Recognizer()
+			}
+			entry.Model = c.Model
+			entry.Languages = c.LanguageCodes
+			entry.Boost = c.Boost
+			entry.WordTimings = c.WordTimeOffsets
+			entry.WordConfidence = c.WordConfidence
+			entry.RedactPII = c.RedactPII
+			entry.MaxSpeakers = c.MaxSpeakers
+			entry.Offset = e.Offset
+		case stt.AudioSent:
+			sum := sha256.Sum256(e.Audio)
+			entry.Event = "audio_sent"
+			entry.Offset = e.Offset - e.Bytes
+			entry.Bytes = e.Bytes
+			entry.SHA256 = hex.EncodeToString(sum[:])
+			if a.opts.Audio {
+				entry.Audio = e.Audio
+			}
+		case stt.PartialResult:
+			a.result(&entry, e.Result)
+		case stt.FinalResult:
+			a.result(&entry, e.Result)
+		case stt.StreamRestarted:
+			entry.Event = "stream_restarted"
+			entry.Offset = e.Offset
+			entry.Error = e.Reason.Error()
+		case stt.SessionTruncated:
+			entry.Event = "session_truncated"
+			entry.Offset = e.Offset
+			entry.Billed = e.Billed
+		case stt.SessionEnded:
+			entry.Event = "session_ended"
+			if e.Err != nil {
+				entry.Error = e.Err.Error()
+			}
+		default:
+			return
+		}
+		if err := a.write(entry); err != nil {
+			log.Printf("Failed to write audit record: %v", err)
+		}
+	})
+}
+
+func (a *AuditLog) result(entry *auditEntry, r stt.Result) {
+	entry.Event = "result"
+	entry.IsFinal = r.IsFinal
+	entry.Language = r.Language
+	entry.Start = r.Start
+	entry.End = r.End
+	entry.Confidence = r.Confidence
+	switch {
+	case a.opts.OmitText:
+	case a.opts.Redact:
+		entry.Text = RedactText(r.Text)
+		entry.Redacted = entry.Text != r.Text
+	default:
+		entry.Text = r.Text
+	}
+}
+
+func (a *AuditLog) write(entry auditEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.w.Write(append(b, '\n'))
+	return err
+}
+
+func (a *AuditLog) Close() error {
+	if a.closer == nil {
+		return nil
+	}
+	return a.closer.Close()
+}
+
+// redactPatterns match email addresses and runs of four or more digits,
+// which may be separated by spaces, dots or dashes.
+var redactPatterns = regexp.MustCompile(`[\w.+-]+@[\w-]+(\.[\w-]+)+|\d(?:[ .-]?\d){3,}`)
+
+// RedactText replaces digit sequences and email addresses in text with
+// [REDACTED].
+func RedactText(text string) string {
+	return redactPatterns.ReplaceAllString(text, "[REDACTED]")
+}
@@ -0,0 +1,439 @@
+package output
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// CaptionPreset holds the captioning conventions of a language, which the
+// srt and vtt formats follow to split results into cues that can be read in
+// time.
+type CaptionPreset struct {
+	// LineLength is the most display cells on a line of a cue, characters
+	// of East Asian scripts and emoji taking two, and Lines the most lines
+	// of a cue.
+	LineLength int
+	Lines      int
+	// CPS is the reading speed in characters, grapheme clusters, per second:
+	// a cue stays up at least as long as its text takes to read, and at
+	// least MinDuration.
+	CPS         float64
+	MinDuration time.Duration
+	// Unspaced is set for scripts written without spaces between words,
+	// whose lines may break between any characters other than before
+	// closing or after opening punctuation.
+	Unspaced bool
+	// Punctuation replaces punctuation marks after words, such as the
+	// full-width marks of Chinese or the non-breaking space French puts
+	// before some marks. Spaces before a replaced mark are dropped, and
+	// after it too in unspaced scripts.
+	Punctuation map[rune]string
+}
+
+// DefaultCaptionPreset is the preset of srt and vtt outputs without a preset
+// option: a name in CaptionPresets, "auto" for the preset of each record's
+// language, or empty to render each result as one cue.
+var DefaultCaptionPreset = ""
+
+// CaptionLanguage is the language whose preset applies under "auto" to
+// records recognized without a language.
+var CaptionLanguage = "en-US"
+
+// CaptionPresets are the caption presets keyed by language like locales,
+// with region-specific overrides. Line lengths and reading speeds follow
+// common broadcast and streaming guidelines for adult viewers.
+var CaptionPresets = map[string]CaptionPreset{
+	"en": latinCaptions(42, nil),
+	"de": latinCaptions(42, nil),
+	"es": latinCaptions(42, nil),
+	"fr": latinCaptions(42, map[rune]string{
+		'?': "\u202f?", '!': "\u202f!", ';': "\u202f;", ':': "\u00a0:",
+	}),
+	"it": latinCaptions(42, nil),
+	"pt": latinCaptions(42, nil),
+	"nl": latinCaptions(42, nil),
+	"pl": latinCaptions(42, nil),
+	"tr": latinCaptions(42, nil),
+	"ru": latinCaptions(39, nil),
+	"ar": latinCaptions(42, nil),
+	"hi": latinCaptions(42, nil),
+	"ja": {LineLength: 26, Lines: 2, CPS: 4, MinDuration: time.Second, Unspaced: true, Punctuation: map[rune]string{
+		',': "、", '.': "。", '?': "？", '!': "！",
+	}},
+	"zh": {LineLength: 32, Lines: 2, CPS: 9, MinDuration: time.Second, Unspaced: true, Punctuation: map[rune]string{
+		',': "，", '.': "。", '?': "？", '!': "！", ':': "：", ';': "；",
+	}},
+	"ko": {LineLength: 32, Lines: 2, CPS: 12, MinDuration: time.Second},
+}
+
+// latinCaptions returns the preset of a language written with spaces, at 17
+// characters per second.
+func latinCaptions(lineLength int, punctuation map[rune]string) CaptionPreset {
+	return CaptionPreset{LineLength: lineLength, Lines: 2, CPS: 17, MinDuration: time.Second, Punctuation: punctuation}
+}
+
+// LookupCaptionPreset returns the caption preset of a BCP 47 tag such as
+// "ja-JP", falling back from the region to the language.
+func LookupCaptionPreset(tag string) (CaptionPreset, error) {
+	tag = strings.ReplaceAll(tag, "_", "-")
+	lang, region, _ := strings.Cut(tag, "-")
+	lang = strings.ToLower(lang)
+	if p, ok := CaptionPresets[lang+"-"+strings.ToUpper(region)]; ok && region != "" {
+		return p, nil
+	}
+	if p, ok := CaptionPresets[lang]; ok {
+		return p, nil
+	}
+	return CaptionPreset{}, fmt.Errorf("no caption preset for %q", tag)
+}
+
+// optCaptionPreset returns the preset named by the "preset" option, or
+// DefaultCaptionPreset, after checking that it exists.
+func optCaptionPreset(opts Options) (string, error) {
+	name, ok := opts["preset"]
+	if !ok {
+		name = DefaultCaptionPreset
+	}
+	if name == "" || name == "auto" {
+		return name, nil
+	}
+	if _, err := LookupCaptionPreset(name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// captionCue is a subtitle cue: some of a record's text broken into lines.
+type captionCue struct {
+	start, end time.Duration
+	lines      []string
+}
+
+// captioner splits the records of a subtitle format into cues following a
+// caption preset.
+type captioner struct {
+	// preset is the name of the preset, "auto" or empty for one cue per
+	// record.
+	preset string
+	// lastEnd is when the last cue ended, before which the next may not
+	// start.
+	lastEnd time.Duration
+}
+
+// cues returns the cues of r, the first starting with its speaker if
+// speaker is set.
+func (c *captioner) cues(r Record, speaker bool) []captionCue {
+	text := displayText(r)
+	if speaker {
+		text = speakerText(r)
+	}
+	whole := []captionCue{{start: r.Start, end: r.End, lines: []string{text}}}
+	if c.preset == "" || r.Event != "" {
+		return markRightToLeft(r, whole)
+	}
+	name := c.preset
+	if name == "auto" {
+		name = cmp.Or(r.Language, CaptionLanguage)
+	}
+	p, err := LookupCaptionPreset(name)
+	if err != nil {
+		// Languages without a preset are captioned like English
+		p = CaptionPresets["en"]
+	}
+	tokens := p.tokenize(r)
+	if len(tokens) == 0 {
+		return markRightToLeft(r, whole)
+	}
+	if speaker && r.Speaker != "" {
+		label := r.Speaker + ":"
+		if p.Unspaced {
+			label += " "
+		}
+		tokens = slices.Insert(tokens, 0, captionToken{text: label, start: tokens[0].start, end: tokens[0].start})
+	}
+
+	var cues []captionCue
+	for _, group := range p.group(tokens) {
+		lines := p.wrap(group)
+		if len(lines) == 2 {
+			lines = p.balance(group)
+		}
+		cue := captionCue{start: group[0].start, end: group[len(group)-1].end}
+		for _, line := range lines {
+			cue.lines = append(cue.lines, p.join(line))
+		}
+		cues = append(cues, cue)
+	}
+	for i := range cues {
+		cue := &cues[i]
+		chars := 0
+		for _, line := range cue.lines {
+			chars += len(graphemes(line))
+		}
+		// Cues do not overlap, and stay up long enough to be read unless
+		// the next cue of the record needs the time
+		cue.start = max(cue.start, c.lastEnd)
+		need := max(p.MinDuration, time.Duration(float64(chars)/p.CPS*float64(time.Second)))
+		end := cue.start + need
+		if i+1 < len(cues) {
+			end = min(end, cues[i+1].start)
+		}
+		cue.end = max(cue.end, end)
+		if cue.end <= cue.start {
+			cue.end = cue.start + need
+		}
+		c.lastEnd = cue.end
+	}
+	return markRightToLeft(r, cues)
+}
+
+// markRightToLeft starts every line of cues with a right-to-left mark if
+// r's text is written right to left, so players that lay out each line on
+// its own do not render a line starting with a number, a Latin word or a
+// speaker label left to right.
+func markRightToLeft(r Record, cues []captionCue) []captionCue {
+	if !rightToLeft(displayText(r)) {
+		return cues
+	}
+	for _, cue := range cues {
+		for i, line := range cue.lines {
+			cue.lines[i] = rlm + line
+		}
+	}
+	return cues
+}
+
+// captionToken is a unit of text lines are broken between: a word, or in
+// unspaced scripts a character with the punctuation attached to it.
+type captionToken struct {
+	text       string
+	start, end time.Duration
+}
+
+// tokenize returns the tokens of r's text, timed by its words where they
+// match the text and otherwise spread over the record in proportion to
+// their length.
+func (p CaptionPreset) tokenize(r Record) []captionToken {
+	sep := " "
+	if p.Unspaced {
+		sep = ""
+	}
+	texts := make([]string, len(r.Words))
+	for i, w := range r.Words {
+		texts[i] = w.Text
+	}
+	if len(r.Words) > 0 && strings.Join(texts, sep) == r.Text {
+		tokens := make([]captionToken, 0, len(r.Words))
+		for _, w := range r.Words {
+			if text := p.punctuate(w.Text); text != "" {
+				tokens = append(tokens, captionToken{text: text, start: w.Start, end: w.End})
+			}
+		}
+		return tokens
+	}
+
+	text := p.punctuate(r.Text)
+	var parts []string
+	if p.Unspaced {
+		parts = unspacedTokens(text)
+	} else {
+		// Only ASCII spaces separate words: the non-breaking spaces of
+		// punctuation do not
+		for _, part := range strings.Split(text, " ") {
+			if part != "" {
+				parts = append(parts, part)
+			}
+		}
+	}
+	total := 0
+	for _, part := range parts {
+		total += len(graphemes(part))
+	}
+	tokens := make([]captionToken, len(parts))
+	chars := 0
+	at := func(chars int) time.Duration {
+		return r.Start + time.Duration(float64(r.End-r.Start)*float64(chars)/float64(total))
+	}
+	for i, part := range parts {
+		n := len(graphemes(part))
+		tokens[i] = captionToken{text: part, start: at(chars), end: at(chars + n)}
+		chars += n
+	}
+	return tokens
+}
+
+// punctuate applies the preset's punctuation to text. Marks between digits,
+// such as decimal points and the colons of times, are left alone.
+func (p CaptionPreset) punctuate(text string) string {
+	if len(p.Punctuation) == 0 {
+		return text
+	}
+	runes := []rune(text)
+	out := make([]rune, 0, len(runes))
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		replacement, ok := p.Punctuation[r]
+		if !ok || i > 0 && i+1 < len(runes) && unicode.IsDigit(runes[i-1]) && unicode.IsDigit(runes[i+1]) {
+			out = append(out, r)
+			continue
+		}
+		for len(out) > 0 && unicode.IsSpace(out[len(out)-1]) {
+			out = out[:len(out)-1]
+		}
+		out = append(out, []rune(replacement)...)
+		if p.Unspaced {
+			for i+1 < len(runes) && runes[i+1] == ' ' {
+				i++
+			}
+		}
+	}
+	return string(out)
+}
+
+// unspacedTokens splits text of an unspaced script into characters, keeping
+// grapheme clusters whole, runs of letters and digits of spaced scripts,
+// such as a product name, together, closing punctuation with the character
+// before it and opening punctuation with the one after it.
+func unspacedTokens(text string) []string {
+	var tokens []string
+	var pending string
+	for _, g := range graphemes(text) {
+		r, _ := utf8.DecodeRuneInString(g)
+		switch {
+		case g == " ":
+			continue
+		case isOpening(r):
+			pending += g
+			continue
+		case isClosing(r) && len(tokens) > 0 && pending == "":
+			tokens[len(tokens)-1] += g
+			continue
+		case spacedWordRune(r) && pending == "" && len(tokens) > 0:
+			clusters := graphemes(tokens[len(tokens)-1])
+			if last, _ := utf8.DecodeRuneInString(clusters[len(clusters)-1]); spacedWordRune(last) {
+				tokens[len(tokens)-1] += g
+				continue
+			}
+		}
+		tokens = append(tokens, pending+g)
+		pending = ""
+	}
+	if pending != "" {
+		tokens = append(tokens, pending)
+	}
+	return tokens
+}
+
+// spacedWordRune reports whether r is a letter or digit of a script written
+// with spaces, such as Latin.
+func spacedWordRune(r rune) bool {
+	return (unicode.IsLetter(r) || unicode.IsDigit(r)) && r < 0x2E80
+}
+
+// isClosing reports whether a line may not start with r, such as a full
+// stop or a closing bracket.
+func isClosing(r rune) bool {
+	return strings.ContainsRune("、。，．・：；？！）」』】〉》〕’”ー々ぁぃぅぇぉっゃゅょゎァィゥェォッャュョヮヵヶ…‥,.!?:;)]}", r)
+}
+
+// isOpening reports whether a line may not end with r, such as an opening
+// bracket.
+func isOpening(r rune) bool {
+	return strings.ContainsRune("（「『【〈《〔‘“([{", r)
+}
+
+// join joins tokens into the text of a line.
+func (p CaptionPreset) join(tokens []captionToken) string {
+	sep := " "
+	if p.Unspaced {
+		sep = ""
+	}
+	texts := make([]string, len(tokens))
+	for i, t := range tokens {
+		texts[i] = t.text
+	}
+	return strings.Join(texts, sep)
+}
+
+// width returns the display cells tokens take up on a line.
+func (p CaptionPreset) width(tokens []captionToken) int {
+	return cells(p.join(tokens))
+}
+
+// wrap breaks tokens into lines of at most LineLength cells, greedily.
+// A token longer than a line gets a line of its own.
+func (p CaptionPreset) wrap(tokens []captionToken) [][]captionToken {
+	var lines [][]captionToken
+	start := 0
+	for i := 1; i <= len(tokens); i++ {
+		if i == len(tokens) || p.width(tokens[start:i+1]) > p.LineLength {
+			lines = append(lines, tokens[start:i])
+			start = i
+		}
+	}
+	return lines
+}
+
+// balance breaks tokens into two lines as even as fit, the top line the
+// shorter one on a tie, as captions are read more easily that way.
+func (p CaptionPreset) balance(tokens []captionToken) [][]captionToken {
+	best, bestWidth := 0, 0
+	for i := 1; i < len(tokens); i++ {
+		top, bottom := p.width(tokens[:i]), p.width(tokens[i:])
+		if top > p.LineLength || bottom > p.LineLength {
+			continue
+		}
+		if w := max(top, bottom); best == 0 || w < bestWidth {
+			best, bestWidth = i, w
+		}
+	}
+	if best == 0 {
+		return p.wrap(tokens)
+	}
+	return [][]captionToken{tokens[:best], tokens[best:]}
+}
+
+// group splits tokens into the tokens of cues that fit in Lines lines, as
+// few as fit and evened out, so a record does not end in a cue of a word or
+// two. A cue ends at the end of a sentence once it is half full.
+func (p CaptionPreset) group(tokens []captionToken) [][]captionToken {
+	groups := p.fill(tokens, p.Lines*p.LineLength)
+	if len(groups) < 2 {
+		return groups
+	}
+	even := p.fill(tokens, (p.width(tokens)+len(groups)-1)/len(groups))
+	if len(even) == len(groups) {
+		return even
+	}
+	return groups
+}
+
+// fill splits tokens into cues greedily, ending each once it is target
+// cells wide unless the rest fits in it.
+func (p CaptionPreset) fill(tokens []captionToken, target int) [][]captionToken {
+	var groups [][]captionToken
+	start := 0
+	for i := range tokens {
+		if i > start && len(p.wrap(tokens[start:i+1])) > p.Lines {
+			groups = append(groups, tokens[start:i])
+			start = i
+		}
+		w := p.width(tokens[start : i+1])
+		last, _ := utf8.DecodeLastRuneInString(tokens[i].text)
+		sentence := strings.ContainsRune(".?!。？！", last)
+		full := w >= target && len(p.wrap(tokens[start:])) > p.Lines
+		if i+1 < len(tokens) && (full || sentence && 2*w >= target) {
+			groups = append(groups, tokens[start:i+1])
+			start = i + 1
+		}
+	}
+	if start < len(tokens) {
+		groups = append(groups, tokens[start:])
+	}
+	return groups
+}
@@ -0,0 +1,80 @@
+package output
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"stt-receivetranscription-mve/stt"
+)
+
+// casingFormatter recases the text of records before rendering them.
+type casingFormatter struct {
+	Formatter
+	recase func(string) string
+	// words also recases each word, for styles that do not depend on the
+	// word's position in a sentence.
+	words bool
+}
+
+// withCasing wraps f to apply the casing style named by the "case" option:
+// "sentence" capitalizes the first letter of each sentence, "upper" suits
+// broadcast captions and "lower" suits analytics. An empty style leaves the
+// text as recognized.
+func withCasing(f Formatter, style string) (Formatter, error) {
+	switch style {
+	case "":
+		return f, nil
+	case "sentence":
+		return &casingFormatter{Formatter: f, recase: sentenceCase}, nil
+	case "upper":
+		return &casingFormatter{Formatter: f, recase: strings.ToUpper, words: true}, nil
+	case "lower":
+		return &casingFormatter{Formatter: f, recase: strings.ToLower, words: true}, nil
+	default:
+		return nil, fmt.Errorf("unknown case %q", style)
+	}
+}
+
+func (f *casingFormatter) Format(r Record) ([]byte, error) {
+	if r.Event != "" {
+		return f.Formatter.Format(r)
+	}
+	r.Text = f.recase(r.Text)
+	if f.words && len(r.Words) > 0 {
+		words := make([]stt.Word, len(r.Words))
+		for i, w := range r.Words {
+			w.Text = f.recase(w.Text)
+			words[i] = w
+		}
+		r.Words = words
+	}
+	return f.Formatter.Format(r)
+}
+
+// sentenceCase capitalizes the first letter of the text and of every
+// sentence following '.', '!' or '?'.
+func sentenceCase(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	start := true
+	for len(s) > 0 {
+		r, n := utf8.DecodeRuneInString(s)
+		s = s[n:]
+		switch {
+		case start && unicode.IsLetter(r):
+			b.WriteRune(unicode.ToUpper(r))
+			start = false
+		case r == '.' || r == '!' || r == '?':
+			b.WriteRune(r)
+			start = true
+		default:
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				start = false
+			}
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
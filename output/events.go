@@ -0,0 +1,79 @@
+package output
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"stt-receivetranscription-mve/stt"
+)
+
+// EventSink injects event records, such as detected DTMF digits, into the
+// final records passing through it in timeline order.
+type EventSink struct {
+	Sink
+
+	mu     sync.Mutex
+	events []Record
+}
+
+// WithEvents wraps s so that each of events, sorted by start offset, is
+// written before the first final record starting after it. Flush writes the
+// events past the last final record.
+func WithEvents(s Sink, events []Record) *EventSink {
+	return &EventSink{Sink: s, events: events}
+}
+
+func (s *EventSink) Write(ctx context.Context, r Record) error {
+	if r.IsFinal {
+		if err := s.flush(ctx, func(e Record) bool { return e.Start < r.Start }); err != nil {
+			return err
+		}
+	}
+	return s.Sink.Write(ctx, r)
+}
+
+// Flush writes every event not written yet.
+func (s *EventSink) Flush(ctx context.Context) error {
+	return s.flush(ctx, func(Record) bool { return true })
+}
+
+func (s *EventSink) flush(ctx context.Context, before func(Record) bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.events) > 0 && before(s.events[0]) {
+		if err := s.Sink.Write(ctx, s.events[0]); err != nil {
+			return err
+		}
+		s.events = s.events[1:]
+	}
+	return nil
+}
+
+// offsetSink maps the offsets of records onto another timeline.
+type offsetSink struct {
+	Sink
+	mapOffset func(time.Duration) time.Duration
+}
+
+// WithOffsets returns a sink that maps the segment and word offsets of every
+// record with mapOffset before passing it on to s, for results of audio
+// that had parts cut out.
+func WithOffsets(s Sink, mapOffset func(time.Duration) time.Duration) Sink {
+	return &offsetSink{Sink: s, mapOffset: mapOffset}
+}
+
+func (s *offsetSink) Write(ctx context.Context, r Record) error {
+	r.Start = s.mapOffset(r.Start)
+	r.End = s.mapOffset(r.End)
+	if len(r.Words) > 0 {
+		words := make([]stt.Word, len(r.Words))
+		for i, w := range r.Words {
+			w.Start = s.mapOffset(w.Start)
+			w.End = s.mapOffset(w.End)
+			words[i] = w
+		}
+		r.Words = words
+	}
+	return s.Sink.Write(ctx, r)
+}
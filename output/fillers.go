@@ -0,0 +1,157 @@
+package output
+
+import (
+	"cmp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"stt-receivetranscription-mve/stt"
+)
+
+// Fillers are the filler words and phrases removed from rendered text, keyed
+// by language like locales, with region-specific overrides. Only words that
+// are never meaningful are listed; "like" or "so" are not.
+var Fillers = map[string][]string{
+	"en": {"um", "umm", "uh", "uhm", "erm", "er", "hmm", "mm", "you know"},
+	"de": {"äh", "ähm", "öh", "öhm", "hm"},
+	"fr": {"euh", "heu", "bah", "hein"},
+	"es": {"eh", "em", "ehm", "o sea"},
+	"it": {"eh", "ehm", "mah", "cioè"},
+	"pt": {"ãh", "hum", "né"},
+	"nl": {"eh", "ehm", "uh", "uhm"},
+}
+
+// lookupFillers returns the fillers of a BCP 47 tag, falling back from the
+// region to the language.
+func lookupFillers(tag string) []string {
+	tag = strings.ReplaceAll(tag, "_", "-")
+	lang, region, _ := strings.Cut(tag, "-")
+	if f, ok := Fillers[strings.ToLower(lang)+"-"+strings.ToUpper(region)]; ok && region != "" {
+		return f
+	}
+	return Fillers[strings.ToLower(lang)]
+}
+
+// fillerFormatter drops filler words from the text of records before
+// rendering them.
+type fillerFormatter struct {
+	Formatter
+}
+
+// withFillers wraps f to remove fillers unless keep is set. Structured
+// formats keep the raw text, so only rendered formats are wrapped.
+func withFillers(f Formatter, format string, keep bool) Formatter {
+	if keep || format == "json" {
+		return f
+	}
+	return &fillerFormatter{Formatter: f}
+}
+
+func (f *fillerFormatter) Format(r Record) ([]byte, error) {
+	if r.Event != "" {
+		return f.Formatter.Format(r)
+	}
+	// Records recognized without a language, and not labeled with their
+	// session's, are taken to be English
+	fillers := lookupFillers(cmp.Or(r.Language, "en"))
+	if len(fillers) == 0 {
+		return f.Formatter.Format(r)
+	}
+	r.Text = removeFillers(r.Text, fillers)
+	// A result that was only fillers is not rendered at all
+	if r.Text == "" {
+		return nil, nil
+	}
+	if len(r.Words) > 0 {
+		texts := make([]string, len(r.Words))
+		for i, w := range r.Words {
+			texts[i] = w.Text
+		}
+		removed := matchFillers(texts, fillers)
+		words := make([]stt.Word, 0, len(r.Words))
+		for i, w := range r.Words {
+			if !removed[i] {
+				words = append(words, w)
+			}
+		}
+		r.Words = words
+	}
+	return f.Formatter.Format(r)
+}
+
+// removeFillers drops every filler from text. Sentence punctuation ending a
+// filler moves to the preceding word, commas setting a filler off are
+// dropped and a capitalized filler passes its capital on to the following
+// word.
+func removeFillers(text string, fillers []string) string {
+	tokens := strings.Fields(text)
+	removed := matchFillers(tokens, fillers)
+	var (
+		kept       []string
+		capitalize bool
+	)
+	for i, tok := range tokens {
+		if removed[i] {
+			if r, _ := utf8.DecodeRuneInString(tok); unicode.IsUpper(r) && (i == 0 || !removed[i-1]) {
+				capitalize = true
+			}
+			if end := tok[len(tok)-1]; (end == '.' || end == '!' || end == '?') && len(kept) > 0 &&
+				(i+1 == len(tokens) || !removed[i+1]) {
+				last := strings.TrimRight(kept[len(kept)-1], ",;:")
+				kept[len(kept)-1] = strings.TrimRight(last, ".!?") + string(end)
+				capitalize = true
+			} else if end == ',' && len(kept) > 0 && strings.HasSuffix(kept[len(kept)-1], ",") &&
+				(i+1 == len(tokens) || !removed[i+1]) {
+				// Commas set off the filler, not the surrounding words
+				kept[len(kept)-1] = strings.TrimSuffix(kept[len(kept)-1], ",")
+			}
+			continue
+		}
+		if capitalize {
+			r, n := utf8.DecodeRuneInString(tok)
+			tok = string(unicode.ToUpper(r)) + tok[n:]
+			capitalize = false
+		}
+		kept = append(kept, tok)
+	}
+	return strings.Join(kept, " ")
+}
+
+// matchFillers reports which tokens belong to a filler phrase, matching
+// case-insensitively and ignoring surrounding punctuation. Longer phrases
+// win over their prefixes.
+func matchFillers(tokens []string, fillers []string) []bool {
+	norm := make([]string, len(tokens))
+	for i, tok := range tokens {
+		norm[i] = strings.ToLower(strings.TrimFunc(tok, unicode.IsPunct))
+	}
+	removed := make([]bool, len(tokens))
+	for i := 0; i < len(norm); {
+		n := 0
+		for _, filler := range fillers {
+			phrase := strings.Fields(strings.ToLower(filler))
+			if len(phrase) > n && i+len(phrase) <= len(norm) && matchPhrase(norm[i:], phrase) {
+				n = len(phrase)
+			}
+		}
+		if n == 0 {
+			i++
+			continue
+		}
+		for j := i; j < i+n; j++ {
+			removed[j] = true
+		}
+		i += n
+	}
+	return removed
+}
+
+func matchPhrase(tokens, phrase []string) bool {
+	for i, word := range phrase {
+		if tokens[i] != word {
+			return false
+		}
+	}
+	return true
+}
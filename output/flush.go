@@ -0,0 +1,84 @@
+package output
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// flushSink forwards records to a sink and, when an utterance produces no
+// final result within the window, additionally emits its latest partial as an
+// unstable final so live consumers are not left waiting.
+type flushSink struct {
+	Sink
+	window time.Duration
+
+	mu      sync.Mutex
+	ctx     context.Context
+	partial *Record
+	timer   *time.Timer
+	// utterance numbers the current utterance, so a timer that fired for
+	// an earlier one does not flush it.
+	utterance uint64
+}
+
+// WithPartialFlush wraps s so that an utterance without a final result after
+// window is flushed from its best partial, marked Unstable. The real final
+// result is still delivered if it arrives later.
+func WithPartialFlush(s Sink, window time.Duration) Sink {
+	return &flushSink{Sink: s, window: window}
+}
+
+func (s *flushSink) Write(ctx context.Context, r Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.IsFinal {
+		s.reset()
+	} else {
+		partial := r
+		s.partial = &partial
+		s.ctx = ctx
+		if s.timer == nil {
+			utterance := s.utterance
+			s.timer = time.AfterFunc(s.window, func() { s.flush(utterance) })
+		}
+	}
+	return s.Sink.Write(ctx, r)
+}
+
+// flush emits the partial of utterance, unless a final result or an earlier
+// flush has ended it.
+func (s *flushSink) flush(utterance uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if utterance != s.utterance || s.partial == nil {
+		return
+	}
+	r := *s.partial
+	r.IsFinal = true
+	r.Unstable = true
+	ctx := s.ctx
+	s.reset()
+	if err := s.Sink.Write(ctx, r); err != nil {
+		log.Printf("Failed to flush partial result: %v", err)
+	}
+}
+
+// reset forgets the current utterance. s.mu must be held.
+func (s *flushSink) reset() {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	s.partial = nil
+	s.utterance++
+}
+
+func (s *flushSink) Close() error {
+	s.mu.Lock()
+	s.reset()
+	s.mu.Unlock()
+	return s.Sink.Close()
+}
@@ -0,0 +1,258 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Formatter renders records into the bytes written to a destination.
+// Formatters may be stateful and skip records by returning nil.
+type Formatter interface {
+	Format(r Record) ([]byte, error)
+	ContentType() string
+}
+
+// NewFormatter returns the formatter registered under name.
+func NewFormatter(name string, opts Options) (Formatter, error) {
+	switch name {
+	case "text":
+		partials, err := opts.Bool("partials", false)
+		if err != nil {
+			return nil, err
+		}
+		confidence, err := opts.Bool("confidence", false)
+		if err != nil {
+			return nil, err
+		}
+		language, err := opts.Bool("language", false)
+		if err != nil {
+			return nil, err
+		}
+		wallTime, err := opts.Bool("time", false)
+		if err != nil {
+			return nil, err
+		}
+		offsets, err := opts.Bool("offsets", false)
+		if err != nil {
+			return nil, err
+		}
+		speakers, err := opts.Bool("speakers", true)
+		if err != nil {
+			return nil, err
+		}
+		locale, err := optLocale(opts)
+		if err != nil {
+			return nil, err
+		}
+		return &textFormatter{
+			partials:   partials,
+			confidence: confidence,
+			language:   language,
+			time:       wallTime,
+			offsets:    offsets,
+			speakers:   speakers,
+			locale:     locale,
+		}, nil
+	case "json":
+		partials, err := opts.Bool("partials", true)
+		if err != nil {
+			return nil, err
+		}
+		pretty, err := opts.Bool("pretty", false)
+		if err != nil {
+			return nil, err
+		}
+		return &jsonFormatter{partials: partials, pretty: pretty}, nil
+	case "srt", "vtt":
+		preset, err := optCaptionPreset(opts)
+		if err != nil {
+			return nil, err
+		}
+		if name == "srt" {
+			return &srtFormatter{captions: captioner{preset: preset}}, nil
+		}
+		return &vttFormatter{captions: captioner{preset: preset}}, nil
+	case "html":
+		locale, err := optLocale(opts)
+		if err != nil {
+			return nil, err
+		}
+		var speakers []string
+		if opts["speakers"] != "" {
+			speakers = strings.Split(opts["speakers"], "|")
+		}
+		highlight, err := opts.Float("highlight", 0.8)
+		if err != nil {
+			return nil, err
+		}
+		return &htmlFormatter{title: opts["title"], speakers: speakers, locale: locale, highlight: float32(highlight)}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format %q", name)
+	}
+}
+
+// optLocale returns the locale named by the "locale" option, or
+// DefaultLocale.
+func optLocale(opts Options) (Locale, error) {
+	tag := opts["locale"]
+	if tag == "" {
+		tag = DefaultLocale
+	}
+	return LookupLocale(tag)
+}
+
+// displayText returns the text shown for a record, rendering events such as
+// DTMF digits as bracketed labels.
+func displayText(r Record) string {
+	switch {
+	case r.Event == "":
+		return r.Text
+	case r.Text == "":
+		return "[" + r.Event + "]"
+	default:
+		return "[" + r.Event + " " + r.Text + "]"
+	}
+}
+
+// speakerText returns the display text of a record prefixed with its
+// speaker, if any.
+func speakerText(r Record) string {
+	if r.Speaker == "" {
+		return displayText(r)
+	}
+	return r.Speaker + ": " + displayText(r)
+}
+
+type textFormatter struct {
+	partials   bool
+	confidence bool
+	language   bool
+	time       bool
+	offsets    bool
+	speakers   bool
+	locale     Locale
+}
+
+func (f *textFormatter) Format(r Record) ([]byte, error) {
+	if !r.IsFinal && !f.partials {
+		return nil, nil
+	}
+	line := displayText(r)
+	if f.speakers {
+		line = speakerText(r)
+	}
+	// Overlapping speech is marked rather than read as consecutive turns
+	if r.Overlaps {
+		line = "[overlap] " + line
+	}
+	if f.language && r.Language != "" {
+		line = "[" + r.Language + "] " + line
+	}
+	if !r.IsFinal {
+		line = "(partial) " + line
+	} else if r.Unstable {
+		line = "(unstable) " + line
+	}
+	if f.offsets {
+		line = fmt.Sprintf("[%s - %s] %s", f.locale.Offset(r.Start), f.locale.Offset(r.End), line)
+	}
+	if f.time && !r.Time.IsZero() {
+		line = f.locale.DateTime(r.Time) + " " + line
+	}
+	if f.confidence {
+		line = fmt.Sprintf("%s (confidence: %s)", line, f.locale.Number(float64(r.Confidence), 2))
+	}
+	return []byte(line + "\n"), nil
+}
+
+func (f *textFormatter) ContentType() string { return "text/plain; charset=utf-8" }
+
+type jsonFormatter struct {
+	partials bool
+	pretty   bool
+}
+
+func (f *jsonFormatter) Format(r Record) ([]byte, error) {
+	if !r.IsFinal && !f.partials {
+		return nil, nil
+	}
+	var (
+		b   []byte
+		err error
+	)
+	if f.pretty {
+		b, err = json.MarshalIndent(r, "", "  ")
+	} else {
+		b, err = json.Marshal(r)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal record: %w", err)
+	}
+	return append(b, '\n'), nil
+}
+
+func (f *jsonFormatter) ContentType() string { return "application/json" }
+
+// srtFormatter emits one SubRip cue per final result, or the cues of its
+// caption preset.
+type srtFormatter struct {
+	index    int
+	captions captioner
+}
+
+func (f *srtFormatter) Format(r Record) ([]byte, error) {
+	if !r.IsFinal {
+		return nil, nil
+	}
+	var b []byte
+	for _, c := range f.captions.cues(r, true) {
+		f.index++
+		b = fmt.Appendf(b, "%d\n%s --> %s\n%s\n\n",
+			f.index, srtTimestamp(c.start), srtTimestamp(c.end), strings.Join(c.lines, "\n"))
+	}
+	return b, nil
+}
+
+func (f *srtFormatter) ContentType() string { return "application/x-subrip" }
+
+func srtTimestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// vttFormatter emits WebVTT cues the same way srtFormatter does, preceded by
+// the WEBVTT header. Speakers are rendered as voice spans, so players can
+// show overlapping cues of different speakers apart.
+type vttFormatter struct {
+	started  bool
+	captions captioner
+}
+
+func (f *vttFormatter) Format(r Record) ([]byte, error) {
+	if !r.IsFinal {
+		return nil, nil
+	}
+	var b []byte
+	if !f.started {
+		f.started = true
+		b = append(b, "WEBVTT\n\n"...)
+	}
+	for _, c := range f.captions.cues(r, false) {
+		text := strings.Join(c.lines, "\n")
+		if r.Speaker != "" {
+			text = "<v " + r.Speaker + ">" + text
+		}
+		b = fmt.Appendf(b, "%s --> %s\n%s\n\n",
+			vttTimestamp(c.start), vttTimestamp(c.end), text)
+	}
+	return b, nil
+}
+
+func (f *vttFormatter) ContentType() string { return "text/vtt; charset=utf-8" }
+
+func vttTimestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
@@ -0,0 +1,111 @@
+package output
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"stt-receivetranscription-mve/stt"
+)
+
+var update = flag.Bool("update", false, "Write the current renderings to the golden files instead of comparing them")
+
+// goldenCases cover every format and the options that change its layout.
+// Each fixture in testdata is rendered with every case, and compared with
+// the golden file named after the fixture with the case's extension, such
+// as testdata/dialogue.srt.golden.
+var goldenCases = []struct {
+	name   string
+	format string
+}{
+	{"txt", "text"},
+	{"offsets.txt", "text,offsets=true,time=true,language=true,confidence=true"},
+	{"clean.txt", "text,fillers=false"},
+	{"json", "json"},
+	{"pretty.json", "json,pretty=true"},
+	{"srt", "srt"},
+	{"upper.srt", "srt,case=upper"},
+	{"en.srt", "srt,preset=en"},
+	{"vtt", "vtt"},
+	{"auto.vtt", "vtt,preset=auto"},
+	{"html", "html,title=Golden"},
+	{"columns.html", "html,speakers=agent|customer"},
+	{"de.html", "html,locale=de-DE,highlight=0"},
+}
+
+// goldenTime is the wall-clock time of every golden record, so renderings do
+// not depend on when they ran.
+var goldenTime = time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+
+// TestGolden pins the rendering of every format, so a change to one shows up
+// as a diff to review rather than being discovered by downstream consumers.
+// A deliberate change is recorded with go test ./output -update.
+func TestGolden(t *testing.T) {
+	fixtures, err := filepath.Glob(filepath.Join("testdata", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixtures) == 0 {
+		t.Fatal("no fixtures in testdata")
+	}
+	for _, fixture := range fixtures {
+		data, err := os.ReadFile(fixture)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var transcript stt.Transcript
+		if err := json.Unmarshal(data, &transcript); err != nil {
+			t.Fatalf("failed to parse fixture %s: %v", fixture, err)
+		}
+		records := FromTranscript(transcript)
+		for i := range records {
+			records[i].Time = goldenTime
+		}
+
+		base := strings.TrimSuffix(fixture, ".json")
+		for _, c := range goldenCases {
+			t.Run(filepath.Base(base)+"."+c.name, func(t *testing.T) {
+				got := render(t, c.format, records)
+				path := base + "." + c.name + ".golden"
+				if *update {
+					if err := os.WriteFile(path, got, 0o644); err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+				want, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatalf("%v; create it with -update", err)
+				}
+				if diff := cmp.Diff(string(want), string(got)); diff != "" {
+					t.Errorf("%s as %q differs from %s (-want +got):\n%s", fixture, c.format, path, diff)
+				}
+			})
+		}
+	}
+}
+
+// render returns the bytes a file output with format would hold after
+// records were written to it.
+func render(t *testing.T, format string, records []Record) []byte {
+	t.Helper()
+	formatter, _, err := parseFormat(format, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []byte
+	for _, r := range records {
+		b, err := formatter.Format(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, b...)
+	}
+	return out
+}
@@ -0,0 +1,115 @@
+package output
+
+import (
+	"fmt"
+	"html"
+	"slices"
+	"strings"
+)
+
+// htmlFormatter renders final records as rows of an HTML table. With the
+// speakers option each speaker gets a column, so overlapping speech appears
+// side by side; without it rows carry a speaker column instead. Overlapping
+// rows are highlighted either way. Words recognized with a confidence below
+// highlight are highlighted and faded by their confidence, so reviewers see
+// which passages likely need correction. The document is left open for
+// streaming, which HTML parsers accept.
+type htmlFormatter struct {
+	title     string
+	speakers  []string
+	locale    Locale
+	highlight float32
+	started   bool
+}
+
+const htmlStyle = `table{border-collapse:collapse;font-family:sans-serif}
+td,th{border:1px solid #ccc;padding:4px 8px;vertical-align:top;text-align:left}
+tr.overlap td{background:#fff4d6}
+tr.event td{color:#666;font-style:italic}
+span.low{background:#fde2e2}`
+
+func (f *htmlFormatter) Format(r Record) ([]byte, error) {
+	if !r.IsFinal {
+		return nil, nil
+	}
+	var b []byte
+	if !f.started {
+		f.started = true
+		b = f.header()
+	}
+
+	class := ""
+	switch {
+	case r.Event != "":
+		class = ` class="event"`
+	case r.Overlaps:
+		class = ` class="overlap"`
+	}
+	b = fmt.Appendf(b, "<tr%s><td>%s</td>", class, f.locale.Offset(r.Start))
+	text := f.text(r)
+	if len(f.speakers) == 0 {
+		return fmt.Appendf(b, "<td>%s</td><td>%s</td></tr>\n", html.EscapeString(r.Speaker), text), nil
+	}
+	col := slices.Index(f.speakers, r.Speaker)
+	if col < 0 {
+		// Events and unlisted speakers span every speaker column
+		if r.Speaker != "" {
+			text = html.EscapeString(r.Speaker) + ": " + text
+		}
+		return fmt.Appendf(b, `<td colspan="%d">%s</td></tr>`+"\n", len(f.speakers), text), nil
+	}
+	for i := range f.speakers {
+		if i == col {
+			b = fmt.Appendf(b, "<td>%s</td>", text)
+		} else {
+			b = append(b, "<td></td>"...)
+		}
+	}
+	return append(b, "</tr>\n"...), nil
+}
+
+// text renders the escaped display text of r, marking low-confidence words.
+// Word confidences apply when the text still has one token per word;
+// otherwise the segment's confidence applies to the whole text.
+func (f *htmlFormatter) text(r Record) string {
+	if r.Event != "" || f.highlight <= 0 {
+		return html.EscapeString(displayText(r))
+	}
+	tokens := strings.Fields(r.Text)
+	if len(tokens) != len(r.Words) {
+		return f.mark(r.Text, r.Confidence)
+	}
+	for i, w := range r.Words {
+		tokens[i] = f.mark(tokens[i], w.Confidence)
+	}
+	return strings.Join(tokens, " ")
+}
+
+// mark escapes text, wrapping it in a faded highlight if confidence is known
+// and below the threshold.
+func (f *htmlFormatter) mark(text string, confidence float32) string {
+	text = html.EscapeString(text)
+	if confidence <= 0 || confidence >= f.highlight {
+		return text
+	}
+	return fmt.Sprintf(`<span class="low" style="opacity:%.2f" title="confidence %.2f">%s</span>`,
+		0.4+0.6*confidence/f.highlight, confidence, text)
+}
+
+func (f *htmlFormatter) header() []byte {
+	title := f.title
+	if title == "" {
+		title = "Transcript"
+	}
+	b := fmt.Appendf(nil, "<!DOCTYPE html>\n<meta charset=\"utf-8\">\n<title>%s</title>\n<style>\n%s\n</style>\n<table>\n<tr><th>Time</th>",
+		html.EscapeString(title), htmlStyle)
+	if len(f.speakers) == 0 {
+		return append(b, "<th>Speaker</th><th>Text</th></tr>\n"...)
+	}
+	for _, s := range f.speakers {
+		b = fmt.Appendf(b, "<th>%s</th>", html.EscapeString(s))
+	}
+	return append(b, "</tr>\n"...)
+}
+
+func (f *htmlFormatter) ContentType() string { return "text/html; charset=utf-8" }
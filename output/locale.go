@@ -0,0 +1,80 @@
+package output
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultLocale is used by outputs that do not set the locale option.
+var DefaultLocale = "en-US"
+
+// Locale controls how human-facing outputs render dates, times and numbers.
+type Locale struct {
+	Date    string // time.Format layout of a date
+	Time    string // time.Format layout of a time of day
+	Decimal string // decimal separator
+}
+
+// locales are keyed by language, with region-specific overrides.
+var locales = map[string]Locale{
+	"en":    {Date: "01/02/2006", Time: "3:04:05 PM", Decimal: "."},
+	"en-GB": {Date: "02/01/2006", Time: "15:04:05", Decimal: "."},
+	"en-AU": {Date: "2/01/2006", Time: "3:04:05 pm", Decimal: "."},
+	"en-IN": {Date: "02/01/2006", Time: "3:04:05 pm", Decimal: "."},
+	"de":    {Date: "02.01.2006", Time: "15:04:05", Decimal: ","},
+	"fr":    {Date: "02/01/2006", Time: "15:04:05", Decimal: ","},
+	"fr-CA": {Date: "2006-01-02", Time: "15 h 04 min 05 s", Decimal: ","},
+	"es":    {Date: "2/1/2006", Time: "15:04:05", Decimal: ","},
+	"es-US": {Date: "1/2/2006", Time: "3:04:05 PM", Decimal: "."},
+	"es-MX": {Date: "02/01/2006", Time: "15:04:05", Decimal: "."},
+	"it":    {Date: "02/01/2006", Time: "15:04:05", Decimal: ","},
+	"pt":    {Date: "02/01/2006", Time: "15:04:05", Decimal: ","},
+	"nl":    {Date: "2-1-2006", Time: "15:04:05", Decimal: ","},
+	"pl":    {Date: "2.01.2006", Time: "15:04:05", Decimal: ","},
+	"ru":    {Date: "02.01.2006", Time: "15:04:05", Decimal: ","},
+	"sv":    {Date: "2006-01-02", Time: "15:04:05", Decimal: ","},
+	"tr":    {Date: "02.01.2006", Time: "15:04:05", Decimal: ","},
+	"ja":    {Date: "2006/01/02", Time: "15:04:05", Decimal: "."},
+	"zh":    {Date: "2006/1/2", Time: "15:04:05", Decimal: "."},
+	"ko":    {Date: "2006. 1. 2.", Time: "PM 3:04:05", Decimal: "."},
+	"hi":    {Date: "2/1/2006", Time: "3:04:05 pm", Decimal: "."},
+	"ar":    {Date: "02/01/2006", Time: "3:04:05 PM", Decimal: "."},
+}
+
+// LookupLocale returns the locale of a BCP 47 tag such as "de-AT", falling
+// back from the region to the language.
+func LookupLocale(tag string) (Locale, error) {
+	tag = strings.ReplaceAll(tag, "_", "-")
+	lang, region, _ := strings.Cut(tag, "-")
+	lang = strings.ToLower(lang)
+	if l, ok := locales[lang+"-"+strings.ToUpper(region)]; ok && region != "" {
+		return l, nil
+	}
+	if l, ok := locales[lang]; ok {
+		return l, nil
+	}
+	return Locale{}, fmt.Errorf("unsupported locale %q", tag)
+}
+
+// DateTime formats t as a date and time of day.
+func (l Locale) DateTime(t time.Time) string {
+	return t.Format(l.Date + " " + l.Time)
+}
+
+// Number formats f with the given number of decimals.
+func (l Locale) Number(f float64, decimals int) string {
+	return strings.Replace(strconv.FormatFloat(f, 'f', decimals, 64), ".", l.Decimal, 1)
+}
+
+// Offset formats an offset into the audio as h:mm:ss.d, or m:ss.d below an
+// hour, with the locale's decimal separator.
+func (l Locale) Offset(d time.Duration) string {
+	tenths := d.Milliseconds() / 100
+	h, m, s := tenths/36000, tenths/600%60, tenths/10%60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d%s%d", h, m, s, l.Decimal, tenths%10)
+	}
+	return fmt.Sprintf("%d:%02d%s%d", m, s, l.Decimal, tenths%10)
+}
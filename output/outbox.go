@@ -0,0 +1,262 @@
+package output
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"stt-receivetranscription-mve/stt"
+)
+
+// outboxDrain bounds how long Close waits for the records still queued to
+// be delivered; the rest stay on disk for the next run.
+const outboxDrain = 30 * time.Second
+
+// Names of the outbox files: the journal of records, one JSON line each,
+// and the count of records at its start already delivered.
+const (
+	outboxJournal = "outbox.jsonl"
+	outboxAcked   = "acked"
+)
+
+// outboxSink persists every record on disk before it is delivered to the
+// sink it wraps, delivering them in order and retrying until each succeeds.
+type outboxSink struct {
+	sink    Sink
+	dir     string
+	journal *os.File
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// queue holds the records not yet delivered, oldest first, and acked
+	// counts the records at the start of the journal delivered before them.
+	queue  []Record
+	acked  int
+	closed bool
+	// drained is closed once delivery has stopped.
+	drained chan struct{}
+	stop    context.CancelFunc
+}
+
+// WithOutbox wraps s so that every record is first appended to an outbox in
+// dir and then delivered to s in the background, in order, retrying with
+// backoff while s fails. Records not delivered when the process stops or
+// crashes are delivered on the next run with the same outbox. Each record is
+// given an ID, kept across retries, which the sinks pass downstream so that
+// a record delivered again after a crash can be deduplicated.
+func WithOutbox(s Sink, dir string) (Sink, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create outbox directory: %w", err)
+	}
+	pending, err := readOutbox(dir)
+	if err != nil {
+		return nil, err
+	}
+	// The journal is rewritten with only the pending records, dropping a
+	// line torn by a crash
+	tmp := filepath.Join(dir, outboxJournal+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create outbox journal: %w", err)
+	}
+	for _, r := range pending {
+		if err := appendRecord(f, r); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write outbox journal: %w", err)
+	}
+	// The count is reset first: a crash in between then delivers records
+	// again, which their IDs deduplicate, rather than skipping some
+	if err := writeAcked(dir, 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, outboxJournal)); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to replace outbox journal: %w", err)
+	}
+	if len(pending) > 0 {
+		log.Printf("Delivering %d records left in outbox %s", len(pending), dir)
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	o := &outboxSink{sink: s, dir: dir, journal: f, queue: pending, drained: make(chan struct{}), stop: stop}
+	o.cond = sync.NewCond(&o.mu)
+	go o.deliver(ctx)
+	return o, nil
+}
+
+// readOutbox returns the records of the journal in dir not yet delivered.
+func readOutbox(dir string) ([]Record, error) {
+	acked := 0
+	if b, err := os.ReadFile(filepath.Join(dir, outboxAcked)); err == nil {
+		if acked, err = strconv.Atoi(strings.TrimSpace(string(b))); err != nil {
+			return nil, fmt.Errorf("invalid outbox acknowledgement: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read outbox acknowledgement: %w", err)
+	}
+	f, err := os.Open(filepath.Join(dir, outboxJournal))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open outbox journal: %w", err)
+	}
+	defer f.Close()
+	var pending []Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16<<20)
+	for n := 0; scanner.Scan(); n++ {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			// Only the last line can be torn
+			break
+		}
+		if n >= acked {
+			pending = append(pending, r)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read outbox journal: %w", err)
+	}
+	return pending, nil
+}
+
+func appendRecord(f *os.File, r Record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to marshal outbox record: %w", err)
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("failed to write outbox journal: %w", err)
+	}
+	return nil
+}
+
+// writeAcked records that the first n records of the journal in dir were
+// delivered, replacing the count atomically.
+func writeAcked(dir string, n int) error {
+	tmp := filepath.Join(dir, outboxAcked+".tmp")
+	if err := os.WriteFile(tmp, []byte(strconv.Itoa(n)+"\n"), 0o600); err != nil {
+		return fmt.Errorf("failed to write outbox acknowledgement: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, outboxAcked)); err != nil {
+		return fmt.Errorf("failed to write outbox acknowledgement: %w", err)
+	}
+	return nil
+}
+
+// Write persists r for delivery, returning once it is on disk.
+func (o *outboxSink) Write(ctx context.Context, r Record) error {
+	if r.ID == "" {
+		r.ID = stt.NewSessionID()
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return fmt.Errorf("outbox %s is closed", o.dir)
+	}
+	if err := appendRecord(o.journal, r); err != nil {
+		return err
+	}
+	if err := o.journal.Sync(); err != nil {
+		return fmt.Errorf("failed to write outbox journal: %w", err)
+	}
+	o.queue = append(o.queue, r)
+	o.cond.Signal()
+	return nil
+}
+
+// deliver writes the queued records to the sink in order until the outbox
+// is closed and empty, or ctx is done.
+func (o *outboxSink) deliver(ctx context.Context) {
+	defer close(o.drained)
+	for {
+		o.mu.Lock()
+		for len(o.queue) == 0 && !o.closed {
+			o.cond.Wait()
+		}
+		if len(o.queue) == 0 {
+			o.mu.Unlock()
+			return
+		}
+		r := o.queue[0]
+		o.mu.Unlock()
+
+		for backoff := time.Second; ; backoff = min(2*backoff, 30*time.Second) {
+			err := o.sink.Write(ctx, r)
+			if err == nil {
+				break
+			}
+			log.Printf("Failed to deliver record from outbox %s, retrying in %s: %v", o.dir, backoff, err)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		o.mu.Lock()
+		o.queue = o.queue[1:]
+		o.acked++
+		err := o.ack()
+		o.mu.Unlock()
+		if err != nil {
+			log.Printf("Failed to acknowledge record in outbox %s: %v", o.dir, err)
+		}
+	}
+}
+
+// ack persists the count of delivered records, emptying the journal once
+// all of them are. o.mu must be held.
+func (o *outboxSink) ack() error {
+	if len(o.queue) > 0 {
+		return writeAcked(o.dir, o.acked)
+	}
+	// As on startup, the count is reset before the journal is emptied
+	if err := writeAcked(o.dir, 0); err != nil {
+		return err
+	}
+	o.acked = 0
+	if err := o.journal.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate outbox journal: %w", err)
+	}
+	if _, err := o.journal.Seek(0, 0); err != nil {
+		return fmt.Errorf("failed to truncate outbox journal: %w", err)
+	}
+	return nil
+}
+
+// Close waits up to outboxDrain for the queued records to be delivered,
+// then closes the sink. Records still queued are delivered on the next run.
+func (o *outboxSink) Close() error {
+	o.mu.Lock()
+	o.closed = true
+	o.cond.Signal()
+	o.mu.Unlock()
+	select {
+	case <-o.drained:
+	case <-time.After(outboxDrain):
+		o.stop()
+		<-o.drained
+		o.mu.Lock()
+		log.Printf("Left %d undelivered records in outbox %s", len(o.queue), o.dir)
+		o.mu.Unlock()
+	}
+	o.stop()
+	o.journal.Close()
+	return o.sink.Close()
+}
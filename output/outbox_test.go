@@ -0,0 +1,148 @@
+package output
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// recordingSink collects the IDs of the records written to it.
+type recordingSink struct {
+	mu  sync.Mutex
+	ids []string
+}
+
+func (s *recordingSink) Write(ctx context.Context, r Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ids = append(s.ids, r.ID)
+	return nil
+}
+
+func (s *recordingSink) Close() error { return nil }
+
+// writeOutbox leaves an outbox in dir as a crash would: a journal of
+// records with the given IDs, then tail, and acked if it is not empty.
+func writeOutbox(t *testing.T, dir string, ids []string, tail, acked string) {
+	t.Helper()
+	var b strings.Builder
+	for _, id := range ids {
+		line, err := json.Marshal(Record{ID: id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.Write(line)
+		b.WriteByte('\n')
+	}
+	b.WriteString(tail)
+	if err := os.WriteFile(filepath.Join(dir, outboxJournal), []byte(b.String()), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if acked != "" {
+		if err := os.WriteFile(filepath.Join(dir, outboxAcked), []byte(acked), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestOutboxReplay checks which records an outbox left by a crash delivers
+// on the next run.
+func TestOutboxReplay(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		ids   []string
+		tail  string
+		acked string
+		want  []string
+	}{
+		{name: "empty"},
+		{name: "none delivered", ids: []string{"a", "b", "c"}, want: []string{"a", "b", "c"}},
+		{name: "some delivered", ids: []string{"a", "b", "c"}, acked: "1\n", want: []string{"b", "c"}},
+		{name: "all delivered", ids: []string{"a", "b"}, acked: "2\n"},
+		{name: "torn last line", ids: []string{"a", "b"}, tail: `{"id":"c","te`, acked: "1\n", want: []string{"b"}},
+		// Crashed while compacting on startup, after the count was reset
+		// but before the journal was replaced: records are redelivered
+		{name: "compaction", ids: []string{"a", "b", "c"}, acked: "0\n", want: []string{"a", "b", "c"}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeOutbox(t, dir, c.ids, c.tail, c.acked)
+			pending, err := readOutbox(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, r := range pending {
+				ids = append(ids, r.ID)
+			}
+			if diff := cmp.Diff(c.want, ids); diff != "" {
+				t.Errorf("readOutbox pending IDs (-want +got):\n%s", diff)
+			}
+
+			sink := &recordingSink{}
+			o, err := WithOutbox(sink, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := o.Write(context.Background(), Record{ID: "new"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := o.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(append(c.want, "new"), sink.ids); diff != "" {
+				t.Errorf("delivered IDs (-want +got):\n%s", diff)
+			}
+			// Everything was delivered, so a restart delivers nothing
+			if pending, err := readOutbox(dir); err != nil || len(pending) > 0 {
+				t.Errorf("outbox after delivery holds %d records, %v", len(pending), err)
+			}
+		})
+	}
+}
+
+// TestOutboxCompaction checks that the journal rewritten on startup holds
+// only the pending records, and that the count no longer skips any of them.
+func TestOutboxCompaction(t *testing.T) {
+	dir := t.TempDir()
+	writeOutbox(t, dir, []string{"a", "b", "c"}, "", "2\n")
+	// A sink that never returns leaves the record pending, as a crash would
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	o, err := WithOutbox(blockingSink{ctx}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, err := readOutbox(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].ID != "c" {
+		t.Errorf("compacted outbox holds %v, want only c", pending)
+	}
+	// Stop delivery without waiting out Close's drain
+	cancel()
+	ob := o.(*outboxSink)
+	ob.stop()
+	<-ob.drained
+	ob.journal.Close()
+}
+
+// blockingSink fails every write once ctx is done, blocking until then.
+type blockingSink struct{ ctx context.Context }
+
+func (s blockingSink) Write(ctx context.Context, r Record) error {
+	select {
+	case <-ctx.Done():
+	case <-s.ctx.Done():
+	}
+	return context.Canceled
+}
+
+func (blockingSink) Close() error { return nil }
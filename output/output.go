@@ -0,0 +1,305 @@
+package output
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"stt-receivetranscription-mve/stt"
+)
+
+// Record is a single transcription result as delivered to outputs: a
+// canonical stt.Result plus delivery and session metadata.
+type Record struct {
+	stt.Result
+	Time time.Time `json:"time"`
+	// Unstable marks a final record flushed from a partial result because
+	// the utterance's real final result did not arrive in time.
+	Unstable bool `json:"unstable,omitempty"`
+
+	SessionID string            `json:"session_id,omitempty"`
+	Tags      map[string]string `json:"tags,omitempty"`
+	Audio     string            `json:"audio,omitempty"`
+
+	// ID identifies the record for deduplication downstream. It is set by
+	// an outbox, and kept when the record is delivered again.
+	ID string `json:"id,omitempty"`
+}
+
+// FromResult wraps a streaming result received now.
+func FromResult(r stt.Result) Record {
+	return Record{Result: r, Time: time.Now()}
+}
+
+// FromTranscript converts every segment of a transcript into a final record.
+func FromTranscript(t stt.Transcript) []Record {
+	now := time.Now()
+	records := make([]Record, 0, len(t.Segments))
+	for _, seg := range t.Segments {
+		records = append(records, Record{Result: stt.Result{Segment: seg, IsFinal: true}, Time: now})
+	}
+	return records
+}
+
+// Sink receives every record of a session. A session writes its records one
+// at a time, in the order they were emitted, but sessions running side by
+// side, such as the languages or channels of one input, share their sinks:
+// Write must be safe for concurrent use.
+type Sink interface {
+	Write(ctx context.Context, r Record) error
+	Close() error
+}
+
+// Options holds the per-output formatting options given in an output spec.
+type Options map[string]string
+
+// Bool returns the boolean value of key, or def if the option is not set.
+func (o Options) Bool(key string, def bool) (bool, error) {
+	v, ok := o[key]
+	if !ok {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid value %q for option %s: %w", v, key, err)
+	}
+	return b, nil
+}
+
+// Float returns the numeric value of key, or def if the option is not set.
+func (o Options) Float(key string, def float64) (float64, error) {
+	v, ok := o[key]
+	if !ok {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q for option %s: %w", v, key, err)
+	}
+	return f, nil
+}
+
+// ParseTag splits a key=value session tag.
+func ParseTag(tag string) (string, string, error) {
+	k, v, ok := strings.Cut(tag, "=")
+	if !ok || k == "" {
+		return "", "", fmt.Errorf("invalid tag %q: expected key=value", tag)
+	}
+	return k, v, nil
+}
+
+// Session identifies the session records belong to.
+type Session struct {
+	ID   string
+	Tags map[string]string
+	// Audio references the transcribed audio, such as a file path or URI.
+	Audio string
+	// Language is the language the session is recognized in, labeling the
+	// records recognized without one.
+	Language string
+}
+
+// sessionSink stamps the session onto every record.
+type sessionSink struct {
+	Sink
+	session Session
+}
+
+// WithSession returns a sink that attaches the session ID, tags and audio
+// reference to every record before passing it on to s.
+func WithSession(s Sink, session Session) Sink {
+	return &sessionSink{Sink: s, session: session}
+}
+
+func (s *sessionSink) Write(ctx context.Context, r Record) error {
+	r.SessionID = s.session.ID
+	r.Tags = s.session.Tags
+	r.Audio = s.session.Audio
+	if r.Language == "" {
+		r.Language = s.session.Language
+	}
+	return s.Sink.Write(ctx, r)
+}
+
+// Multi fans every record out to all of its sinks. A record is offered to all
+// of them or, once ctx is done, to none, so sinks never disagree on it.
+type Multi []Sink
+
+func (m Multi) Write(ctx context.Context, r Record) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var errs []error
+	for _, s := range m {
+		if err := s.Write(ctx, r); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m Multi) Close() error {
+	var errs []error
+	for _, s := range m {
+		if err := s.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Open parses an output spec and opens the sink it describes.
+//
+// A spec has the form format[,key=value...]:destination, for example
+// "text:stdout", "srt:out.srt", "json,pretty=true:https://example.com/hook",
+// "json:kafka://localhost:9092/transcripts", "json:pubsub://project/topic"
+// or "text:gs://bucket/call.txt". Any output takes the option
+// lang=code[|code...] to receive only records in those languages,
+// case=sentence|upper|lower to recase the text, fillers=false to drop filler
+// words such as "um" from rendered (non-JSON) text and outbox=dir to deliver
+// through an on-disk outbox.
+func Open(spec string) (Sink, error) {
+	return open(spec, nil)
+}
+
+func open(spec string, defaults Options) (Sink, error) {
+	head, dest, ok := strings.Cut(spec, ":")
+	if !ok || dest == "" {
+		return nil, fmt.Errorf("invalid output spec %q: expected format:destination", spec)
+	}
+	formatter, opts, err := parseFormat(head, defaults)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output spec %q: %w", spec, err)
+	}
+
+	s, err := openDest(dest, formatter)
+	if err != nil {
+		return nil, err
+	}
+	if dir, ok := opts["outbox"]; ok {
+		if s, err = WithOutbox(s, dir); err != nil {
+			return nil, err
+		}
+	}
+	if langs, ok := opts["lang"]; ok {
+		s = WithLanguages(s, strings.Split(langs, "|"))
+	}
+	return s, nil
+}
+
+// parseFormat returns the formatter of the format and options before the
+// destination of an output spec, such as "srt,case=upper", along with the
+// options, completed with the defaults the spec does not set.
+func parseFormat(head string, defaults Options) (Formatter, Options, error) {
+	parts := strings.Split(head, ",")
+	format := parts[0]
+	opts := maps.Clone(defaults)
+	if opts == nil {
+		opts = Options{}
+	}
+	for _, p := range parts[1:] {
+		k, v, ok := strings.Cut(p, "=")
+		if !ok {
+			return nil, nil, fmt.Errorf("invalid option %q", p)
+		}
+		opts[k] = v
+	}
+
+	formatter, err := NewFormatter(format, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if formatter, err = withCasing(formatter, opts["case"]); err != nil {
+		return nil, nil, err
+	}
+	// Fillers are removed before recasing, which may capitalize a word
+	keepFillers, err := opts.Bool("fillers", true)
+	if err != nil {
+		return nil, nil, err
+	}
+	return withFillers(formatter, format, keepFillers), opts, nil
+}
+
+func openDest(dest string, formatter Formatter) (Sink, error) {
+	switch {
+	case dest == "log":
+		return &logSink{formatter: formatter}, nil
+	case dest == "stdout" || dest == "stderr":
+		return newStdSink(dest, formatter), nil
+	case strings.HasPrefix(dest, "http://") || strings.HasPrefix(dest, "https://"):
+		return newWebhookSink(dest, formatter), nil
+	case strings.HasPrefix(dest, "kafka://"):
+		return newKafkaSink(dest, formatter)
+	case strings.HasPrefix(dest, "gs://"):
+		return newGCSSink(dest, formatter)
+	case strings.HasPrefix(dest, "pubsub://"):
+		return newPubSubSink(dest, formatter)
+	default:
+		return newFileSink(dest, formatter)
+	}
+}
+
+// languageSink passes on only records in one of its languages.
+type languageSink struct {
+	Sink
+	languages []string
+}
+
+// WithLanguages returns a sink that passes on to s only the records
+// recognized in one of languages, for routing each language of a
+// code-switching conversation to its own pipeline.
+func WithLanguages(s Sink, languages []string) Sink {
+	return &languageSink{Sink: s, languages: languages}
+}
+
+func (s *languageSink) Write(ctx context.Context, r Record) error {
+	for _, lang := range s.languages {
+		if strings.EqualFold(lang, r.Language) {
+			return s.Sink.Write(ctx, r)
+		}
+	}
+	return nil
+}
+
+// speakerSink labels every record with one speaker.
+type speakerSink struct {
+	Sink
+	speaker string
+}
+
+// WithSpeaker returns a sink that labels every record and its words with
+// speaker before passing it on to s, for audio known to hold one speaker,
+// such as one channel of a call recording.
+func WithSpeaker(s Sink, speaker string) Sink {
+	return &speakerSink{Sink: s, speaker: speaker}
+}
+
+func (s *speakerSink) Write(ctx context.Context, r Record) error {
+	r.Speaker = s.speaker
+	r.Words = slices.Clone(r.Words)
+	for i := range r.Words {
+		r.Words[i].Speaker = s.speaker
+	}
+	return s.Sink.Write(ctx, r)
+}
+
+// OpenAll opens every spec and combines the sinks into a Multi. defaults
+// holds options, such as "fillers", applied to the outputs that do not set
+// them.
+func OpenAll(specs []string, defaults Options) (Multi, error) {
+	var sinks Multi
+	for _, spec := range specs {
+		s, err := open(spec, defaults)
+		if err != nil {
+			sinks.Close()
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	return sinks, nil
+}
@@ -0,0 +1,67 @@
+package output
+
+import (
+	"context"
+	"sync"
+
+	"stt-receivetranscription-mve/stt"
+)
+
+// ParagraphSink regroups the final records passing through it into
+// paragraphs with stt.Transcript.Paragraphs. A paragraph is written once the
+// next one has started; partial records pass straight through.
+type ParagraphSink struct {
+	Sink
+	opts stt.ParagraphOptions
+
+	mu sync.Mutex
+	// pending holds the finals of the paragraph not written yet.
+	pending []stt.Segment
+}
+
+// WithParagraphs wraps s to write paragraphs instead of recognition results.
+// Flush writes the last paragraph.
+func WithParagraphs(s Sink, opts stt.ParagraphOptions) *ParagraphSink {
+	return &ParagraphSink{Sink: s, opts: opts}
+}
+
+func (s *ParagraphSink) Write(ctx context.Context, r Record) error {
+	if !r.IsFinal {
+		return s.Sink.Write(ctx, r)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.Event != "" {
+		if err := s.flush(ctx, 0); err != nil {
+			return err
+		}
+		return s.Sink.Write(ctx, r)
+	}
+	s.pending = append(s.pending, r.Segment)
+	// The last paragraph may still grow
+	return s.flush(ctx, 1)
+}
+
+// Flush writes the paragraph not written yet.
+func (s *ParagraphSink) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flush(ctx, 0)
+}
+
+// flush writes all but the last keep paragraphs of the pending finals. s.mu
+// must be held.
+func (s *ParagraphSink) flush(ctx context.Context, keep int) error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+	paragraphs := stt.TranscriptFromSegments(s.pending).Paragraphs(s.opts).Segments
+	n := max(len(paragraphs)-keep, 0)
+	for _, r := range FromTranscript(stt.Transcript{Segments: paragraphs[:n]}) {
+		if err := s.Sink.Write(ctx, r); err != nil {
+			return err
+		}
+	}
+	s.pending = paragraphs[n:]
+	return nil
+}
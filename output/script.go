@@ -0,0 +1,140 @@
+package output
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/bidi"
+	"golang.org/x/text/width"
+)
+
+// rlm is the right-to-left mark, which sets the direction of a line of
+// text that starts with a number or a word of a left-to-right script.
+const rlm = "\u200f"
+
+// graphemes splits s into user-perceived characters, the extended grapheme
+// clusters of UAX #29 for the cases transcripts meet: a base character with
+// its combining marks, variation selectors and emoji modifiers, emoji joined
+// with zero-width joiners, flags of regional indicator pairs or tags, and
+// Hangul syllables of conjoining jamo. Text is never broken inside one.
+func graphemes(s string) []string {
+	var clusters []string
+	start := 0
+	prev := rune(-1)
+	// regional counts the regional indicators of the cluster, which pair
+	// up into flags
+	regional := 0
+	for i, r := range s {
+		if i > start && !extendsCluster(prev, r, regional) {
+			clusters = append(clusters, s[start:i])
+			start, regional = i, 0
+		}
+		if isRegionalIndicator(r) {
+			regional++
+		}
+		prev = r
+	}
+	if start < len(s) {
+		clusters = append(clusters, s[start:])
+	}
+	return clusters
+}
+
+// extendsCluster reports whether r continues the grapheme cluster ending in
+// prev, which holds regional regional indicators.
+func extendsCluster(prev, r rune, regional int) bool {
+	switch {
+	case prev == '\r' && r == '\n':
+		return true
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc):
+		return true
+	case r == '\u200d' || prev == '\u200d':
+		return true
+	case r >= 0x1f3fb && r <= 0x1f3ff, r >= 0xe0020 && r <= 0xe007f:
+		// Emoji modifiers and tags
+		return true
+	case isRegionalIndicator(prev) && isRegionalIndicator(r):
+		return regional%2 == 1
+	}
+	// Hangul syllables of conjoining jamo: leading consonants, vowels and
+	// trailing consonants, or precomposed syllables they extend
+	p, c := hangulKind(prev), hangulKind(r)
+	switch p {
+	case 'L':
+		return c == 'L' || c == 'V' || c == 'v' || c == 't'
+	case 'V', 'v':
+		return c == 'V' || c == 'T'
+	case 'T', 't':
+		return c == 'T'
+	}
+	return false
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1f1e6 && r <= 0x1f1ff
+}
+
+// hangulKind returns the Hangul syllable type of r: 'L', 'V' or 'T' for a
+// leading consonant, vowel or trailing consonant jamo, 'v' for a precomposed
+// syllable without a trailing consonant, 't' for one with, or 0.
+func hangulKind(r rune) byte {
+	switch {
+	case r >= 0x1100 && r <= 0x115f, r >= 0xa960 && r <= 0xa97c:
+		return 'L'
+	case r >= 0x1160 && r <= 0x11a7, r >= 0xd7b0 && r <= 0xd7c6:
+		return 'V'
+	case r >= 0x11a8 && r <= 0x11ff, r >= 0xd7cb && r <= 0xd7fb:
+		return 'T'
+	case r >= 0xac00 && r <= 0xd7a3:
+		if (r-0xac00)%28 == 0 {
+			return 'v'
+		}
+		return 't'
+	}
+	return 0
+}
+
+// cells returns the width of s in display cells, as terminals and caption
+// guidelines count it: two for East Asian wide characters and emoji, none
+// for combining marks and other zero-width characters, and one for the rest.
+func cells(s string) int {
+	n := 0
+	for _, g := range graphemes(s) {
+		n += clusterCells(g)
+	}
+	return n
+}
+
+func clusterCells(g string) int {
+	r, _ := utf8.DecodeRuneInString(g)
+	switch {
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf, unicode.Cc):
+		return 0
+	case strings.ContainsRune(g, '\ufe0f'), isRegionalIndicator(r):
+		// Emoji presentation and flags
+		return 2
+	}
+	switch width.LookupRune(r).Kind() {
+	case width.EastAsianWide, width.EastAsianFullwidth:
+		return 2
+	}
+	return 1
+}
+
+// rightToLeft reports whether text is written right to left: whether most
+// of its strongly directional characters belong to a right-to-left script
+// such as Arabic or Hebrew, rather than just the first, which may start a
+// name or a number in a left-to-right script.
+func rightToLeft(text string) bool {
+	n := 0
+	for _, r := range text {
+		switch p, _ := bidi.LookupRune(r); p.Class() {
+		case bidi.R, bidi.AL:
+			n++
+		case bidi.L:
+			n--
+		}
+	}
+	return n > 0
+}
@@ -0,0 +1,265 @@
+package output
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/storage"
+	"github.com/segmentio/kafka-go"
+)
+
+// lockedFormatter serializes a formatter, which may keep state between
+// records, for sinks that deliver the records of concurrent sessions
+// without holding a lock while they do.
+type lockedFormatter struct {
+	mu sync.Mutex
+	Formatter
+}
+
+func (f *lockedFormatter) format(r Record) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.Format(r)
+}
+
+// logSink writes formatted records through the standard logger.
+type logSink struct {
+	mu        sync.Mutex
+	formatter Formatter
+}
+
+func (s *logSink) Write(ctx context.Context, r Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.formatter.Format(r)
+	if err != nil || b == nil {
+		return err
+	}
+	log.Print(string(b))
+	return nil
+}
+
+func (s *logSink) Close() error { return nil }
+
+// writerSink writes formatted records to an io.Writer such as a file.
+type writerSink struct {
+	// mu serializes formatting and writing, so the records of concurrent
+	// sessions are numbered in the order they are written.
+	mu        sync.Mutex
+	w         io.Writer
+	closer    io.Closer
+	formatter Formatter
+}
+
+func newStdSink(name string, formatter Formatter) *writerSink {
+	w := os.Stdout
+	if name == "stderr" {
+		w = os.Stderr
+	}
+	return &writerSink{w: w, formatter: formatter}
+}
+
+func newFileSink(path string, formatter Formatter) (*writerSink, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	return &writerSink{w: f, closer: f, formatter: formatter}, nil
+}
+
+func (s *writerSink) Write(ctx context.Context, r Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.formatter.Format(r)
+	if err != nil || b == nil {
+		return err
+	}
+	if _, err := s.w.Write(b); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	return nil
+}
+
+func (s *writerSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+// newGCSSink accepts destinations of the form gs://bucket/object, uploading
+// the formatted records as they are written. The object only appears once
+// the sink is closed.
+func newGCSSink(dest string, formatter Formatter) (*writerSink, error) {
+	bucket, object, _ := strings.Cut(strings.TrimPrefix(dest, "gs://"), "/")
+	if bucket == "" || object == "" {
+		return nil, fmt.Errorf("invalid GCS destination %q: expected gs://bucket/object", dest)
+	}
+	client, err := storage.NewClient(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage client: %w", err)
+	}
+	w := client.Bucket(bucket).Object(object).NewWriter(context.Background())
+	w.ContentType = formatter.ContentType()
+	return &writerSink{w: w, closer: gcsCloser{w, client}, formatter: formatter}, nil
+}
+
+// gcsCloser finishes an upload, then releases its client.
+type gcsCloser struct {
+	w      *storage.Writer
+	client *storage.Client
+}
+
+func (c gcsCloser) Close() error {
+	defer c.client.Close()
+	if err := c.w.Close(); err != nil {
+		return fmt.Errorf("failed to upload output: %w", err)
+	}
+	return nil
+}
+
+// pubsubSink publishes every formatted record as a message on a Pub/Sub
+// topic, with the session ID as ordering key.
+type pubsubSink struct {
+	client    *pubsub.Client
+	topic     *pubsub.Topic
+	formatter lockedFormatter
+}
+
+// newPubSubSink accepts destinations of the form pubsub://project/topic.
+func newPubSubSink(dest string, formatter Formatter) (*pubsubSink, error) {
+	project, topic, _ := strings.Cut(strings.TrimPrefix(dest, "pubsub://"), "/")
+	if project == "" || topic == "" || strings.Contains(topic, "/") {
+		return nil, fmt.Errorf("invalid pubsub destination %q: expected pubsub://project/topic", dest)
+	}
+	client, err := pubsub.NewClient(context.Background(), project)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Pub/Sub client: %w", err)
+	}
+	t := client.Topic(topic)
+	t.EnableMessageOrdering = true
+	return &pubsubSink{client: client, topic: t, formatter: lockedFormatter{Formatter: formatter}}, nil
+}
+
+func (s *pubsubSink) Write(ctx context.Context, r Record) error {
+	b, err := s.formatter.format(r)
+	if err != nil || b == nil {
+		return err
+	}
+	msg := &pubsub.Message{Data: b, OrderingKey: r.SessionID, Attributes: map[string]string{}}
+	if r.SessionID != "" {
+		msg.Attributes["session_id"] = r.SessionID
+	}
+	if r.ID != "" {
+		msg.Attributes["id"] = r.ID
+	}
+	if _, err := s.topic.Publish(ctx, msg).Get(ctx); err != nil {
+		// A failed publish pauses its ordering key until resumed
+		s.topic.ResumePublish(r.SessionID)
+		return fmt.Errorf("failed to publish to pubsub: %w", err)
+	}
+	return nil
+}
+
+func (s *pubsubSink) Close() error {
+	s.topic.Stop()
+	return s.client.Close()
+}
+
+// webhookSink POSTs every formatted record to an HTTP endpoint.
+type webhookSink struct {
+	url       string
+	client    *http.Client
+	formatter lockedFormatter
+}
+
+func newWebhookSink(url string, formatter Formatter) *webhookSink {
+	return &webhookSink{
+		url:       url,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		formatter: lockedFormatter{Formatter: formatter},
+	}
+}
+
+func (s *webhookSink) Write(ctx context.Context, r Record) error {
+	b, err := s.formatter.format(r)
+	if err != nil || b == nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", s.formatter.ContentType())
+	if r.ID != "" {
+		req.Header.Set("Idempotency-Key", r.ID)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *webhookSink) Close() error { return nil }
+
+// kafkaSink publishes every formatted record as a message on a topic, keyed
+// by session ID so that the records of a session stay in order on one
+// partition.
+type kafkaSink struct {
+	writer    *kafka.Writer
+	formatter lockedFormatter
+}
+
+// newKafkaSink accepts destinations of the form kafka://broker[,broker...]/topic.
+func newKafkaSink(dest string, formatter Formatter) (*kafkaSink, error) {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid kafka destination: %w", err)
+	}
+	topic := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || topic == "" {
+		return nil, fmt.Errorf("invalid kafka destination %q: expected kafka://broker/topic", dest)
+	}
+	return &kafkaSink{
+		writer: &kafka.Writer{
+			Addr:     kafka.TCP(strings.Split(u.Host, ",")...),
+			Topic:    topic,
+			Balancer: &kafka.Hash{},
+		},
+		formatter: lockedFormatter{Formatter: formatter},
+	}, nil
+}
+
+func (s *kafkaSink) Write(ctx context.Context, r Record) error {
+	b, err := s.formatter.format(r)
+	if err != nil || b == nil {
+		return err
+	}
+	msg := kafka.Message{Key: []byte(r.SessionID), Value: b}
+	if r.ID != "" {
+		msg.Headers = []kafka.Header{{Key: "id", Value: []byte(r.ID)}}
+	}
+	if err := s.writer.WriteMessages(ctx, msg); err != nil {
+		return fmt.Errorf("failed to publish to kafka: %w", err)
+	}
+	return nil
+}
+
+func (s *kafkaSink) Close() error {
+	return s.writer.Close()
+}
@@ -0,0 +1,6 @@
+// Package sttpb holds the Go code generated from the protobuf schemas in
+// proto: the stt.v1.Ingest service and the transcript messages. Regenerate
+// it with go generate after changing a schema.
+package sttpb
+
+//go:generate protoc -I .. --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative ../ingest.proto ../transcript.proto
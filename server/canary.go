@@ -0,0 +1,57 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	speech "cloud.google.com/go/speech/apiv2"
+
+	"stt-receivetranscription-mve/stt"
+)
+
+type canaryResponse struct {
+	Arms []stt.CanaryArm `json:"arms"`
+}
+
+type referenceRequest struct {
+	Reference string `json:"reference"`
+}
+
+// routeCanary returns the config session id is recognized with and its
+// canary arm, which is empty without a canary.
+func (s *Server) routeCanary(id string, config stt.Config) (stt.Config, string) {
+	if s.canary == nil {
+		return config, ""
+	}
+	return s.canary.Route(id, config)
+}
+
+// clientFor returns the speech client for one-shot and batch recognition of
+// sessions routed to arm, bound to its region.
+func (s *Server) clientFor(arm string) *speech.Client {
+	if arm == stt.ArmCanary && s.canaryClient != nil {
+		return s.canaryClient
+	}
+	return s.client
+}
+
+// handleCanary reports how the sessions of each canary arm fared.
+func (s *Server) handleCanary(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, canaryResponse{Arms: s.canary.Report()})
+}
+
+// handleCanaryReference scores an ended session against the reference
+// transcript of its audio in the JSON body, such as a reviewed correction,
+// adding it to its arm's WER.
+func (s *Server) handleCanaryReference(w http.ResponseWriter, r *http.Request) {
+	var req referenceRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil || req.Reference == "" {
+		writeJSON(w, http.StatusBadRequest, errorResponse{"expected a JSON body with a reference transcript"})
+		return
+	}
+	if err := s.canary.Score(r.PathValue("id"), req.Reference); err != nil {
+		writeJSON(w, http.StatusNotFound, errorResponse{err.Error()})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// corsAllowedHeaders covers the headers sent by browser fetch and gRPC-Web clients.
+var corsAllowedHeaders = []string{
+	"Authorization", "Content-Type", "X-Grpc-Web", "X-User-Agent", "Grpc-Timeout",
+}
+
+// corsExposedHeaders lets browser gRPC-Web clients read the call status.
+var corsExposedHeaders = []string{"Grpc-Status", "Grpc-Message"}
+
+// allowOrigin reports whether origin is permitted by the configured list,
+// where "*" permits any origin.
+func allowOrigin(origins []string, origin string) bool {
+	return slices.Contains(origins, "*") || slices.Contains(origins, origin)
+}
+
+// withCORS answers CORS preflight requests and decorates responses for
+// allowed origins. With no configured origins it is a no-op.
+func withCORS(origins []string, next http.Handler) http.Handler {
+	if len(origins) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !allowOrigin(origins, origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Add("Vary", "Origin")
+		h.Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			h.Set("Access-Control-Allow-Headers", strings.Join(corsAllowedHeaders, ", "))
+			h.Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
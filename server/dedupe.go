@@ -0,0 +1,437 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"stt-receivetranscription-mve/audio"
+	"stt-receivetranscription-mve/stt"
+)
+
+const (
+	// fingerprintDuration is how much audio at the start of a stream is
+	// hashed to fingerprint it.
+	fingerprintDuration = time.Second
+	// fingerprintFloor is the peak 16-bit sample below which the start of
+	// a stream is taken for silence, which many unrelated streams share,
+	// and not fingerprinted.
+	fingerprintFloor = 64
+	// followDrain bounds how long a session sharing another's recognition
+	// waits after its own audio ends for the results of that audio.
+	followDrain = 30 * time.Second
+)
+
+var sharedRecognitions = expvar.NewInt("server_shared_recognitions")
+
+// recognition is an upstream recognition that identical requests share.
+type recognition struct {
+	done       chan struct{}
+	transcript stt.Transcript
+	err        error
+}
+
+// sharedStream is an upstream streaming recognition that sessions streaming
+// the same audio share. Results are passed on to every session following
+// it, and the final results so far replayed to sessions joining late.
+type sharedStream struct {
+	mu        sync.Mutex
+	finals    []stt.Result
+	followers map[int]*resultQueue
+	next      int
+	done      chan struct{}
+}
+
+// resultQueue passes results on to a follower from a goroutine of its own,
+// so that a follower slow to send them to its client holds up neither the
+// leader's recognition nor the other followers.
+type resultQueue struct {
+	mu      sync.Mutex
+	pending []stt.Result
+	closed  bool
+	wake    chan struct{}
+	done    chan struct{}
+}
+
+func newResultQueue(result func(stt.Result)) *resultQueue {
+	q := &resultQueue{wake: make(chan struct{}, 1), done: make(chan struct{})}
+	go q.run(result)
+	return q
+}
+
+// push queues r without waiting for it to be passed on.
+func (q *resultQueue) push(r stt.Result) {
+	q.mu.Lock()
+	q.pending = append(q.pending, r)
+	q.mu.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// close waits for the results queued so far to be passed on, and stops.
+func (q *resultQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	<-q.done
+}
+
+func (q *resultQueue) run(result func(stt.Result)) {
+	defer close(q.done)
+	for {
+		q.mu.Lock()
+		pending, closed := q.pending, q.closed
+		q.pending = nil
+		q.mu.Unlock()
+		for _, r := range pending {
+			result(r)
+		}
+		if len(pending) > 0 {
+			continue
+		}
+		if closed {
+			return
+		}
+		<-q.wake
+	}
+}
+
+// dedupe shares one upstream recognition between concurrent requests for the
+// same audio and settings, such as several clients of one broadcast.
+type dedupe struct {
+	mu       sync.Mutex
+	inflight map[string]*recognition
+	streams  map[string]*sharedStream
+}
+
+func newDedupe() *dedupe {
+	return &dedupe{inflight: map[string]*recognition{}, streams: map[string]*sharedStream{}}
+}
+
+// settings returns the recognition settings that affect the transcript.
+func settings(config stt.Config) string {
+	return fmt.Sprintf("|%s|%s|%t|%d", config.Model,
+		strings.Join(config.LanguageCodes, ","), config.WordTimeOffsets, config.MaxSpeakers)
+}
+
+// fingerprint hashes all of the audio read from r together with the
+// settings that affect the transcript.
+func fingerprint(config stt.Config, r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", fmt.Errorf("failed to fingerprint audio: %w", err)
+	}
+	io.WriteString(h, settings(config))
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// do runs recognize unless an identical recognition is already in flight, in
+// which case it waits for and returns that one's transcript.
+func (d *dedupe) do(ctx context.Context, key string, recognize func() (stt.Transcript, error)) (stt.Transcript, error) {
+	d.mu.Lock()
+	if rec, ok := d.inflight[key]; ok {
+		d.mu.Unlock()
+		select {
+		case <-rec.done:
+		case <-ctx.Done():
+			return stt.Transcript{}, ctx.Err()
+		}
+		// The first client going away must not fail the others
+		if errors.Is(rec.err, context.Canceled) && ctx.Err() == nil {
+			return recognize()
+		}
+		if rec.err == nil {
+			sharedRecognitions.Add(1)
+		}
+		return rec.transcript, rec.err
+	}
+	rec := &recognition{done: make(chan struct{})}
+	d.inflight[key] = rec
+	d.mu.Unlock()
+
+	rec.transcript, rec.err = recognize()
+	d.mu.Lock()
+	delete(d.inflight, key)
+	d.mu.Unlock()
+	close(rec.done)
+	return rec.transcript, rec.err
+}
+
+// join returns the shared stream of key, and whether it was started for the
+// caller, which then leads it and must end it with leave.
+func (d *dedupe) join(key string) (*sharedStream, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if shared, ok := d.streams[key]; ok {
+		return shared, false
+	}
+	shared := &sharedStream{followers: map[int]*resultQueue{}, done: make(chan struct{})}
+	d.streams[key] = shared
+	return shared, true
+}
+
+// leave ends the shared stream of key once its leader's recognition ends.
+func (d *dedupe) leave(key string, shared *sharedStream) {
+	d.mu.Lock()
+	delete(d.streams, key)
+	d.mu.Unlock()
+	close(shared.done)
+}
+
+// publish queues r for the followers, in order. It is called for one result
+// at a time, from the leader's session.
+func (s *sharedStream) publish(r stt.Result) {
+	s.mu.Lock()
+	if r.IsFinal {
+		s.finals = append(s.finals, r)
+	}
+	queues := make([]*resultQueue, 0, len(s.followers))
+	for _, q := range s.followers {
+		queues = append(queues, q)
+	}
+	s.mu.Unlock()
+	for _, q := range queues {
+		q.push(r)
+	}
+}
+
+// follow passes the final results so far and the following ones on to
+// result, from a goroutine of its own, until the returned function is
+// called; that waits for the results queued until then to be passed on.
+func (s *sharedStream) follow(result func(stt.Result)) (unfollow func()) {
+	q := newResultQueue(result)
+	s.mu.Lock()
+	for _, r := range s.finals {
+		q.push(r)
+	}
+	id := s.next
+	s.next++
+	s.followers[id] = q
+	s.mu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			delete(s.followers, id)
+			s.mu.Unlock()
+			q.close()
+		})
+	}
+}
+
+// stream transcribes session id from r, a live WAV stream of 16-bit PCM at
+// rate and channels, or for rate 0 audio of another encoding, sent in
+// chunks of chunkSize bytes, calling result with every result. With shared
+// duplicates, a session whose first second of audio and settings match a
+// session in flight follows that session's recognition instead of starting
+// its own, and takes over with its own if that session ends first.
+func (s *Server) stream(ctx context.Context, id string, config stt.Config, rate, channels, chunkSize int, r io.Reader, result func(stt.Result)) error {
+	if s.dedupe == nil || rate == 0 {
+		return s.runStream(ctx, id, config, chunkSize, r, result)
+	}
+	header := audio.StreamHeader(rate, channels)
+	head := make([]byte, len(header)+int(fingerprintDuration.Seconds()*float64(rate*channels*2)))
+	n, err := io.ReadFull(r, head)
+	head = head[:n]
+	if err != nil || silent(head[len(header):]) {
+		// Too short or quiet to tell apart from other streams
+		return s.runStream(ctx, id, config, chunkSize, io.MultiReader(bytes.NewReader(head), r), result)
+	}
+	h := sha256.New()
+	h.Write(head)
+	io.WriteString(h, settings(config))
+	key := hex.EncodeToString(h.Sum(nil))
+
+	shared, leader := s.dedupe.join(key)
+	if leader {
+		defer s.dedupe.leave(key, shared)
+		return s.runStream(ctx, id, config, chunkSize, io.MultiReader(bytes.NewReader(head), r), func(r stt.Result) {
+			result(r)
+			shared.publish(r)
+		})
+	}
+	f := &follower{id: id, config: config, header: header, frame: channels * 2, byteRate: rate * channels * 2, chunkSize: chunkSize}
+	f.keep(head[len(header):])
+	return s.followStream(ctx, f, r, shared, result)
+}
+
+// runStream transcribes session id from r in a streaming session of its
+// own.
+func (s *Server) runStream(ctx context.Context, id string, config stt.Config, chunkSize int, r io.Reader, result func(stt.Result)) error {
+	config, arm := s.routeCanary(id, config)
+	session := stt.NewSession(id, stt.SessionOptions{Config: config, Budget: s.budget, ChunkSize: chunkSize, Keepalive: s.keepalive})
+	s.watch(session, arm)
+	session.OnPartial(result)
+	session.OnFinal(result)
+	return session.RunLive(ctx, r)
+}
+
+// follower is a session following the recognition of another.
+type follower struct {
+	id     string
+	config stt.Config
+	// header is the WAV header of its audio, of frame bytes per sample frame
+	// and byteRate bytes per second, which is sent in chunks of chunkSize.
+	header          []byte
+	frame, byteRate int
+	chunkSize       int
+
+	mu sync.Mutex
+	// read is how many bytes of audio past the header have been read, of
+	// which kept, from byte keptFrom on, are not yet covered by a final
+	// result, for its own recognition to resume from.
+	read     int64
+	kept     []byte
+	keptFrom int64
+	// seq is the number of the last result passed on, and lastEnd the end
+	// of the last final one.
+	seq     uint64
+	lastEnd time.Duration
+}
+
+// number numbers r as the session's next result, since the results of
+// shared are numbered for its leader.
+func (f *follower) number(r stt.Result) stt.Result {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.seq++
+	r.Seq = f.seq
+	return r
+}
+
+// keep records audio p as read.
+func (f *follower) keep(p []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.read += int64(len(p))
+	f.kept = append(f.kept, p...)
+}
+
+// covered records final result r, dropping the audio kept up to its end.
+func (f *follower) covered(r stt.Result) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.lastEnd = r.End
+	cut := int64(r.End) * int64(f.byteRate) / int64(time.Second)
+	cut -= cut % int64(f.frame)
+	if cut = min(cut, f.read); cut > f.keptFrom {
+		f.kept = slices.Clone(f.kept[cut-f.keptFrom:])
+		f.keptFrom = cut
+	}
+}
+
+// duration returns the playback duration of n bytes of the audio.
+func (f *follower) duration(n int64) time.Duration {
+	return time.Duration(n) * time.Second / time.Duration(f.byteRate)
+}
+
+// followStream passes the results of shared on to session f, whose audio
+// is read from r. Its audio is read as it arrives and kept only until a
+// final result covers it, so that if shared ends before it, its own
+// recognition takes over from the last shared final result, at the same
+// offsets and numbering.
+func (s *Server) followStream(ctx context.Context, f *follower, r io.Reader, shared *sharedStream, result func(stt.Result)) error {
+	log.Printf("Session %s shares the recognition of a session streaming the same audio", f.id)
+	caught := make(chan struct{}, 1)
+	unfollow := shared.follow(func(r stt.Result) {
+		r = f.number(r)
+		if r.IsFinal {
+			f.covered(r)
+			select {
+			case caught <- struct{}{}:
+			default:
+			}
+		}
+		result(r)
+	})
+	defer unfollow()
+
+	buf := make([]byte, f.chunkSize)
+	for {
+		select {
+		case <-shared.done:
+			unfollow()
+			return s.takeOver(ctx, f, r, result)
+		default:
+		}
+		n, err := r.Read(buf)
+		f.keep(buf[:n])
+		if err == nil {
+			continue
+		}
+		if err != io.EOF {
+			return err
+		}
+		// The audio has ended; its results may still be on the way
+		timeout := time.After(followDrain)
+		for {
+			f.mu.Lock()
+			done := f.lastEnd >= f.duration(f.read)
+			f.mu.Unlock()
+			if done {
+				sharedRecognitions.Add(1)
+				return nil
+			}
+			select {
+			case <-caught:
+			case <-shared.done:
+				sharedRecognitions.Add(1)
+				return nil
+			case <-timeout:
+				log.Printf("Session %s ended without the results of its last audio", f.id)
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// takeOver transcribes the rest of session f, the audio kept and then the
+// rest of r, in a streaming session of its own, continuing the offsets and
+// numbering of the results shared.
+func (s *Server) takeOver(ctx context.Context, f *follower, r io.Reader, result func(stt.Result)) error {
+	f.mu.Lock()
+	offset, seq := f.duration(f.keptFrom), f.seq
+	audio := io.MultiReader(bytes.NewReader(f.header), bytes.NewReader(f.kept), r)
+	f.mu.Unlock()
+	log.Printf("Session %s takes over at %s from the session it shared, which ended first", f.id, offset)
+	return s.runStream(ctx, f.id, f.config, f.chunkSize, audio, func(r stt.Result) {
+		r.Seq += seq
+		r.Start += offset
+		r.End += offset
+		r.Words = slices.Clone(r.Words)
+		for i := range r.Words {
+			r.Words[i].Start += offset
+			r.Words[i].End += offset
+		}
+		result(r)
+	})
+}
+
+// silent reports whether pcm, 16-bit samples, peaks below the fingerprint
+// floor.
+func silent(pcm []byte) bool {
+	for i := 0; i+1 < len(pcm); i += 2 {
+		if v := int16(uint16(pcm[i]) | uint16(pcm[i+1])<<8); v > fingerprintFloor || v < -fingerprintFloor {
+			return false
+		}
+	}
+	return true
+}
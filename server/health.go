@@ -0,0 +1,93 @@
+package server
+
+import (
+	"expvar"
+	"net/http"
+)
+
+var activeSessions = expvar.NewInt("server_active_sessions")
+
+type healthResponse struct {
+	Status string `json:"status"`
+}
+
+// handleHealthz reports that the server is alive, even while draining.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, healthResponse{"ok"})
+}
+
+// handleReadyz reports whether the server accepts new sessions, failing once
+// it drains so that load balancers stop routing to it.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if s.Draining() {
+		writeJSON(w, http.StatusServiceUnavailable, healthResponse{"draining"})
+		return
+	}
+	writeJSON(w, http.StatusOK, healthResponse{"ready"})
+}
+
+// admit counts a new session in, returning the func that counts it out, or
+// false while the server drains.
+func (s *Server) admit() (func(), bool) {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+	if s.idle != nil {
+		return nil, false
+	}
+	s.active++
+	activeSessions.Add(1)
+	return func() {
+		s.drainMu.Lock()
+		defer s.drainMu.Unlock()
+		s.active--
+		activeSessions.Add(-1)
+		if s.idle != nil && s.active == 0 {
+			close(s.idle)
+		}
+	}, true
+}
+
+// admitting wraps a handler that starts a session, refusing it with 503
+// while the server drains.
+func (s *Server) admitting(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		done, ok := s.admit()
+		if !ok {
+			// Clients reconnect to another instance
+			w.Header().Set("Connection", "close")
+			writeJSON(w, http.StatusServiceUnavailable, errorResponse{"server is draining"})
+			return
+		}
+		defer done()
+		h(w, r)
+	}
+}
+
+// Drain makes the server refuse new sessions and fail /readyz, while the
+// sessions in progress carry on. The returned channel is closed once they
+// have all ended.
+func (s *Server) Drain() <-chan struct{} {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+	if s.idle == nil {
+		s.idle = make(chan struct{})
+		if s.active == 0 {
+			close(s.idle)
+		}
+	}
+	return s.idle
+}
+
+// Draining reports whether Drain was called.
+func (s *Server) Draining() bool {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+	return s.idle != nil
+}
+
+// Active returns the number of sessions in progress.
+func (s *Server) Active() int {
+	s.drainMu.Lock()
+	defer s.drainMu.Unlock()
+	return s.active
+}
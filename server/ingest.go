@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"stt-receivetranscription-mve/audio"
+	"stt-receivetranscription-mve/output"
+	"stt-receivetranscription-mve/proto/sttpb"
+	"stt-receivetranscription-mve/stt"
+)
+
+// ingestServer serves the stt.v1.Ingest service of proto/ingest.proto.
+type ingestServer struct {
+	sttpb.UnimplementedIngestServer
+	s *Server
+}
+
+func (i ingestServer) Transcribe(stream sttpb.Ingest_TranscribeServer) error {
+	return i.s.transcribe(stream)
+}
+
+// transcribe runs the streaming session of an Ingest.Transcribe call, like
+// handleStream does for a WebSocket.
+func (s *Server) transcribe(stream sttpb.Ingest_TranscribeServer) error {
+	done, ok := s.admit()
+	if !ok {
+		return status.Error(codes.Unavailable, "server is draining")
+	}
+	defer done()
+	ctx := stream.Context()
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	c := first.GetConfig()
+	if c == nil {
+		return status.Error(codes.InvalidArgument, "the first request must carry the config")
+	}
+	config := s.config
+	if c.Language != "" {
+		config.LanguageCodes = []string{c.Language}
+	}
+	if c.Model != "" {
+		config.Model = c.Model
+	}
+	rate, channels := 16000, 1
+	switch c.Encoding {
+	case "", "pcm":
+		if c.SampleRateHertz < 0 || c.Channels < 0 {
+			return status.Error(codes.InvalidArgument, "invalid sample rate or channels")
+		}
+		if c.SampleRateHertz > 0 {
+			rate = int(c.SampleRateHertz)
+		}
+		if c.Channels > 0 {
+			channels = int(c.Channels)
+		}
+	case "opus":
+	default:
+		return status.Errorf(codes.InvalidArgument, "unsupported encoding %q", c.Encoding)
+	}
+	if c.TranscribeLater && s.recordURI == "" {
+		return status.Error(codes.InvalidArgument, "transcribing later is not enabled")
+	}
+
+	id := stt.NewSessionID()
+	pr, pw := io.Pipe()
+	go func() {
+		if c.Encoding != "opus" {
+			if _, err := pw.Write(audio.StreamHeader(rate, channels)); err != nil {
+				return
+			}
+		}
+		for {
+			req, err := stream.Recv()
+			switch {
+			case err == io.EOF:
+				pw.Close()
+				return
+			case err != nil:
+				pw.CloseWithError(err)
+				return
+			}
+			if _, err := pw.Write(req.GetAudio()); err != nil {
+				return
+			}
+		}
+	}()
+	if c.TranscribeLater {
+		log.Printf("Recording gRPC session %s", id)
+		job, err := s.recordLater(ctx, id, config, c.Tags, pr)
+		pr.CloseWithError(io.ErrClosedPipe)
+		if err != nil {
+			log.Printf("Recording gRPC session %s failed: %v", id, err)
+			if errors.Is(err, errNoAudio) {
+				return status.Error(codes.InvalidArgument, err.Error())
+			}
+			return status.Error(ingestCode(err), err.Error())
+		}
+		log.Printf("Queued gRPC session %s for transcription from %s", id, job.URI)
+		return stream.Send(&sttpb.TranscribeResponse{SessionId: id, AudioUri: job.URI})
+	}
+
+	chunkSize := rate * channels * 2 / 10
+	if c.Encoding == "opus" {
+		chunkSize, rate = 1024, 0
+	}
+	var sink output.Sink
+	if s.store != nil {
+		sink = output.WithSession(s.store, output.Session{ID: id, Tags: c.Tags})
+	}
+	// Partial and final results may be sent from different goroutines
+	var mu sync.Mutex
+	send := func(result stt.Result) {
+		mu.Lock()
+		err := stream.Send(&sttpb.TranscribeResponse{SessionId: id, Result: result.Proto()})
+		mu.Unlock()
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Failed to send result to gRPC client: %v", err)
+		}
+		if sink != nil && result.IsFinal {
+			record := output.FromResult(result)
+			record.SessionID = id
+			if err := sink.Write(ctx, record); err != nil {
+				log.Printf("Failed to persist result: %v", err)
+			}
+		}
+	}
+
+	log.Printf("gRPC streaming session %s started", id)
+	err = s.stream(ctx, id, config, rate, channels, chunkSize, pr, send)
+	pr.CloseWithError(io.ErrClosedPipe)
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			log.Printf("gRPC streaming session %s failed: %v", id, err)
+		}
+		return status.Error(ingestCode(err), err.Error())
+	}
+	return nil
+}
+
+// ingestCode is the status code an Ingest call failing with err ends with.
+func ingestCode(err error) codes.Code {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return codes.Canceled
+	case errors.Is(err, stt.ErrAudioFormat):
+		return codes.InvalidArgument
+	case errors.Is(err, stt.ErrQuota), errors.Is(err, stt.ErrBudgetExhausted):
+		return codes.ResourceExhausted
+	default:
+		return codes.Unavailable
+	}
+}
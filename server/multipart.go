@@ -0,0 +1,122 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// maxFormValue bounds the fields of a multipart upload other than its file.
+const maxFormValue = 64 << 10
+
+// upload is the "file" of a multipart upload, spilled to a temporary file
+// as it arrives instead of being buffered in memory.
+type upload struct {
+	f    *os.File
+	size int64
+}
+
+// readUpload streams the multipart form of r, of at most limit bytes,
+// spilling its "file" field to disk and keeping the other fields in r.Form.
+// A form over the limit fails with an error wrapping *http.MaxBytesError;
+// uploadStatus maps it to 413. The caller must remove the upload.
+func readUpload(w http.ResponseWriter, r *http.Request, limit int64) (u *upload, err error) {
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	defer func() {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			err = fmt.Errorf("upload exceeds the limit of %d bytes: %w", tooLarge.Limit, err)
+		}
+		if err != nil && u != nil {
+			u.remove()
+			u = nil
+		}
+	}()
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse multipart form: %w", err)
+	}
+	form := r.URL.Query()
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return u, fmt.Errorf("failed to parse multipart form: %w", err)
+		}
+		switch name := part.FormName(); {
+		case name == "file" && u == nil:
+			if u, err = spill(part); err != nil {
+				return u, err
+			}
+		case part.FileName() == "":
+			v, err := io.ReadAll(io.LimitReader(part, maxFormValue+1))
+			if err != nil {
+				return u, fmt.Errorf("failed to parse multipart form: %w", err)
+			}
+			if len(v) > maxFormValue {
+				return u, fmt.Errorf("form field %q is longer than %d bytes", name, maxFormValue)
+			}
+			form.Add(name, string(v))
+		}
+		part.Close()
+	}
+	if u == nil {
+		return nil, errors.New("missing file")
+	}
+	r.Form = form
+	return u, nil
+}
+
+// spill copies r to a new temporary file.
+func spill(r io.Reader) (*upload, error) {
+	f, err := os.CreateTemp("", "stt-upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to spill upload: %w", err)
+	}
+	u := &upload{f: f}
+	if u.size, err = io.Copy(f, r); err != nil {
+		return u, fmt.Errorf("failed to read file: %w", err)
+	}
+	return u, nil
+}
+
+// uploadStatus maps a readUpload error to the HTTP status returned to the
+// client.
+func uploadStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// bytes reads the whole upload into memory.
+func (u *upload) bytes() ([]byte, error) {
+	b := make([]byte, u.size)
+	if _, err := u.f.ReadAt(b, 0); err != nil {
+		return nil, fmt.Errorf("failed to read upload: %w", err)
+	}
+	return b, nil
+}
+
+// reader returns the upload from its start.
+func (u *upload) reader() io.Reader {
+	return io.NewSectionReader(u.f, 0, u.size)
+}
+
+// header returns up to the first 64 KiB of the upload, enough for a WAV
+// header.
+func (u *upload) header() []byte {
+	b := make([]byte, min(u.size, 64<<10))
+	n, _ := u.f.ReadAt(b, 0)
+	return b[:n]
+}
+
+func (u *upload) remove() {
+	u.f.Close()
+	os.Remove(u.f.Name())
+}
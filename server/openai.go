@@ -0,0 +1,245 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"stt-receivetranscription-mve/output"
+	"stt-receivetranscription-mve/stt"
+)
+
+// maxUploadSize matches the 25 MB limit of the OpenAI transcription API,
+// and is the default limit of uploads to it.
+const maxUploadSize = 25 << 20
+
+// openAIError is the error body shape used by the OpenAI API.
+type openAIError struct {
+	Error struct {
+		Message string  `json:"message"`
+		Type    string  `json:"type"`
+		Param   *string `json:"param"`
+		Code    *string `json:"code"`
+	} `json:"error"`
+}
+
+func writeOpenAIError(w http.ResponseWriter, status int, param, format string, args ...any) {
+	var body openAIError
+	body.Error.Message = fmt.Sprintf(format, args...)
+	body.Error.Type = "invalid_request_error"
+	if status >= 500 {
+		body.Error.Type = "server_error"
+	}
+	if param != "" {
+		body.Error.Param = &param
+	}
+	writeJSON(w, status, body)
+}
+
+type verboseSegment struct {
+	ID               int     `json:"id"`
+	Seek             int     `json:"seek"`
+	Start            float64 `json:"start"`
+	End              float64 `json:"end"`
+	Text             string  `json:"text"`
+	Tokens           []int   `json:"tokens"`
+	Temperature      float64 `json:"temperature"`
+	AvgLogprob       float64 `json:"avg_logprob"`
+	CompressionRatio float64 `json:"compression_ratio"`
+	NoSpeechProb     float64 `json:"no_speech_prob"`
+}
+
+type verboseWord struct {
+	Word  string  `json:"word"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+type verboseTranscription struct {
+	Task     string           `json:"task"`
+	Language string           `json:"language"`
+	Duration float64          `json:"duration"`
+	Text     string           `json:"text"`
+	Segments []verboseSegment `json:"segments,omitempty"`
+	Words    []verboseWord    `json:"words,omitempty"`
+}
+
+// handleOpenAITranscription implements the OpenAI /v1/audio/transcriptions
+// request and response shapes on top of one-shot recognition.
+func (s *Server) handleOpenAITranscription(w http.ResponseWriter, r *http.Request) {
+	u, err := readUpload(w, r, cmp.Or(s.maxUpload, maxUploadSize))
+	if err != nil {
+		writeOpenAIError(w, uploadStatus(err), "file", "%v", err)
+		return
+	}
+	defer u.remove()
+	audio, err := u.bytes()
+	if err != nil {
+		writeOpenAIError(w, http.StatusInternalServerError, "file", "%v", err)
+		return
+	}
+
+	format := r.FormValue("response_format")
+	if format == "" {
+		format = "json"
+	}
+	switch format {
+	case "json", "text", "srt", "vtt", "verbose_json":
+	default:
+		writeOpenAIError(w, http.StatusBadRequest, "response_format", "unsupported response_format %q", format)
+		return
+	}
+
+	config := s.config
+	// Whisper model names select the configured model; anything else is
+	// passed through as a Google model name.
+	if model := r.FormValue("model"); model != "" && !strings.HasPrefix(model, "whisper") {
+		config.Model = model
+	}
+	if lang := r.FormValue("language"); lang != "" {
+		config.LanguageCodes = []string{lang}
+	}
+	config.WordTimeOffsets = format == "verbose_json" &&
+		strings.Contains(strings.Join(r.Form["timestamp_granularities[]"], ","), "word")
+
+	if s.budget != nil {
+		if err := s.budget.Allow(r.Context()); err != nil {
+			writeOpenAIError(w, recognitionStatus(err), "", "%v", err)
+			return
+		}
+	}
+	id := stt.NewSessionID()
+	config, arm := s.routeCanary(id, config)
+	recognize := func() (stt.Transcript, error) {
+		t, err := stt.Recognize(r.Context(), s.clientFor(arm), config, audio)
+		s.observe(id, arm, config, t, err)
+		if s.budget != nil && err == nil {
+			d, _ := stt.WAVDuration(audio)
+			if err := s.budget.Add(r.Context(), d); err != nil {
+				log.Printf("Failed to charge transcription to the usage budget: %v", err)
+			}
+		}
+		return t, err
+	}
+	var transcript stt.Transcript
+	if s.dedupe != nil {
+		key, _ := fingerprint(config, bytes.NewReader(audio))
+		transcript, err = s.dedupe.do(r.Context(), key, recognize)
+	} else {
+		transcript, err = recognize()
+	}
+	if err != nil {
+		log.Printf("OpenAI transcription failed: %v", err)
+		writeOpenAIError(w, recognitionStatus(err), "", "%v", err)
+		return
+	}
+	records := output.FromTranscript(transcript)
+	if s.store != nil {
+		if err := s.persist(r, id, records); err != nil {
+			log.Printf("Failed to persist transcription: %v", err)
+		}
+	}
+
+	var texts []string
+	for _, rec := range records {
+		texts = append(texts, strings.TrimSpace(rec.Text))
+	}
+	text := strings.Join(texts, " ")
+
+	switch format {
+	case "json":
+		writeJSON(w, http.StatusOK, map[string]string{"text": text})
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, text)
+	case "srt", "vtt":
+		writeRendered(w, format, records)
+	case "verbose_json":
+		writeJSON(w, http.StatusOK, verbose(config, records, text))
+	}
+}
+
+// recognitionStatus maps a recognition failure to the HTTP status returned
+// to the client.
+func recognitionStatus(err error) int {
+	switch {
+	case errors.Is(err, stt.ErrAudioFormat):
+		return http.StatusBadRequest
+	case errors.Is(err, stt.ErrQuota), errors.Is(err, stt.ErrBudgetExhausted):
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// persist stores records under session id, tagged with any "tag" form
+// fields of the request.
+func (s *Server) persist(r *http.Request, id string, records []output.Record) error {
+	tags := map[string]string{}
+	for _, tag := range r.Form["tag"] {
+		k, v, err := output.ParseTag(tag)
+		if err != nil {
+			return err
+		}
+		tags[k] = v
+	}
+	sink := output.WithSession(s.store, output.Session{ID: id, Tags: tags})
+	for _, rec := range records {
+		if err := sink.Write(r.Context(), rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeRendered(w http.ResponseWriter, format string, records []output.Record) {
+	formatter, err := output.NewFormatter(format, nil)
+	if err != nil {
+		writeOpenAIError(w, http.StatusInternalServerError, "", "%v", err)
+		return
+	}
+	var buf bytes.Buffer
+	for _, rec := range records {
+		b, err := formatter.Format(rec)
+		if err != nil {
+			writeOpenAIError(w, http.StatusInternalServerError, "", "%v", err)
+			return
+		}
+		buf.Write(b)
+	}
+	w.Header().Set("Content-Type", formatter.ContentType())
+	w.Write(buf.Bytes())
+}
+
+func verbose(config stt.Config, records []output.Record, text string) verboseTranscription {
+	v := verboseTranscription{Task: "transcribe", Text: text}
+	if len(config.LanguageCodes) > 0 {
+		v.Language = config.LanguageCodes[0]
+	}
+	for i, rec := range records {
+		v.Segments = append(v.Segments, verboseSegment{
+			ID:     i,
+			Start:  rec.Start.Seconds(),
+			End:    rec.End.Seconds(),
+			Text:   rec.Text,
+			Tokens: []int{},
+		})
+		for _, word := range rec.Words {
+			v.Words = append(v.Words, verboseWord{
+				Word:  word.Text,
+				Start: word.Start.Seconds(),
+				End:   word.End.Seconds(),
+			})
+		}
+		if rec.Language != "" {
+			v.Language = rec.Language
+		}
+		v.Duration = rec.End.Seconds()
+	}
+	return v
+}
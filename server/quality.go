@@ -0,0 +1,38 @@
+package server
+
+import (
+	"net/http"
+
+	"stt-receivetranscription-mve/stt"
+)
+
+type qualityResponse struct {
+	Recognizers []stt.QualityStats `json:"recognizers"`
+}
+
+// watch accounts for a streaming session, routed to canary arm, in the
+// canary and the quality monitor, if any.
+func (s *Server) watch(session *stt.Session, arm string) {
+	if arm != "" {
+		s.canary.Watch(session, arm)
+	}
+	if s.quality != nil {
+		s.quality.Watch(session)
+	}
+}
+
+// observe accounts for a transcription with config, routed to canary arm,
+// in the canary and the quality monitor, if any.
+func (s *Server) observe(id, arm string, config stt.Config, t stt.Transcript, err error) {
+	if arm != "" {
+		s.canary.Observe(id, arm, t, err)
+	}
+	if s.quality != nil {
+		s.quality.Observe(config, t, err)
+	}
+}
+
+// handleQuality reports the rolling quality metrics of each recognizer.
+func (s *Server) handleQuality(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, qualityResponse{Recognizers: s.quality.Stats()})
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"stt-receivetranscription-mve/audio"
+	"stt-receivetranscription-mve/stt"
+)
+
+// Job is the batch transcription job of a session recorded to be
+// transcribed later, in the message format of the worker subcommand.
+type Job struct {
+	// ID is the session's ID.
+	ID string `json:"id"`
+	// URI is the gs:// URI the session's audio was recorded to.
+	URI      string            `json:"uri"`
+	Language string            `json:"language,omitempty"`
+	Model    string            `json:"model,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+}
+
+// errNoAudio fails a session recorded without any audio.
+var errNoAudio = errors.New("no audio was recorded")
+
+// recordLater records the audio of session id read from r, a live WAV
+// stream or a WebM or Ogg Opus stream, under the record URI and enqueues its
+// job, transcribing it with config later instead of live.
+func (s *Server) recordLater(ctx context.Context, id string, config stt.Config, tags map[string]string, r io.Reader) (Job, error) {
+	f, err := os.CreateTemp("", "stt-record-*")
+	if err != nil {
+		return Job{}, fmt.Errorf("failed to record audio: %w", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	n, err := io.Copy(f, r)
+	if err != nil {
+		return Job{}, fmt.Errorf("failed to record audio: %w", err)
+	}
+	// Live PCM is a WAV stream of unknown length until now
+	var head [4]byte
+	if _, err := f.ReadAt(head[:], 0); err == nil && string(head[:]) == "RIFF" {
+		if n, err = audio.FinishWAV(f); err != nil {
+			return Job{}, fmt.Errorf("failed to record audio: %w", err)
+		}
+	}
+	if n <= 0 {
+		return Job{}, errNoAudio
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return Job{}, fmt.Errorf("failed to record audio: %w", err)
+	}
+	uri, _, err := stt.StageReader(ctx, s.recordURI, f)
+	if err != nil {
+		return Job{}, err
+	}
+
+	job := Job{ID: id, URI: uri, Model: config.Model, Tags: tags}
+	if len(config.LanguageCodes) > 0 {
+		job.Language = config.LanguageCodes[0]
+	}
+	if err := s.enqueue(ctx, job); err != nil {
+		return Job{}, fmt.Errorf("failed to enqueue transcription of %s: %w", uri, err)
+	}
+	return job, nil
+}
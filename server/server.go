@@ -0,0 +1,206 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	speech "cloud.google.com/go/speech/apiv2"
+	"github.com/improbable-eng/grpc-web/go/grpcweb"
+	"google.golang.org/grpc"
+
+	"stt-receivetranscription-mve/proto/sttpb"
+	"stt-receivetranscription-mve/store"
+	"stt-receivetranscription-mve/stt"
+)
+
+// Options configures optional server features.
+type Options struct {
+	// CORSOrigins lists the browser origins allowed to call the server.
+	// "*" allows any origin; empty disables CORS handling.
+	CORSOrigins []string
+	// GRPC, when set, is served to gRPC-Web clients on the HTTP listener.
+	GRPC *grpc.Server
+	// Store, when set, persists transcriptions and enables the session
+	// retrieval endpoints.
+	Store *store.Store
+	// Embedder, when set with Store, enables semantic search.
+	Embedder store.Embedder
+	// ShareDuplicates lets concurrent requests for the same audio share one
+	// upstream recognition: uploads by a hash of their content, streaming
+	// sessions by a hash of their first second of PCM.
+	ShareDuplicates bool
+	// Budget, when set, refuses transcriptions once the period's usage
+	// budget is spent.
+	Budget *stt.Budget
+	// StagingURI is a gs:// prefix uploads to /transcribe too long for
+	// one-shot recognition are staged under for batch recognition; without
+	// it they are rejected.
+	StagingURI string
+	// MaxUpload caps the size of uploads in bytes, answered with 413 above
+	// it; 0 keeps the defaults of 480 MB for /transcribe and the OpenAI
+	// API's 25 MB for /v1/audio/transcriptions.
+	MaxUpload int64
+	// WebSocket enables streaming transcription of audio sent over a
+	// WebSocket at /v1/stream.
+	WebSocket bool
+	// RecordURI, when set with Enqueue, lets streaming clients choose to
+	// have their session recorded now and transcribed later, sparing the
+	// live path any recognition: its audio is stored under this gs://
+	// prefix once it ends, and Enqueue is called with its batch job.
+	RecordURI string
+	Enqueue   func(ctx context.Context, job Job) error
+	// Twilio enables live transcription of calls streamed by Twilio Media
+	// Streams to /v1/twilio.
+	Twilio bool
+	// Ingest registers the stt.v1.Ingest streaming service on GRPC.
+	Ingest bool
+	// Canary, when set, sends a share of sessions to an alternate
+	// recognizer and reports both arms at /v1/canary. CanaryClient is the
+	// speech client for its region, if it differs.
+	Canary       *stt.Canary
+	CanaryClient *speech.Client
+	// Quality, when set, tracks the quality of every session, reported at
+	// /v1/quality.
+	Quality *stt.QualityMonitor
+	// Keepalive, when set, injects silence into streamed audio after this
+	// long without any, as stt.SessionOptions.Keepalive.
+	Keepalive time.Duration
+	// Demo serves a live transcription page at /, built into the binary,
+	// which streams the browser's microphone to /v1/stream.
+	Demo bool
+}
+
+// Server exposes transcription over HTTP, backed by a single speech client.
+type Server struct {
+	client   *speech.Client
+	config   stt.Config
+	mux      *http.ServeMux
+	store    *store.Store
+	embedder store.Embedder
+	grpc     *grpc.Server
+	grpcWeb  *grpcweb.WrappedGrpcServer
+	handler  http.Handler
+	dedupe   *dedupe
+	budget   *stt.Budget
+
+	canary       *stt.Canary
+	canaryClient *speech.Client
+	quality      *stt.QualityMonitor
+
+	keepalive  time.Duration
+	stagingURI string
+	maxUpload  int64
+	recordURI  string
+	enqueue    func(ctx context.Context, job Job) error
+	// corsOrigins also may open WebSockets.
+	corsOrigins []string
+
+	drainMu sync.Mutex
+	active  int
+	// idle is set once the server drains, and closed once no sessions are
+	// active.
+	idle chan struct{}
+}
+
+// New creates a server that recognizes audio with client using config as the
+// default recognition settings.
+func New(client *speech.Client, config stt.Config, opts Options) *Server {
+	s := &Server{
+		client:   client,
+		config:   config,
+		mux:      http.NewServeMux(),
+		store:    opts.Store,
+		embedder: opts.Embedder,
+		budget:   opts.Budget,
+
+		canary:       opts.Canary,
+		canaryClient: opts.CanaryClient,
+		quality:      opts.Quality,
+
+		keepalive:   opts.Keepalive,
+		stagingURI:  opts.StagingURI,
+		maxUpload:   opts.MaxUpload,
+		recordURI:   opts.RecordURI,
+		enqueue:     opts.Enqueue,
+		corsOrigins: opts.CORSOrigins,
+	}
+	if s.enqueue == nil {
+		s.recordURI = ""
+	}
+	if opts.ShareDuplicates {
+		s.dedupe = newDedupe()
+	}
+	s.mux.HandleFunc("POST /v1/audio/transcriptions", s.admitting(s.handleOpenAITranscription))
+	s.mux.HandleFunc("POST /transcribe", s.admitting(s.handleTranscribe))
+	s.mux.Handle("GET /debug/vars", expvar.Handler())
+	s.mux.HandleFunc("GET /healthz", s.handleHealthz)
+	s.mux.HandleFunc("GET /readyz", s.handleReadyz)
+	if opts.WebSocket {
+		s.mux.HandleFunc("GET /v1/stream", s.admitting(s.handleStream))
+	}
+	if opts.Twilio {
+		s.mux.HandleFunc("GET /v1/twilio", s.admitting(s.handleTwilio))
+	}
+	if opts.Demo {
+		s.mux.HandleFunc("GET /{$}", s.handleDemo)
+		s.mux.Handle("GET /static/", staticHandler())
+	}
+	if s.canary != nil {
+		s.mux.HandleFunc("GET /v1/canary", s.handleCanary)
+		s.mux.HandleFunc("POST /v1/canary/{id}/reference", s.handleCanaryReference)
+	}
+	if s.quality != nil {
+		s.mux.HandleFunc("GET /v1/quality", s.handleQuality)
+	}
+	if s.store != nil {
+		s.mux.HandleFunc("GET /v1/sessions", s.handleListSessions)
+		s.mux.HandleFunc("GET /v1/sessions/{id}", s.handleGetSession)
+		s.mux.HandleFunc("GET /v1/sessions/{id}/transcript", s.handleGetTranscript)
+		s.mux.HandleFunc("GET /v1/search", s.handleSearch)
+	}
+
+	if opts.GRPC != nil {
+		if opts.Ingest {
+			sttpb.RegisterIngestServer(opts.GRPC, ingestServer{s: s})
+		}
+		s.grpc = opts.GRPC
+		s.grpcWeb = grpcweb.WrapServer(opts.GRPC,
+			grpcweb.WithOriginFunc(func(origin string) bool {
+				return allowOrigin(opts.CORSOrigins, origin)
+			}))
+	}
+	s.handler = withCORS(opts.CORSOrigins, http.HandlerFunc(s.route))
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// route sends gRPC and gRPC-Web requests to the gRPC server and everything
+// else to the HTTP routes.
+func (s *Server) route(w http.ResponseWriter, r *http.Request) {
+	if s.grpcWeb != nil && s.grpcWeb.IsGrpcWebRequest(r) {
+		s.grpcWeb.ServeHTTP(w, r)
+		return
+	}
+	if s.grpc != nil && r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		s.grpc.ServeHTTP(w, r)
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
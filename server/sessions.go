@@ -0,0 +1,170 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"stt-receivetranscription-mve/output"
+	"stt-receivetranscription-mve/store"
+)
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type listSessionsResponse struct {
+	Sessions   []store.Session `json:"sessions"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+// handleListSessions lists stored sessions. Query parameters: tag=key=value
+// (repeatable), since and until (RFC 3339), limit and cursor.
+func (s *Server) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	q := store.Query{Tags: map[string]string{}, Cursor: params.Get("cursor")}
+
+	for _, tag := range params["tag"] {
+		k, v, err := output.ParseTag(tag)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+			return
+		}
+		q.Tags[k] = v
+	}
+	for name, dst := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+		if v := params.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, errorResponse{name + " must be an RFC 3339 time"})
+				return
+			}
+			*dst = t
+		}
+	}
+	if v := params.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > 500 {
+			writeJSON(w, http.StatusBadRequest, errorResponse{"limit must be between 1 and 500"})
+			return
+		}
+		q.Limit = limit
+	}
+
+	sessions, next, err := s.store.ListSessions(r.Context(), q)
+	if errors.Is(err, store.ErrInvalidCursor) {
+		writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to list sessions: %v", err)
+		writeJSON(w, http.StatusInternalServerError, errorResponse{"failed to list sessions"})
+		return
+	}
+	if sessions == nil {
+		sessions = []store.Session{}
+	}
+	writeJSON(w, http.StatusOK, listSessionsResponse{Sessions: sessions, NextCursor: next})
+}
+
+func (s *Server) handleGetSession(w http.ResponseWriter, r *http.Request) {
+	sess, err := s.store.Session(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, sess)
+}
+
+// handleGetTranscript renders a stored session in any output format, selected
+// with the format query parameter (default text).
+func (s *Server) handleGetTranscript(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "text"
+	}
+	formatter, err := output.NewFormatter(format, nil)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{err.Error()})
+		return
+	}
+
+	records, err := s.store.Records(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", formatter.ContentType())
+	for _, rec := range records {
+		b, err := formatter.Format(rec)
+		if err != nil {
+			log.Printf("Failed to render transcript: %v", err)
+			return
+		}
+		w.Write(b)
+	}
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, http.StatusNotFound, errorResponse{"session not found"})
+		return
+	}
+	log.Printf("Store error: %v", err)
+	writeJSON(w, http.StatusInternalServerError, errorResponse{"failed to read session"})
+}
+
+type searchResponse struct {
+	Matches []store.Match `json:"matches"`
+}
+
+// handleSearch returns stored segments matching the q query parameter, by
+// full text or, with mode=semantic, by embedding similarity.
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	if strings.TrimSpace(q) == "" {
+		writeJSON(w, http.StatusBadRequest, errorResponse{"q is required"})
+		return
+	}
+	limit := 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 500 {
+			writeJSON(w, http.StatusBadRequest, errorResponse{"limit must be between 1 and 500"})
+			return
+		}
+		limit = n
+	}
+
+	var (
+		matches []store.Match
+		err     error
+	)
+	switch r.URL.Query().Get("mode") {
+	case "", "text":
+		matches, err = s.store.Search(r.Context(), q, limit)
+	case "semantic":
+		if s.embedder == nil {
+			writeJSON(w, http.StatusBadRequest, errorResponse{"semantic search is not configured"})
+			return
+		}
+		matches, err = s.store.SemanticSearch(r.Context(), s.embedder, q, limit)
+	default:
+		writeJSON(w, http.StatusBadRequest, errorResponse{"mode must be text or semantic"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to search transcripts: %v", err)
+		writeJSON(w, http.StatusInternalServerError, errorResponse{"failed to search transcripts"})
+		return
+	}
+	if matches == nil {
+		matches = []store.Match{}
+	}
+	writeJSON(w, http.StatusOK, searchResponse{Matches: matches})
+}
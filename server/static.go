@@ -0,0 +1,27 @@
+package server
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// staticFiles are the assets of the demo page, built into the binary so the
+// server needs no files besides itself.
+//
+//go:embed static
+var staticFiles embed.FS
+
+// staticHandler serves the embedded assets under /static/.
+func staticHandler() http.Handler {
+	sub, err := fs.Sub(staticFiles, "static")
+	if err != nil {
+		panic(err)
+	}
+	return http.StripPrefix("/static/", http.FileServerFS(sub))
+}
+
+// handleDemo serves the live transcription demo page.
+func (s *Server) handleDemo(w http.ResponseWriter, r *http.Request) {
+	http.ServeFileFS(w, r, staticFiles, "static/index.html")
+}
@@ -0,0 +1,77 @@
+// Streams the microphone to /v1/stream as WebM Opus, showing partial results
+// in grey until their final result replaces them.
+const statusEl = document.getElementById("status");
+const errorEl = document.getElementById("error");
+const toggle = document.getElementById("toggle");
+const partial = document.getElementById("partial");
+const transcript = document.getElementById("transcript");
+let recorder = null;
+let socket = null;
+
+fetch("/readyz")
+  .then((r) => r.json())
+  .then((r) => (statusEl.textContent = "Server " + r.status))
+  .catch(() => (statusEl.textContent = "Server unreachable"));
+
+function showError(message) {
+  errorEl.textContent = message;
+  errorEl.hidden = false;
+}
+
+function stop() {
+  if (recorder && recorder.state !== "inactive") {
+    recorder.stop();
+    recorder.stream.getTracks().forEach((t) => t.stop());
+  }
+  // A text message ends the audio; results keep arriving until "end"
+  if (socket && socket.readyState === WebSocket.OPEN) {
+    socket.send("end");
+  }
+  recorder = null;
+  toggle.textContent = "Start";
+}
+
+async function start() {
+  errorEl.hidden = true;
+  const stream = await navigator.mediaDevices.getUserMedia({ audio: true });
+  const scheme = location.protocol === "https:" ? "wss:" : "ws:";
+  const language = encodeURIComponent(document.getElementById("language").value);
+  socket = new WebSocket(`${scheme}//${location.host}/v1/stream?encoding=opus&language=${language}`);
+  socket.binaryType = "arraybuffer";
+  socket.onmessage = (e) => {
+    const msg = JSON.parse(e.data);
+    switch (msg.type) {
+      case "partial":
+        partial.textContent = msg.text;
+        break;
+      case "final":
+        partial.textContent = "";
+        transcript.insertBefore(document.createTextNode(msg.text + " "), partial);
+        break;
+      case "error":
+        showError(msg.error);
+        break;
+    }
+  };
+  socket.onerror = () => showError("The server did not accept the stream; is it running with -ws?");
+  socket.onclose = () => stop();
+  socket.onopen = () => {
+    recorder = new MediaRecorder(stream, { mimeType: "audio/webm;codecs=opus" });
+    recorder.ondataavailable = (e) => {
+      if (e.data.size > 0 && socket.readyState === WebSocket.OPEN) {
+        socket.send(e.data);
+      }
+    };
+    recorder.start(250);
+    toggle.textContent = "Stop";
+  };
+}
+
+document.getElementById("controls").addEventListener("submit", (e) => {
+  e.preventDefault();
+  if (recorder) {
+    stop();
+  } else {
+    start().catch((err) => showError(err.message));
+  }
+});
@@ -0,0 +1,227 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"nhooyr.io/websocket"
+	"nhooyr.io/websocket/wsjson"
+
+	"stt-receivetranscription-mve/audio"
+	"stt-receivetranscription-mve/output"
+	"stt-receivetranscription-mve/stt"
+)
+
+// streamMessage is a message sent to a streaming client: a result as
+// written to json outputs, or the end of the session.
+type streamMessage struct {
+	// Type is "partial", "final", "queued", "error" or "end".
+	Type string `json:"type"`
+	*output.Record
+	// Job is the job of a session recorded to be transcribed later.
+	Job   *Job   `json:"job,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// handleStream transcribes audio streamed over a WebSocket in one streaming
+// session per connection. Binary messages carry the audio: raw 16-bit
+// little-endian PCM at the rate and channels query parameters, with
+// encoding=opus the WebM or Ogg Opus stream of a browser MediaRecorder, or
+// with encoding=opus-frames one Opus packet per message, such as the frames
+// of a WebRTC track, decoded to PCM at rate and channels. The frame
+// parameter gives their duration (default 20ms); an empty message stands
+// for a lost frame. A text message or closing the connection ends the
+// audio; results are sent back as JSON messages until the session ends.
+// With transcribe=later the audio is recorded instead, and the job
+// transcribing it later is sent back once it is queued.
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	later := false
+	switch q.Get("transcribe") {
+	case "", "live":
+	case "later":
+		if s.recordURI == "" {
+			http.Error(w, "transcribing later is not enabled", http.StatusBadRequest)
+			return
+		}
+		later = true
+	default:
+		http.Error(w, "transcribe must be live or later", http.StatusBadRequest)
+		return
+	}
+	config := s.config
+	if lang := q.Get("language"); lang != "" {
+		config.LanguageCodes = []string{lang}
+	}
+	if model := q.Get("model"); model != "" {
+		config.Model = model
+	}
+	encoding := q.Get("encoding")
+	rate, channels := 16000, 1
+	switch encoding {
+	case "", "pcm", "opus-frames":
+		var err error
+		if v := q.Get("rate"); v != "" {
+			if rate, err = strconv.Atoi(v); err != nil || rate <= 0 {
+				http.Error(w, "invalid rate", http.StatusBadRequest)
+				return
+			}
+		}
+		if v := q.Get("channels"); v != "" {
+			if channels, err = strconv.Atoi(v); err != nil || channels <= 0 {
+				http.Error(w, "invalid channels", http.StatusBadRequest)
+				return
+			}
+		}
+	case "opus":
+	default:
+		http.Error(w, "unsupported encoding "+strconv.Quote(encoding), http.StatusBadRequest)
+		return
+	}
+	var opus *audio.OpusDecoder
+	if encoding == "opus-frames" {
+		var frame time.Duration
+		var err error
+		if v := q.Get("frame"); v != "" {
+			if frame, err = time.ParseDuration(v); err != nil {
+				http.Error(w, "invalid frame", http.StatusBadRequest)
+				return
+			}
+		}
+		if opus, err = audio.NewOpusDecoder(rate, channels, frame); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	tags := map[string]string{}
+	for _, tag := range q["tag"] {
+		k, v, err := output.ParseTag(tag)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		tags[k] = v
+	}
+
+	conn, err := websocket.Accept(w, r, s.acceptOptions())
+	if err != nil {
+		log.Printf("Failed to accept WebSocket: %v", err)
+		return
+	}
+	defer conn.Close(websocket.StatusInternalError, "")
+	ctx := r.Context()
+
+	id := stt.NewSessionID()
+	// Audio is read from the socket while the session streams or records it
+	pr, pw := io.Pipe()
+	go func() {
+		if encoding != "opus" {
+			if _, err := pw.Write(audio.StreamHeader(rate, channels)); err != nil {
+				return
+			}
+		}
+		for {
+			typ, data, err := conn.Read(ctx)
+			switch {
+			case websocket.CloseStatus(err) == websocket.StatusNormalClosure || websocket.CloseStatus(err) == websocket.StatusGoingAway:
+				pw.Close()
+				return
+			case err != nil:
+				pw.CloseWithError(err)
+				return
+			case typ == websocket.MessageText:
+				// Any text message ends the audio
+				pw.Close()
+				return
+			}
+			if opus != nil {
+				if data, err = opus.Decode(data); err != nil {
+					log.Printf("Dropping Opus frame: %v", err)
+					continue
+				}
+			}
+			if _, err := pw.Write(data); err != nil {
+				return
+			}
+		}
+	}()
+	if later {
+		log.Printf("Recording session %s for %s", id, r.RemoteAddr)
+		job, err := s.recordLater(ctx, id, config, tags, pr)
+		pr.CloseWithError(io.ErrClosedPipe)
+		if err != nil {
+			log.Printf("Recording session %s failed: %v", id, err)
+			wsjson.Write(ctx, conn, streamMessage{Type: "error", Error: err.Error()})
+			conn.Close(websocket.StatusInternalError, "recording failed")
+			return
+		}
+		log.Printf("Queued session %s for transcription from %s", id, job.URI)
+		wsjson.Write(ctx, conn, streamMessage{Type: "queued", Job: &job})
+		wsjson.Write(ctx, conn, streamMessage{Type: "end"})
+		conn.Close(websocket.StatusNormalClosure, "")
+		return
+	}
+
+	// 100ms of PCM keeps latency low; Opus is far more compact
+	chunkSize := rate * channels * 2 / 10
+	if encoding == "opus" {
+		chunkSize, rate = 1024, 0
+	}
+	var sink output.Sink
+	if s.store != nil {
+		sink = output.WithSession(s.store, output.Session{ID: id, Tags: tags})
+	}
+	send := func(typ string, result stt.Result) {
+		record := output.FromResult(result)
+		record.SessionID = id
+		if err := wsjson.Write(ctx, conn, streamMessage{Type: typ, Record: &record}); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to send result to WebSocket: %v", err)
+		}
+		if sink != nil && result.IsFinal {
+			if err := sink.Write(ctx, record); err != nil {
+				log.Printf("Failed to persist result: %v", err)
+			}
+		}
+	}
+
+	log.Printf("Streaming session %s started for %s", id, r.RemoteAddr)
+	err = s.stream(ctx, id, config, rate, channels, chunkSize, pr, func(r stt.Result) {
+		if r.IsFinal {
+			send("final", r)
+		} else {
+			send("partial", r)
+		}
+	})
+	pr.CloseWithError(io.ErrClosedPipe)
+
+	if err != nil && !errors.Is(err, context.Canceled) {
+		log.Printf("Streaming session %s failed: %v", id, err)
+		wsjson.Write(ctx, conn, streamMessage{Type: "error", Error: err.Error()})
+		conn.Close(websocket.StatusInternalError, "recognition failed")
+		return
+	}
+	wsjson.Write(ctx, conn, streamMessage{Type: "end"})
+	conn.Close(websocket.StatusNormalClosure, "")
+}
+
+// acceptOptions allows WebSocket connections from the CORS origins besides
+// the server's own.
+func (s *Server) acceptOptions() *websocket.AcceptOptions {
+	opts := &websocket.AcceptOptions{}
+	for _, origin := range s.corsOrigins {
+		if origin == "*" {
+			opts.InsecureSkipVerify = true
+			continue
+		}
+		if u, err := url.Parse(origin); err == nil && u.Host != "" {
+			opts.OriginPatterns = append(opts.OriginPatterns, u.Host)
+		}
+	}
+	return opts
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"nhooyr.io/websocket"
+	"nhooyr.io/websocket/wsjson"
+
+	"stt-receivetranscription-mve/audio"
+	"stt-receivetranscription-mve/output"
+	"stt-receivetranscription-mve/stt"
+)
+
+// twilioMessage is a message of Twilio's Media Streams protocol.
+type twilioMessage struct {
+	// Event is "connected", "start", "media", "mark", "dtmf" or "stop".
+	Event string `json:"event"`
+	Start struct {
+		CallSid          string            `json:"callSid"`
+		StreamSid        string            `json:"streamSid"`
+		CustomParameters map[string]string `json:"customParameters"`
+		MediaFormat      struct {
+			Encoding   string `json:"encoding"`
+			SampleRate int    `json:"sampleRate"`
+			Channels   int    `json:"channels"`
+		} `json:"mediaFormat"`
+	} `json:"start"`
+	Media struct {
+		Track   string `json:"track"`
+		Payload string `json:"payload"`
+	} `json:"media"`
+}
+
+// handleTwilio transcribes a call streamed by a Twilio <Stream> in one
+// streaming session per call. The session starts with the "start" event,
+// which must announce 8-bit μ-law audio; "media" payloads of the call's
+// inbound track are decoded to PCM and streamed, and "stop" or closing the
+// connection ends the audio. Custom parameters named "language" and "model"
+// override the defaults; the others, the call SID and the stream SID tag the
+// stored session. Twilio takes no results back, so final results are logged
+// and stored.
+func (s *Server) handleTwilio(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		log.Printf("Failed to accept Twilio WebSocket: %v", err)
+		return
+	}
+	defer conn.Close(websocket.StatusInternalError, "")
+	ctx := r.Context()
+
+	var start twilioMessage
+	for start.Event != "start" {
+		if err := wsjson.Read(ctx, conn, &start); err != nil {
+			log.Printf("Twilio stream ended before it started: %v", err)
+			return
+		}
+	}
+	format := start.Start.MediaFormat
+	if format.Encoding != "audio/x-mulaw" || format.Channels > 1 {
+		log.Printf("Unsupported Twilio media format %s with %d channels", format.Encoding, format.Channels)
+		conn.Close(websocket.StatusUnsupportedData, "unsupported media format")
+		return
+	}
+	rate := format.SampleRate
+	if rate <= 0 {
+		rate = 8000
+	}
+
+	config := s.config
+	tags := map[string]string{"call_sid": start.Start.CallSid, "stream_sid": start.Start.StreamSid}
+	for k, v := range start.Start.CustomParameters {
+		switch k {
+		case "language":
+			config.LanguageCodes = []string{v}
+		case "model":
+			config.Model = v
+		default:
+			tags[k] = v
+		}
+	}
+
+	id := stt.NewSessionID()
+	var sink output.Sink
+	if s.store != nil {
+		sink = output.WithSession(s.store, output.Session{ID: id, Tags: tags})
+	}
+	final := func(result stt.Result) {
+		if !result.IsFinal {
+			return
+		}
+		log.Printf("Call %s: %s", start.Start.CallSid, result.Text)
+		if sink == nil {
+			return
+		}
+		record := output.FromResult(result)
+		record.SessionID = id
+		if err := sink.Write(ctx, record); err != nil {
+			log.Printf("Failed to persist result: %v", err)
+		}
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		if _, err := pw.Write(audio.StreamHeader(rate, 1)); err != nil {
+			return
+		}
+		for {
+			var msg twilioMessage
+			err := wsjson.Read(ctx, conn, &msg)
+			switch {
+			case websocket.CloseStatus(err) == websocket.StatusNormalClosure || websocket.CloseStatus(err) == websocket.StatusGoingAway:
+				pw.Close()
+				return
+			case err != nil:
+				pw.CloseWithError(err)
+				return
+			}
+			switch msg.Event {
+			case "stop":
+				pw.Close()
+				return
+			case "media":
+				// Bidirectional streams also carry the outbound track
+				if msg.Media.Track != "" && msg.Media.Track != "inbound" {
+					continue
+				}
+				mulaw, err := base64.StdEncoding.DecodeString(msg.Media.Payload)
+				if err != nil {
+					pw.CloseWithError(fmt.Errorf("invalid media payload: %w", err))
+					return
+				}
+				if _, err := pw.Write(audio.DecodeMULaw(mulaw)); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	log.Printf("Transcribing Twilio call %s in session %s", start.Start.CallSid, id)
+	// Chunks of 100ms of decoded audio
+	err = s.stream(ctx, id, config, rate, 1, rate*2/10, pr, final)
+	pr.CloseWithError(io.ErrClosedPipe)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		log.Printf("Twilio session %s failed: %v", id, err)
+		conn.Close(websocket.StatusInternalError, "recognition failed")
+		return
+	}
+	conn.Close(websocket.StatusNormalClosure, "")
+}
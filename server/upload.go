@@ -0,0 +1,139 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"log"
+	"net/http"
+
+	"stt-receivetranscription-mve/output"
+	"stt-receivetranscription-mve/stt"
+)
+
+// maxTranscribeUpload bounds uploads to /transcribe by default; audio
+// beyond the one-shot limits is transcribed with batch recognition.
+const maxTranscribeUpload = 480 << 20
+
+// transcribeResponse is the response of /transcribe.
+type transcribeResponse struct {
+	// SessionID identifies the stored session, if the server has a store,
+	// and the session scored at /v1/canary/{id}/reference under a canary.
+	SessionID string   `json:"session_id,omitempty"`
+	Mode      stt.Mode `json:"mode"`
+	// CanaryArm is the canary arm the session was routed to, if any.
+	CanaryArm string `json:"canary_arm,omitempty"`
+	stt.Transcript
+}
+
+// handleTranscribe transcribes the audio uploaded in the "file" field of a
+// multipart form and returns the transcript as JSON. The upload is spilled
+// to disk as it arrives. Audio within the one-shot limits is recognized
+// directly; longer audio is staged in Cloud Storage for batch recognition.
+// The optional "language" and "model" fields override the defaults and
+// "tag" fields tag the stored session. Under a canary, a "reference" field
+// with the known transcript of the audio scores the session right away.
+func (s *Server) handleTranscribe(w http.ResponseWriter, r *http.Request) {
+	u, err := readUpload(w, r, cmp.Or(s.maxUpload, maxTranscribeUpload))
+	if err != nil {
+		writeJSON(w, uploadStatus(err), errorResponse{err.Error()})
+		return
+	}
+	defer u.remove()
+	// Only audio small enough for one-shot recognition is read into memory
+	var audio []byte
+	if u.size <= stt.OneShotMaxBytes {
+		if audio, err = u.bytes(); err != nil {
+			writeJSON(w, http.StatusInternalServerError, errorResponse{err.Error()})
+			return
+		}
+	}
+
+	config := s.config
+	if lang := r.FormValue("language"); lang != "" {
+		config.LanguageCodes = []string{lang}
+	}
+	if model := r.FormValue("model"); model != "" {
+		config.Model = model
+	}
+	config.WordTimeOffsets = true
+
+	mode := stt.ModeOneShot
+	if audio == nil || !stt.FitsOneShot(audio) {
+		if s.stagingURI == "" {
+			writeJSON(w, http.StatusRequestEntityTooLarge, errorResponse{"audio is not WAV within the one-shot recognition limits and no staging URI is configured for batch recognition"})
+			return
+		}
+		mode = stt.ModeBatch
+	}
+
+	if s.budget != nil {
+		if err := s.budget.Allow(r.Context()); err != nil {
+			writeJSON(w, recognitionStatus(err), errorResponse{err.Error()})
+			return
+		}
+	}
+	id := stt.NewSessionID()
+	config, arm := s.routeCanary(id, config)
+	recognize := func() (stt.Transcript, error) {
+		t, err := s.recognize(r.Context(), config, arm, mode, audio, u)
+		s.observe(id, arm, config, t, err)
+		if s.budget != nil && err == nil {
+			d, _ := stt.WAVSizeDuration(u.header(), u.size)
+			if err := s.budget.Add(r.Context(), d); err != nil {
+				log.Printf("Failed to charge transcription to the usage budget: %v", err)
+			}
+		}
+		return t, err
+	}
+	var transcript stt.Transcript
+	if s.dedupe != nil {
+		var key string
+		if key, err = fingerprint(config, u.reader()); err != nil {
+			writeJSON(w, http.StatusInternalServerError, errorResponse{err.Error()})
+			return
+		}
+		transcript, err = s.dedupe.do(r.Context(), key, recognize)
+	} else {
+		transcript, err = recognize()
+	}
+	if ref := r.FormValue("reference"); ref != "" && arm != "" && err == nil {
+		s.canary.Score(id, ref)
+	}
+	if err != nil {
+		log.Printf("Transcription failed: %v", err)
+		writeJSON(w, recognitionStatus(err), errorResponse{err.Error()})
+		return
+	}
+
+	resp := transcribeResponse{Mode: mode, CanaryArm: arm, Transcript: transcript}
+	if arm != "" {
+		resp.SessionID = id
+	}
+	if s.store != nil {
+		if err := s.persist(r, id, output.FromTranscript(transcript)); err != nil {
+			log.Printf("Failed to persist transcription: %v", err)
+		} else {
+			resp.SessionID = id
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// recognize transcribes the upload routed to canary arm with one-shot
+// recognition of audio, its contents, or with batch recognition of a copy
+// staged under the staging URI.
+func (s *Server) recognize(ctx context.Context, config stt.Config, arm string, mode stt.Mode, audio []byte, u *upload) (stt.Transcript, error) {
+	if mode == stt.ModeOneShot {
+		return stt.Recognize(ctx, s.clientFor(arm), config, audio)
+	}
+	uri, remove, err := stt.StageReader(ctx, s.stagingURI, u.reader())
+	if err != nil {
+		return stt.Transcript{}, err
+	}
+	defer func() {
+		if err := remove(context.WithoutCancel(ctx)); err != nil {
+			log.Printf("Failed to delete staged audio %s: %v", uri, err)
+		}
+	}()
+	return stt.BatchRecognize(ctx, s.clientFor(arm), config, uri, stt.BatchOptions{})
+}
@@ -0,0 +1,213 @@
+package store
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"stt-receivetranscription-mve/output"
+)
+
+// archiveVersion is bumped whenever the archive layout changes incompatibly.
+const archiveVersion = 1
+
+// Manifest describes the contents of an export archive.
+type Manifest struct {
+	Version    int               `json:"version"`
+	ExportedAt time.Time         `json:"exported_at"`
+	Sessions   []ManifestSession `json:"sessions"`
+}
+
+// ManifestSession locates one session's files within an archive.
+type ManifestSession struct {
+	Session
+	// Records is the archive path of the session's records as JSON.
+	Records string `json:"records"`
+	// AudioFile is the archive path of the session's audio, if included.
+	AudioFile string `json:"audio_file,omitempty"`
+}
+
+// Export writes the sessions with the given IDs to w as a zip archive with a
+// JSON manifest. With includeAudio, audio files referenced by local path are
+// copied into the archive.
+func (s *Store) Export(ctx context.Context, w io.Writer, ids []string, includeAudio bool) (*Manifest, error) {
+	zw := zip.NewWriter(w)
+	manifest := &Manifest{Version: archiveVersion, ExportedAt: time.Now().UTC()}
+
+	for _, id := range ids {
+		sess, err := s.Session(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read session %s: %w", id, err)
+		}
+		records, err := s.Records(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read records of session %s: %w", id, err)
+		}
+
+		entry := ManifestSession{Session: sess, Records: path.Join("sessions", id+".json")}
+		if err := writeZipJSON(zw, entry.Records, records); err != nil {
+			return nil, err
+		}
+		if includeAudio && sess.Audio != "" {
+			if _, err := os.Stat(sess.Audio); err == nil {
+				entry.AudioFile = path.Join("audio", id, filepath.Base(sess.Audio))
+				if err := writeZipFile(zw, entry.AudioFile, sess.Audio); err != nil {
+					return nil, err
+				}
+			}
+		}
+		manifest.Sessions = append(manifest.Sessions, entry)
+	}
+
+	if err := writeZipJSON(zw, "manifest.json", manifest); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish archive: %w", err)
+	}
+	return manifest, nil
+}
+
+// Import loads every session of the archive at archivePath that is not yet in
+// the store. Archived audio is extracted into audioDir, which becomes the
+// imported sessions' audio reference; with an empty audioDir audio is skipped.
+func (s *Store) Import(ctx context.Context, archivePath, audioDir string) (imported int, err error) {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer zr.Close()
+
+	var manifest Manifest
+	if err := readZipJSON(&zr.Reader, "manifest.json", &manifest); err != nil {
+		return 0, err
+	}
+	if manifest.Version != archiveVersion {
+		return 0, fmt.Errorf("unsupported archive version %d", manifest.Version)
+	}
+
+	for _, entry := range manifest.Sessions {
+		if _, err := s.Session(ctx, entry.ID); err == nil {
+			continue
+		}
+		var records []output.Record
+		if err := readZipJSON(&zr.Reader, entry.Records, &records); err != nil {
+			return imported, err
+		}
+
+		sess := entry.Session
+		if entry.AudioFile != "" && audioDir != "" {
+			// Base names keep extracted files inside audioDir
+			dst := filepath.Join(audioDir, filepath.Base(entry.ID), path.Base(entry.AudioFile))
+			if err := extractZipFile(&zr.Reader, entry.AudioFile, dst); err != nil {
+				return imported, err
+			}
+			sess.Audio = dst
+		}
+		if err := s.insertSession(ctx, sess, records); err != nil {
+			return imported, fmt.Errorf("failed to import session %s: %w", entry.ID, err)
+		}
+		imported++
+	}
+	return imported, nil
+}
+
+// insertSession stores a complete session in one transaction, keeping its
+// original creation time.
+func (s *Store) insertSession(ctx context.Context, sess Session, records []output.Record) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	tags, err := json.Marshal(sess.Tags)
+	if err != nil {
+		return fmt.Errorf("failed to marshal tags: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO sessions (id, created_at, tags, audio) VALUES (?, ?, ?, ?)`,
+		sess.ID, sess.CreatedAt.UnixMilli(), string(tags), sess.Audio); err != nil {
+		return fmt.Errorf("failed to insert session: %w", err)
+	}
+	for k, v := range sess.Tags {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO session_tags (session_id, key, value) VALUES (?, ?, ?)`,
+			sess.ID, k, v); err != nil {
+			return fmt.Errorf("failed to insert session tag: %w", err)
+		}
+	}
+	for _, r := range records {
+		if _, err := insertSegment(ctx, tx, sess.ID, r); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func writeZipJSON(zw *zip.Writer, name string, v any) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to add %s to archive: %w", name, err)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+func writeZipFile(zw *zip.Writer, name, src string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open audio: %w", err)
+	}
+	defer f.Close()
+	w, err := zw.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to add %s to archive: %w", name, err)
+	}
+	if _, err := io.Copy(w, f); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+func readZipJSON(zr *zip.Reader, name string, v any) error {
+	f, err := zr.Open(name)
+	if err != nil {
+		return fmt.Errorf("failed to open %s in archive: %w", name, err)
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(v); err != nil {
+		return fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	return nil
+}
+
+func extractZipFile(zr *zip.Reader, name, dst string) error {
+	f, err := zr.Open(name)
+	if err != nil {
+		return fmt.Errorf("failed to open %s in archive: %w", name, err)
+	}
+	defer f.Close()
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return fmt.Errorf("failed to create audio directory: %w", err)
+	}
+	out, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("failed to create audio file: %w", err)
+	}
+	if _, err := io.Copy(out, f); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to extract %s: %w", name, err)
+	}
+	return out.Close()
+}
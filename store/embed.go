@@ -0,0 +1,286 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Embedder computes embedding vectors for transcript text.
+type Embedder interface {
+	// Model names the embedding model; vectors from different models are
+	// never compared.
+	Model() string
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// NewEmbedder creates an embedding backend from a backend:model spec.
+//
+// Supported backends are "openai" (any OpenAI-compatible /v1/embeddings API,
+// configured with OPENAI_BASE_URL and OPENAI_API_KEY) and "ollama" (a local
+// Ollama server at OLLAMA_HOST, default http://localhost:11434).
+func NewEmbedder(spec string) (Embedder, error) {
+	backend, model, ok := strings.Cut(spec, ":")
+	if !ok || model == "" {
+		return nil, fmt.Errorf("invalid embedder %q: expected backend:model", spec)
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	switch backend {
+	case "openai":
+		baseURL := os.Getenv("OPENAI_BASE_URL")
+		if baseURL == "" {
+			baseURL = "https://api.openai.com/v1"
+		}
+		return &openAIEmbedder{client: client, baseURL: baseURL, apiKey: os.Getenv("OPENAI_API_KEY"), model: model}, nil
+	case "ollama":
+		host := os.Getenv("OLLAMA_HOST")
+		if host == "" {
+			host = "http://localhost:11434"
+		}
+		return &ollamaEmbedder{client: client, host: host, model: model}, nil
+	default:
+		return nil, fmt.Errorf("unknown embedder backend %q", backend)
+	}
+}
+
+type openAIEmbedder struct {
+	client  *http.Client
+	baseURL string
+	apiKey  string
+	model   string
+}
+
+func (e *openAIEmbedder) Model() string { return "openai:" + e.model }
+
+func (e *openAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	var resp struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	body := map[string]any{"model": e.model, "input": texts}
+	if err := postJSON(ctx, e.client, strings.TrimSuffix(e.baseURL, "/")+"/embeddings", e.apiKey, body, &resp); err != nil {
+		return nil, err
+	}
+	vectors := make([][]float32, len(texts))
+	for _, d := range resp.Data {
+		if d.Index < 0 || d.Index >= len(vectors) {
+			return nil, fmt.Errorf("embedding index %d out of range", d.Index)
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	return vectors, nil
+}
+
+type ollamaEmbedder struct {
+	client *http.Client
+	host   string
+	model  string
+}
+
+func (e *ollamaEmbedder) Model() string { return "ollama:" + e.model }
+
+func (e *ollamaEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	var resp struct {
+		Embeddings [][]float32 `json:"embeddings"`
+	}
+	body := map[string]any{"model": e.model, "input": texts}
+	if err := postJSON(ctx, e.client, strings.TrimSuffix(e.host, "/")+"/api/embed", "", body, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(resp.Embeddings))
+	}
+	return resp.Embeddings, nil
+}
+
+func postJSON(ctx context.Context, client *http.Client, url, token string, body, out any) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call embedder: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("embedder returned status %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode embedder response: %w", err)
+	}
+	return nil
+}
+
+// SetEmbedder makes Write compute embeddings for every stored segment.
+func (s *Store) SetEmbedder(e Embedder) {
+	s.embedder = e
+}
+
+func (s *Store) storeEmbedding(ctx context.Context, segmentID int64, text string) error {
+	vectors, err := s.embedder.Embed(ctx, []string{text})
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx,
+		`INSERT OR REPLACE INTO segment_embeddings (segment_id, model, vector) VALUES (?, ?, ?)`,
+		segmentID, s.embedder.Model(), encodeVector(vectors[0]))
+	if err != nil {
+		return fmt.Errorf("failed to store embedding: %w", err)
+	}
+	return nil
+}
+
+// EmbedMissing computes embeddings with e for every segment that has none
+// from that model yet, and returns how many were added.
+func (s *Store) EmbedMissing(ctx context.Context, e Embedder) (int, error) {
+	const batchSize = 64
+	total := 0
+	for {
+		rows, err := s.db.QueryContext(ctx, `
+			SELECT g.id, g.transcript FROM segments g
+			WHERE NOT EXISTS (SELECT 1 FROM segment_embeddings m WHERE m.segment_id = g.id AND m.model = ?)
+			ORDER BY g.id LIMIT ?`, e.Model(), batchSize)
+		if err != nil {
+			return total, fmt.Errorf("failed to query segments: %w", err)
+		}
+		var (
+			ids   []int64
+			texts []string
+		)
+		for rows.Next() {
+			var (
+				id   int64
+				text string
+			)
+			if err := rows.Scan(&id, &text); err != nil {
+				rows.Close()
+				return total, fmt.Errorf("failed to scan segment: %w", err)
+			}
+			ids = append(ids, id)
+			texts = append(texts, text)
+		}
+		rows.Close()
+		if len(ids) == 0 {
+			return total, nil
+		}
+
+		vectors, err := e.Embed(ctx, texts)
+		if err != nil {
+			return total, err
+		}
+		for i, id := range ids {
+			if _, err := s.db.ExecContext(ctx,
+				`INSERT OR REPLACE INTO segment_embeddings (segment_id, model, vector) VALUES (?, ?, ?)`,
+				id, e.Model(), encodeVector(vectors[i])); err != nil {
+				return total, fmt.Errorf("failed to store embedding: %w", err)
+			}
+		}
+		total += len(ids)
+	}
+}
+
+// SemanticSearch returns the segments whose embeddings are most similar to
+// the embedding of query. Snippet holds the similarity score.
+func (s *Store) SemanticSearch(ctx context.Context, e Embedder, query string, limit int) ([]Match, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	vectors, err := e.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, err
+	}
+	q := vectors[0]
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT g.session_id, g.id, g.end_offset, g.transcript, s.audio, m.vector,
+			g.start_offset
+		FROM segment_embeddings m
+		JOIN segments g ON g.id = m.segment_id
+		JOIN sessions s ON s.id = g.session_id
+		WHERE m.model = ?`, e.Model())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query embeddings: %w", err)
+	}
+	defer rows.Close()
+
+	type scored struct {
+		Match
+		score float64
+	}
+	var results []scored
+	for rows.Next() {
+		var (
+			m          Match
+			start, end int64
+			vector     []byte
+		)
+		if err := rows.Scan(&m.SessionID, &m.SegmentID, &end, &m.Text, &m.Audio, &vector, &start); err != nil {
+			return nil, fmt.Errorf("failed to scan embedding: %w", err)
+		}
+		m.Start = time.Duration(start)
+		m.End = time.Duration(end)
+		results = append(results, scored{Match: m, score: cosine(q, decodeVector(vector))})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].score > results[j].score })
+	var matches []Match
+	for _, r := range results[:min(limit, len(results))] {
+		r.Snippet = fmt.Sprintf("%.3f", r.score)
+		matches = append(matches, r.Match)
+	}
+	return matches, nil
+}
+
+func encodeVector(v []float32) []byte {
+	b := make([]byte, 4*len(v))
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(f))
+	}
+	return b
+}
+
+func decodeVector(b []byte) []float32 {
+	v := make([]float32, len(b)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	return v
+}
+
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
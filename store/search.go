@@ -0,0 +1,81 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Match is a stored segment matching a search query.
+type Match struct {
+	SessionID string        `json:"session_id"`
+	SegmentID int64         `json:"segment_id"`
+	Start     time.Duration `json:"start"`
+	End       time.Duration `json:"end"`
+	Text      string        `json:"text"`
+	// Snippet is the transcript with matching terms wrapped in [ and ].
+	Snippet string `json:"snippet"`
+	Audio   string `json:"audio,omitempty"`
+}
+
+// Search returns segments containing every term of query, best matches first.
+// Terms are matched as literal words; prefix matches use a trailing *.
+func (s *Store) Search(ctx context.Context, query string, limit int) ([]Match, error) {
+	expr := ftsExpression(query)
+	if expr == "" {
+		return nil, fmt.Errorf("empty search query")
+	}
+	if limit <= 0 {
+		limit = 50
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT g.session_id, g.id, g.end_offset, g.transcript,
+			snippet(segments_fts, 0, '[', ']', '…', 16), s.audio,
+			g.start_offset
+		FROM segments_fts
+		JOIN segments g ON g.id = segments_fts.rowid
+		JOIN sessions s ON s.id = g.session_id
+		WHERE segments_fts MATCH ?
+		ORDER BY rank
+		LIMIT ?`, expr, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search transcripts: %w", err)
+	}
+	defer rows.Close()
+
+	var matches []Match
+	for rows.Next() {
+		var (
+			m          Match
+			start, end int64
+		)
+		if err := rows.Scan(&m.SessionID, &m.SegmentID, &end, &m.Text, &m.Snippet, &m.Audio, &start); err != nil {
+			return nil, fmt.Errorf("failed to scan match: %w", err)
+		}
+		m.Start = time.Duration(start)
+		m.End = time.Duration(end)
+		matches = append(matches, m)
+	}
+	return matches, rows.Err()
+}
+
+// ftsExpression quotes every term of a user query so FTS5 operators and
+// punctuation are matched literally.
+func ftsExpression(query string) string {
+	var terms []string
+	for _, term := range strings.Fields(query) {
+		prefix := strings.HasSuffix(term, "*")
+		term = strings.ReplaceAll(strings.TrimSuffix(term, "*"), `"`, `""`)
+		if term == "" {
+			continue
+		}
+		quoted := `"` + term + `"`
+		if prefix {
+			quoted += "*"
+		}
+		terms = append(terms, quoted)
+	}
+	return strings.Join(terms, " ")
+}
@@ -0,0 +1,377 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"stt-receivetranscription-mve/output"
+)
+
+// migrations are applied in order; PRAGMA user_version records how many
+// have run against a database.
+var migrations = []string{`
+CREATE TABLE IF NOT EXISTS sessions (
+	id         TEXT PRIMARY KEY,
+	created_at INTEGER NOT NULL,
+	tags       TEXT NOT NULL DEFAULT '{}'
+);
+CREATE INDEX IF NOT EXISTS sessions_created ON sessions (created_at, id);
+CREATE TABLE IF NOT EXISTS session_tags (
+	session_id TEXT NOT NULL REFERENCES sessions (id),
+	key        TEXT NOT NULL,
+	value      TEXT NOT NULL,
+	PRIMARY KEY (session_id, key)
+);
+CREATE INDEX IF NOT EXISTS session_tags_kv ON session_tags (key, value);
+CREATE TABLE IF NOT EXISTS segments (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	session_id  TEXT NOT NULL REFERENCES sessions (id),
+	received_at INTEGER NOT NULL,
+	end_offset  INTEGER NOT NULL,
+	transcript  TEXT NOT NULL,
+	confidence  REAL NOT NULL,
+	language    TEXT NOT NULL DEFAULT '',
+	words       TEXT NOT NULL DEFAULT '[]'
+);
+CREATE INDEX IF NOT EXISTS segments_session ON segments (session_id, id);
+`, `
+ALTER TABLE sessions ADD COLUMN audio TEXT NOT NULL DEFAULT '';
+CREATE VIRTUAL TABLE segments_fts USING fts5 (transcript, content='segments', content_rowid='id');
+CREATE TRIGGER segments_fts_insert AFTER INSERT ON segments BEGIN
+	INSERT INTO segments_fts (rowid, transcript) VALUES (new.id, new.transcript);
+END;
+CREATE TRIGGER segments_fts_delete AFTER DELETE ON segments BEGIN
+	INSERT INTO segments_fts (segments_fts, rowid, transcript) VALUES ('delete', old.id, old.transcript);
+END;
+INSERT INTO segments_fts (segments_fts) VALUES ('rebuild');
+`, `
+CREATE TABLE segment_embeddings (
+	segment_id INTEGER PRIMARY KEY REFERENCES segments (id),
+	model      TEXT NOT NULL,
+	vector     BLOB NOT NULL
+);
+CREATE INDEX segment_embeddings_model ON segment_embeddings (model);
+`, `
+ALTER TABLE segments ADD COLUMN start_offset INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE segments ADD COLUMN speaker TEXT NOT NULL DEFAULT '';
+UPDATE segments SET start_offset = COALESCE((SELECT MAX(p.end_offset) FROM segments p
+	WHERE p.session_id = segments.session_id AND p.id < segments.id), 0);
+`, `
+CREATE TABLE usage (
+	period       TEXT PRIMARY KEY,
+	billed_nanos INTEGER NOT NULL
+);
+`, `
+ALTER TABLE segments ADD COLUMN event TEXT NOT NULL DEFAULT '';
+`}
+
+// Session is the stored metadata of a transcription session.
+type Session struct {
+	ID        string            `json:"id"`
+	CreatedAt time.Time         `json:"created_at"`
+	Tags      map[string]string `json:"tags,omitempty"`
+	Audio     string            `json:"audio,omitempty"`
+	Segments  int               `json:"segments"`
+}
+
+// Store persists sessions and their final results in a SQLite database.
+type Store struct {
+	db       *sql.DB
+	embedder Embedder
+}
+
+// Open opens or creates the database at path.
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store: %w", err)
+	}
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize store: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Write stores a final record, creating its session on first use. Partial
+// results, and unstable finals flushed from them, are not persisted, since
+// the real final result follows. Write makes the store usable as an
+// output.Sink.
+func (s *Store) Write(ctx context.Context, r output.Record) error {
+	if !r.IsFinal || r.Unstable {
+		return nil
+	}
+	if r.SessionID == "" {
+		return fmt.Errorf("record has no session ID")
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := createSession(ctx, tx, r); err != nil {
+		return err
+	}
+
+	id, err := insertSegment(ctx, tx, r.SessionID, r)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if s.embedder != nil {
+		if err := s.storeEmbedding(ctx, id, r.Text); err != nil {
+			// The segment stays searchable by text; EmbedMissing backfills it.
+			log.Printf("Failed to embed segment %d: %v", id, err)
+		}
+	}
+	return nil
+}
+
+func insertSegment(ctx context.Context, tx *sql.Tx, sessionID string, r output.Record) (int64, error) {
+	words, err := json.Marshal(r.Words)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal words: %w", err)
+	}
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO segments (session_id, received_at, start_offset, end_offset, transcript, confidence, language, speaker, words, event)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		sessionID, r.Time.UnixMilli(), int64(r.Start), int64(r.End), r.Text, r.Confidence, r.Language, r.Speaker, string(words), r.Event)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert segment: %w", err)
+	}
+	return res.LastInsertId()
+}
+
+func createSession(ctx context.Context, tx *sql.Tx, r output.Record) error {
+	tags, err := json.Marshal(r.Tags)
+	if err != nil {
+		return fmt.Errorf("failed to marshal tags: %w", err)
+	}
+	res, err := tx.ExecContext(ctx,
+		`INSERT OR IGNORE INTO sessions (id, created_at, tags, audio) VALUES (?, ?, ?, ?)`,
+		r.SessionID, r.Time.UnixMilli(), string(tags), r.Audio)
+	if err != nil {
+		return fmt.Errorf("failed to insert session: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+	for k, v := range r.Tags {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO session_tags (session_id, key, value) VALUES (?, ?, ?)`,
+			r.SessionID, k, v); err != nil {
+			return fmt.Errorf("failed to insert session tag: %w", err)
+		}
+	}
+	return nil
+}
+
+// Query filters and paginates ListSessions.
+type Query struct {
+	// Tags restricts results to sessions carrying every key/value pair.
+	Tags map[string]string
+	// Since and Until bound the session creation time when non-zero.
+	Since, Until time.Time
+	// Limit caps the page size; zero means 50.
+	Limit int
+	// Cursor continues a previous listing from its NextCursor.
+	Cursor string
+}
+
+// ErrInvalidCursor is returned by ListSessions for a Query.Cursor that is not
+// the NextCursor of a previous listing.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ListSessions returns sessions newest first, plus a cursor for the next page
+// which is empty when there are no more results.
+func (s *Store) ListSessions(ctx context.Context, q Query) ([]Session, string, error) {
+	limit := q.Limit
+	if limit <= 0 {
+		limit = 50
+	}
+
+	var (
+		where []string
+		args  []any
+	)
+	for k, v := range q.Tags {
+		where = append(where, `EXISTS (SELECT 1 FROM session_tags t WHERE t.session_id = s.id AND t.key = ? AND t.value = ?)`)
+		args = append(args, k, v)
+	}
+	if !q.Since.IsZero() {
+		where = append(where, `s.created_at >= ?`)
+		args = append(args, q.Since.UnixMilli())
+	}
+	if !q.Until.IsZero() {
+		where = append(where, `s.created_at < ?`)
+		args = append(args, q.Until.UnixMilli())
+	}
+	if q.Cursor != "" {
+		createdAt, id, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		where = append(where, `(s.created_at < ? OR (s.created_at = ? AND s.id < ?))`)
+		args = append(args, createdAt, createdAt, id)
+	}
+
+	query := `SELECT s.id, s.created_at, s.tags, s.audio,
+		(SELECT COUNT(*) FROM segments g WHERE g.session_id = s.id)
+		FROM sessions s`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += ` ORDER BY s.created_at DESC, s.id DESC LIMIT ?`
+	args = append(args, limit+1)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		sess, err := scanSession(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		sessions = append(sessions, sess)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	var next string
+	if len(sessions) > limit {
+		sessions = sessions[:limit]
+		last := sessions[limit-1]
+		next = encodeCursor(last.CreatedAt.UnixMilli(), last.ID)
+	}
+	return sessions, next, nil
+}
+
+// Session returns a single session, or sql.ErrNoRows if it does not exist.
+func (s *Store) Session(ctx context.Context, id string) (Session, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT s.id, s.created_at, s.tags, s.audio,
+		(SELECT COUNT(*) FROM segments g WHERE g.session_id = s.id)
+		FROM sessions s WHERE s.id = ?`, id)
+	return scanSession(row)
+}
+
+// Records returns the stored final records of a session in arrival order.
+func (s *Store) Records(ctx context.Context, id string) ([]output.Record, error) {
+	sess, err := s.Session(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT received_at, start_offset, end_offset, transcript, confidence, language, speaker, words, event
+		 FROM segments WHERE session_id = ? ORDER BY id`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query segments: %w", err)
+	}
+	defer rows.Close()
+
+	var records []output.Record
+	for rows.Next() {
+		var (
+			receivedAt, start, end int64
+			words                  string
+		)
+		r := output.Record{SessionID: sess.ID, Tags: sess.Tags, Audio: sess.Audio}
+		r.IsFinal = true
+		if err := rows.Scan(&receivedAt, &start, &end, &r.Text, &r.Confidence, &r.Language, &r.Speaker, &words, &r.Event); err != nil {
+			return nil, fmt.Errorf("failed to scan segment: %w", err)
+		}
+		r.Time = time.UnixMilli(receivedAt)
+		r.Start = time.Duration(start)
+		r.End = time.Duration(end)
+		if err := json.Unmarshal([]byte(words), &r.Words); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal words: %w", err)
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSession(row scanner) (Session, error) {
+	var (
+		sess      Session
+		createdAt int64
+		tags      string
+	)
+	if err := row.Scan(&sess.ID, &createdAt, &tags, &sess.Audio, &sess.Segments); err != nil {
+		return Session{}, err
+	}
+	sess.CreatedAt = time.UnixMilli(createdAt)
+	if err := json.Unmarshal([]byte(tags), &sess.Tags); err != nil {
+		return Session{}, fmt.Errorf("failed to unmarshal tags: %w", err)
+	}
+	return sess, nil
+}
+
+func encodeCursor(createdAt int64, id string) string {
+	return base64.RawURLEncoding.EncodeToString(fmt.Appendf(nil, "%d:%s", createdAt, id))
+}
+
+func decodeCursor(cursor string) (int64, string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, "", fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+	var createdAt int64
+	ts, id, ok := strings.Cut(string(b), ":")
+	if _, err := fmt.Sscan(ts, &createdAt); !ok || err != nil {
+		return 0, "", fmt.Errorf("%w %q", ErrInvalidCursor, cursor)
+	}
+	return createdAt, id, nil
+}
@@ -0,0 +1,35 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// AddUsage adds d to the billed audio of period and returns the new total,
+// letting processes sharing the database enforce one usage budget.
+func (s *Store) AddUsage(ctx context.Context, period string, d time.Duration) (time.Duration, error) {
+	var total int64
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO usage (period, billed_nanos) VALUES (?, ?)
+		ON CONFLICT (period) DO UPDATE SET billed_nanos = billed_nanos + excluded.billed_nanos
+		RETURNING billed_nanos`, period, int64(d)).Scan(&total)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(total), nil
+}
+
+// Usage returns the billed audio recorded for period.
+func (s *Store) Usage(ctx context.Context, period string) (time.Duration, error) {
+	var total int64
+	err := s.db.QueryRowContext(ctx, `SELECT billed_nanos FROM usage WHERE period = ?`, period).Scan(&total)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(total), nil
+}
@@ -0,0 +1,170 @@
+package stt
+
+import (
+	"cmp"
+	"expvar"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	talkSeconds   = expvar.NewFloat("stt_talk_seconds")
+	wordsSpoken   = expvar.NewInt("stt_words")
+	interruptions = expvar.NewInt("stt_interruptions")
+)
+
+// Analytics are conversation metrics of a session or transcript.
+type Analytics struct {
+	// Duration is the audio analyzed; Speech is the part of it during which
+	// anyone was talking.
+	Duration     time.Duration  `json:"duration"`
+	Speech       time.Duration  `json:"speech"`
+	SilenceRatio float64        `json:"silence_ratio"`
+	Speakers     []SpeakerStats `json:"speakers"`
+}
+
+// SpeakerStats are the metrics of one speaker, in order of first speech.
+type SpeakerStats struct {
+	Speaker        string        `json:"speaker"`
+	TalkTime       time.Duration `json:"talk_time"`
+	Words          int           `json:"words"`
+	WordsPerMinute float64       `json:"words_per_minute"`
+	// Interruptions counts the turns the speaker started while another
+	// speaker was still talking.
+	Interruptions int `json:"interruptions"`
+}
+
+// turn is a stretch of consecutive words by one speaker.
+type turn struct {
+	speaker    string
+	start, end time.Duration
+	words      int
+}
+
+// Analyzer accumulates conversation metrics from final results as they
+// arrive. Sessions keep one, reported by Session.Analytics.
+type Analyzer struct {
+	mu       sync.Mutex
+	turns    []turn
+	speakers []SpeakerStats
+	// last is the latest turn of each speaker.
+	last     map[string]turn
+	duration time.Duration
+}
+
+func NewAnalyzer() *Analyzer {
+	return &Analyzer{last: map[string]turn{}}
+}
+
+// Add accounts for a final segment; event segments are ignored.
+func (a *Analyzer) Add(seg Segment) {
+	a.add(seg)
+}
+
+// add accounts for seg and returns the talk time, words and interruptions
+// it added.
+func (a *Analyzer) add(seg Segment) (talk time.Duration, words, interrupts int) {
+	if seg.Event != "" {
+		return 0, 0, 0
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, t := range segmentTurns(seg) {
+		stats := a.stats(t.speaker)
+		stats.TalkTime += t.end - t.start
+		stats.Words += t.words
+		talk += t.end - t.start
+		words += t.words
+		for speaker, prev := range a.last {
+			if speaker != t.speaker && prev.start < t.start && t.start < prev.end {
+				stats.Interruptions++
+				interrupts++
+				break
+			}
+		}
+		a.last[t.speaker] = t
+		a.turns = append(a.turns, t)
+		a.duration = max(a.duration, t.end)
+	}
+	return talk, words, interrupts
+}
+
+// extend makes the analyzed audio at least d long, so silence after the
+// last words is counted.
+func (a *Analyzer) extend(d time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.duration = max(a.duration, d)
+}
+
+func (a *Analyzer) stats(speaker string) *SpeakerStats {
+	for i := range a.speakers {
+		if a.speakers[i].Speaker == speaker {
+			return &a.speakers[i]
+		}
+	}
+	a.speakers = append(a.speakers, SpeakerStats{Speaker: speaker})
+	return &a.speakers[len(a.speakers)-1]
+}
+
+// Analytics returns the metrics of the results added so far.
+func (a *Analyzer) Analytics() Analytics {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	an := Analytics{Duration: a.duration, Speakers: slices.Clone(a.speakers)}
+	for i, s := range an.Speakers {
+		if s.TalkTime > 0 {
+			an.Speakers[i].WordsPerMinute = float64(s.Words) / s.TalkTime.Minutes()
+		}
+	}
+
+	// Speech is the union of all turns, counting overlapping talk once
+	turns := slices.Clone(a.turns)
+	slices.SortFunc(turns, func(x, y turn) int { return cmp.Compare(x.start, y.start) })
+	var end time.Duration
+	for _, t := range turns {
+		start := max(t.start, end)
+		if t.end > start {
+			an.Speech += t.end - start
+			end = t.end
+		}
+	}
+	if an.Duration > 0 {
+		an.SilenceRatio = 1 - float64(an.Speech)/float64(an.Duration)
+	}
+	return an
+}
+
+// Analytics returns the conversation metrics of the transcript.
+func (t Transcript) Analytics() Analytics {
+	a := NewAnalyzer()
+	for _, seg := range t.Segments {
+		a.add(seg)
+	}
+	a.extend(t.Duration)
+	return a.Analytics()
+}
+
+// segmentTurns splits a segment into turns at speaker changes between its
+// words, or returns the whole segment as one turn without word timings.
+func segmentTurns(seg Segment) []turn {
+	if len(seg.Words) == 0 {
+		return []turn{{speaker: seg.Speaker, start: seg.Start, end: seg.End, words: len(strings.Fields(seg.Text))}}
+	}
+	var turns []turn
+	for _, w := range seg.Words {
+		speaker := w.Speaker
+		if speaker == "" {
+			speaker = seg.Speaker
+		}
+		if n := len(turns); n > 0 && turns[n-1].speaker == speaker {
+			turns[n-1].end = w.End
+			turns[n-1].words++
+			continue
+		}
+		turns = append(turns, turn{speaker: speaker, start: w.Start, end: w.End, words: 1})
+	}
+	return turns
+}
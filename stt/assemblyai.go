@@ -0,0 +1,227 @@
+package stt
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"nhooyr.io/websocket"
+	"nhooyr.io/websocket/wsjson"
+)
+
+// ProviderAssemblyAI is the name of AssemblyAI's real-time streaming
+// provider, authenticated with the ASSEMBLYAI_API_KEY environment variable.
+const ProviderAssemblyAI = "assemblyai"
+
+// assemblyAIURL is AssemblyAI's real-time WebSocket endpoint.
+const assemblyAIURL = "wss://api.assemblyai.com/v2/realtime/ws"
+
+// assemblyAIPIIPolicies are the kinds of personal information redacted with
+// Config.RedactPII.
+var assemblyAIPIIPolicies = []string{
+	"person_name", "phone_number", "email_address", "location", "date_of_birth",
+	"credit_card_number", "credit_card_cvv", "credit_card_expiration",
+	"banking_information", "us_social_security_number",
+}
+
+func init() {
+	RegisterProvider(assemblyAIProvider{})
+}
+
+type assemblyAIProvider struct{}
+
+func (assemblyAIProvider) Name() string { return ProviderAssemblyAI }
+
+func (assemblyAIProvider) Capabilities() Capabilities {
+	return Capabilities{
+		Languages:      []string{"en"},
+		WordTimings:    true,
+		WordConfidence: true,
+		WordBoost:      true,
+		PIIRedaction:   true,
+		Streaming:      true,
+		Codecs:         []string{"LINEAR16"},
+	}
+}
+
+func (assemblyAIProvider) OpenStream(ctx context.Context, config Config) (Stream, error) {
+	key := os.Getenv("ASSEMBLYAI_API_KEY")
+	if key == "" {
+		return nil, fmt.Errorf("ASSEMBLYAI_API_KEY environment variable is not set")
+	}
+	return &assemblyAIStream{ctx: ctx, config: config, key: key, connected: make(chan struct{})}, nil
+}
+
+// assemblyAIStream is one real-time session. The session's sample rate is
+// part of the connection URL, so it connects on the first audio, taking the
+// rate from the WAV header and sending only the PCM samples that follow.
+type assemblyAIStream struct {
+	ctx    context.Context
+	config Config
+	key    string
+
+	conn *websocket.Conn
+	// connected is closed once conn is set or err reports why it is not.
+	connected chan struct{}
+	once      sync.Once
+	err       error
+}
+
+func (s *assemblyAIStream) SendAudio(ctx context.Context, audio []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if s.conn == nil {
+		var err error
+		if audio, err = s.connect(audio); err != nil {
+			s.done(err)
+			return err
+		}
+		s.done(nil)
+	}
+	if len(audio) == 0 {
+		return nil
+	}
+	msg := map[string]string{"audio_data": base64.StdEncoding.EncodeToString(audio)}
+	return wsjson.Write(ctx, s.conn, msg)
+}
+
+// connect opens the session for the WAV audio starting with header,
+// returning the samples following the header.
+func (s *assemblyAIStream) connect(header []byte) ([]byte, error) {
+	n := wavHeaderLen(header)
+	encoding, channels, rate, bits := wavFormat(header)
+	if n == 0 || encoding != wavPCM || bits != 16 || channels != 1 {
+		return nil, &CapabilityError{Provider: ProviderAssemblyAI, Feature: "audio other than 16-bit mono PCM WAV"}
+	}
+
+	q := url.Values{}
+	q.Set("sample_rate", strconv.Itoa(rate))
+	q.Set("encoding", "pcm_s16le")
+	if len(s.config.Boost) > 0 {
+		boost, _ := json.Marshal(s.config.Boost)
+		q.Set("word_boost", string(boost))
+	}
+	if s.config.RedactPII {
+		policies, _ := json.Marshal(assemblyAIPIIPolicies)
+		q.Set("redact_pii", "true")
+		q.Set("redact_pii_policies", string(policies))
+	}
+	conn, resp, err := websocket.Dial(s.ctx, assemblyAIURL+"?"+q.Encode(), &websocket.DialOptions{
+		HTTPHeader: http.Header{"Authorization": {s.key}},
+	})
+	if err != nil {
+		if resp != nil && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+			return nil, &Error{Op: "open stream", Kind: ErrAuth, Err: err}
+		}
+		return nil, &Error{Op: "open stream", Err: err}
+	}
+	// Final results of long turns with their words exceed the default limit
+	conn.SetReadLimit(1 << 20)
+	s.conn = conn
+	return header[n:], nil
+}
+
+// done releases Receive once connecting has finished.
+func (s *assemblyAIStream) done(err error) {
+	s.once.Do(func() {
+		s.err = err
+		close(s.connected)
+	})
+}
+
+// assemblyAIMessage is a message received from a real-time session.
+type assemblyAIMessage struct {
+	Type       string  `json:"message_type"`
+	Error      string  `json:"error"`
+	Text       string  `json:"text"`
+	Confidence float32 `json:"confidence"`
+	AudioStart int64   `json:"audio_start"`
+	AudioEnd   int64   `json:"audio_end"`
+	Words      []struct {
+		Text       string  `json:"text"`
+		Start      int64   `json:"start"`
+		End        int64   `json:"end"`
+		Confidence float32 `json:"confidence"`
+	} `json:"words"`
+}
+
+func (s *assemblyAIStream) Receive(ctx context.Context) (*Result, error) {
+	select {
+	case <-s.connected:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	var msg assemblyAIMessage
+	if err := wsjson.Read(ctx, s.conn, &msg); err != nil {
+		if websocket.CloseStatus(err) == websocket.StatusNormalClosure {
+			return nil, io.EOF
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, &Error{Op: "receive response", Err: err}
+	}
+	switch {
+	case msg.Error != "":
+		return nil, &Error{Op: "receive response", Err: errors.New(msg.Error)}
+	case msg.Type == "SessionTerminated":
+		return nil, io.EOF
+	case msg.Type != "PartialTranscript" && msg.Type != "FinalTranscript", msg.Text == "":
+		return nil, nil
+	}
+
+	r := Result{
+		Segment: Segment{
+			Text:       msg.Text,
+			Start:      time.Duration(msg.AudioStart) * time.Millisecond,
+			End:        time.Duration(msg.AudioEnd) * time.Millisecond,
+			Confidence: msg.Confidence,
+		},
+		IsFinal: msg.Type == "FinalTranscript",
+	}
+	if len(s.config.LanguageCodes) > 0 {
+		r.Language = s.config.LanguageCodes[0]
+	}
+	for _, w := range msg.Words {
+		r.Words = append(r.Words, Word{
+			Text:       w.Text,
+			Start:      time.Duration(w.Start) * time.Millisecond,
+			End:        time.Duration(w.End) * time.Millisecond,
+			Confidence: w.Confidence,
+		})
+	}
+	return &r, nil
+}
+
+// CloseSend asks the server to finish the session; the final results
+// arrive before Receive returns io.EOF.
+func (s *assemblyAIStream) CloseSend() error {
+	if s.conn == nil {
+		// Nothing was sent, so there are no results to wait for
+		s.done(io.EOF)
+		return nil
+	}
+	return wsjson.Write(s.ctx, s.conn, map[string]bool{"terminate_session": true})
+}
+
+func (s *assemblyAIStream) Close() error {
+	s.done(io.EOF)
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close(websocket.StatusNormalClosure, "")
+}
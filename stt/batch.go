@@ -0,0 +1,166 @@
+package stt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	speech "cloud.google.com/go/speech/apiv2"
+	speechpb "cloud.google.com/go/speech/apiv2/speechpb"
+	storage "google.golang.org/api/storage/v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// BatchOptions configures BatchRecognize.
+type BatchOptions struct {
+	// OutputURI, when set, is a gs:// prefix the results are written under
+	// instead of being returned inline, as long audio requires. They are
+	// read back once the operation finishes.
+	OutputURI string
+	// PollInterval is how often the operation's progress is checked; it
+	// defaults to 10s.
+	PollInterval time.Duration
+}
+
+// BatchRecognize transcribes the audio at a gs:// URI with the long-running
+// BatchRecognize API, polling the operation until it finishes and returning
+// its results.
+func BatchRecognize(ctx context.Context, client *speech.Client, c Config, uri string, opts BatchOptions) (Transcript, error) {
+	req := &speechpb.BatchRecognizeRequest{
+		Recognizer: c.Recognizer(),
+		Config:     c.RecognitionConfig(),
+		Files: []*speechpb.BatchRecognizeFileMetadata{{
+			AudioSource: &speechpb.BatchRecognizeFileMetadata_Uri{Uri: uri},
+		}},
+		RecognitionOutputConfig: &speechpb.RecognitionOutputConfig{
+			Output: &speechpb.RecognitionOutputConfig_InlineResponseConfig{
+				InlineResponseConfig: &speechpb.InlineOutputConfig{},
+			},
+		},
+	}
+	if opts.OutputURI != "" {
+		req.RecognitionOutputConfig.Output = &speechpb.RecognitionOutputConfig_GcsOutputConfig{
+			GcsOutputConfig: &speechpb.GcsOutputConfig{Uri: opts.OutputURI},
+		}
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = 10 * time.Second
+	}
+
+	log.Printf("Starting batch recognition of %s...", uri)
+	op, err := client.BatchRecognize(c.outgoing(ctx), req)
+	if err != nil {
+		err = wrapError("start batch recognition", err)
+		if fallback, ok := c.withFallback(err); ok {
+			return BatchRecognize(ctx, client, fallback, uri, opts)
+		}
+		return Transcript{}, err
+	}
+	resp, err := pollBatch(c.outgoing(ctx), op, opts.PollInterval)
+	if err != nil {
+		return Transcript{}, err
+	}
+
+	result, ok := resp.Results[uri]
+	if !ok {
+		return Transcript{}, fmt.Errorf("no batch result for %s", uri)
+	}
+	if result.Error != nil && result.Error.Code != 0 {
+		return Transcript{}, wrapError("recognize "+uri, status.FromProto(result.Error).Err())
+	}
+	results := result.Transcript
+	if inline := result.GetInlineResult(); inline != nil {
+		results = inline.Transcript
+	}
+	if stored := result.GetCloudStorageResult(); stored != nil {
+		log.Printf("Reading batch results from %s", stored.Uri)
+		if results, err = readBatchResults(ctx, stored.Uri); err != nil {
+			return Transcript{}, err
+		}
+	}
+	return transcriptFromResults(results.GetResults()), nil
+}
+
+// pollBatch waits for a batch operation to finish, logging its progress
+// every interval.
+func pollBatch(ctx context.Context, op *speech.BatchRecognizeOperation, interval time.Duration) (*speechpb.BatchRecognizeResponse, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		resp, err := op.Poll(ctx)
+		if err != nil {
+			return nil, wrapError("run batch recognition", err)
+		}
+		if op.Done() {
+			return resp, nil
+		}
+		if meta, err := op.Metadata(); err == nil && meta != nil {
+			log.Printf("Batch recognition %s is %d%% done", op.Name(), meta.ProgressPercent)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// StageAudio uploads audio to a new object under a gs:// prefix for batch
+// recognition, returning its URI and a function deleting it again.
+func StageAudio(ctx context.Context, prefix string, audio []byte) (string, func(context.Context) error, error) {
+	return StageReader(ctx, prefix, bytes.NewReader(audio))
+}
+
+// StageReader is StageAudio for audio read from r, such as an upload spilled
+// to disk, so it need not be held in memory.
+func StageReader(ctx context.Context, prefix string, r io.Reader) (string, func(context.Context) error, error) {
+	bucket, dir, _ := strings.Cut(strings.TrimPrefix(prefix, "gs://"), "/")
+	if !strings.HasPrefix(prefix, "gs://") || bucket == "" {
+		return "", nil, fmt.Errorf("invalid staging URI %q", prefix)
+	}
+	svc, err := storage.NewService(ctx)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create storage client: %w", err)
+	}
+	name := strings.TrimSuffix(dir, "/") + "/" + NewSessionID() + ".wav"
+	name = strings.TrimPrefix(name, "/")
+	if _, err := svc.Objects.Insert(bucket, &storage.Object{Name: name}).Media(r).Context(ctx).Do(); err != nil {
+		return "", nil, fmt.Errorf("failed to stage audio: %w", err)
+	}
+	remove := func(ctx context.Context) error {
+		return svc.Objects.Delete(bucket, name).Context(ctx).Do()
+	}
+	return "gs://" + bucket + "/" + name, remove, nil
+}
+
+// readBatchResults downloads the results a batch operation wrote to a gs://
+// URI.
+func readBatchResults(ctx context.Context, uri string) (*speechpb.BatchRecognizeResults, error) {
+	bucket, object, ok := strings.Cut(strings.TrimPrefix(uri, "gs://"), "/")
+	if !strings.HasPrefix(uri, "gs://") || !ok {
+		return nil, fmt.Errorf("invalid batch result URI %q", uri)
+	}
+	svc, err := storage.NewService(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage client: %w", err)
+	}
+	resp, err := svc.Objects.Get(bucket, object).Context(ctx).Download()
+	if err != nil {
+		return nil, fmt.Errorf("failed to download batch results: %w", err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read batch results: %w", err)
+	}
+	var results speechpb.BatchRecognizeResults
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(b, &results); err != nil {
+		return nil, fmt.Errorf("failed to parse batch results: %w", err)
+	}
+	return &results, nil
+}
@@ -0,0 +1,160 @@
+package stt
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrBudgetExhausted reports that the usage budget of the current period has
+// been spent, so no new sessions are started.
+var ErrBudgetExhausted = errors.New("usage budget exhausted")
+
+var (
+	budgetUsed      = expvar.NewFloat("stt_budget_used_seconds")
+	budgetRemaining = expvar.NewFloat("stt_budget_remaining_seconds")
+)
+
+// budgetAlerts are the fractions of the budget at which an alert is raised.
+var budgetAlerts = []float64{0.5, 0.8, 0.9, 1}
+
+// BudgetPeriod is the interval a usage budget applies to.
+type BudgetPeriod string
+
+const (
+	Daily   BudgetPeriod = "daily"
+	Monthly BudgetPeriod = "monthly"
+)
+
+// key identifies the period containing t, in UTC.
+func (p BudgetPeriod) key(t time.Time) (string, error) {
+	switch p {
+	case Daily:
+		return "daily:" + t.UTC().Format("2006-01-02"), nil
+	case Monthly:
+		return "monthly:" + t.UTC().Format("2006-01"), nil
+	default:
+		return "", fmt.Errorf("unknown budget period %q", p)
+	}
+}
+
+// UsageStore shares billed audio totals between processes.
+type UsageStore interface {
+	// AddUsage adds d to the period's total and returns the new total.
+	AddUsage(ctx context.Context, period string, d time.Duration) (time.Duration, error)
+	Usage(ctx context.Context, period string) (time.Duration, error)
+}
+
+// Budget caps the billed audio of all sessions in a period. Without a store
+// usage is tracked in process.
+type Budget struct {
+	Limit  time.Duration
+	Period BudgetPeriod
+	Store  UsageStore
+	// Alert is called when usage crosses 50%, 80%, 90% and 100% of Limit;
+	// it defaults to logging a warning.
+	Alert func(period string, used, limit time.Duration)
+
+	mu      sync.Mutex
+	used    map[string]time.Duration
+	alerted map[string]float64
+}
+
+func NewBudget(limit time.Duration, period BudgetPeriod, store UsageStore) (*Budget, error) {
+	if _, err := period.key(time.Now()); err != nil {
+		return nil, err
+	}
+	return &Budget{Limit: limit, Period: period, Store: store}, nil
+}
+
+// Allow returns ErrBudgetExhausted if the current period's budget is spent.
+func (b *Budget) Allow(ctx context.Context) error {
+	key, used, err := b.usage(ctx)
+	if err != nil {
+		return err
+	}
+	b.report(key, used)
+	if used >= b.Limit {
+		return fmt.Errorf("%w: %s of %s used in %s", ErrBudgetExhausted, used.Round(time.Second), b.Limit, key)
+	}
+	return nil
+}
+
+// Add records d of billed audio in the current period.
+func (b *Budget) Add(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	key, err := b.Period.key(time.Now())
+	if err != nil {
+		return err
+	}
+	var used time.Duration
+	if b.Store != nil {
+		if used, err = b.Store.AddUsage(ctx, key, d); err != nil {
+			return fmt.Errorf("failed to record usage: %w", err)
+		}
+	} else {
+		b.mu.Lock()
+		if b.used == nil {
+			b.used = map[string]time.Duration{}
+		}
+		b.used[key] += d
+		used = b.used[key]
+		b.mu.Unlock()
+	}
+	b.report(key, used)
+	return nil
+}
+
+func (b *Budget) usage(ctx context.Context) (string, time.Duration, error) {
+	key, err := b.Period.key(time.Now())
+	if err != nil {
+		return "", 0, err
+	}
+	if b.Store != nil {
+		used, err := b.Store.Usage(ctx, key)
+		if err != nil {
+			return "", 0, fmt.Errorf("failed to read usage: %w", err)
+		}
+		return key, used, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return key, b.used[key], nil
+}
+
+// report updates the metrics and raises an alert for the highest threshold
+// crossed that has not been alerted yet in this period.
+func (b *Budget) report(key string, used time.Duration) {
+	budgetUsed.Set(used.Seconds())
+	budgetRemaining.Set(max(b.Limit-used, 0).Seconds())
+
+	b.mu.Lock()
+	if b.alerted == nil {
+		b.alerted = map[string]float64{}
+	}
+	var crossed float64
+	for _, t := range budgetAlerts {
+		if float64(used) >= t*float64(b.Limit) {
+			crossed = t
+		}
+	}
+	if crossed <= b.alerted[key] {
+		b.mu.Unlock()
+		return
+	}
+	b.alerted[key] = crossed
+	b.mu.Unlock()
+
+	if b.Alert != nil {
+		b.Alert(key, used, b.Limit)
+		return
+	}
+	log.Printf("Warning: %.0f%% of the %s usage budget used (%s of %s)",
+		crossed*100, key, used.Round(time.Second), b.Limit)
+}
@@ -0,0 +1,197 @@
+package stt
+
+import (
+	"errors"
+	"fmt"
+
+	speechpb "cloud.google.com/go/speech/apiv2/speechpb"
+)
+
+// maxPhraseBoost is the highest boost Google accepts for a phrase.
+const maxPhraseBoost = 20
+
+// Features are the optional recognition features of a RecognitionBuilder.
+type Features struct {
+	WordTimeOffsets bool
+	WordConfidence  bool
+	// Punctuation adds punctuation to results; SpokenPunctuation and
+	// SpokenEmojis transcribe them when spoken instead.
+	Punctuation       bool
+	SpokenPunctuation bool
+	SpokenEmojis      bool
+	ProfanityFilter   bool
+	// SeparateChannels recognizes each channel of multi-channel audio
+	// independently.
+	SeparateChannels bool
+	// MaxAlternatives is the number of alternative transcripts returned,
+	// from 0 (one) to 30.
+	MaxAlternatives int
+}
+
+// RecognitionBuilder assembles a Google RecognitionConfig from typed
+// options, for library callers sending their own requests. Options are
+// chained and checked together by Build, so a config is only returned when
+// the API would accept it:
+//
+//	rc, err := stt.NewRecognitionBuilder("en-US").
+//		WithModel("telephony").
+//		WithDiarization(2, 2).
+//		WithPhrases(10, "Acme", "AcmeCloud").
+//		Build()
+type RecognitionBuilder struct {
+	languages []string
+	model     string
+	features  Features
+
+	// decoding is nil for automatic decoding.
+	decoding *speechpb.ExplicitDecodingConfig
+
+	minSpeakers, maxSpeakers int
+
+	phrases []*speechpb.PhraseSet_Phrase
+
+	errs []error
+}
+
+// NewRecognitionBuilder returns a builder recognizing the given languages
+// with the default model and automatic decoding.
+func NewRecognitionBuilder(languages ...string) *RecognitionBuilder {
+	return &RecognitionBuilder{languages: languages, model: DefaultModel}
+}
+
+// WithModel selects the recognition model, such as "latest_short" or
+// "telephony".
+func (b *RecognitionBuilder) WithModel(model string) *RecognitionBuilder {
+	if model == "" {
+		b.errs = append(b.errs, errors.New("model is empty"))
+	}
+	b.model = model
+	return b
+}
+
+// WithLanguages replaces the languages recognized, the first being primary.
+func (b *RecognitionBuilder) WithLanguages(languages ...string) *RecognitionBuilder {
+	b.languages = languages
+	return b
+}
+
+// WithFeatures enables the given recognition features.
+func (b *RecognitionBuilder) WithFeatures(f Features) *RecognitionBuilder {
+	if f.MaxAlternatives < 0 || f.MaxAlternatives > 30 {
+		b.errs = append(b.errs, fmt.Errorf("max alternatives %d is not between 0 and 30", f.MaxAlternatives))
+	}
+	b.features = f
+	return b
+}
+
+// WithDiarization labels words by speaker, for between min and max speakers.
+// Speakers are attributed per word, so it also enables word time offsets.
+func (b *RecognitionBuilder) WithDiarization(min, max int) *RecognitionBuilder {
+	if min < 1 || max < min {
+		b.errs = append(b.errs, fmt.Errorf("speaker count range %d-%d is invalid", min, max))
+	}
+	b.minSpeakers, b.maxSpeakers = min, max
+	return b
+}
+
+// WithPhrases biases recognition towards the phrases, such as product
+// names, by boost, which ranges from 0 exclusive to 20. It can be called
+// again to add phrases with a different boost.
+func (b *RecognitionBuilder) WithPhrases(boost float32, phrases ...string) *RecognitionBuilder {
+	if boost <= 0 || boost > maxPhraseBoost {
+		b.errs = append(b.errs, fmt.Errorf("phrase boost %g is not in (0, %d]", boost, maxPhraseBoost))
+	}
+	for _, phrase := range phrases {
+		if phrase == "" {
+			b.errs = append(b.errs, errors.New("phrase is empty"))
+			continue
+		}
+		b.phrases = append(b.phrases, &speechpb.PhraseSet_Phrase{Value: phrase, Boost: boost})
+	}
+	return b
+}
+
+// WithEncoding declares the encoding of headerless audio instead of
+// detecting it. LINEAR16, MULAW and ALAW need the sample rate and channel
+// count; for other encodings zero leaves them to the recognizer.
+func (b *RecognitionBuilder) WithEncoding(encoding speechpb.ExplicitDecodingConfig_AudioEncoding, rate, channels int) *RecognitionBuilder {
+	raw := encoding == speechpb.ExplicitDecodingConfig_LINEAR16 ||
+		encoding == speechpb.ExplicitDecodingConfig_MULAW || encoding == speechpb.ExplicitDecodingConfig_ALAW
+	switch {
+	case encoding == speechpb.ExplicitDecodingConfig_AUDIO_ENCODING_UNSPECIFIED:
+		b.errs = append(b.errs, errors.New("encoding is unspecified"))
+	case rate == 0 && raw, rate != 0 && (rate < 8000 || rate > 48000):
+		b.errs = append(b.errs, fmt.Errorf("sample rate %d is not between 8000 and 48000 Hz", rate))
+	case channels == 0 && raw, channels != 0 && (channels < 1 || channels > 8):
+		b.errs = append(b.errs, fmt.Errorf("channel count %d is not between 1 and 8", channels))
+	}
+	b.decoding = &speechpb.ExplicitDecodingConfig{
+		Encoding:          encoding,
+		SampleRateHertz:   int32(rate),
+		AudioChannelCount: int32(channels),
+	}
+	return b
+}
+
+// Build returns the config, or an error listing every invalid option.
+func (b *RecognitionBuilder) Build() (*speechpb.RecognitionConfig, error) {
+	errs := b.errs
+	if len(b.languages) == 0 {
+		errs = append(errs, errors.New("no language code"))
+	}
+	for _, lang := range b.languages {
+		if lang == "" {
+			errs = append(errs, errors.New("language code is empty"))
+		}
+	}
+	if b.features.SeparateChannels && b.decoding != nil && b.decoding.AudioChannelCount == 1 {
+		errs = append(errs, errors.New("separate channel recognition needs multi-channel audio"))
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid recognition config: %w", errors.Join(errs...))
+	}
+
+	rc := &speechpb.RecognitionConfig{
+		DecodingConfig: &speechpb.RecognitionConfig_AutoDecodingConfig{
+			AutoDecodingConfig: &speechpb.AutoDetectDecodingConfig{},
+		},
+		LanguageCodes: b.languages,
+		Model:         b.model,
+		Features: &speechpb.RecognitionFeatures{
+			ProfanityFilter:            b.features.ProfanityFilter,
+			EnableWordTimeOffsets:      b.features.WordTimeOffsets || b.maxSpeakers > 0,
+			EnableWordConfidence:       b.features.WordConfidence,
+			EnableAutomaticPunctuation: b.features.Punctuation,
+			EnableSpokenPunctuation:    b.features.SpokenPunctuation,
+			EnableSpokenEmojis:         b.features.SpokenEmojis,
+			MaxAlternatives:            int32(b.features.MaxAlternatives),
+		},
+	}
+	if b.decoding != nil {
+		rc.DecodingConfig = &speechpb.RecognitionConfig_ExplicitDecodingConfig{ExplicitDecodingConfig: b.decoding}
+	}
+	if b.features.SeparateChannels {
+		rc.Features.MultiChannelMode = speechpb.RecognitionFeatures_SEPARATE_RECOGNITION_PER_CHANNEL
+	}
+	if b.maxSpeakers > 0 {
+		rc.Features.DiarizationConfig = &speechpb.SpeakerDiarizationConfig{
+			MinSpeakerCount: int32(b.minSpeakers),
+			MaxSpeakerCount: int32(b.maxSpeakers),
+		}
+	}
+	if len(b.phrases) > 0 {
+		rc.Adaptation = inlinePhrases(b.phrases)
+	}
+	return rc, nil
+}
+
+// inlinePhrases returns an adaptation biasing recognition towards phrases.
+func inlinePhrases(phrases []*speechpb.PhraseSet_Phrase) *speechpb.SpeechAdaptation {
+	return &speechpb.SpeechAdaptation{
+		PhraseSets: []*speechpb.SpeechAdaptation_AdaptationPhraseSet{{
+			Value: &speechpb.SpeechAdaptation_AdaptationPhraseSet_InlinePhraseSet{
+				InlinePhraseSet: &speechpb.PhraseSet{Phrases: phrases},
+			},
+		}},
+	}
+}
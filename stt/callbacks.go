@@ -0,0 +1,58 @@
+package stt
+
+import (
+	"context"
+)
+
+// OnPartial registers fn for every interim result. Callbacks run on the
+// receive loop, so slow callbacks delay further results.
+func (s *Session) OnPartial(fn func(Result)) {
+	On(s.bus, func(_ context.Context, e PartialResult) { fn(e.Result) })
+}
+
+// OnFinal registers fn for every final result.
+func (s *Session) OnFinal(fn func(Result)) {
+	On(s.bus, func(_ context.Context, e FinalResult) { fn(e.Result) })
+}
+
+// OnError registers fn for the error that ends a failed session.
+func (s *Session) OnError(fn func(error)) {
+	On(s.bus, func(_ context.Context, e SessionEnded) {
+		if e.Err != nil {
+			fn(e.Err)
+		}
+	})
+}
+
+// Results returns a channel receiving every partial and final result, closed
+// when the session ends. The channel applies backpressure: results are not
+// received from the server faster than they are read. Call it before Run or
+// Start.
+func (s *Session) Results() <-chan Result {
+	ch := make(chan Result, 16)
+	send := func(ctx context.Context, r Result) {
+		select {
+		case ch <- r:
+		case <-ctx.Done():
+		}
+	}
+	On(s.bus, func(ctx context.Context, e PartialResult) { send(ctx, e.Result) })
+	On(s.bus, func(ctx context.Context, e FinalResult) { send(ctx, e.Result) })
+	On(s.bus, func(context.Context, SessionEnded) { close(ch) })
+	return ch
+}
+
+// Start runs the session in the background; Wait returns its result.
+func (s *Session) Start(ctx context.Context, audio []byte) {
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		s.err = s.Run(ctx, audio)
+	}()
+}
+
+// Wait blocks until a session started with Start ends and returns its error.
+func (s *Session) Wait() error {
+	<-s.done
+	return s.err
+}
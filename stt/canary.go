@@ -0,0 +1,228 @@
+package stt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+)
+
+// Arms of a canary: the configured recognizer, and the alternate one a
+// share of sessions is sent to.
+const (
+	ArmBaseline = "baseline"
+	ArmCanary   = "canary"
+)
+
+// canaryHistory is how many ended sessions a Canary keeps transcripts of
+// for scoring against references.
+const canaryHistory = 1000
+
+// CanaryOptions selects the alternate recognizer of a Canary. Empty fields
+// keep the baseline's.
+type CanaryOptions struct {
+	Region       string
+	RecognizerID string
+	Model        string
+	// Percent is the share of sessions sent to the alternate recognizer,
+	// from 0 to 100.
+	Percent float64
+}
+
+// Canary sends a share of sessions to an alternate recognizer, such as a new
+// model or the same recognizer in another region, and compares the two arms
+// on the results they return, to validate a recognizer change on production
+// traffic before rolling it out. Sessions are assigned by ID, so a session
+// retried under the same ID stays on its arm.
+type Canary struct {
+	opts CanaryOptions
+
+	mu   sync.Mutex
+	arms map[string]*CanaryArm
+	// texts holds the transcripts of the latest ended sessions by ID, and
+	// order their IDs, oldest first.
+	texts map[string]canaryText
+	order []string
+}
+
+// canaryText is the transcript of an ended session, for scoring.
+type canaryText struct {
+	arm  string
+	text string
+}
+
+// CanaryArm is the aggregate of the sessions sent to one arm.
+type CanaryArm struct {
+	Arm        string `json:"arm"`
+	Region     string `json:"region,omitempty"`
+	Recognizer string `json:"recognizer,omitempty"`
+	Model      string `json:"model"`
+
+	Sessions int `json:"sessions"`
+	Failed   int `json:"failed"`
+	Results  int `json:"results"`
+	Words    int `json:"words"`
+	// MeanConfidence is the mean confidence of the final results.
+	MeanConfidence float64 `json:"mean_confidence"`
+	confidence     float64
+
+	// Scored counts the sessions scored against a reference transcript,
+	// and WER is their word error rate over all reference words.
+	Scored     int     `json:"scored"`
+	WER        float64 `json:"wer"`
+	errs, refs int
+}
+
+// NewCanary returns a canary sending opts.Percent of sessions to the
+// alternate recognizer.
+func NewCanary(opts CanaryOptions) (*Canary, error) {
+	if opts.Percent < 0 || opts.Percent > 100 {
+		return nil, fmt.Errorf("invalid canary percentage %g: expected 0 to 100", opts.Percent)
+	}
+	if opts.Region == "" && opts.RecognizerID == "" && opts.Model == "" {
+		return nil, errors.New("canary needs a region, recognizer or model")
+	}
+	return &Canary{
+		opts:  opts,
+		arms:  map[string]*CanaryArm{ArmBaseline: {Arm: ArmBaseline}, ArmCanary: {Arm: ArmCanary}},
+		texts: map[string]canaryText{},
+	}, nil
+}
+
+// Route returns the config session id is to be recognized with, config or
+// its canary variant, and the arm it belongs to.
+func (c *Canary) Route(id string, config Config) (Config, string) {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	if float64(h.Sum32()%10000) >= c.opts.Percent*100 {
+		c.describe(ArmBaseline, config)
+		return config, ArmBaseline
+	}
+	config = c.Variant(config)
+	c.describe(ArmCanary, config)
+	return config, ArmCanary
+}
+
+// Variant returns config with the alternate recognizer. A fallback model is
+// dropped with a model override, so that a model the canary is meant to
+// validate is not silently replaced.
+func (c *Canary) Variant(config Config) Config {
+	if c.opts.Region != "" {
+		config.Region = c.opts.Region
+	}
+	if c.opts.RecognizerID != "" {
+		config.RecognizerID = c.opts.RecognizerID
+	}
+	if c.opts.Model != "" {
+		config.Model = c.opts.Model
+		config.FallbackModel = ""
+	}
+	return config
+}
+
+// describe records the recognizer of arm as last routed to.
+func (c *Canary) describe(arm string, config Config) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	a := c.arms[arm]
+	a.Region, a.Recognizer, a.Model = config.Region, config.RecognizerID, config.model()
+}
+
+// Watch accounts for the results of s, routed to arm, as they arrive. Call
+// it before Run or Start.
+func (c *Canary) Watch(s *Session, arm string) {
+	var text []string
+	s.Events().Subscribe(func(_ context.Context, e Event) {
+		switch e := e.(type) {
+		case FinalResult:
+			if e.Result.Event != "" {
+				return
+			}
+			text = append(text, e.Result.Text)
+			c.add(arm, e.Result.Segment)
+		case SessionEnded:
+			c.end(s.ID, arm, strings.Join(text, " "), e.Err)
+		}
+	})
+}
+
+// Observe accounts for a session recognized in one piece, such as with
+// Recognize, that was routed to arm and returned t or failed with err.
+func (c *Canary) Observe(id, arm string, t Transcript, err error) {
+	var text []string
+	for _, seg := range t.Segments {
+		if seg.Event == "" {
+			text = append(text, seg.Text)
+			c.add(arm, seg)
+		}
+	}
+	c.end(id, arm, strings.Join(text, " "), err)
+}
+
+func (c *Canary) add(arm string, seg Segment) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	a := c.arms[arm]
+	a.Results++
+	a.Words += len(normalizeWords(seg.Text))
+	a.confidence += float64(seg.Confidence)
+}
+
+func (c *Canary) end(id, arm, text string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	a := c.arms[arm]
+	a.Sessions++
+	if err != nil {
+		a.Failed++
+		return
+	}
+	if _, ok := c.texts[id]; !ok {
+		c.order = append(c.order, id)
+	}
+	c.texts[id] = canaryText{arm: arm, text: text}
+	if len(c.order) > canaryHistory {
+		delete(c.texts, c.order[0])
+		c.order = c.order[1:]
+	}
+}
+
+// Score compares the transcript of the ended session id with a reference
+// transcript of its audio, such as a human correction, adding its word
+// errors to its arm's WER. Only the latest sessions can be scored.
+func (c *Canary) Score(id, reference string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, ok := c.texts[id]
+	if !ok {
+		return fmt.Errorf("no ended canary session %s", id)
+	}
+	errs, words := WordErrors(reference, t.text)
+	a := c.arms[t.arm]
+	a.Scored++
+	a.errs += errs
+	a.refs += words
+	// A session is scored once
+	delete(c.texts, id)
+	return nil
+}
+
+// Report returns the aggregates of both arms, baseline first.
+func (c *Canary) Report() []CanaryArm {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	report := make([]CanaryArm, 0, 2)
+	for _, name := range []string{ArmBaseline, ArmCanary} {
+		a := *c.arms[name]
+		if a.Results > 0 {
+			a.MeanConfidence = a.confidence / float64(a.Results)
+		}
+		if a.refs > 0 {
+			a.WER = float64(a.errs) / float64(a.refs)
+		}
+		report = append(report, a)
+	}
+	return report
+}
@@ -0,0 +1,199 @@
+package stt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrUnsupported reports a requested feature the provider does not support.
+var ErrUnsupported = errors.New("not supported by provider")
+
+// Capabilities describes what a recognition provider supports.
+type Capabilities struct {
+	// Languages lists the supported language codes. It is empty for a
+	// provider accepting any language its models support.
+	Languages []string `json:"languages,omitempty"`
+	// MultiLanguage reports support for recognizing alternative languages
+	// alongside the primary one in the same session.
+	MultiLanguage  bool `json:"multi_language"`
+	Diarization    bool `json:"diarization"`
+	WordTimings    bool `json:"word_timings"`
+	WordConfidence bool `json:"word_confidence"`
+	// WordBoost reports support for biasing recognition towards
+	// Config.Boost phrases.
+	WordBoost    bool `json:"word_boost"`
+	PIIRedaction bool `json:"pii_redaction"`
+	OneShot      bool `json:"one_shot"`
+	Streaming    bool `json:"streaming"`
+	Batch        bool `json:"batch"`
+	// MaxStreamDuration is the longest audio one stream may carry, or 0 for
+	// no limit.
+	MaxStreamDuration time.Duration `json:"max_stream_duration,omitempty"`
+	// Codecs lists the audio encodings the provider decodes.
+	Codecs []string `json:"codecs"`
+}
+
+// Provider is a speech recognition backend.
+type Provider interface {
+	Name() string
+	Capabilities() Capabilities
+}
+
+// providers are the registered providers by name.
+var providers = map[string]Provider{}
+
+// RegisterProvider makes a provider selectable by its name.
+func RegisterProvider(p Provider) {
+	providers[p.Name()] = p
+}
+
+// LookupProvider returns the provider registered under name; an empty name
+// selects Google.
+func LookupProvider(name string) (Provider, error) {
+	if name == "" {
+		name = ProviderGoogle
+	}
+	p, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q", name)
+	}
+	return p, nil
+}
+
+// ProviderGoogle is the name of the Google Cloud Speech-to-Text v2 provider.
+const ProviderGoogle = "google"
+
+func init() {
+	RegisterProvider(googleProvider{})
+}
+
+type googleProvider struct{}
+
+func (googleProvider) Name() string { return ProviderGoogle }
+
+func (googleProvider) OpenStream(ctx context.Context, config Config) (Stream, error) {
+	return NewStreamingClient(ctx, config)
+}
+
+func (googleProvider) Capabilities() Capabilities {
+	return Capabilities{
+		MultiLanguage:     true,
+		Diarization:       true,
+		WordTimings:       true,
+		WordConfidence:    true,
+		WordBoost:         true,
+		OneShot:           true,
+		Streaming:         true,
+		Batch:             true,
+		MaxStreamDuration: 5 * time.Minute,
+		Codecs: []string{"LINEAR16", "MULAW", "ALAW", "FLAC", "MP3", "OGG_OPUS", "WEBM_OPUS",
+			"AMR", "AMR_WB", "MP4_AAC", "M4A_AAC", "MOV_AAC"},
+	}
+}
+
+// capabilities returns the capabilities of the configured provider, or none
+// if it is unknown.
+func (c Config) capabilities() Capabilities {
+	p, err := LookupProvider(c.Provider)
+	if err != nil {
+		return Capabilities{}
+	}
+	return p.Capabilities()
+}
+
+// CapabilityError reports a requested feature a provider does not support.
+// It matches ErrUnsupported with errors.Is.
+type CapabilityError struct {
+	Provider string
+	Feature  string
+}
+
+func (e *CapabilityError) Error() string {
+	return fmt.Sprintf("provider %s doesn't support %s", e.Provider, e.Feature)
+}
+
+func (e *CapabilityError) Unwrap() error { return ErrUnsupported }
+
+// Request lists what a transcription asks of its provider beyond Config.
+type Request struct {
+	OneShot   bool
+	Streaming bool
+	Batch     bool
+	// Duration of the audio, if known; streams longer than the provider's
+	// limit are rejected.
+	Duration time.Duration
+	// Codec of the audio, if known, as named in Capabilities.Codecs.
+	Codec string
+}
+
+// Check reports every feature of c and req that p does not support, as
+// joined *CapabilityErrors.
+func Check(p Provider, c Config, req Request) error {
+	caps := p.Capabilities()
+	var errs []error
+	unsupported := func(feature string) {
+		errs = append(errs, &CapabilityError{Provider: p.Name(), Feature: feature})
+	}
+	if len(caps.Languages) > 0 {
+		for _, lang := range c.LanguageCodes {
+			if !supportsLanguage(caps.Languages, lang) {
+				unsupported("language " + lang)
+			}
+		}
+	}
+	if len(c.LanguageCodes) > 1 && !caps.MultiLanguage {
+		unsupported("alternative languages in one session")
+	}
+	if c.MaxSpeakers > 0 && !caps.Diarization {
+		unsupported("speaker diarization")
+	}
+	if c.WordTimeOffsets && !caps.WordTimings {
+		unsupported("word timings")
+	}
+	if c.WordConfidence && !caps.WordConfidence {
+		unsupported("word confidence")
+	}
+	if len(c.Boost) > 0 && !caps.WordBoost {
+		unsupported("word boost")
+	}
+	if c.RedactPII && !caps.PIIRedaction {
+		unsupported("PII redaction")
+	}
+	if req.OneShot && !caps.OneShot {
+		unsupported("one-shot recognition")
+	}
+	if req.Streaming && !caps.Streaming {
+		unsupported("streaming recognition")
+	}
+	if req.Batch && !caps.Batch {
+		unsupported("batch recognition")
+	}
+	if req.Streaming && caps.MaxStreamDuration > 0 && req.Duration > caps.MaxStreamDuration {
+		unsupported(fmt.Sprintf("streams longer than %s (the audio is %s)", caps.MaxStreamDuration, req.Duration.Round(time.Second)))
+	}
+	if c.Compression != "" && !containsFold(caps.Codecs, c.Compression) {
+		unsupported(strings.ToUpper(c.Compression) + "-compressed streaming")
+	}
+	if req.Codec != "" && !containsFold(caps.Codecs, req.Codec) {
+		unsupported("the " + req.Codec + " codec")
+	}
+	return errors.Join(errs...)
+}
+
+// supportsLanguage reports whether lang, or its base language, is listed.
+func supportsLanguage(languages []string, lang string) bool {
+	base, _, _ := strings.Cut(lang, "-")
+	return containsFold(languages, lang) || containsFold(languages, base)
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
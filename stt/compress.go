@@ -0,0 +1,155 @@
+package stt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+
+	"github.com/mewkiz/flac"
+	"github.com/mewkiz/flac/frame"
+	"github.com/mewkiz/flac/meta"
+)
+
+// CompressionFLAC selects FLAC as Config.Compression.
+const CompressionFLAC = "flac"
+
+// flacStream re-encodes the 16-bit PCM WAV audio sent on a stream as FLAC,
+// which is lossless and typically takes half the bandwidth or less. Audio in
+// any other encoding is sent as it is.
+type flacStream struct {
+	Stream
+
+	// header collects the WAV header until it is complete; passthrough is
+	// set once it shows audio that is not 16-bit PCM.
+	header      []byte
+	passthrough bool
+
+	enc      *flac.Encoder
+	out      bytes.Buffer
+	channels int
+	rate     int
+	// pcm holds audio not yet encoded, less than a block.
+	pcm []byte
+
+	sentPCM, sentFLAC int
+}
+
+// flacFramesPerSecond sets the audio per FLAC frame, 100ms, short enough
+// not to delay recognition.
+const flacFramesPerSecond = 10
+
+func (s *flacStream) SendAudio(ctx context.Context, audio []byte) error {
+	if s.passthrough {
+		return s.Stream.SendAudio(ctx, audio)
+	}
+	if s.enc == nil {
+		s.header = append(s.header, audio...)
+		n := wavHeaderLen(s.header)
+		if n == 0 && len(s.header) >= 4 && string(s.header[:4]) != "RIFF" {
+			log.Printf("Warning: only WAV audio is compressed, sending the audio as it is")
+			s.passthrough = true
+			return s.Stream.SendAudio(ctx, s.header)
+		}
+		if n == 0 {
+			// The header continues in the next chunk
+			return nil
+		}
+		encoding, channels, rate, bits := wavFormat(s.header)
+		if encoding != wavPCM || bits != 16 || channels < 1 || channels > 8 || rate < 16*flacFramesPerSecond {
+			log.Printf("Warning: only 16-bit PCM audio is compressed, sending the audio as it is")
+			s.passthrough = true
+			return s.Stream.SendAudio(ctx, s.header)
+		}
+		s.channels, s.rate = channels, rate
+		// Every frame but the last holds one block; the stream's length is
+		// not known up front
+		block := uint16(rate / flacFramesPerSecond)
+		enc, err := flac.NewEncoder(&s.out, &meta.StreamInfo{
+			BlockSizeMin:  block,
+			BlockSizeMax:  block,
+			SampleRate:    uint32(rate),
+			NChannels:     uint8(channels),
+			BitsPerSample: 16,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to start FLAC encoding: %w", err)
+		}
+		s.enc = enc
+		audio = s.header[n:]
+		s.header = nil
+	}
+	s.pcm = append(s.pcm, audio...)
+	s.sentPCM += len(audio)
+	block := s.rate / flacFramesPerSecond * s.channels * 2
+	for len(s.pcm) >= block {
+		if err := s.encode(s.pcm[:block]); err != nil {
+			return err
+		}
+		s.pcm = s.pcm[block:]
+	}
+	return s.flush(ctx)
+}
+
+// encode encodes pcm as a FLAC frame into s.out.
+func (s *flacStream) encode(pcm []byte) error {
+	n := len(pcm) / (s.channels * 2)
+	if n == 0 {
+		return nil
+	}
+	f := &frame.Frame{
+		Header: frame.Header{
+			HasFixedBlockSize: true,
+			BlockSize:         uint16(n),
+			SampleRate:        uint32(s.rate),
+			Channels:          frame.Channels(s.channels - 1),
+			BitsPerSample:     16,
+		},
+		Subframes: make([]*frame.Subframe, s.channels),
+	}
+	for ch := range f.Subframes {
+		samples := make([]int32, n)
+		for i := range samples {
+			at := (i*s.channels + ch) * 2
+			samples[i] = int32(int16(binary.LittleEndian.Uint16(pcm[at:])))
+		}
+		// The encoder picks a better predictor for verbatim subframes
+		f.Subframes[ch] = &frame.Subframe{
+			SubHeader: frame.SubHeader{Pred: frame.PredVerbatim},
+			Samples:   samples,
+			NSamples:  n,
+		}
+	}
+	if err := s.enc.WriteFrame(f); err != nil {
+		return fmt.Errorf("failed to encode FLAC frame: %w", err)
+	}
+	return nil
+}
+
+// flush sends the encoded audio not yet sent.
+func (s *flacStream) flush(ctx context.Context) error {
+	if s.out.Len() == 0 {
+		return nil
+	}
+	s.sentFLAC += s.out.Len()
+	err := s.Stream.SendAudio(ctx, bytes.Clone(s.out.Bytes()))
+	s.out.Reset()
+	return err
+}
+
+func (s *flacStream) CloseSend() error {
+	if s.enc != nil {
+		if err := s.encode(s.pcm); err != nil {
+			return err
+		}
+		s.pcm = nil
+		if err := s.flush(context.Background()); err != nil {
+			return err
+		}
+		if s.sentPCM > 0 {
+			log.Printf("Sent %d bytes of FLAC for %d bytes of PCM (%.0f%%)", s.sentFLAC, s.sentPCM, 100*float64(s.sentFLAC)/float64(s.sentPCM))
+		}
+	}
+	return s.Stream.CloseSend()
+}
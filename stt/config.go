@@ -0,0 +1,150 @@
+package stt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	speech "cloud.google.com/go/speech/apiv2"
+	speechpb "cloud.google.com/go/speech/apiv2/speechpb"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc/metadata"
+)
+
+// DefaultModel is the recognition model used when none is configured.
+const DefaultModel = "latest_long"
+
+// boostWeight is the boost given to Config.Boost phrases, in the middle of
+// the range Google recommends.
+const boostWeight = 10
+
+// Config identifies the recognizer and the recognition settings of a session.
+type Config struct {
+	// Provider names the recognition backend, as registered with
+	// RegisterProvider; empty selects Google.
+	Provider      string
+	ProjectID     string
+	Region        string
+	RecognizerID  string
+	LanguageCodes []string
+	Model         string
+	// FallbackModel is used instead of Model, with a warning, when Model is
+	// not available for the region or language.
+	FallbackModel string
+	// WordTimeOffsets requests per-word timings in results.
+	WordTimeOffsets bool
+	// WordConfidence requests per-word confidences in results.
+	WordConfidence bool
+	// Boost lists words and phrases recognition is biased towards, such as
+	// product names.
+	Boost []string
+	// RedactPII asks the provider to redact personal information such as
+	// names and phone numbers from results.
+	RedactPII bool
+	// MaxSpeakers enables speaker diarization for up to this many speakers.
+	MaxSpeakers int
+	// ChunkDuration and ChunkOverlap size the overlapping windows providers
+	// without native streaming, such as Whisper, transcribe streamed audio
+	// in; zero selects the provider's defaults.
+	ChunkDuration time.Duration
+	ChunkOverlap  time.Duration
+	// Compression re-encodes 16-bit PCM audio before streaming it, to save
+	// uplink bandwidth: CompressionFLAC, or empty to send it as it is. The
+	// provider must decode the codec.
+	Compression string
+	// ClientOptions are passed to the Google speech clients after the
+	// regional endpoint, to authenticate with a custom token source, set a
+	// user agent or dial gRPC differently. An option.WithEndpoint among them
+	// overrides the region's.
+	ClientOptions []option.ClientOption
+	// Metadata is sent as gRPC metadata with every Google call of the
+	// session, for attributing and auditing API usage, such as
+	// x-goog-request-reason or an organization's own labels.
+	Metadata map[string]string
+}
+
+func (c Config) model() string {
+	if c.Model == "" {
+		return DefaultModel
+	}
+	return c.Model
+}
+
+// withFallback returns the config switched to the fallback model if err
+// reports the configured model as unavailable.
+func (c Config) withFallback(err error) (Config, bool) {
+	if c.FallbackModel == "" || c.FallbackModel == c.model() || !errors.Is(err, ErrModelUnavailable) {
+		return c, false
+	}
+	log.Printf("Warning: model %s is not available for %v in %s, falling back to %s",
+		c.model(), c.LanguageCodes, c.Region, c.FallbackModel)
+	c.Model = c.FallbackModel
+	return c, true
+}
+
+// outgoing returns ctx carrying the configured metadata for a call.
+func (c Config) outgoing(ctx context.Context) context.Context {
+	if len(c.Metadata) == 0 {
+		return ctx
+	}
+	kv := make([]string, 0, 2*len(c.Metadata))
+	for k, v := range c.Metadata {
+		kv = append(kv, k, v)
+	}
+	return metadata.AppendToOutgoingContext(ctx, kv...)
+}
+
+// Recognizer returns the full resource name of the configured recognizer.
+func (c Config) Recognizer() string {
+	return fmt.Sprintf("projects/%s/locations/%s/recognizers/%s",
+		c.ProjectID, c.Region, c.RecognizerID)
+}
+
+// Endpoint returns the regional API endpoint for the configured region.
+func (c Config) Endpoint() string {
+	return fmt.Sprintf("%s-speech.googleapis.com:443", c.Region)
+}
+
+// RecognitionConfig builds the request config sent with every recognition.
+func (c Config) RecognitionConfig() *speechpb.RecognitionConfig {
+	rc := &speechpb.RecognitionConfig{
+		DecodingConfig: &speechpb.RecognitionConfig_AutoDecodingConfig{
+			AutoDecodingConfig: &speechpb.AutoDetectDecodingConfig{},
+		},
+		LanguageCodes: c.LanguageCodes,
+		Model:         c.model(),
+	}
+	if c.WordTimeOffsets || c.MaxSpeakers > 0 || c.WordConfidence {
+		rc.Features = &speechpb.RecognitionFeatures{
+			EnableWordTimeOffsets: c.WordTimeOffsets || c.MaxSpeakers > 0,
+			EnableWordConfidence:  c.WordConfidence,
+		}
+	}
+	if len(c.Boost) > 0 {
+		phrases := make([]*speechpb.PhraseSet_Phrase, len(c.Boost))
+		for i, phrase := range c.Boost {
+			phrases[i] = &speechpb.PhraseSet_Phrase{Value: phrase, Boost: boostWeight}
+		}
+		rc.Adaptation = inlinePhrases(phrases)
+	}
+	if c.MaxSpeakers > 0 {
+		rc.Features.DiarizationConfig = &speechpb.SpeakerDiarizationConfig{
+			MinSpeakerCount: 1,
+			MaxSpeakerCount: int32(c.MaxSpeakers),
+		}
+	}
+	return rc
+}
+
+// NewClient creates a speech client bound to the configured regional
+// endpoint, with the configured client options.
+func NewClient(ctx context.Context, c Config) (*speech.Client, error) {
+	opts := append([]option.ClientOption{option.WithEndpoint(c.Endpoint())}, c.ClientOptions...)
+	client, err := speech.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, wrapError("create speech client", err)
+	}
+	return client, nil
+}
@@ -0,0 +1,88 @@
+package stt
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	speechpb "cloud.google.com/go/speech/apiv2/speechpb"
+	"google.golang.org/grpc/codes"
+)
+
+// WAV encodings besides wavPCM that Google decodes.
+const (
+	wavALaw       = 6
+	wavMuLaw      = 7
+	wavExtensible = 0xFFFE
+)
+
+// wavDecoding returns the explicit decoding config of WAV audio from its
+// header, and the length of the header, which must not be sent with it.
+// Sending the format explicitly avoids the recognizer misdetecting it. For
+// audio that is not WAV, or whose header is incomplete, it returns nil and 0
+// so the format is detected. WAV audio Google cannot decode is reported as
+// ErrAudioFormat before any of it is sent.
+func wavDecoding(audio []byte) (*speechpb.ExplicitDecodingConfig, int, error) {
+	n := wavHeaderLen(audio)
+	if n == 0 {
+		return nil, 0, nil
+	}
+	encoding, channels, rate, bits := wavFormat(audio)
+	if encoding == wavExtensible {
+		encoding = wavSubFormat(audio)
+	}
+	var enc speechpb.ExplicitDecodingConfig_AudioEncoding
+	switch {
+	case encoding == wavPCM && bits == 16:
+		enc = speechpb.ExplicitDecodingConfig_LINEAR16
+	case encoding == wavMuLaw && bits == 8:
+		enc = speechpb.ExplicitDecodingConfig_MULAW
+	case encoding == wavALaw && bits == 8:
+		enc = speechpb.ExplicitDecodingConfig_ALAW
+	case encoding == wavPCM:
+		return nil, 0, audioFormatError("%d-bit PCM WAV audio is not supported, only 16-bit", bits)
+	case encoding == 0:
+		return nil, 0, audioFormatError("WAV audio has no format chunk")
+	default:
+		return nil, 0, audioFormatError("WAV encoding %#x is not supported, only PCM, mu-law and A-law", encoding)
+	}
+	if rate < 8000 || rate > 48000 {
+		return nil, 0, audioFormatError("WAV sample rate %d Hz is not between 8000 and 48000 Hz", rate)
+	}
+	if channels < 1 || channels > 8 {
+		return nil, 0, audioFormatError("WAV channel count %d is not between 1 and 8", channels)
+	}
+	return &speechpb.ExplicitDecodingConfig{
+		Encoding:          enc,
+		SampleRateHertz:   int32(rate),
+		AudioChannelCount: int32(channels),
+	}, n, nil
+}
+
+// wavSubFormat returns the encoding of a WAVE_FORMAT_EXTENSIBLE "fmt "
+// chunk, the first two bytes of its sub-format GUID, or 0.
+func wavSubFormat(audio []byte) int {
+	n := wavHeaderLen(audio)
+	for pos := 12; pos+8 <= n; {
+		size := int(binary.LittleEndian.Uint32(audio[pos+4 : pos+8]))
+		if string(audio[pos:pos+4]) == "fmt " && size >= 40 && pos+34 <= len(audio) {
+			return int(binary.LittleEndian.Uint16(audio[pos+32 : pos+34]))
+		}
+		pos += 8 + size + size%2
+	}
+	return 0
+}
+
+// audioFormatError returns an ErrAudioFormat error for audio rejected before
+// it was sent.
+func audioFormatError(format string, args ...any) error {
+	return &Error{Op: "decode audio", Kind: ErrAudioFormat, Code: codes.InvalidArgument, Err: fmt.Errorf(format, args...)}
+}
+
+// withDecoding returns rc decoding audio with d, or detecting its format if
+// d is nil.
+func withDecoding(rc *speechpb.RecognitionConfig, d *speechpb.ExplicitDecodingConfig) *speechpb.RecognitionConfig {
+	if d != nil {
+		rc.DecodingConfig = &speechpb.RecognitionConfig_ExplicitDecodingConfig{ExplicitDecodingConfig: d}
+	}
+	return rc
+}
@@ -0,0 +1,79 @@
+package stt
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	speech "cloud.google.com/go/speech/apiv2"
+	speechpb "cloud.google.com/go/speech/apiv2/speechpb"
+)
+
+// Drift is a setting on which the recognizer's stored default config and the
+// requested config disagree. Requested settings override the recognizer's
+// defaults, but defaults the request leaves unset still apply.
+type Drift struct {
+	Field      string
+	Recognizer string
+	Requested  string
+}
+
+func (d Drift) String() string {
+	return fmt.Sprintf("%s: recognizer has %s, requested %s", d.Field, d.Recognizer, d.Requested)
+}
+
+// CheckRecognizer fetches the configured recognizer and compares its default
+// config with c.
+func CheckRecognizer(ctx context.Context, client *speech.Client, c Config) ([]Drift, error) {
+	rec, err := client.GetRecognizer(c.outgoing(ctx), &speechpb.GetRecognizerRequest{Name: c.Recognizer()})
+	if err != nil {
+		return nil, wrapError("get recognizer", err)
+	}
+	return compareRecognizer(rec, c.RecognitionConfig()), nil
+}
+
+func compareRecognizer(rec *speechpb.Recognizer, req *speechpb.RecognitionConfig) []Drift {
+	def := rec.GetDefaultRecognitionConfig()
+	var drift []Drift
+	add := func(field, recognizer, requested string) {
+		drift = append(drift, Drift{Field: field, Recognizer: recognizer, Requested: requested})
+	}
+
+	// The deprecated recognizer-level model and languages still pin older
+	// recognizers
+	model := def.GetModel()
+	if model == "" {
+		model = rec.GetModel()
+	}
+	if model != "" && model != req.GetModel() {
+		add("model", model, req.GetModel())
+	}
+	langs := def.GetLanguageCodes()
+	if len(langs) == 0 {
+		langs = rec.GetLanguageCodes()
+	}
+	if len(langs) > 0 && !slices.Equal(langs, req.GetLanguageCodes()) {
+		add("language_codes", strings.Join(langs, ","), strings.Join(req.GetLanguageCodes(), ","))
+	}
+	if def.GetExplicitDecodingConfig() != nil && req.GetAutoDecodingConfig() != nil {
+		add("decoding", "explicit "+def.GetExplicitDecodingConfig().GetEncoding().String(), "auto")
+	}
+
+	// Features the request does not set are inherited from the recognizer
+	f, rf := def.GetFeatures(), req.GetFeatures()
+	if f.GetProfanityFilter() && !rf.GetProfanityFilter() {
+		add("profanity_filter", "enabled", "unset")
+	}
+	if f.GetDiarizationConfig() != nil && rf.GetDiarizationConfig() == nil {
+		add("diarization", "enabled", "unset")
+	}
+	if f.GetMultiChannelMode() != speechpb.RecognitionFeatures_MULTI_CHANNEL_MODE_UNSPECIFIED &&
+		rf.GetMultiChannelMode() == speechpb.RecognitionFeatures_MULTI_CHANNEL_MODE_UNSPECIFIED {
+		add("multi_channel_mode", f.GetMultiChannelMode().String(), "unset")
+	}
+	if f.GetMaxAlternatives() > 1 && rf.GetMaxAlternatives() == 0 {
+		add("max_alternatives", fmt.Sprint(f.GetMaxAlternatives()), "unset")
+	}
+	return drift
+}
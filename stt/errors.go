@@ -0,0 +1,85 @@
+package stt
+
+import (
+	"errors"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Error kinds a failed Speech-to-Text call is classified as. Use errors.Is to
+// branch on them; the *Error wrapping them carries the operation and the
+// underlying gRPC status.
+var (
+	// ErrAudioFormat reports audio the recognizer could not decode.
+	ErrAudioFormat = errors.New("unsupported audio format")
+	// ErrQuota reports an exhausted project quota or rate limit.
+	ErrQuota = errors.New("quota exceeded")
+	// ErrAuth reports missing or insufficient credentials.
+	ErrAuth = errors.New("not authorized")
+	// ErrModelUnavailable reports a model that is not available for the
+	// requested region or language.
+	ErrModelUnavailable = errors.New("model not available")
+	// ErrStreamLimit reports a stream that exceeded the maximum duration or
+	// went too long without audio.
+	ErrStreamLimit = errors.New("stream limit exceeded")
+	// ErrUnreachable reports a service that could not be reached, such as
+	// when the network is down.
+	ErrUnreachable = errors.New("service unreachable")
+)
+
+// Error is a failed Speech-to-Text call.
+type Error struct {
+	// Op describes the failed operation, such as "recognize audio".
+	Op string
+	// Kind is one of the Err values above, or nil if the failure is not
+	// classified.
+	Kind error
+	// Code is the gRPC status code of the failure.
+	Code codes.Code
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return "failed to " + e.Op + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() []error {
+	if e.Kind == nil {
+		return []error{e.Err}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// wrapError wraps err from a call performing op, classifying it by its gRPC
+// status.
+func wrapError(op string, err error) error {
+	code := status.Code(err)
+	return &Error{Op: op, Kind: classify(code, err), Code: code, Err: err}
+}
+
+func classify(code codes.Code, err error) error {
+	switch code {
+	case codes.Unauthenticated, codes.PermissionDenied:
+		return ErrAuth
+	case codes.ResourceExhausted:
+		return ErrQuota
+	case codes.Unavailable:
+		return ErrUnreachable
+	case codes.OutOfRange:
+		return ErrStreamLimit
+	case codes.InvalidArgument:
+		msg := strings.ToLower(status.Convert(err).Message())
+		switch {
+		case strings.Contains(msg, "model"):
+			return ErrModelUnavailable
+		case strings.Contains(msg, "stream duration") || strings.Contains(msg, "audio timeout"):
+			return ErrStreamLimit
+		case strings.Contains(msg, "audio") || strings.Contains(msg, "encoding") ||
+			strings.Contains(msg, "sample rate") || strings.Contains(msg, "decod"):
+			return ErrAudioFormat
+		}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package stt
+
+import (
+	"context"
+	"expvar"
+	"sync"
+	"time"
+)
+
+// Event is published on a session's Bus as the session progresses.
+type Event interface {
+	event()
+}
+
+// StreamOpened is published when a recognition stream is opened with
+// Config, sending the audio from Offset.
+type StreamOpened struct {
+	Config Config
+	Offset int
+}
+
+// AudioSent is published after each audio chunk is sent.
+type AudioSent struct {
+	// Bytes is the size of the chunk; Offset is the input position after it.
+	Bytes  int
+	Offset int
+	// Audio is the chunk itself; subscribers must not modify or retain it.
+	Audio []byte
+}
+
+// PartialResult is published for every interim result.
+type PartialResult struct {
+	Result Result
+}
+
+// FinalResult is published for every final result.
+type FinalResult struct {
+	Result Result
+}
+
+// StreamRestarted is published when a stalled stream is replaced, resuming
+// from Offset.
+type StreamRestarted struct {
+	Offset int
+	Reason error
+}
+
+// SessionTruncated is published when a session stops sending audio at Offset
+// because Billed reached SessionOptions.MaxBilled. Results for the audio
+// already sent are still delivered.
+type SessionTruncated struct {
+	Offset int
+	Billed time.Duration
+}
+
+// SessionEnded is published once when a session finishes; Err is nil on
+// success.
+type SessionEnded struct {
+	Err error
+}
+
+func (StreamOpened) event()     {}
+func (AudioSent) event()        {}
+func (PartialResult) event()    {}
+func (FinalResult) event()      {}
+func (StreamRestarted) event()  {}
+func (SessionTruncated) event() {}
+func (SessionEnded) event()     {}
+
+// Bus delivers session events synchronously, in publish order, to every
+// subscriber.
+type Bus struct {
+	mu     sync.RWMutex
+	nextID int
+	subs   map[int]func(context.Context, Event)
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: map[int]func(context.Context, Event){}}
+}
+
+// Subscribe registers fn for every event and returns a function removing it.
+func (b *Bus) Subscribe(fn func(context.Context, Event)) (unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.nextID
+	b.nextID++
+	b.subs[id] = fn
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs, id)
+	}
+}
+
+// Publish delivers e to all subscribers before returning.
+func (b *Bus) Publish(ctx context.Context, e Event) {
+	b.mu.RLock()
+	subs := make([]func(context.Context, Event), 0, len(b.subs))
+	for _, fn := range b.subs {
+		subs = append(subs, fn)
+	}
+	b.mu.RUnlock()
+	for _, fn := range subs {
+		fn(ctx, e)
+	}
+}
+
+// On subscribes fn to events of type E only.
+func On[E Event](b *Bus, fn func(context.Context, E)) (unsubscribe func()) {
+	return b.Subscribe(func(ctx context.Context, e Event) {
+		if e, ok := e.(E); ok {
+			fn(ctx, e)
+		}
+	})
+}
+
+var (
+	audioBytesSent = expvar.NewInt("stt_audio_bytes_sent")
+	partialResults = expvar.NewInt("stt_partial_results")
+	finalResults   = expvar.NewInt("stt_final_results")
+	streamRestarts = expvar.NewInt("stt_stream_restarts")
+	sessionsEnded  = expvar.NewInt("stt_sessions_ended")
+	sessionsFailed = expvar.NewInt("stt_sessions_failed")
+)
+
+// subscribeMetrics keeps the expvar counters up to date from b.
+func subscribeMetrics(b *Bus) {
+	b.Subscribe(func(_ context.Context, e Event) {
+		switch e := e.(type) {
+		case AudioSent:
+			audioBytesSent.Add(int64(e.Bytes))
+		case PartialResult:
+			partialResults.Add(1)
+		case FinalResult:
+			finalResults.Add(1)
+		case StreamRestarted:
+			streamRestarts.Add(1)
+		case SessionEnded:
+			sessionsEnded.Add(1)
+			if e.Err != nil {
+				sessionsFailed.Add(1)
+			}
+		}
+	})
+}
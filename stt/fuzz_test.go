@@ -0,0 +1,30 @@
+package stt
+
+import (
+	"testing"
+
+	"stt-receivetranscription-mve/audio"
+)
+
+// FuzzWAVHeader parses data as the WAV header of an upload or stream is
+// parsed before recognition: for its duration, its fit for one-shot
+// recognition and its explicit decoding config.
+func FuzzWAVHeader(f *testing.F) {
+	a := &audio.Audio{SampleRate: 16000, Channels: 1, Samples: make([]float32, 1600)}
+	f.Add(a.WAV())
+	f.Add(audio.StreamHeader(8000, 2))
+	f.Add([]byte("RIFF\x24\x00\x00\x00WAVEfmt "))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if d, ok := WAVDuration(data); ok && d < 0 {
+			t.Fatalf("negative duration %s", d)
+		}
+		FitsOneShot(data)
+		decoding, n, err := wavDecoding(data)
+		if err != nil || decoding == nil {
+			return
+		}
+		if n <= 0 || n > len(data) {
+			t.Fatalf("header length %d of %d bytes", n, len(data))
+		}
+	})
+}
@@ -0,0 +1,245 @@
+package stt
+
+import (
+	"bytes"
+	"context"
+	"expvar"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// keepaliveChunks counts the chunks of silence injected into idle live
+// audio, exported through expvar.
+var keepaliveChunks = expvar.NewInt("stt_keepalive_chunks")
+
+// audioSource is the audio a session streams, either complete or arriving
+// in real time.
+type audioSource interface {
+	// wait blocks until n bytes past offset are available or the audio has
+	// ended, and returns the audio available so far.
+	wait(ctx context.Context, offset, n int) (audioWindow, error)
+	// release lets the source drop the audio before offset, which no stream
+	// will resume from.
+	release(offset int)
+	// live reports whether the audio arrives in real time, which paces
+	// sending by itself.
+	live() bool
+}
+
+// audioWindow is the part of a source's audio that is kept: data holds the
+// audio from offset start on. Offsets count from the start of the audio,
+// however much of it has been dropped.
+type audioWindow struct {
+	// header is the WAV header, or empty for other audio or until it has
+	// been read in full.
+	header []byte
+	data   []byte
+	start  int
+}
+
+// end returns the offset following the audio available so far.
+func (w audioWindow) end() int {
+	return w.start + len(w.data)
+}
+
+// slice returns the audio from offset i to j.
+func (w audioWindow) slice(i, j int) []byte {
+	return w.data[i-w.start : j-w.start]
+}
+
+type bufferedAudio []byte
+
+func (b bufferedAudio) wait(context.Context, int, int) (audioWindow, error) {
+	return audioWindow{header: b[:wavHeaderLen(b)], data: b}, nil
+}
+
+func (bufferedAudio) release(int) {}
+
+func (bufferedAudio) live() bool { return false }
+
+// liveAudio is audio read from a live source such as a microphone. Audio is
+// kept from the last final result on, so a stalled stream can resume there,
+// and dropped once released.
+type liveAudio struct {
+	mu sync.Mutex
+	// header is the WAV header once read in full; data holds the audio from
+	// offset start on.
+	header []byte
+	data   []byte
+	start  int
+	// err is io.EOF once the source has ended.
+	err error
+	// grown is closed and replaced whenever data grows or err is set.
+	grown chan struct{}
+
+	// want is the offset up to which audio has been waited for; wanted is
+	// closed and replaced whenever it grows. stopped is closed once the
+	// audio is no longer read.
+	want    int
+	wanted  chan struct{}
+	stopped chan struct{}
+}
+
+// pipeReadAhead is how far past the audio waited for piped audio is read,
+// so the next chunk is at hand when it is due.
+const pipeReadAhead = 64 << 10
+
+// readLive reads r in the background until it returns an error. With ahead
+// 0 it is read as it arrives, as audio captured in real time must be;
+// otherwise at most about ahead bytes past the audio waited for are read, so
+// a pipe or download is read only as fast as it is sent rather than into
+// memory.
+func readLive(r io.Reader, ahead int) *liveAudio {
+	l := &liveAudio{grown: make(chan struct{}), wanted: make(chan struct{}), stopped: make(chan struct{})}
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			if ahead > 0 && !l.readable(ahead) {
+				return
+			}
+			n, err := r.Read(buf)
+			l.mu.Lock()
+			l.data = append(l.data, buf[:n]...)
+			if l.header == nil && l.start == 0 {
+				if h := wavHeaderLen(l.data); h > 0 {
+					l.header = bytes.Clone(l.data[:h])
+				}
+			}
+			l.err = err
+			close(l.grown)
+			l.grown = make(chan struct{})
+			l.mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return l
+}
+
+// readable blocks until less than ahead bytes past the audio waited for
+// have been read, and reports false if the audio is stopped first.
+func (l *liveAudio) readable(ahead int) bool {
+	for {
+		l.mu.Lock()
+		ok, wanted := l.start+len(l.data) < l.want+ahead, l.wanted
+		l.mu.Unlock()
+		if ok {
+			return true
+		}
+		select {
+		case <-wanted:
+		case <-l.stopped:
+			return false
+		}
+	}
+}
+
+// stop ends reading the audio once no more of it will be waited for.
+func (l *liveAudio) stop() {
+	close(l.stopped)
+}
+
+func (l *liveAudio) wait(ctx context.Context, offset, n int) (audioWindow, error) {
+	l.mu.Lock()
+	if offset+n > l.want {
+		l.want = offset + n
+		close(l.wanted)
+		l.wanted = make(chan struct{})
+	}
+	l.mu.Unlock()
+	for {
+		l.mu.Lock()
+		w := audioWindow{header: l.header, data: l.data, start: l.start}
+		err, grown := l.err, l.grown
+		l.mu.Unlock()
+		if w.end() >= offset+n || err == io.EOF {
+			return w, nil
+		}
+		if err != nil {
+			return audioWindow{}, fmt.Errorf("failed to read live audio: %w", err)
+		}
+		select {
+		case <-grown:
+		case <-ctx.Done():
+			return audioWindow{}, ctx.Err()
+		}
+	}
+}
+
+func (l *liveAudio) release(offset int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if offset <= l.start {
+		return
+	}
+	// Windows handed out keep the old array; appends go to a new one
+	l.data = bytes.Clone(l.data[min(offset-l.start, len(l.data)):])
+	l.start = offset
+}
+
+func (*liveAudio) live() bool { return true }
+
+// keepalive appends size bytes of silence whenever no audio has arrived for
+// idle, until the audio ends or ctx is done, so that the stream keeps
+// flowing through gaps such as push-to-talk pauses. Silence can only be made
+// for PCM WAV audio; other audio is left alone.
+func (l *liveAudio) keepalive(ctx context.Context, idle time.Duration, size int) {
+	timer := time.NewTimer(idle)
+	defer timer.Stop()
+	for {
+		l.mu.Lock()
+		grown, err := l.grown, l.err
+		l.mu.Unlock()
+		if err != nil {
+			return
+		}
+		select {
+		case <-grown:
+		case <-timer.C:
+			l.appendSilence(size)
+		case <-ctx.Done():
+			return
+		}
+		timer.Reset(idle)
+	}
+}
+
+// appendSilence appends at least size bytes of silence, in whole sample
+// frames, unless the format is unknown or a frame is only partly read.
+func (l *liveAudio) appendSilence(size int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := len(l.header)
+	encoding, channels, _, bits := wavFormat(l.header)
+	if n == 0 || encoding != wavPCM || channels == 0 || bits == 0 || bits%8 != 0 {
+		return
+	}
+	frame := channels * bits / 8
+	if (l.start+len(l.data)-n)%frame != 0 {
+		return
+	}
+	size = (size + frame - 1) / frame * frame
+	// 8-bit PCM is unsigned, centered on 128
+	var zero byte
+	if bits == 8 {
+		zero = 0x80
+	}
+	for range size {
+		l.data = append(l.data, zero)
+	}
+	close(l.grown)
+	l.grown = make(chan struct{})
+	keepaliveChunks.Add(1)
+}
+
+// pipedAudio is audio read from a pipe as it arrives. A pipe may deliver
+// audio faster than real time, so unlike liveAudio it is paced, and read no
+// faster than it is sent.
+type pipedAudio struct {
+	*liveAudio
+}
+
+func (pipedAudio) live() bool { return false }
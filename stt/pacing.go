@@ -0,0 +1,95 @@
+package stt
+
+import (
+	"context"
+	"time"
+)
+
+// pacer delays sending after each chunk of n bytes.
+type pacer interface {
+	wait(ctx context.Context, n int) error
+	stop()
+}
+
+// newPacer returns the pacer configured by opts: a token bucket when
+// ByteRate is set, otherwise one at byteRate, the playback rate of WAV
+// audio, times RealtimeFactor, or for audio of unknown rate one chunk per
+// ChunkInterval divided by it. Live audio is not paced unless ByteRate is
+// set, since it arrives in real time.
+func newPacer(opts SessionOptions, live bool, byteRate int) pacer {
+	if opts.ByteRate > 0 {
+		return newTokenBucket(float64(opts.ByteRate), opts.Burst, opts.ChunkSize)
+	}
+	if live {
+		return noPacer{}
+	}
+	if byteRate > 0 {
+		return newTokenBucket(float64(byteRate)*opts.RealtimeFactor, opts.Burst, opts.ChunkSize)
+	}
+	interval := time.Duration(float64(opts.ChunkInterval) / opts.RealtimeFactor)
+	return &tickerPacer{ticker: time.NewTicker(max(interval, time.Millisecond))}
+}
+
+type noPacer struct{}
+
+func (noPacer) wait(context.Context, int) error { return nil }
+
+func (noPacer) stop() {}
+
+type tickerPacer struct {
+	ticker *time.Ticker
+}
+
+func (p *tickerPacer) wait(ctx context.Context, n int) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-p.ticker.C:
+		return nil
+	}
+}
+
+func (p *tickerPacer) stop() { p.ticker.Stop() }
+
+// tokenBucket limits sending to rate bytes per second, allowing bursts of up
+// to burst bytes.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns a full token bucket of rate bytes per second,
+// holding burst bytes, or chunkSize if burst is not set.
+func newTokenBucket(rate float64, burst, chunkSize int) *tokenBucket {
+	if burst <= 0 {
+		burst = chunkSize
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+func (b *tokenBucket) wait(ctx context.Context, n int) error {
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return nil
+	}
+	timer := time.NewTimer(time.Duration(-b.tokens / b.rate * float64(time.Second)))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (b *tokenBucket) stop() {}
@@ -0,0 +1,186 @@
+package stt
+
+import (
+	"strings"
+	"time"
+)
+
+// ParagraphOptions tunes how Transcript.Paragraphs groups speech.
+type ParagraphOptions struct {
+	// Pause starts a new paragraph after silence of at least this long; it
+	// defaults to 2s.
+	Pause time.Duration
+	// MaxSentences and MaxDuration end a paragraph at the next sentence
+	// boundary once it has this many sentences or lasts this long. They
+	// default to 5 sentences and 1m.
+	MaxSentences int
+	MaxDuration  time.Duration
+}
+
+func (o ParagraphOptions) withDefaults() ParagraphOptions {
+	if o.Pause <= 0 {
+		o.Pause = 2 * time.Second
+	}
+	if o.MaxSentences <= 0 {
+		o.MaxSentences = 5
+	}
+	if o.MaxDuration <= 0 {
+		o.MaxDuration = time.Minute
+	}
+	return o
+}
+
+// Paragraphs returns the transcript with its speech regrouped into
+// paragraphs for reading, instead of one segment per recognition result. A
+// paragraph ends when the speaker changes, after a long pause, or at a
+// sentence boundary once it is long enough. Segments with word timings may
+// be split between words; event segments stand on their own.
+func (t Transcript) Paragraphs(opts ParagraphOptions) Transcript {
+	opts = opts.withDefaults()
+	var (
+		segments []Segment
+		cur      *paragraph
+	)
+	end := func() {
+		if cur != nil {
+			segments = append(segments, cur.segment())
+			cur = nil
+		}
+	}
+	for _, seg := range t.Segments {
+		if seg.Event != "" {
+			end()
+			segments = append(segments, seg)
+			continue
+		}
+		for _, p := range pieces(seg) {
+			if cur != nil && cur.breaksBefore(p, opts) {
+				end()
+			}
+			if cur == nil {
+				cur = &paragraph{}
+			}
+			cur.add(p)
+		}
+	}
+	end()
+
+	paragraphs := Transcript{Segments: segments, Truncated: t.Truncated}
+	markOverlaps(paragraphs.Segments)
+	paragraphs.finish()
+	return paragraphs
+}
+
+// piece is the smallest unit a paragraph is built from: a word of a segment
+// with word timings, or else the whole segment.
+type piece struct {
+	seg Segment
+	// word is set when the piece is a single word of seg.
+	word *Word
+}
+
+func pieces(seg Segment) []piece {
+	if len(seg.Words) == 0 {
+		return []piece{{seg: seg}}
+	}
+	ps := make([]piece, len(seg.Words))
+	for i := range seg.Words {
+		ps[i] = piece{seg: seg, word: &seg.Words[i]}
+	}
+	return ps
+}
+
+func (p piece) text() string {
+	if p.word != nil {
+		return p.word.Text
+	}
+	return p.seg.Text
+}
+
+func (p piece) start() time.Duration {
+	if p.word != nil {
+		return p.word.Start
+	}
+	return p.seg.Start
+}
+
+func (p piece) end() time.Duration {
+	if p.word != nil {
+		return p.word.End
+	}
+	return p.seg.End
+}
+
+func (p piece) speaker() string {
+	if p.word != nil && p.word.Speaker != "" {
+		return p.word.Speaker
+	}
+	return p.seg.Speaker
+}
+
+// paragraph accumulates the pieces of one paragraph.
+type paragraph struct {
+	pieces    []piece
+	sentences int
+}
+
+func (c *paragraph) add(p piece) {
+	c.pieces = append(c.pieces, p)
+	c.sentences += sentenceEnds(p.text())
+}
+
+// breaksBefore reports whether p starts a new paragraph.
+func (c *paragraph) breaksBefore(p piece, opts ParagraphOptions) bool {
+	last := c.pieces[len(c.pieces)-1]
+	switch {
+	case p.speaker() != last.speaker():
+		return true
+	case p.start()-last.end() >= opts.Pause:
+		return true
+	}
+	long := c.sentences >= opts.MaxSentences || last.end()-c.pieces[0].start() >= opts.MaxDuration
+	return long && endsSentence(last.text())
+}
+
+// segment joins the pieces into one segment, with the confidence of its
+// segments weighted by their share of the paragraph's pieces.
+func (c *paragraph) segment() Segment {
+	first := c.pieces[0]
+	seg := Segment{
+		Start:    first.start(),
+		End:      c.pieces[len(c.pieces)-1].end(),
+		Language: first.seg.Language,
+		Speaker:  first.speaker(),
+	}
+	var (
+		texts      []string
+		confidence float32
+	)
+	for _, p := range c.pieces {
+		texts = append(texts, p.text())
+		confidence += p.seg.Confidence
+		if p.word != nil {
+			seg.Words = append(seg.Words, *p.word)
+		}
+	}
+	seg.Text = strings.Join(texts, " ")
+	seg.Confidence = confidence / float32(len(c.pieces))
+	return seg
+}
+
+// endsSentence reports whether text ends with sentence punctuation.
+func endsSentence(text string) bool {
+	text = strings.TrimRight(text, `"')]”’ `)
+	return strings.HasSuffix(text, ".") || strings.HasSuffix(text, "!") || strings.HasSuffix(text, "?")
+}
+
+// sentenceEnds counts the sentence boundaries in text.
+func sentenceEnds(text string) int {
+	n := 0
+	for _, f := range strings.Fields(text) {
+		if endsSentence(f) {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,252 @@
+package stt
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// qualityMetrics publishes the rolling confidence and error rate of every
+// recognizer watched, as "<recognizer>.confidence" and
+// "<recognizer>.error_rate".
+var qualityMetrics = expvar.NewMap("stt_quality")
+
+// Quality metrics a QualityAlert can be about.
+const (
+	MetricConfidence = "confidence"
+	MetricErrorRate  = "error_rate"
+)
+
+// QualityOptions configures a QualityMonitor.
+type QualityOptions struct {
+	// Window is how many of the latest sessions of each recognizer the
+	// rolling averages cover; it defaults to 50.
+	Window int
+	// MinSessions is how many sessions a recognizer needs in its window
+	// before it can raise alerts; it defaults to 10.
+	MinSessions int
+	// MinConfidence raises an alert when the mean confidence of the final
+	// results in the window drops below it; 0 disables it.
+	MinConfidence float64
+	// MaxErrorRate raises an alert when the share of sessions in the window
+	// that failed rises above it; 0 disables it.
+	MaxErrorRate float64
+	// Alert is called when a metric crosses its threshold, and again when
+	// it recovers; it defaults to logging a warning.
+	Alert func(QualityAlert)
+}
+
+// QualityAlert reports a recognizer's metric crossing its threshold.
+type QualityAlert struct {
+	// Recognizer identifies the provider, recognizer and model.
+	Recognizer string  `json:"recognizer"`
+	Metric     string  `json:"metric"`
+	Value      float64 `json:"value"`
+	Threshold  float64 `json:"threshold"`
+	Sessions   int     `json:"sessions"`
+	// Recovered is set when the metric is back within its threshold.
+	Recovered bool `json:"recovered,omitempty"`
+}
+
+func (a QualityAlert) String() string {
+	if a.Recovered {
+		return fmt.Sprintf("%s of %s recovered to %.3f over the last %d sessions (threshold %.3f)",
+			a.Metric, a.Recognizer, a.Value, a.Sessions, a.Threshold)
+	}
+	return fmt.Sprintf("%s of %s degraded to %.3f over the last %d sessions (threshold %.3f)",
+		a.Metric, a.Recognizer, a.Value, a.Sessions, a.Threshold)
+}
+
+// QualityMonitor tracks the rolling mean confidence and error rate of the
+// sessions of each recognizer and model, and raises alerts when they
+// degrade, to catch upstream model changes or a broken audio path early.
+type QualityMonitor struct {
+	opts QualityOptions
+
+	mu          sync.Mutex
+	recognizers map[string]*qualityWindow
+}
+
+// qualityWindow holds the latest sessions of a recognizer.
+type qualityWindow struct {
+	sessions []qualitySample
+	// alerting holds the metrics currently past their thresholds.
+	alerting map[string]bool
+}
+
+// qualitySample is the outcome of one session.
+type qualitySample struct {
+	results    int
+	confidence float64
+	failed     bool
+}
+
+// QualityStats are the rolling metrics of a recognizer.
+type QualityStats struct {
+	Recognizer string  `json:"recognizer"`
+	Sessions   int     `json:"sessions"`
+	Confidence float64 `json:"confidence"`
+	ErrorRate  float64 `json:"error_rate"`
+}
+
+func NewQualityMonitor(opts QualityOptions) *QualityMonitor {
+	if opts.Window <= 0 {
+		opts.Window = 50
+	}
+	if opts.MinSessions <= 0 {
+		opts.MinSessions = min(10, opts.Window)
+	}
+	return &QualityMonitor{opts: opts, recognizers: map[string]*qualityWindow{}}
+}
+
+// qualityKey names the recognizer and model of config.
+func qualityKey(c Config) string {
+	provider := c.Provider
+	if provider == "" {
+		provider = ProviderGoogle
+	}
+	if provider == ProviderGoogle {
+		return fmt.Sprintf("%s/%s/%s/%s", provider, c.Region, c.RecognizerID, c.model())
+	}
+	return provider + "/" + c.model()
+}
+
+// Watch accounts for s once it ends, under the config of its last stream.
+// Sessions cancelled by the caller are left out. Call it before Run or
+// Start.
+func (m *QualityMonitor) Watch(s *Session) {
+	config := s.opts.Config
+	var sample qualitySample
+	s.Events().Subscribe(func(_ context.Context, e Event) {
+		switch e := e.(type) {
+		case StreamOpened:
+			config = e.Config
+		case FinalResult:
+			if e.Result.Event == "" {
+				sample.results++
+				sample.confidence += float64(e.Result.Confidence)
+			}
+		case SessionEnded:
+			if !errors.Is(e.Err, context.Canceled) {
+				sample.failed = e.Err != nil
+				m.add(config, sample)
+			}
+		}
+	})
+}
+
+// Observe accounts for a session recognized with config in one piece, such
+// as with Recognize, that returned t or failed with err.
+func (m *QualityMonitor) Observe(config Config, t Transcript, err error) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	sample := qualitySample{failed: err != nil}
+	for _, seg := range t.Segments {
+		if seg.Event == "" {
+			sample.results++
+			sample.confidence += float64(seg.Confidence)
+		}
+	}
+	m.add(config, sample)
+}
+
+// add adds a session to its recognizer's window and raises the alerts due.
+func (m *QualityMonitor) add(config Config, sample qualitySample) {
+	key := qualityKey(config)
+	m.mu.Lock()
+	w := m.recognizers[key]
+	if w == nil {
+		w = &qualityWindow{alerting: map[string]bool{}}
+		m.recognizers[key] = w
+	}
+	w.sessions = append(w.sessions, sample)
+	if len(w.sessions) > m.opts.Window {
+		w.sessions = w.sessions[1:]
+	}
+	stats := w.stats(key)
+	setQualityMetric(key+"."+MetricConfidence, stats.Confidence)
+	setQualityMetric(key+"."+MetricErrorRate, stats.ErrorRate)
+
+	var alerts []QualityAlert
+	if stats.Sessions >= m.opts.MinSessions {
+		check := func(metric string, value, threshold float64, degraded bool) {
+			if threshold == 0 || degraded == w.alerting[metric] {
+				return
+			}
+			w.alerting[metric] = degraded
+			alerts = append(alerts, QualityAlert{
+				Recognizer: key,
+				Metric:     metric,
+				Value:      value,
+				Threshold:  threshold,
+				Sessions:   stats.Sessions,
+				Recovered:  !degraded,
+			})
+		}
+		// Sessions without results say nothing about confidence
+		if stats.confidenceResults > 0 {
+			check(MetricConfidence, stats.Confidence, m.opts.MinConfidence, stats.Confidence < m.opts.MinConfidence)
+		}
+		check(MetricErrorRate, stats.ErrorRate, m.opts.MaxErrorRate, stats.ErrorRate > m.opts.MaxErrorRate)
+	}
+	m.mu.Unlock()
+
+	for _, a := range alerts {
+		if m.opts.Alert != nil {
+			m.opts.Alert(a)
+			continue
+		}
+		log.Printf("Warning: %s", a)
+	}
+}
+
+func setQualityMetric(name string, v float64) {
+	f := new(expvar.Float)
+	f.Set(v)
+	qualityMetrics.Set(name, f)
+}
+
+// windowStats are the metrics of a window, with the count of results the
+// confidence is averaged over.
+type windowStats struct {
+	QualityStats
+	confidenceResults int
+}
+
+func (w *qualityWindow) stats(key string) windowStats {
+	s := windowStats{QualityStats: QualityStats{Recognizer: key, Sessions: len(w.sessions)}}
+	var confidence float64
+	failed := 0
+	for _, sample := range w.sessions {
+		s.confidenceResults += sample.results
+		confidence += sample.confidence
+		if sample.failed {
+			failed++
+		}
+	}
+	if s.confidenceResults > 0 {
+		s.Confidence = confidence / float64(s.confidenceResults)
+	}
+	if s.Sessions > 0 {
+		s.ErrorRate = float64(failed) / float64(s.Sessions)
+	}
+	return s
+}
+
+// Stats returns the rolling metrics of every recognizer seen.
+func (m *QualityMonitor) Stats() []QualityStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := make([]QualityStats, 0, len(m.recognizers))
+	for key, w := range m.recognizers {
+		stats = append(stats, w.stats(key).QualityStats)
+	}
+	slices.SortFunc(stats, func(a, b QualityStats) int { return strings.Compare(a.Recognizer, b.Recognizer) })
+	return stats
+}
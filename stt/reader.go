@@ -0,0 +1,82 @@
+package stt
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// TranscriptReader is an io.Reader of a session's final text, one line per
+// final result, produced as results arrive. Reads block until more text is
+// recognized; once the session ends they return io.EOF, or the error that
+// ended it. Text is buffered rather than holding results back, so a slow
+// reader never delays the session.
+type TranscriptReader struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    []byte
+	err    error
+	closed bool
+}
+
+// NewTranscriptReader returns a reader of the final text of s. Call it
+// before Run or Start.
+func NewTranscriptReader(s *Session) *TranscriptReader {
+	r := &TranscriptReader{}
+	r.cond = sync.NewCond(&r.mu)
+	s.OnFinal(func(result Result) {
+		if result.Text == "" {
+			return
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if !r.closed {
+			r.buf = append(r.buf, result.Text...)
+			r.buf = append(r.buf, '\n')
+			r.cond.Broadcast()
+		}
+	})
+	On(s.bus, func(_ context.Context, e SessionEnded) {
+		err := e.Err
+		if err == nil {
+			err = io.EOF
+		}
+		r.end(err)
+	})
+	return r
+}
+
+func (r *TranscriptReader) Read(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for len(r.buf) == 0 && r.err == nil {
+		r.cond.Wait()
+	}
+	if len(r.buf) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// Close discards the text not yet read and any that follows; further reads
+// return io.ErrClosedPipe. It does not stop the session.
+func (r *TranscriptReader) Close() error {
+	r.mu.Lock()
+	r.closed = true
+	r.buf = nil
+	r.mu.Unlock()
+	r.end(io.ErrClosedPipe)
+	return nil
+}
+
+// end makes reads return err once the buffered text is read.
+func (r *TranscriptReader) end(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		r.err = err
+	}
+	r.cond.Broadcast()
+}
@@ -0,0 +1,36 @@
+package stt
+
+import (
+	"context"
+	"log"
+
+	speech "cloud.google.com/go/speech/apiv2"
+	speechpb "cloud.google.com/go/speech/apiv2/speechpb"
+)
+
+// Recognize runs one-shot recognition of audio with the given client. WAV
+// audio is sent without its header, with its format declared explicitly.
+func Recognize(ctx context.Context, client *speech.Client, c Config, audio []byte) (Transcript, error) {
+	decoding, n, err := wavDecoding(audio)
+	if err != nil {
+		return Transcript{}, err
+	}
+	req := &speechpb.RecognizeRequest{
+		Recognizer: c.Recognizer(),
+		Config:     withDecoding(c.RecognitionConfig(), decoding),
+		AudioSource: &speechpb.RecognizeRequest_Content{
+			Content: audio[n:],
+		},
+	}
+
+	log.Printf("Sending one-shot recognition request...")
+	resp, err := client.Recognize(c.outgoing(ctx), req)
+	if err != nil {
+		err = wrapError("recognize audio", err)
+		if fallback, ok := c.withFallback(err); ok {
+			return Recognize(ctx, client, fallback, audio)
+		}
+		return Transcript{}, err
+	}
+	return transcriptFromResults(resp.Results), nil
+}
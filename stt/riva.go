@@ -0,0 +1,369 @@
+package stt
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// ProviderRiva is the name of the NVIDIA Riva streaming ASR provider, at the
+// gRPC address in RIVA_URI (default localhost:50051), over TLS if RIVA_TLS
+// is true.
+const ProviderRiva = "riva"
+
+// rivaStreamingMethod is the full name of Riva's streaming RPC.
+const rivaStreamingMethod = "/nvidia.riva.asr.RivaSpeechRecognition/StreamingRecognize"
+
+// Riva's AudioEncoding values.
+const (
+	rivaLinearPCM = 1
+	rivaMULAW     = 3
+	rivaALAW      = 20
+)
+
+// WAV encodings Riva decodes besides PCM.
+const (
+	wavALAW  = 6
+	wavMULAW = 7
+)
+
+func init() {
+	RegisterProvider(rivaProvider{})
+}
+
+type rivaProvider struct{}
+
+func (rivaProvider) Name() string { return ProviderRiva }
+
+func (rivaProvider) Capabilities() Capabilities {
+	return Capabilities{
+		WordTimings:    true,
+		WordConfidence: true,
+		WordBoost:      true,
+		Streaming:      true,
+		Codecs:         []string{"LINEAR16", "MULAW", "ALAW"},
+	}
+}
+
+func (rivaProvider) OpenStream(ctx context.Context, config Config) (Stream, error) {
+	addr := os.Getenv("RIVA_URI")
+	if addr == "" {
+		addr = "localhost:50051"
+	}
+	creds := insecure.NewCredentials()
+	if ok, _ := strconv.ParseBool(os.Getenv("RIVA_TLS")); ok {
+		creds = credentials.NewTLS(&tls.Config{})
+	}
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Riva client: %w", err)
+	}
+	desc := &grpc.StreamDesc{StreamName: "StreamingRecognize", ClientStreams: true, ServerStreams: true}
+	stream, err := conn.NewStream(ctx, desc, rivaStreamingMethod, grpc.ForceCodec(rivaCodec{}))
+	if err != nil {
+		conn.Close()
+		return nil, wrapError("open stream", err)
+	}
+	return &rivaStream{config: config, conn: conn, stream: stream}, nil
+}
+
+// rivaStream is one StreamingRecognize call. The recognition config names
+// the audio's encoding and sample rate, so it is sent with the first audio,
+// taking them from the WAV header.
+type rivaStream struct {
+	config     Config
+	conn       *grpc.ClientConn
+	stream     grpc.ClientStream
+	configured bool
+	sendClosed bool
+}
+
+func (s *rivaStream) SendAudio(ctx context.Context, audio []byte) error {
+	if !s.configured {
+		n := wavHeaderLen(audio)
+		format, channels, rate, bits := wavFormat(audio)
+		encoding := 0
+		switch {
+		case format == wavPCM && bits == 16:
+			encoding = rivaLinearPCM
+		case format == wavMULAW && bits == 8:
+			encoding = rivaMULAW
+		case format == wavALAW && bits == 8:
+			encoding = rivaALAW
+		}
+		if n == 0 || encoding == 0 {
+			return &CapabilityError{Provider: ProviderRiva, Feature: "audio other than 16-bit PCM, μ-law or A-law WAV"}
+		}
+		if err := s.stream.SendMsg(rivaConfigRequest(s.config, encoding, rate, channels)); err != nil {
+			return wrapError("send config", err)
+		}
+		s.configured = true
+		audio = audio[n:]
+	}
+	if len(audio) == 0 {
+		return nil
+	}
+	if err := s.stream.SendMsg(rivaAudioRequest(audio)); err != nil {
+		return wrapError("send audio", err)
+	}
+	return nil
+}
+
+func (s *rivaStream) Receive(ctx context.Context) (*Result, error) {
+	var resp rivaResponse
+	if err := s.stream.RecvMsg(&resp); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, wrapError("receive response", err)
+	}
+	if len(resp.results) == 0 {
+		return nil, nil
+	}
+	r := resp.results[0]
+	if len(s.config.LanguageCodes) > 0 {
+		r.Language = s.config.LanguageCodes[0]
+	}
+	return &r, nil
+}
+
+func (s *rivaStream) CloseSend() error {
+	s.sendClosed = true
+	return s.stream.CloseSend()
+}
+
+func (s *rivaStream) Close() error {
+	if !s.sendClosed {
+		s.CloseSend()
+	}
+	return s.conn.Close()
+}
+
+// rivaConfigRequest encodes the StreamingRecognizeRequest carrying the
+// StreamingRecognitionConfig.
+func rivaConfigRequest(c Config, encoding, rate, channels int) rivaRequest {
+	var rc []byte
+	rc = appendInt(rc, 1, int64(encoding))
+	rc = appendInt(rc, 2, int64(rate))
+	if len(c.LanguageCodes) > 0 {
+		rc = appendString(rc, 3, c.LanguageCodes[0])
+	}
+	rc = appendInt(rc, 4, 1)
+	if len(c.Boost) > 0 {
+		var sc []byte
+		for _, phrase := range c.Boost {
+			sc = appendString(sc, 1, phrase)
+		}
+		sc = appendFloat(sc, 4, boostWeight)
+		rc = protowire.AppendTag(rc, 6, protowire.BytesType)
+		rc = protowire.AppendBytes(rc, sc)
+	}
+	rc = appendInt(rc, 7, int64(channels))
+	// Word time offsets and automatic punctuation
+	rc = appendInt(rc, 8, 1)
+	rc = appendInt(rc, 11, 1)
+	rc = appendString(rc, 13, c.Model)
+
+	var src []byte
+	src = protowire.AppendTag(src, 1, protowire.BytesType)
+	src = protowire.AppendBytes(src, rc)
+	// Interim results
+	src = appendInt(src, 2, 1)
+
+	req := protowire.AppendTag(nil, 1, protowire.BytesType)
+	return protowire.AppendBytes(req, src)
+}
+
+// rivaAudioRequest encodes a StreamingRecognizeRequest carrying audio.
+func rivaAudioRequest(audio []byte) rivaRequest {
+	req := protowire.AppendTag(nil, 2, protowire.BytesType)
+	return protowire.AppendBytes(req, audio)
+}
+
+// rivaRequest is an encoded StreamingRecognizeRequest.
+type rivaRequest []byte
+
+// rivaResponse is a decoded StreamingRecognizeResponse.
+type rivaResponse struct {
+	results []Result
+}
+
+// rivaCodec encodes Riva's messages without generated protobuf code, as
+// Riva's schema is not vendored; the few fields used are hand-encoded.
+type rivaCodec struct{}
+
+func (rivaCodec) Name() string { return "proto" }
+
+func (rivaCodec) Marshal(v any) ([]byte, error) {
+	req, ok := v.(rivaRequest)
+	if !ok {
+		return nil, fmt.Errorf("unexpected Riva message %T", v)
+	}
+	return req, nil
+}
+
+func (rivaCodec) Unmarshal(data []byte, v any) error {
+	resp, ok := v.(*rivaResponse)
+	if !ok {
+		return fmt.Errorf("unexpected Riva message %T", v)
+	}
+	return walkFields(data, func(num protowire.Number, _ protowire.Type, v []byte, _ uint64) error {
+		if num != 1 {
+			return nil
+		}
+		r, err := parseRivaResult(v)
+		if err == nil && r != nil {
+			resp.results = append(resp.results, *r)
+		}
+		return err
+	})
+}
+
+// parseRivaResult decodes a StreamingRecognitionResult, returning nil for
+// one without alternatives. Riva's offsets are from the start of the stream.
+func parseRivaResult(b []byte) (*Result, error) {
+	r := &Result{}
+	var alternatives int
+	err := walkFields(b, func(num protowire.Number, _ protowire.Type, v []byte, n uint64) error {
+		switch num {
+		case 1:
+			// Only the best alternative is kept
+			if alternatives++; alternatives > 1 {
+				return nil
+			}
+			return walkFields(v, func(num protowire.Number, _ protowire.Type, v []byte, n uint64) error {
+				switch num {
+				case 1:
+					r.Text = strings.TrimSpace(string(v))
+				case 2:
+					r.Confidence = math.Float32frombits(uint32(n))
+				case 3:
+					w, err := parseRivaWord(v)
+					if err != nil {
+						return err
+					}
+					r.Words = append(r.Words, w)
+				}
+				return nil
+			})
+		case 2:
+			r.IsFinal = n != 0
+		case 3:
+			r.Stability = math.Float32frombits(uint32(n))
+		case 6:
+			// Seconds of audio processed when the result was produced
+			r.End = time.Duration(float64(math.Float32frombits(uint32(n))) * float64(time.Second))
+		}
+		return nil
+	})
+	if err != nil || alternatives == 0 {
+		return nil, err
+	}
+	if len(r.Words) > 0 {
+		r.Start = r.Words[0].Start
+		r.End = max(r.End, r.Words[len(r.Words)-1].End)
+		r.Speaker = r.Words[0].Speaker
+	}
+	return r, nil
+}
+
+// parseRivaWord decodes a WordInfo, whose times are in milliseconds.
+func parseRivaWord(b []byte) (Word, error) {
+	var w Word
+	err := walkFields(b, func(num protowire.Number, _ protowire.Type, v []byte, n uint64) error {
+		switch num {
+		case 1:
+			w.Start = time.Duration(int32(n)) * time.Millisecond
+		case 2:
+			w.End = time.Duration(int32(n)) * time.Millisecond
+		case 3:
+			w.Text = string(v)
+		case 4:
+			w.Confidence = math.Float32frombits(uint32(n))
+		case 5:
+			if tag := int32(n); tag > 0 {
+				w.Speaker = strconv.Itoa(int(tag))
+			}
+		}
+		return nil
+	})
+	return w, err
+}
+
+// Proto3 omits fields holding their zero value.
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendInt(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+func appendFloat(b []byte, num protowire.Number, v float32) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.Fixed32Type)
+	return protowire.AppendFixed32(b, math.Float32bits(v))
+}
+
+// walkFields calls fn for every field of an encoded message, passing the
+// payload of length-delimited fields as v and numeric fields as n. Unknown
+// wire types are skipped.
+func walkFields(b []byte, fn func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error) error {
+	for len(b) > 0 {
+		num, typ, l := protowire.ConsumeTag(b)
+		if l < 0 {
+			return fmt.Errorf("invalid Riva message: %w", protowire.ParseError(l))
+		}
+		b = b[l:]
+
+		var (
+			v []byte
+			n uint64
+		)
+		switch typ {
+		case protowire.BytesType:
+			v, l = protowire.ConsumeBytes(b)
+		case protowire.VarintType:
+			n, l = protowire.ConsumeVarint(b)
+		case protowire.Fixed32Type:
+			var f uint32
+			f, l = protowire.ConsumeFixed32(b)
+			n = uint64(f)
+		default:
+			l = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if l < 0 {
+			return fmt.Errorf("invalid Riva message: %w", protowire.ParseError(l))
+		}
+		b = b[l:]
+		if err := fn(num, typ, v, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,424 @@
+package stt
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// NewSessionID returns a random identifier for correlating session output.
+func NewSessionID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// SessionOptions configures how a Session streams audio.
+type SessionOptions struct {
+	Config Config
+	// ChunkSize is the size of the chunks audio is sent in; it defaults to
+	// 8192 bytes. WAV audio is sent as fast as it plays, at the byte rate of
+	// its header, and other audio one chunk every ChunkInterval, which
+	// defaults to 200ms.
+	ChunkSize     int
+	ChunkInterval time.Duration
+	// RealtimeFactor speeds up or slows down sending by this multiple of
+	// real time, such as 2 to replay a recording at double speed; it
+	// defaults to 1.
+	RealtimeFactor float64
+	// ByteRate, when set, paces sending with a token bucket at this many
+	// bytes per second instead of ChunkInterval, independent of the audio's
+	// playback rate. Burst bounds how far sending may run ahead; it defaults
+	// to ChunkSize.
+	ByteRate int
+	Burst    int
+	// StallTimeout enables the Watchdog; RestartOnStall lets it restart the
+	// stream after the last final result.
+	StallTimeout   time.Duration
+	RestartOnStall bool
+	// DrainTimeout bounds how long results are received after the last
+	// audio; it defaults to 30s.
+	DrainTimeout time.Duration
+	// MaxBilled caps the audio sent in the session, including audio resent
+	// after restarts. Once reached the session stops sending, drains the
+	// remaining results and publishes SessionTruncated. It needs WAV input
+	// to measure the audio.
+	MaxBilled time.Duration
+	// Budget, when set, refuses to start the session once the period's
+	// budget is spent and is charged the session's billed audio.
+	Budget *Budget
+	// Keepalive, when set, injects a chunk of silence into live PCM audio
+	// whenever none has arrived for this long, keeping the stream and any
+	// NAT mappings alive through gaps in the source. The silence is billed
+	// like any other audio.
+	Keepalive time.Duration
+}
+
+// Session streams one audio input through the streaming recognition of the
+// configured provider, publishing its progress on an event bus.
+type Session struct {
+	ID   string
+	opts SessionOptions
+	bus  *Bus
+
+	// billed is the audio sent so far across all streams; truncated is set
+	// once it reaches MaxBilled.
+	billed    time.Duration
+	truncated bool
+	// seq counts the results published, partial and final, numbering them.
+	seq uint64
+
+	analyzer *Analyzer
+
+	// done and err track a session started with Start.
+	done chan struct{}
+	err  error
+}
+
+func NewSession(id string, opts SessionOptions) *Session {
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = 8192
+	}
+	if opts.ChunkInterval <= 0 {
+		opts.ChunkInterval = 200 * time.Millisecond
+	}
+	if opts.RealtimeFactor <= 0 {
+		opts.RealtimeFactor = 1
+	}
+	if opts.DrainTimeout <= 0 {
+		opts.DrainTimeout = 30 * time.Second
+	}
+	s := &Session{ID: id, opts: opts, bus: NewBus(), analyzer: NewAnalyzer()}
+	subscribeMetrics(s.bus)
+	On(s.bus, func(_ context.Context, e FinalResult) {
+		talk, words, interrupts := s.analyzer.add(e.Result.Segment)
+		talkSeconds.Add(talk.Seconds())
+		wordsSpoken.Add(int64(words))
+		interruptions.Add(int64(interrupts))
+	})
+	return s
+}
+
+// Analytics returns the conversation metrics of the results received so
+// far, over the audio sent so far.
+func (s *Session) Analytics() Analytics {
+	return s.analyzer.Analytics()
+}
+
+// Events returns the bus the session publishes its events on.
+func (s *Session) Events() *Bus {
+	return s.bus
+}
+
+// Run streams audio until it has been sent and all results received, ctx is
+// cancelled or the stream fails. A server that does not close the stream in
+// time after the last audio is logged but not treated as a failure.
+func (s *Session) Run(ctx context.Context, audio []byte) error {
+	return s.run(ctx, bufferedAudio(audio))
+}
+
+// RunLive is Run for WAV audio read from r as it is captured, such as from a
+// microphone. Audio is sent as soon as it arrives, and the session ends once
+// r returns io.EOF and the remaining results are received.
+func (s *Session) RunLive(ctx context.Context, r io.Reader) error {
+	l := readLive(r, 0)
+	if s.opts.Keepalive > 0 {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go l.keepalive(ctx, s.opts.Keepalive, s.opts.ChunkSize)
+	}
+	return s.run(ctx, l)
+}
+
+// RunReader is Run for WAV audio read from r as it arrives, such as a pipe
+// from another process, without loading it all first. Sending is paced as
+// for Run, since r may deliver audio faster than real time, and r is read no
+// faster than audio is sent.
+func (s *Session) RunReader(ctx context.Context, r io.Reader) error {
+	l := readLive(r, pipeReadAhead)
+	defer l.stop()
+	return s.run(ctx, pipedAudio{l})
+}
+
+func (s *Session) run(ctx context.Context, src audioSource) (err error) {
+	defer func() {
+		s.bus.Publish(ctx, SessionEnded{Err: err})
+	}()
+	if b := s.opts.Budget; b != nil {
+		if err := b.Allow(ctx); err != nil {
+			return err
+		}
+		defer func() {
+			if err := b.Add(context.WithoutCancel(ctx), s.billed); err != nil {
+				log.Printf("Failed to charge session to the usage budget: %v", err)
+			}
+		}()
+	}
+
+	offset := 0
+	for {
+		resumeAt, err := s.stream(ctx, src, offset)
+		if errors.Is(err, ErrDrainTimeout) {
+			log.Printf("Server did not close the stream within %s after the last audio; trailing results may be missing", s.opts.DrainTimeout)
+			return nil
+		}
+		// The model is only rejected once the stream is open
+		if config, ok := s.opts.Config.withFallback(err); ok {
+			s.opts.Config = config
+			offset = resumeAt
+			continue
+		}
+		if !errors.Is(err, ErrStalled) {
+			return err
+		}
+		// A restart would not send any more audio
+		if s.truncated {
+			return nil
+		}
+		log.Printf("Restarting stalled stream from byte %d", resumeAt)
+		s.bus.Publish(ctx, StreamRestarted{Offset: resumeAt, Reason: err})
+		offset = resumeAt
+	}
+}
+
+// stream sends audio from offset in a single StreamingRecognize call. When
+// the watchdog restarts a stalled stream it returns ErrStalled and the offset
+// of the audio following the last final result.
+func (s *Session) stream(ctx context.Context, src audioSource, offset int) (int, error) {
+	// The group context ends the stream as soon as either side fails or the
+	// caller cancels
+	g, ctx := errgroup.WithContext(ctx)
+
+	audio, err := src.wait(ctx, offset, 0)
+	if err != nil {
+		return offset, err
+	}
+
+	client, err := OpenStream(ctx, s.opts.Config)
+	if err != nil {
+		return offset, err
+	}
+	defer client.Close()
+	s.bus.Publish(ctx, StreamOpened{Config: s.opts.Config, Offset: offset})
+
+	done := make(chan struct{})
+	var (
+		mu    sync.Mutex
+		sent  = offset
+		acked = offset
+		// header is the WAV header of the audio sent, once it has arrived
+		header = audio.header
+		// Result offsets restart at zero on a resumed stream
+		base    = audioDuration(audio.header, offset)
+		lastEnd = base
+	)
+	watchdog := NewWatchdog(s.opts.StallTimeout, s.opts.RestartOnStall)
+
+	// Send audio chunks
+	g.Go(func() error {
+		// A resumed stream needs the WAV header again for decoding
+		if offset > 0 && len(audio.header) > 0 {
+			if err := client.SendAudio(ctx, audio.header); err != nil {
+				return fmt.Errorf("failed to send audio header: %w", err)
+			}
+		}
+
+		watchdog.Sending(true)
+		defer watchdog.Sending(false)
+
+		var pacer pacer
+		defer func() {
+			if pacer != nil {
+				pacer.stop()
+			}
+		}()
+		for i := offset; ; i += s.opts.ChunkSize {
+			audio, err := src.wait(ctx, i, s.opts.ChunkSize)
+			if err != nil {
+				return err
+			}
+			if i >= audio.end() {
+				break
+			}
+			if pacer == nil {
+				// Piped audio has its header by the first chunk
+				pacer = newPacer(s.opts, src.live(), wavByteRate(audio.header))
+			}
+			end := min(i+s.opts.ChunkSize, audio.end())
+			chunk := audioDuration(audio.header, end) - audioDuration(audio.header, i)
+			if s.opts.MaxBilled > 0 && s.billed+chunk > s.opts.MaxBilled {
+				log.Printf("Billing ceiling of %s reached, truncating session at byte %d", s.opts.MaxBilled, i)
+				s.truncated = true
+				s.bus.Publish(ctx, SessionTruncated{Offset: i, Billed: s.billed})
+				break
+			}
+			s.billed += chunk
+			if err := client.SendAudio(ctx, audio.slice(i, end)); err != nil {
+				return fmt.Errorf("failed to send audio chunk: %w", err)
+			}
+			mu.Lock()
+			sent, header = end, audio.header
+			mu.Unlock()
+			s.analyzer.extend(audioDuration(audio.header, end))
+			s.bus.Publish(ctx, AudioSent{Bytes: end - i, Offset: end, Audio: audio.slice(i, end)})
+			if err := pacer.wait(ctx, end-i); err != nil {
+				return err
+			}
+		}
+
+		// Half-close, then let the receiver drain trailing results until the
+		// server closes the stream
+		if err := client.CloseSend(); err != nil {
+			return fmt.Errorf("failed to close send direction: %w", err)
+		}
+		log.Printf("All audio sent, draining remaining results")
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(s.opts.DrainTimeout):
+			return ErrDrainTimeout
+		}
+	})
+
+	// Receive transcriptions
+	g.Go(func() error {
+		defer close(done)
+		for {
+			result, err := client.Receive(ctx)
+			if err != nil {
+				if err == io.EOF {
+					return nil
+				}
+				return fmt.Errorf("failed to receive transcription: %w", err)
+			}
+			watchdog.Responded()
+			if result == nil {
+				log.Printf("Received nil result")
+				continue
+			}
+			r := result.rebase(base, lastEnd)
+			s.seq++
+			r.Seq = s.seq
+			if r.IsFinal {
+				mu.Lock()
+				// Audio sent past the end of the final result is still to be
+				// recognized, so a restart resumes where the result ends
+				acked = max(acked, min(sent, audioOffset(header, offset, result.End, sent)))
+				mu.Unlock()
+				// A restart resumes from here, so nothing before is needed
+				src.release(acked)
+				lastEnd = r.End
+				s.bus.Publish(ctx, FinalResult{Result: r})
+			} else {
+				s.bus.Publish(ctx, PartialResult{Result: r})
+			}
+		}
+	})
+
+	// Watch for stalls until the stream has been fully received
+	if s.opts.StallTimeout > 0 {
+		g.Go(func() error {
+			watchCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			go func() {
+				<-done
+				cancel()
+			}()
+			if err := watchdog.Run(watchCtx); errors.Is(err, ErrStalled) {
+				return err
+			}
+			return nil
+		})
+	}
+
+	err = g.Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	return acked, err
+}
+
+// wavHeaderLen returns the length of the RIFF header preceding the "data"
+// chunk payload, or 0 if audio is not a WAV file.
+func wavHeaderLen(audio []byte) int {
+	if len(audio) < 12 || string(audio[0:4]) != "RIFF" || string(audio[8:12]) != "WAVE" {
+		return 0
+	}
+	for pos := 12; pos+8 <= len(audio); {
+		size := int(binary.LittleEndian.Uint32(audio[pos+4 : pos+8]))
+		if string(audio[pos:pos+4]) == "data" {
+			return pos + 8
+		}
+		pos += 8 + size + size%2
+	}
+	return 0
+}
+
+// audioDuration returns the playback duration of WAV audio up to offset, or
+// 0 if it cannot be determined.
+func audioDuration(audio []byte, offset int) time.Duration {
+	n := wavHeaderLen(audio)
+	rate := wavByteRate(audio)
+	if rate == 0 || offset <= n {
+		return 0
+	}
+	return time.Duration(float64(offset-n) / float64(rate) * float64(time.Second))
+}
+
+// audioOffset returns the byte offset of WAV audio at d into a stream that
+// started at byte start, on a whole sample frame, or fallback if the audio's
+// byte rate is unknown.
+func audioOffset(audio []byte, start int, d time.Duration, fallback int) int {
+	rate := wavByteRate(audio)
+	if rate == 0 {
+		return fallback
+	}
+	n := int(d.Seconds() * float64(rate))
+	if _, channels, _, bits := wavFormat(audio); channels > 0 && bits > 0 {
+		frame := channels * ((bits + 7) / 8)
+		n -= n % frame
+	}
+	return max(start, wavHeaderLen(audio)) + n
+}
+
+// wavByteRate returns the byte rate from the WAV "fmt " chunk, or 0.
+func wavByteRate(audio []byte) int {
+	n := wavHeaderLen(audio)
+	for pos := 12; pos+8 <= n; {
+		size := int(binary.LittleEndian.Uint32(audio[pos+4 : pos+8]))
+		if string(audio[pos:pos+4]) == "fmt " && pos+20 <= len(audio) {
+			return int(binary.LittleEndian.Uint32(audio[pos+16 : pos+20]))
+		}
+		pos += 8 + size + size%2
+	}
+	return 0
+}
+
+// wavPCM is the WAV encoding of integer PCM samples.
+const wavPCM = 1
+
+// wavFormat returns the encoding, channel count, sample rate and bits per
+// sample from the WAV "fmt " chunk, or zeros.
+func wavFormat(audio []byte) (encoding, channels, rate, bits int) {
+	n := wavHeaderLen(audio)
+	for pos := 12; pos+8 <= n; {
+		size := int(binary.LittleEndian.Uint32(audio[pos+4 : pos+8]))
+		if string(audio[pos:pos+4]) == "fmt " && pos+24 <= len(audio) {
+			f := audio[pos+8 : pos+24]
+			return int(binary.LittleEndian.Uint16(f[0:2])), int(binary.LittleEndian.Uint16(f[2:4])),
+				int(binary.LittleEndian.Uint32(f[4:8])), int(binary.LittleEndian.Uint16(f[14:16]))
+		}
+		pos += 8 + size + size%2
+	}
+	return 0, 0, 0, 0
+}
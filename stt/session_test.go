@@ -0,0 +1,129 @@
+package stt
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"stt-receivetranscription-mve/audio"
+)
+
+// stallingProvider stalls its first stream after the results of the first
+// chunk of audio, and answers every chunk of later streams with a partial
+// and a final result.
+type stallingProvider struct {
+	mu      sync.Mutex
+	streams int
+}
+
+func (*stallingProvider) Name() string               { return "test-stalling" }
+func (*stallingProvider) Capabilities() Capabilities { return Capabilities{Streaming: true} }
+
+func (p *stallingProvider) OpenStream(ctx context.Context, config Config) (Stream, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.streams++
+	return &stallingStream{stall: p.streams == 1, results: make(chan *Result, 64)}, nil
+}
+
+type stallingStream struct {
+	stall   bool
+	header  bool
+	sent    time.Duration
+	results chan *Result
+	once    sync.Once
+}
+
+func (s *stallingStream) SendAudio(ctx context.Context, b []byte) error {
+	if !s.header {
+		// Every stream starts with the WAV header
+		s.header = true
+		return nil
+	}
+	if s.stall && s.sent > 0 {
+		return nil
+	}
+	start := s.sent
+	s.sent += time.Duration(len(b)) * time.Second / 32000
+	seg := Segment{Text: "word", Start: start, End: s.sent}
+	s.results <- &Result{Segment: seg}
+	s.results <- &Result{Segment: seg, IsFinal: true}
+	return nil
+}
+
+func (s *stallingStream) Receive(ctx context.Context) (*Result, error) {
+	select {
+	case r, ok := <-s.results:
+		if !ok {
+			return nil, io.EOF
+		}
+		return r, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *stallingStream) CloseSend() error {
+	s.once.Do(func() { close(s.results) })
+	return nil
+}
+
+func (s *stallingStream) Close() error { return nil }
+
+// TestSessionOrder checks that every result of a session is numbered in
+// order, partial and final alike, across a restart of a stalled stream, and
+// that the restart resumes where the last final result ended.
+func TestSessionOrder(t *testing.T) {
+	RegisterProvider(&stallingProvider{})
+	const chunk = 3200 // 100ms of 16 kHz mono
+	wav := append(audio.StreamHeader(16000, 1), make([]byte, 10*chunk)...)
+
+	s := NewSession("order", SessionOptions{
+		Config:         Config{Provider: "test-stalling"},
+		ChunkSize:      chunk,
+		RealtimeFactor: 4,
+		StallTimeout:   50 * time.Millisecond,
+		RestartOnStall: true,
+	})
+	var (
+		mu       sync.Mutex
+		results  []Result
+		restarts []int
+	)
+	collect := func(r Result) {
+		mu.Lock()
+		defer mu.Unlock()
+		results = append(results, r)
+	}
+	s.OnPartial(collect)
+	s.OnFinal(collect)
+	On(s.Events(), func(_ context.Context, e StreamRestarted) { restarts = append(restarts, e.Offset) })
+	if err := s.Run(context.Background(), wav); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(restarts) != 1 {
+		t.Fatalf("got %d restarts, want 1", len(restarts))
+	}
+	// The first stream's only final result ends after the first chunk
+	if want := 44 + chunk; restarts[0] != want {
+		t.Errorf("restarted from byte %d, want %d", restarts[0], want)
+	}
+	var lastEnd time.Duration
+	for i, r := range results {
+		if r.Seq != uint64(i+1) {
+			t.Errorf("result %d is numbered %d", i+1, r.Seq)
+		}
+		if r.IsFinal {
+			if r.End < lastEnd {
+				t.Errorf("final result %d ends at %s, before the previous one at %s", r.Seq, r.End, lastEnd)
+			}
+			lastEnd = r.End
+		}
+	}
+	if want := 10 * 100 * time.Millisecond; lastEnd != want {
+		t.Errorf("last final result ends at %s, want %s", lastEnd, want)
+	}
+}
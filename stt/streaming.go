@@ -0,0 +1,225 @@
+package stt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+
+	speech "cloud.google.com/go/speech/apiv2"
+	speechpb "cloud.google.com/go/speech/apiv2/speechpb"
+)
+
+// Stream is one streaming recognition call of a provider. The offsets of
+// its results are relative to the start of the stream.
+type Stream interface {
+	SendAudio(ctx context.Context, audio []byte) error
+	// Receive returns the next result, nil for a response without one, or
+	// io.EOF once the provider has closed the stream.
+	Receive(ctx context.Context) (*Result, error)
+	// CloseSend half-closes the stream; results keep arriving until Receive
+	// returns io.EOF.
+	CloseSend() error
+	Close() error
+}
+
+// StreamingProvider is a Provider that recognizes streamed audio.
+type StreamingProvider interface {
+	Provider
+	OpenStream(ctx context.Context, config Config) (Stream, error)
+}
+
+// OpenStream opens a stream with the provider selected by config. The
+// stream is bound to ctx.
+func OpenStream(ctx context.Context, config Config) (Stream, error) {
+	p, err := LookupProvider(config.Provider)
+	if err != nil {
+		return nil, err
+	}
+	sp, ok := p.(StreamingProvider)
+	if !ok {
+		return nil, &CapabilityError{Provider: p.Name(), Feature: "streaming recognition"}
+	}
+	stream, err := sp.OpenStream(ctx, config)
+	if err != nil || config.Compression != CompressionFLAC {
+		return stream, err
+	}
+	return &flacStream{Stream: stream}, nil
+}
+
+// StreamingClient is a single StreamingRecognize session. The stream is bound
+// to the context given to NewStreamingClient; cancelling it ends the session.
+type StreamingClient struct {
+	client     *speech.Client
+	stream     speechpb.Speech_StreamingRecognizeClient
+	sendClosed bool
+
+	// The recognition config is sent with the first audio, once the WAV
+	// header, if any, shows the format to declare. Until then header
+	// collects the audio sent.
+	config     Config
+	configured bool
+	header     []byte
+}
+
+// ErrDrainTimeout reports that the server did not close a half-closed stream
+// in time, so trailing results may be missing.
+var ErrDrainTimeout = errors.New("timed out draining stream")
+
+func NewStreamingClient(ctx context.Context, config Config) (*StreamingClient, error) {
+	// Create client with explicit regional endpoint
+	client, err := NewClient(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := client.StreamingRecognize(config.outgoing(ctx))
+	if err != nil {
+		return nil, wrapError("open stream", err)
+	}
+
+	return &StreamingClient{
+		client: client,
+		stream: stream,
+		config: config,
+	}, nil
+}
+
+// maxWAVHeader bounds the audio collected looking for the end of a WAV
+// header; audio without one by then has its format detected.
+const maxWAVHeader = 64 << 10
+
+// sendConfig sends the recognition config, declaring the format of the WAV
+// audio described by header, and returns the audio following the header.
+func (c *StreamingClient) sendConfig(header []byte) ([]byte, error) {
+	decoding, n, err := wavDecoding(header)
+	if err != nil {
+		return nil, err
+	}
+	configReq := &speechpb.StreamingRecognizeRequest{
+		StreamingRequest: &speechpb.StreamingRecognizeRequest_StreamingConfig{
+			StreamingConfig: &speechpb.StreamingRecognitionConfig{
+				Config: withDecoding(c.config.RecognitionConfig(), decoding),
+			},
+		},
+		Recognizer: c.config.Recognizer(),
+	}
+	if err := c.stream.Send(configReq); err != nil {
+		return nil, wrapError("send config", err)
+	}
+	c.configured = true
+	c.header = nil
+	return header[n:], nil
+}
+
+// SendAudio sends the next chunk of audio. WAV audio is sent without its
+// header, with its format declared explicitly; other audio is sent as it is
+// for the recognizer to detect its format.
+func (c *StreamingClient) SendAudio(ctx context.Context, audio []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !c.configured {
+		c.header = append(c.header, audio...)
+		if wavHeaderLen(c.header) == 0 && len(c.header) < maxWAVHeader &&
+			(len(c.header) < 4 || string(c.header[:4]) == "RIFF") {
+			// The header continues in the next chunk
+			return nil
+		}
+		var err error
+		if audio, err = c.sendConfig(c.header); err != nil {
+			return err
+		}
+		if len(audio) == 0 {
+			return nil
+		}
+	}
+	req := &speechpb.StreamingRecognizeRequest{
+		StreamingRequest: &speechpb.StreamingRecognizeRequest_Audio{
+			Audio: audio,
+		},
+	}
+	log.Printf("Sending audio chunk: %d bytes", len(audio))
+	return c.stream.Send(req)
+}
+
+func (c *StreamingClient) ReceiveTranscription(ctx context.Context) (*speechpb.StreamingRecognitionResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	log.Printf("Waiting for transcription response...")
+	resp, err := c.stream.Recv()
+	if err != nil {
+		if err == io.EOF {
+			log.Printf("Stream ended with EOF")
+			return nil, io.EOF
+		}
+		// The stream is bound to ctx, so cancellation surfaces here as a
+		// gRPC error; report the context error instead.
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, wrapError("receive response", err)
+	}
+
+	log.Printf("Received STT response: %+v", resp)
+
+	if len(resp.Results) == 0 {
+		log.Printf("No results in response")
+		return nil, nil
+	}
+
+	return resp.Results[0], nil
+}
+
+// Receive returns the next result of the stream.
+func (c *StreamingClient) Receive(ctx context.Context) (*Result, error) {
+	result, err := c.ReceiveTranscription(ctx)
+	if err != nil || result == nil {
+		return nil, err
+	}
+	if len(result.Alternatives) == 0 {
+		log.Printf("Received empty alternatives")
+		return nil, nil
+	}
+	r := newResult(result)
+	return &r, nil
+}
+
+// CloseSend half-closes the stream, signalling the server that no more audio
+// will be sent. Results keep arriving until ReceiveTranscription returns
+// io.EOF. It must not be called concurrently with SendAudio.
+func (c *StreamingClient) CloseSend() error {
+	c.sendClosed = true
+	if !c.configured {
+		// Too little audio was sent to complete a header
+		audio, err := c.sendConfig(c.header)
+		if err != nil {
+			return err
+		}
+		if len(audio) > 0 {
+			if err := c.stream.Send(&speechpb.StreamingRecognizeRequest{
+				StreamingRequest: &speechpb.StreamingRecognizeRequest_Audio{Audio: audio},
+			}); err != nil {
+				return wrapError("send audio", err)
+			}
+		}
+	}
+	return c.stream.CloseSend()
+}
+
+// Close half-closes the stream if CloseSend has not been called and releases
+// the underlying client, which is released even if half-closing fails.
+func (c *StreamingClient) Close() error {
+	var errs []error
+	if !c.sendClosed {
+		if err := c.CloseSend(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close stream: %w", err))
+		}
+	}
+	if err := c.client.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close client: %w", err))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,74 @@
+package stt
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Track is one participant's audio of a multitrack recording.
+type Track struct {
+	Speaker string
+	Path    string
+}
+
+// TranscribeTracks transcribes every track concurrently and merges them into
+// one speaker-labelled transcript with MergeTracks.
+func TranscribeTracks(ctx context.Context, tracks []Track, opts TranscribeOptions) (Transcript, error) {
+	transcripts := make([]Transcript, len(tracks))
+	g, ctx := errgroup.WithContext(ctx)
+	for i, track := range tracks {
+		g.Go(func() error {
+			t, err := TranscribeFile(ctx, track.Path, opts)
+			if err != nil {
+				return fmt.Errorf("failed to transcribe track %s: %w", track.Speaker, err)
+			}
+			transcripts[i] = t
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return Transcript{}, err
+	}
+	return MergeTracks(tracks, transcripts), nil
+}
+
+// MergeTracks merges per-track transcripts, labelling each segment and word
+// with its track's speaker and ordering segments by start offset. Segments
+// of different speakers that overlap in time are marked Overlaps.
+func MergeTracks(tracks []Track, transcripts []Transcript) Transcript {
+	var merged Transcript
+	for i, t := range transcripts {
+		for _, seg := range t.Segments {
+			seg.Speaker = tracks[i].Speaker
+			seg.Words = slices.Clone(seg.Words)
+			for j := range seg.Words {
+				seg.Words[j].Speaker = tracks[i].Speaker
+			}
+			merged.Segments = append(merged.Segments, seg)
+		}
+		merged.Truncated = merged.Truncated || t.Truncated
+	}
+	slices.SortStableFunc(merged.Segments, func(a, b Segment) int {
+		return cmp.Compare(a.Start, b.Start)
+	})
+	markOverlaps(merged.Segments)
+	merged.finish()
+	return merged
+}
+
+// markOverlaps flags the segments, ordered by start, that overlap a segment
+// of another speaker.
+func markOverlaps(segments []Segment) {
+	for i := range segments {
+		for j := i + 1; j < len(segments) && segments[j].Start < segments[i].End; j++ {
+			if segments[j].Speaker != segments[i].Speaker && segments[j].Event == "" && segments[i].Event == "" {
+				segments[i].Overlaps = true
+				segments[j].Overlaps = true
+			}
+		}
+	}
+}
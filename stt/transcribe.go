@@ -0,0 +1,194 @@
+package stt
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Limits of synchronous Recognize requests.
+const (
+	oneShotMaxDuration = time.Minute
+	// OneShotMaxBytes is the largest audio one-shot recognition accepts.
+	OneShotMaxBytes = 10 << 20
+)
+
+// Mode selects the recognition API used by TranscribeFile.
+type Mode string
+
+const (
+	ModeAuto      Mode = ""
+	ModeOneShot   Mode = "one-shot"
+	ModeStreaming Mode = "streaming"
+	ModeBatch     Mode = "batch"
+)
+
+// TranscribeOptions configures TranscribeFile.
+type TranscribeOptions struct {
+	Config Config
+	// Mode forces a recognition API; ModeAuto picks one from the input.
+	Mode Mode
+	// Session configures streaming; its Config is taken from Config. Its
+	// Budget also applies to one-shot and batch recognition.
+	Session SessionOptions
+	// Batch configures batch recognition.
+	Batch BatchOptions
+}
+
+// TranscribeFile transcribes a local audio file or gs:// URI in one call.
+// With ModeAuto, gs:// URIs use batch recognition, short local files one-shot
+// recognition if the provider supports it and anything else streaming
+// recognition.
+func TranscribeFile(ctx context.Context, path string, opts TranscribeOptions) (Transcript, error) {
+	mode := opts.Mode
+	if mode == ModeAuto && strings.HasPrefix(path, "gs://") {
+		mode = ModeBatch
+	}
+
+	if mode == ModeBatch {
+		if !strings.HasPrefix(path, "gs://") {
+			return Transcript{}, fmt.Errorf("batch recognition requires a gs:// URI, got %q", path)
+		}
+		if b := opts.Session.Budget; b != nil {
+			if err := b.Allow(ctx); err != nil {
+				return Transcript{}, err
+			}
+		}
+		client, err := NewClient(ctx, opts.Config)
+		if err != nil {
+			return Transcript{}, err
+		}
+		defer client.Close()
+		t, err := BatchRecognize(ctx, client, opts.Config, path, opts.Batch)
+		if b := opts.Session.Budget; b != nil && err == nil {
+			if err := b.Add(ctx, t.Duration); err != nil {
+				log.Printf("Failed to charge recognition to the usage budget: %v", err)
+			}
+		}
+		return t, err
+	}
+
+	audio, err := os.ReadFile(path)
+	if err != nil {
+		return Transcript{}, fmt.Errorf("failed to read audio file: %w", err)
+	}
+	if mode == ModeAuto {
+		mode = ModeStreaming
+		if FitsOneShot(audio) && opts.Config.capabilities().OneShot {
+			mode = ModeOneShot
+		}
+	}
+
+	switch mode {
+	case ModeOneShot:
+		if b := opts.Session.Budget; b != nil {
+			if err := b.Allow(ctx); err != nil {
+				return Transcript{}, err
+			}
+		}
+		client, err := NewClient(ctx, opts.Config)
+		if err != nil {
+			return Transcript{}, err
+		}
+		defer client.Close()
+		t, err := Recognize(ctx, client, opts.Config, audio)
+		if b := opts.Session.Budget; b != nil && err == nil {
+			d, _ := WAVDuration(audio)
+			if err := b.Add(ctx, d); err != nil {
+				log.Printf("Failed to charge recognition to the usage budget: %v", err)
+			}
+		}
+		return t, err
+	case ModeStreaming:
+		return transcribeStreaming(ctx, audio, opts)
+	default:
+		return Transcript{}, fmt.Errorf("unknown recognition mode %q", mode)
+	}
+}
+
+// TranscribeLanguages transcribes path once per language code concurrently,
+// for audio whose language is unknown and too unreliable to auto-detect. The
+// transcripts are returned in the order of languages.
+func TranscribeLanguages(ctx context.Context, path string, languages []string, opts TranscribeOptions) ([]Transcript, error) {
+	transcripts := make([]Transcript, len(languages))
+	g, ctx := errgroup.WithContext(ctx)
+	for i, lang := range languages {
+		g.Go(func() error {
+			o := opts
+			o.Config.LanguageCodes = []string{lang}
+			t, err := TranscribeFile(ctx, path, o)
+			if err != nil {
+				return fmt.Errorf("%s: %w", lang, err)
+			}
+			if t.Language == "" {
+				t.Language = lang
+			}
+			transcripts[i] = t
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return transcripts, nil
+}
+
+// transcribeStreaming collects the final results of a streaming session.
+func transcribeStreaming(ctx context.Context, audio []byte, opts TranscribeOptions) (Transcript, error) {
+	sessionOpts := opts.Session
+	sessionOpts.Config = opts.Config
+	session := NewSession(NewSessionID(), sessionOpts)
+
+	var (
+		mu        sync.Mutex
+		segments  []Segment
+		truncated bool
+	)
+	On(session.Events(), func(ctx context.Context, e SessionTruncated) {
+		mu.Lock()
+		defer mu.Unlock()
+		truncated = true
+	})
+	session.OnFinal(func(r Result) {
+		mu.Lock()
+		defer mu.Unlock()
+		segments = append(segments, r.Segment)
+	})
+	if err := session.Run(ctx, audio); err != nil {
+		return Transcript{}, err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	t := TranscriptFromSegments(segments)
+	t.Truncated = truncated
+	return t, nil
+}
+
+// FitsOneShot reports whether WAV audio is within the size and duration
+// limits of one-shot recognition.
+func FitsOneShot(audio []byte) bool {
+	d, ok := WAVDuration(audio)
+	return ok && len(audio) <= OneShotMaxBytes && d <= oneShotMaxDuration
+}
+
+// WAVDuration returns the playback duration of WAV audio from its header.
+func WAVDuration(audio []byte) (time.Duration, bool) {
+	return WAVSizeDuration(audio, int64(len(audio)))
+}
+
+// WAVSizeDuration returns the playback duration of size bytes of WAV audio
+// from its header, for audio not held in memory, such as an upload spilled
+// to disk.
+func WAVSizeDuration(header []byte, size int64) (time.Duration, bool) {
+	if wavByteRate(header) == 0 {
+		return 0, false
+	}
+	return audioDuration(header, int(size)), true
+}
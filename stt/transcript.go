@@ -0,0 +1,180 @@
+package stt
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"time"
+
+	speechpb "cloud.google.com/go/speech/apiv2/speechpb"
+)
+
+// Transcript is the complete result of transcribing one audio input.
+type Transcript struct {
+	Text     string        `json:"text"`
+	Language string        `json:"language,omitempty"`
+	Duration time.Duration `json:"duration"`
+	Segments []Segment     `json:"segments"`
+	// Truncated reports a session that ended early at its billing ceiling.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// Segment is one recognized utterance.
+type Segment struct {
+	Text       string        `json:"text"`
+	Start      time.Duration `json:"start"`
+	End        time.Duration `json:"end"`
+	Confidence float32       `json:"confidence"`
+	Language   string        `json:"language,omitempty"`
+	// Speaker is the diarization label of the segment's first word.
+	Speaker string `json:"speaker,omitempty"`
+	Words   []Word `json:"words,omitempty"`
+	// Overlaps marks speech overlapping another speaker's segment.
+	Overlaps bool `json:"overlaps,omitempty"`
+	// Event marks a non-speech segment detected in the audio, such as
+	// "dtmf"; Text then holds the event's value, if any.
+	Event string `json:"event,omitempty"`
+}
+
+// Word is a single recognized word with its timing.
+type Word struct {
+	Text       string        `json:"text"`
+	Start      time.Duration `json:"start"`
+	End        time.Duration `json:"end"`
+	Confidence float32       `json:"confidence,omitempty"`
+	Speaker    string        `json:"speaker,omitempty"`
+}
+
+// Result is a streaming recognition result: a segment that is either final
+// or an interim hypothesis that may still change.
+type Result struct {
+	Segment
+	IsFinal   bool    `json:"is_final"`
+	Stability float32 `json:"stability,omitempty"`
+	// Seq numbers the results of a session from 1, partial and final
+	// alike, in the order they are delivered and across stream restarts. A
+	// gap means a lost result, a repeated number a duplicate.
+	Seq uint64 `json:"seq,omitempty"`
+}
+
+// newResult converts a streaming result, with offsets relative to the start
+// of its stream.
+func newResult(r *speechpb.StreamingRecognitionResult) Result {
+	seg := newSegment(r.Alternatives[0], r.LanguageCode, 0, r.ResultEndOffset.AsDuration())
+	return Result{Segment: seg, IsFinal: r.IsFinal, Stability: r.Stability}
+}
+
+// rebase returns r, received on a stream starting at the audio position
+// base, with offsets relative to the start of the audio. Its segment starts
+// at start unless word timings say otherwise.
+func (r Result) rebase(base, start time.Duration) Result {
+	r.End += base
+	r.Start = start
+	if len(r.Words) > 0 {
+		words := make([]Word, len(r.Words))
+		for i, w := range r.Words {
+			w.Start += base
+			w.End += base
+			words[i] = w
+		}
+		r.Words = words
+		r.Start = words[0].Start
+	}
+	return r
+}
+
+// newSegment converts the best alternative of a result into a segment
+// starting at start, or at its first word if word timings are present.
+func newSegment(alt *speechpb.SpeechRecognitionAlternative, language string, start, end time.Duration) Segment {
+	seg := Segment{
+		Text:       strings.TrimSpace(alt.Transcript),
+		Start:      start,
+		End:        end,
+		Confidence: alt.Confidence,
+		Language:   language,
+	}
+	for _, w := range alt.Words {
+		seg.Words = append(seg.Words, Word{
+			Text:       w.Word,
+			Start:      w.StartOffset.AsDuration(),
+			End:        w.EndOffset.AsDuration(),
+			Confidence: w.Confidence,
+			Speaker:    w.SpeakerLabel,
+		})
+	}
+	if len(seg.Words) > 0 {
+		seg.Start = seg.Words[0].Start
+		seg.Speaker = seg.Words[0].Speaker
+	}
+	return seg
+}
+
+// TranscriptFromSegments builds a transcript from final segments in order.
+func TranscriptFromSegments(segments []Segment) Transcript {
+	t := Transcript{Segments: segments}
+	t.finish()
+	return t
+}
+
+// transcriptFromResults builds a transcript from one-shot or batch results.
+func transcriptFromResults(results []*speechpb.SpeechRecognitionResult) Transcript {
+	var (
+		t    Transcript
+		last time.Duration
+	)
+	for _, r := range results {
+		if len(r.Alternatives) == 0 {
+			continue
+		}
+		end := r.ResultEndOffset.AsDuration()
+		t.Segments = append(t.Segments, newSegment(r.Alternatives[0], r.LanguageCode, last, end))
+		last = end
+	}
+	markOverlaps(t.Segments)
+	t.finish()
+	return t
+}
+
+// WithEvents returns the transcript with event segments merged into its
+// timeline.
+func (t Transcript) WithEvents(events []Segment) Transcript {
+	segments := append(slices.Clone(t.Segments), events...)
+	slices.SortStableFunc(segments, func(a, b Segment) int {
+		return cmp.Compare(a.Start, b.Start)
+	})
+	merged := Transcript{Segments: segments, Truncated: t.Truncated}
+	merged.finish()
+	return merged
+}
+
+// LanguageRuns splits a code-switching transcript into runs of consecutive
+// segments recognized in the same language, each labelled with it.
+func (t Transcript) LanguageRuns() []Transcript {
+	var runs []Transcript
+	for _, seg := range t.Segments {
+		if n := len(runs); n > 0 && strings.EqualFold(runs[n-1].Language, seg.Language) {
+			runs[n-1].Segments = append(runs[n-1].Segments, seg)
+			continue
+		}
+		runs = append(runs, Transcript{Language: seg.Language, Segments: []Segment{seg}})
+	}
+	for i := range runs {
+		runs[i].finish()
+	}
+	return runs
+}
+
+// finish derives the transcript-level fields from its segments.
+func (t *Transcript) finish() {
+	var texts []string
+	for _, seg := range t.Segments {
+		if seg.Text != "" && seg.Event == "" {
+			texts = append(texts, seg.Text)
+		}
+		if t.Language == "" {
+			t.Language = seg.Language
+		}
+		t.Duration = max(t.Duration, seg.End)
+	}
+	t.Text = strings.Join(texts, " ")
+}
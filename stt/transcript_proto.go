@@ -0,0 +1,155 @@
+package stt
+
+import (
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"stt-receivetranscription-mve/proto/sttpb"
+)
+
+// MarshalProto encodes t in the protobuf wire format described by
+// proto/transcript.proto.
+func (t Transcript) MarshalProto() ([]byte, error) {
+	b, err := proto.Marshal(t.Proto())
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal transcript: %w", err)
+	}
+	return b, nil
+}
+
+// UnmarshalProto decodes a transcript encoded by MarshalProto.
+func (t *Transcript) UnmarshalProto(b []byte) error {
+	var pb sttpb.Transcript
+	if err := proto.Unmarshal(b, &pb); err != nil {
+		return fmt.Errorf("invalid transcript encoding: %w", err)
+	}
+	*t = TranscriptFromProto(&pb)
+	return nil
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler using the protobuf
+// encoding.
+func (t Transcript) MarshalBinary() ([]byte, error) {
+	return t.MarshalProto()
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (t *Transcript) UnmarshalBinary(b []byte) error {
+	return t.UnmarshalProto(b)
+}
+
+// MarshalProto encodes r in the protobuf wire format described by
+// proto/transcript.proto.
+func (r Result) MarshalProto() ([]byte, error) {
+	b, err := proto.Marshal(r.Proto())
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal result: %w", err)
+	}
+	return b, nil
+}
+
+// UnmarshalProto decodes a result encoded by MarshalProto.
+func (r *Result) UnmarshalProto(b []byte) error {
+	var pb sttpb.Result
+	if err := proto.Unmarshal(b, &pb); err != nil {
+		return fmt.Errorf("invalid result encoding: %w", err)
+	}
+	*r = ResultFromProto(&pb)
+	return nil
+}
+
+// Proto returns t as the generated stt.v1.Transcript message.
+func (t Transcript) Proto() *sttpb.Transcript {
+	pb := &sttpb.Transcript{
+		Text:          t.Text,
+		Language:      t.Language,
+		DurationNanos: int64(t.Duration),
+		Truncated:     t.Truncated,
+	}
+	for _, seg := range t.Segments {
+		pb.Segments = append(pb.Segments, seg.proto())
+	}
+	return pb
+}
+
+// TranscriptFromProto converts a stt.v1.Transcript message.
+func TranscriptFromProto(pb *sttpb.Transcript) Transcript {
+	t := Transcript{
+		Text:      pb.GetText(),
+		Language:  pb.GetLanguage(),
+		Duration:  time.Duration(pb.GetDurationNanos()),
+		Truncated: pb.GetTruncated(),
+	}
+	for _, seg := range pb.GetSegments() {
+		t.Segments = append(t.Segments, segmentFromProto(seg))
+	}
+	return t
+}
+
+// Proto returns r as the generated stt.v1.Result message.
+func (r Result) Proto() *sttpb.Result {
+	return &sttpb.Result{
+		Segment:   r.Segment.proto(),
+		IsFinal:   r.IsFinal,
+		Stability: r.Stability,
+		Seq:       r.Seq,
+	}
+}
+
+// ResultFromProto converts a stt.v1.Result message.
+func ResultFromProto(pb *sttpb.Result) Result {
+	return Result{
+		Segment:   segmentFromProto(pb.GetSegment()),
+		IsFinal:   pb.GetIsFinal(),
+		Stability: pb.GetStability(),
+		Seq:       pb.GetSeq(),
+	}
+}
+
+func (s Segment) proto() *sttpb.Segment {
+	pb := &sttpb.Segment{
+		Text:       s.Text,
+		StartNanos: int64(s.Start),
+		EndNanos:   int64(s.End),
+		Confidence: s.Confidence,
+		Language:   s.Language,
+		Speaker:    s.Speaker,
+		Event:      s.Event,
+		Overlaps:   s.Overlaps,
+	}
+	for _, w := range s.Words {
+		pb.Words = append(pb.Words, &sttpb.Word{
+			Text:       w.Text,
+			StartNanos: int64(w.Start),
+			EndNanos:   int64(w.End),
+			Confidence: w.Confidence,
+			Speaker:    w.Speaker,
+		})
+	}
+	return pb
+}
+
+func segmentFromProto(pb *sttpb.Segment) Segment {
+	s := Segment{
+		Text:       pb.GetText(),
+		Start:      time.Duration(pb.GetStartNanos()),
+		End:        time.Duration(pb.GetEndNanos()),
+		Confidence: pb.GetConfidence(),
+		Language:   pb.GetLanguage(),
+		Speaker:    pb.GetSpeaker(),
+		Event:      pb.GetEvent(),
+		Overlaps:   pb.GetOverlaps(),
+	}
+	for _, w := range pb.GetWords() {
+		s.Words = append(s.Words, Word{
+			Text:       w.GetText(),
+			Start:      time.Duration(w.GetStartNanos()),
+			End:        time.Duration(w.GetEndNanos()),
+			Confidence: w.GetConfidence(),
+			Speaker:    w.GetSpeaker(),
+		})
+	}
+	return s
+}
@@ -0,0 +1,207 @@
+package stt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"nhooyr.io/websocket"
+	"nhooyr.io/websocket/wsjson"
+)
+
+// ProviderVosk is the name of the provider for self-hosted Vosk or Kaldi
+// WebSocket servers, at the URL in VOSK_URL (default ws://localhost:2700).
+// The language and model are those the server was started with.
+const ProviderVosk = "vosk"
+
+func init() {
+	RegisterProvider(voskProvider{})
+}
+
+type voskProvider struct{}
+
+func (voskProvider) Name() string { return ProviderVosk }
+
+func (voskProvider) Capabilities() Capabilities {
+	return Capabilities{
+		WordTimings:    true,
+		WordConfidence: true,
+		Streaming:      true,
+		Codecs:         []string{"LINEAR16"},
+	}
+}
+
+func (voskProvider) OpenStream(ctx context.Context, config Config) (Stream, error) {
+	addr := os.Getenv("VOSK_URL")
+	if addr == "" {
+		addr = "ws://localhost:2700"
+	}
+	return &voskStream{ctx: ctx, config: config, url: addr, connected: make(chan struct{})}, nil
+}
+
+// voskStream is one recognition session. The server is told the sample rate
+// in a config message before any audio, so it connects on the first audio,
+// taking the rate from the WAV header and sending only the PCM samples that
+// follow.
+type voskStream struct {
+	ctx    context.Context
+	config Config
+	url    string
+
+	conn *websocket.Conn
+	// connected is closed once conn is set or err reports why it is not.
+	connected chan struct{}
+	once      sync.Once
+	err       error
+}
+
+func (s *voskStream) SendAudio(ctx context.Context, audio []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if s.conn == nil {
+		var err error
+		if audio, err = s.connect(audio); err != nil {
+			s.done(err)
+			return err
+		}
+		s.done(nil)
+	}
+	if len(audio) == 0 {
+		return nil
+	}
+	if err := s.conn.Write(ctx, websocket.MessageBinary, audio); err != nil {
+		return &Error{Op: "send audio", Err: err}
+	}
+	return nil
+}
+
+// connect opens the session for the WAV audio starting with header,
+// returning the samples following the header.
+func (s *voskStream) connect(header []byte) ([]byte, error) {
+	n := wavHeaderLen(header)
+	encoding, channels, rate, bits := wavFormat(header)
+	if n == 0 || encoding != wavPCM || bits != 16 || channels != 1 {
+		return nil, &CapabilityError{Provider: ProviderVosk, Feature: "audio other than 16-bit mono PCM WAV"}
+	}
+	conn, _, err := websocket.Dial(s.ctx, s.url, nil)
+	if err != nil {
+		return nil, &Error{Op: "open stream", Err: err}
+	}
+	// Final results of long utterances with their words exceed the default
+	// limit
+	conn.SetReadLimit(1 << 20)
+	config := map[string]any{"config": map[string]any{"sample_rate": rate, "words": 1}}
+	if err := wsjson.Write(s.ctx, conn, config); err != nil {
+		conn.Close(websocket.StatusInternalError, "")
+		return nil, &Error{Op: "send config", Err: err}
+	}
+	s.conn = conn
+	return header[n:], nil
+}
+
+// done releases Receive once connecting has finished.
+func (s *voskStream) done(err error) {
+	s.once.Do(func() {
+		s.err = err
+		close(s.connected)
+	})
+}
+
+// voskMessage is a result received from the server: a partial hypothesis,
+// or the final text of an utterance with its words. Times are in seconds
+// from the start of the session.
+type voskMessage struct {
+	Partial *string `json:"partial"`
+	Text    *string `json:"text"`
+	Result  []struct {
+		Word  string  `json:"word"`
+		Start float64 `json:"start"`
+		End   float64 `json:"end"`
+		Conf  float32 `json:"conf"`
+	} `json:"result"`
+}
+
+func (s *voskStream) Receive(ctx context.Context) (*Result, error) {
+	select {
+	case <-s.connected:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	var msg voskMessage
+	if err := wsjson.Read(ctx, s.conn, &msg); err != nil {
+		if websocket.CloseStatus(err) == websocket.StatusNormalClosure || errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, &Error{Op: "receive response", Err: err}
+	}
+
+	var r Result
+	switch {
+	case msg.Partial != nil:
+		if *msg.Partial == "" {
+			return nil, nil
+		}
+		r.Text = *msg.Partial
+	case msg.Text != nil:
+		if *msg.Text == "" {
+			return nil, nil
+		}
+		r.Text = *msg.Text
+		r.IsFinal = true
+	default:
+		return nil, nil
+	}
+	if len(s.config.LanguageCodes) > 0 {
+		r.Language = s.config.LanguageCodes[0]
+	}
+	var conf float32
+	for _, w := range msg.Result {
+		r.Words = append(r.Words, Word{
+			Text:       w.Word,
+			Start:      time.Duration(w.Start * float64(time.Second)),
+			End:        time.Duration(w.End * float64(time.Second)),
+			Confidence: w.Conf,
+		})
+		conf += w.Conf
+	}
+	if len(r.Words) > 0 {
+		r.Start = r.Words[0].Start
+		r.End = r.Words[len(r.Words)-1].End
+		r.Confidence = conf / float32(len(r.Words))
+	}
+	return &r, nil
+}
+
+// CloseSend asks the server for the final result of the remaining audio;
+// the server sends it and closes the connection, so Receive returns io.EOF.
+func (s *voskStream) CloseSend() error {
+	if s.conn == nil {
+		// Nothing was sent, so there are no results to wait for
+		s.done(io.EOF)
+		return nil
+	}
+	if err := wsjson.Write(s.ctx, s.conn, map[string]int{"eof": 1}); err != nil {
+		return fmt.Errorf("failed to end audio: %w", err)
+	}
+	return nil
+}
+
+func (s *voskStream) Close() error {
+	s.done(io.EOF)
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close(websocket.StatusNormalClosure, "")
+}
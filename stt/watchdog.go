@@ -0,0 +1,84 @@
+package stt
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrStalled is returned by Watchdog.Run when a stalled stream should be
+// restarted.
+var ErrStalled = errors.New("stream stalled")
+
+// streamStalls counts detected stalls, exported through expvar.
+var streamStalls = expvar.NewInt("stt_stream_stalls")
+
+// Watchdog detects streams that stop producing responses while audio is still
+// being sent. Callers report activity with Sending and Responded.
+type Watchdog struct {
+	// Timeout is how long to wait for a response while sending.
+	Timeout time.Duration
+	// Restart makes Run return ErrStalled instead of only reporting stalls.
+	Restart bool
+
+	mu           sync.Mutex
+	sending      bool
+	lastResponse time.Time
+	reported     bool
+}
+
+func NewWatchdog(timeout time.Duration, restart bool) *Watchdog {
+	return &Watchdog{Timeout: timeout, Restart: restart, lastResponse: time.Now()}
+}
+
+// Sending records whether audio is currently being sent. The silence clock
+// restarts when sending begins.
+func (w *Watchdog) Sending(sending bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if sending && !w.sending {
+		w.lastResponse = time.Now()
+	}
+	w.sending = sending
+}
+
+// Responded records that a response arrived.
+func (w *Watchdog) Responded() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.lastResponse = time.Now()
+	w.reported = false
+}
+
+// Run checks for stalls until ctx is done. Each stall is logged and counted
+// once; with Restart set, Run returns ErrStalled on the first one.
+func (w *Watchdog) Run(ctx context.Context) error {
+	ticker := time.NewTicker(min(w.Timeout/4, time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		w.mu.Lock()
+		idle := time.Since(w.lastResponse)
+		stalled := w.sending && !w.reported && idle >= w.Timeout
+		if stalled {
+			w.reported = true
+		}
+		w.mu.Unlock()
+
+		if stalled {
+			streamStalls.Add(1)
+			log.Printf("No response for %s while sending audio; stream appears stalled", idle.Round(time.Second))
+			if w.Restart {
+				return ErrStalled
+			}
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package stt
+
+import (
+	"strings"
+	"unicode"
+)
+
+// WordErrors returns the substitutions, insertions and deletions turning
+// the reference into the hypothesis, and the number of reference words.
+// Case and punctuation are ignored.
+func WordErrors(reference, hypothesis string) (errs, words int) {
+	ref, hyp := normalizeWords(reference), normalizeWords(hypothesis)
+	// Levenshtein distance over words, keeping one row
+	row := make([]int, len(hyp)+1)
+	for j := range row {
+		row[j] = j
+	}
+	for i := 1; i <= len(ref); i++ {
+		prev := row[0]
+		row[0] = i
+		for j := 1; j <= len(hyp); j++ {
+			cost := 1
+			if ref[i-1] == hyp[j-1] {
+				cost = 0
+			}
+			prev, row[j] = row[j], min(row[j]+1, row[j-1]+1, prev+cost)
+		}
+	}
+	return row[len(hyp)], len(ref)
+}
+
+func normalizeWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	})
+}
@@ -0,0 +1,404 @@
+package stt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ProviderWhisper is the name of the provider for hosted Whisper-compatible
+// transcription APIs, such as OpenAI's, at OPENAI_BASE_URL authenticated with
+// OPENAI_API_KEY. Streamed audio is transcribed in overlapping chunks.
+const ProviderWhisper = "whisper"
+
+// whisperModel is the model used when Config.Model is not set.
+const whisperModel = "whisper-1"
+
+// Chunking of streamed audio for Whisper when Config.ChunkDuration and
+// Config.ChunkOverlap are not set.
+const (
+	whisperChunk   = 10 * time.Second
+	whisperOverlap = time.Second
+)
+
+// whisperPromptLen bounds the prompt, which Whisper truncates to its last
+// 224 tokens.
+const whisperPromptLen = 600
+
+func init() {
+	RegisterProvider(whisperProvider{})
+}
+
+type whisperProvider struct{}
+
+func (whisperProvider) Name() string { return ProviderWhisper }
+
+func (whisperProvider) Capabilities() Capabilities {
+	return Capabilities{
+		WordTimings: true,
+		// Boost phrases are passed in the prompt
+		WordBoost: true,
+		Streaming: true,
+		Codecs:    []string{"LINEAR16"},
+	}
+}
+
+func (whisperProvider) OpenStream(ctx context.Context, config Config) (Stream, error) {
+	baseURL := os.Getenv("OPENAI_BASE_URL")
+	if baseURL == "" {
+		baseURL = "https://api.openai.com/v1"
+	}
+	s := &whisperStream{
+		ctx:     ctx,
+		config:  config,
+		url:     strings.TrimSuffix(baseURL, "/") + "/audio/transcriptions",
+		key:     os.Getenv("OPENAI_API_KEY"),
+		chunk:   config.ChunkDuration,
+		overlap: config.ChunkOverlap,
+		windows: make(chan whisperWindow, 1),
+		results: make(chan whisperResult, 4),
+	}
+	if s.chunk <= 0 {
+		s.chunk = whisperChunk
+	}
+	if s.overlap <= 0 {
+		s.overlap = min(whisperOverlap, s.chunk/4)
+	}
+	if s.overlap >= s.chunk {
+		return nil, fmt.Errorf("chunk overlap %s must be shorter than the chunk duration %s", s.overlap, s.chunk)
+	}
+	go s.run()
+	return s, nil
+}
+
+// whisperStream pseudo-streams audio by transcribing windows of it as they
+// fill up. Consecutive windows overlap, and words are taken from the window
+// that has them furthest from its edges: up to the middle of the overlap
+// from the earlier window, the rest from the later one.
+type whisperStream struct {
+	ctx            context.Context
+	config         Config
+	url, key       string
+	chunk, overlap time.Duration
+
+	// header is the WAV header of the audio, with the format windows are
+	// sent in.
+	header     []byte
+	byteRate   int
+	blockAlign int
+	// pcm is the audio of the next window, starting at start.
+	pcm    []byte
+	start  time.Duration
+	sent   bool
+	closed bool
+
+	windows chan whisperWindow
+	results chan whisperResult
+}
+
+// whisperWindow is a window of audio to transcribe. Words before stitch are
+// final; a zero stitch marks the last window. A last window without audio
+// ends audio that stopped on a window boundary, making the tentative words
+// of the window before final.
+type whisperWindow struct {
+	pcm    []byte
+	start  time.Duration
+	stitch time.Duration
+}
+
+type whisperResult struct {
+	result *Result
+	err    error
+}
+
+func (s *whisperStream) SendAudio(ctx context.Context, audio []byte) error {
+	if s.header == nil {
+		n := wavHeaderLen(audio)
+		encoding, channels, rate, bits := wavFormat(audio)
+		if n == 0 || encoding != wavPCM {
+			return &CapabilityError{Provider: ProviderWhisper, Feature: "audio other than PCM WAV"}
+		}
+		s.header = append([]byte(nil), audio[:n]...)
+		s.blockAlign = channels * bits / 8
+		s.byteRate = rate * s.blockAlign
+		audio = audio[n:]
+	}
+	s.pcm = append(s.pcm, audio...)
+	chunk, step := s.bytes(s.chunk), s.bytes(s.chunk-s.overlap)
+	for len(s.pcm) >= chunk {
+		w := whisperWindow{pcm: s.pcm[:chunk:chunk], start: s.start, stitch: s.start + s.chunk - s.overlap/2}
+		if err := s.send(ctx, w); err != nil {
+			return err
+		}
+		// The overlap is sent again at the start of the next window
+		s.pcm = append([]byte(nil), s.pcm[step:]...)
+		s.start += s.chunk - s.overlap
+	}
+	return nil
+}
+
+// bytes returns the length of d of audio, in whole sample frames.
+func (s *whisperStream) bytes(d time.Duration) int {
+	n := int(d.Seconds() * float64(s.byteRate))
+	return n - n%max(s.blockAlign, 1)
+}
+
+func (s *whisperStream) send(ctx context.Context, w whisperWindow) error {
+	select {
+	case s.windows <- w:
+		s.sent = true
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
+
+// CloseSend transcribes the audio not yet sent as the last window.
+func (s *whisperStream) CloseSend() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	defer close(s.windows)
+	switch {
+	case len(s.pcm) > s.bytes(s.overlap) || !s.sent && len(s.pcm) > 0:
+		return s.send(s.ctx, whisperWindow{pcm: s.pcm, start: s.start})
+	case s.sent:
+		// Audio within the last window's overlap has been transcribed
+		// already, but its words past the stitch only tentatively
+		return s.send(s.ctx, whisperWindow{start: s.start})
+	}
+	return nil
+}
+
+func (s *whisperStream) Close() error {
+	if !s.closed {
+		s.closed = true
+		close(s.windows)
+	}
+	return nil
+}
+
+func (s *whisperStream) Receive(ctx context.Context) (*Result, error) {
+	select {
+	case r, ok := <-s.results:
+		if !ok {
+			return nil, io.EOF
+		}
+		return r.result, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// run transcribes the windows in order, passing the final words of each on
+// as a final result and the rest as a partial one.
+func (s *whisperStream) run() {
+	defer close(s.results)
+	var (
+		from   time.Duration
+		prompt string
+		// tentative are the words of the previous window past its stitch.
+		tentative []Word
+		words     bool
+	)
+	for w := range s.windows {
+		if w.pcm == nil {
+			if r := whisperPiecesResult(tentative, words, s.config, true); r != nil {
+				s.deliver(whisperResult{result: r})
+			}
+			return
+		}
+		pieces, pieceWords, err := s.transcribe(w, prompt)
+		if err != nil {
+			s.deliver(whisperResult{err: err})
+			return
+		}
+		var final []Word
+		tentative, words = nil, pieceWords
+		for _, p := range pieces {
+			mid := (p.Start + p.End) / 2
+			switch {
+			case mid < from:
+			case w.stitch == 0 || mid < w.stitch:
+				final = append(final, p)
+			default:
+				tentative = append(tentative, p)
+			}
+		}
+		from = w.stitch
+		if r := whisperPiecesResult(final, words, s.config, true); r != nil {
+			prompt = lastRunes(prompt+" "+r.Text, whisperPromptLen)
+			if !s.deliver(whisperResult{result: r}) {
+				return
+			}
+		}
+		if r := whisperPiecesResult(tentative, words, s.config, false); r != nil {
+			if !s.deliver(whisperResult{result: r}) {
+				return
+			}
+		}
+	}
+}
+
+func (s *whisperStream) deliver(r whisperResult) bool {
+	select {
+	case s.results <- r:
+		return true
+	case <-s.ctx.Done():
+		return false
+	}
+}
+
+// whisperResponse is a verbose_json transcription.
+type whisperResponse struct {
+	Text     string `json:"text"`
+	Segments []struct {
+		Text  string  `json:"text"`
+		Start float64 `json:"start"`
+		End   float64 `json:"end"`
+	} `json:"segments"`
+	Words []struct {
+		Word  string  `json:"word"`
+		Start float64 `json:"start"`
+		End   float64 `json:"end"`
+	} `json:"words"`
+}
+
+// transcribe sends a window and returns its words with stream offsets. For
+// APIs without word timestamps it returns the segments as pieces instead,
+// reporting whether they are words.
+func (s *whisperStream) transcribe(w whisperWindow, prompt string) ([]Word, bool, error) {
+	header := append([]byte(nil), s.header...)
+	binary.LittleEndian.PutUint32(header[4:8], uint32(len(header)-8+len(w.pcm)))
+	binary.LittleEndian.PutUint32(header[len(header)-4:], uint32(len(w.pcm)))
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", "audio.wav")
+	if err != nil {
+		return nil, false, err
+	}
+	fw.Write(header)
+	fw.Write(w.pcm)
+	model := s.config.Model
+	if model == "" {
+		model = whisperModel
+	}
+	mw.WriteField("model", model)
+	mw.WriteField("response_format", "verbose_json")
+	mw.WriteField("timestamp_granularities[]", "word")
+	mw.WriteField("timestamp_granularities[]", "segment")
+	if len(s.config.LanguageCodes) > 0 {
+		// Whisper takes ISO-639-1 codes
+		lang, _, _ := strings.Cut(s.config.LanguageCodes[0], "-")
+		mw.WriteField("language", strings.ToLower(lang))
+	}
+	if len(s.config.Boost) > 0 {
+		prompt = strings.Join(s.config.Boost, ", ") + ". " + prompt
+	}
+	if prompt = strings.TrimSpace(prompt); prompt != "" {
+		mw.WriteField("prompt", prompt)
+	}
+	if err := mw.Close(); err != nil {
+		return nil, false, err
+	}
+
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, s.url, &body)
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	if s.key != "" {
+		req.Header.Set("Authorization", "Bearer "+s.key)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		if ctxErr := s.ctx.Err(); ctxErr != nil {
+			return nil, false, ctxErr
+		}
+		return nil, false, &Error{Op: "transcribe chunk", Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		err := &Error{Op: "transcribe chunk", Err: fmt.Errorf("unexpected status %s: %s", resp.Status, bytes.TrimSpace(msg))}
+		switch resp.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			err.Kind = ErrAuth
+		case http.StatusTooManyRequests:
+			err.Kind = ErrQuota
+		}
+		return nil, false, err
+	}
+	var r whisperResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, false, &Error{Op: "transcribe chunk", Err: fmt.Errorf("invalid response: %w", err)}
+	}
+
+	offset := func(seconds float64) time.Duration {
+		return w.start + time.Duration(seconds*float64(time.Second))
+	}
+	var pieces []Word
+	for _, word := range r.Words {
+		pieces = append(pieces, Word{Text: strings.TrimSpace(word.Word), Start: offset(word.Start), End: offset(word.End)})
+	}
+	if len(pieces) > 0 {
+		return pieces, true, nil
+	}
+	if len(r.Segments) > 0 {
+		for _, seg := range r.Segments {
+			pieces = append(pieces, Word{Text: strings.TrimSpace(seg.Text), Start: offset(seg.Start), End: offset(seg.End)})
+		}
+	}
+	if len(pieces) == 0 && strings.TrimSpace(r.Text) != "" {
+		return nil, false, &Error{Op: "transcribe chunk", Err: errors.New("response has neither word nor segment timestamps")}
+	}
+	return pieces, false, nil
+}
+
+// whisperPiecesResult joins the pieces of a window into a result, or returns
+// nil if there are none.
+func whisperPiecesResult(pieces []Word, words bool, config Config, final bool) *Result {
+	var text []string
+	for _, p := range pieces {
+		if p.Text != "" {
+			text = append(text, p.Text)
+		}
+	}
+	if len(text) == 0 {
+		return nil
+	}
+	r := &Result{
+		Segment: Segment{
+			Text:  strings.Join(text, " "),
+			Start: pieces[0].Start,
+			End:   pieces[len(pieces)-1].End,
+		},
+		IsFinal: final,
+	}
+	if words {
+		r.Words = pieces
+	}
+	if len(config.LanguageCodes) > 0 {
+		r.Language = config.LanguageCodes[0]
+	}
+	return r
+}
+
+// lastRunes returns the last n runes of s.
+func lastRunes(s string, n int) string {
+	r := []rune(s)
+	return string(r[max(len(r)-n, 0):])
+}
@@ -0,0 +1,183 @@
+// Package sttest simulates a speaker for end-to-end tests of the recognition
+// pipeline: it synthesizes speech from text, with Cloud Text-to-Speech or a
+// local espeak, transcribes it and reports whether the text round-trips. It
+// is a test helper, only meant to be imported from _test.go files.
+package sttest
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"google.golang.org/api/option"
+	texttospeech "google.golang.org/api/texttospeech/v1"
+
+	"stt-receivetranscription-mve/stt"
+)
+
+// Speaker synthesizes speech.
+type Speaker interface {
+	// Speak returns text spoken as WAV audio.
+	Speak(ctx context.Context, text string) ([]byte, error)
+}
+
+// CloudTTS is a Speaker using Google Cloud Text-to-Speech, authenticated
+// like the speech clients.
+type CloudTTS struct {
+	// Language is the BCP-47 code of the voice, such as "en-US".
+	Language string
+	// Voice names the voice, such as "en-US-Standard-C"; empty lets the
+	// service pick one for Language.
+	Voice string
+	// SampleRate defaults to 16000.
+	SampleRate int
+
+	ClientOptions []option.ClientOption
+}
+
+func (c CloudTTS) Speak(ctx context.Context, text string) ([]byte, error) {
+	svc, err := texttospeech.NewService(ctx, c.ClientOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create text-to-speech client: %w", err)
+	}
+	rate := c.SampleRate
+	if rate == 0 {
+		rate = 16000
+	}
+	resp, err := svc.Text.Synthesize(&texttospeech.SynthesizeSpeechRequest{
+		Input: &texttospeech.SynthesisInput{Text: text},
+		Voice: &texttospeech.VoiceSelectionParams{LanguageCode: c.Language, Name: c.Voice},
+		// LINEAR16 audio comes with a WAV header
+		AudioConfig: &texttospeech.AudioConfig{AudioEncoding: "LINEAR16", SampleRateHertz: int64(rate)},
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to synthesize speech: %w", err)
+	}
+	audio, err := base64.StdEncoding.DecodeString(resp.AudioContent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode synthesized speech: %w", err)
+	}
+	return audio, nil
+}
+
+// Espeak is a Speaker running espeak-ng, or espeak, locally, for tests
+// without Text-to-Speech credentials. Its voices are robotic, so expect a
+// higher error rate than with CloudTTS.
+type Espeak struct {
+	// Voice is an espeak voice, such as "en-us"; empty selects the
+	// default.
+	Voice string
+	// Command is the executable; empty tries espeak-ng, then espeak.
+	Command string
+}
+
+func (e Espeak) Speak(ctx context.Context, text string) ([]byte, error) {
+	command := e.Command
+	if command == "" {
+		command = "espeak-ng"
+		if _, err := exec.LookPath(command); err != nil {
+			command = "espeak"
+		}
+	}
+	args := []string{"--stdout"}
+	if e.Voice != "" {
+		args = append(args, "-v", e.Voice)
+	}
+	// The text is read from stdin so it cannot be taken for an option
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Stdin = strings.NewReader(text)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	audio, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("failed to run %s: %w: %s", command, err, msg)
+		}
+		return nil, fmt.Errorf("failed to run %s: %w", command, err)
+	}
+	return audio, nil
+}
+
+// Result is the outcome of a round trip.
+type Result struct {
+	// Text is the text spoken, and Transcript what was recognized.
+	Text       string
+	Transcript stt.Transcript
+	// Audio is the synthesized WAV audio.
+	Audio []byte
+	// Errors and Words are the word errors of the transcript against Text
+	// and the words of Text.
+	Errors, Words int
+	// Elapsed is how long recognition took.
+	Elapsed time.Duration
+}
+
+// WER returns the word error rate of the transcript.
+func (r *Result) WER() float64 {
+	if r.Words == 0 {
+		return 0
+	}
+	return float64(r.Errors) / float64(r.Words)
+}
+
+// Check returns an error describing the mismatch if the word error rate
+// exceeds maxWER, for a test to fail with.
+func (r *Result) Check(maxWER float64) error {
+	if r.WER() <= maxWER {
+		return nil
+	}
+	return fmt.Errorf("word error rate %.1f%% exceeds %.1f%%: spoke %q, recognized %q",
+		r.WER()*100, maxWER*100, r.Text, r.Transcript.Text)
+}
+
+// RoundTrip has speaker speak text and transcribes the audio with opts, as
+// stt.TranscribeFile would a recording of it. A test then asserts on the
+// result:
+//
+//	r, err := sttest.RoundTrip(ctx, sttest.Espeak{}, "turn on the lights", opts)
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	if err := r.Check(0.2); err != nil {
+//		t.Error(err)
+//	}
+func RoundTrip(ctx context.Context, speaker Speaker, text string, opts stt.TranscribeOptions) (*Result, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, errors.New("no text to speak")
+	}
+	audio, err := speaker.Speak(ctx, text)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := stt.WAVDuration(audio); !ok {
+		return nil, errors.New("speaker did not return WAV audio")
+	}
+
+	f, err := os.CreateTemp("", "sttest-*.wav")
+	if err != nil {
+		return nil, fmt.Errorf("failed to write speech: %w", err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(audio)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write speech: %w", err)
+	}
+
+	start := time.Now()
+	t, err := stt.TranscribeFile(ctx, f.Name(), opts)
+	if err != nil {
+		return nil, err
+	}
+	r := &Result{Text: text, Transcript: t, Audio: audio, Elapsed: time.Since(start)}
+	r.Errors, r.Words = stt.WordErrors(text, t.Text)
+	return r, nil
+}
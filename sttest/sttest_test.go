@@ -0,0 +1,60 @@
+package sttest_test
+
+import (
+	"cmp"
+	"context"
+	"os"
+	"testing"
+
+	"stt-receivetranscription-mve/stt"
+	"stt-receivetranscription-mve/sttest"
+)
+
+// roundTripPhrases are spoken by TestRoundTrip.
+var roundTripPhrases = []string{
+	"The quick brown fox jumps over the lazy dog.",
+	"Please call me back tomorrow morning at nine.",
+}
+
+// TestRoundTrip is an end-to-end check of credentials, recognizer and
+// pipeline: every phrase is synthesized, transcribed with each recognition
+// API and must come back within a 20% word error rate. It runs against the
+// recognizer in GOOGLE_PROJECT_ID, GOOGLE_REGION and RECOGNIZER_ID, speaking
+// with Cloud Text-to-Speech, or with espeak if STTEST_SPEAKER is espeak.
+func TestRoundTrip(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping end-to-end test in short mode")
+	}
+	config := stt.Config{
+		ProjectID:     os.Getenv("GOOGLE_PROJECT_ID"),
+		Region:        cmp.Or(os.Getenv("GOOGLE_REGION"), "global"),
+		RecognizerID:  os.Getenv("RECOGNIZER_ID"),
+		LanguageCodes: []string{"en-US"},
+	}
+	if config.ProjectID == "" || config.RecognizerID == "" {
+		t.Skip("GOOGLE_PROJECT_ID and RECOGNIZER_ID are not set")
+	}
+	var speaker sttest.Speaker = sttest.CloudTTS{Language: "en-US"}
+	switch s := os.Getenv("STTEST_SPEAKER"); s {
+	case "", "tts":
+	case "espeak":
+		speaker = sttest.Espeak{Voice: "en-us"}
+	default:
+		t.Fatalf("unknown STTEST_SPEAKER %q: expected tts or espeak", s)
+	}
+
+	for _, mode := range []stt.Mode{stt.ModeOneShot, stt.ModeStreaming} {
+		for _, text := range roundTripPhrases {
+			t.Run(string(mode), func(t *testing.T) {
+				r, err := sttest.RoundTrip(context.Background(), speaker, text, stt.TranscribeOptions{Config: config, Mode: mode})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := r.Check(0.2); err != nil {
+					t.Error(err)
+				}
+				t.Logf("WER %.1f%% in %s: %q", r.WER()*100, r.Elapsed, r.Transcript.Text)
+			})
+		}
+	}
+}